@@ -0,0 +1,569 @@
+package api
+
+import "net/http"
+
+// pathParams describes the path parameters by name
+var pathParams = map[string]string{
+	"username": "The username of a worker",
+	"id":       "The ID of the resource",
+	"country":  "An ISO 3166 country code, e.g. BD",
+	"division": "The code or the name of a division",
+	"base":     "The currency the rate is of",
+	"quote":    "The currency the rate is in",
+	"date":     "The date the rate holds from, YYYY-MM-DD",
+	"delivery": "The ID of a delivery of the webhook",
+}
+
+var (
+	workerFilterParams = []apiParam{
+		{name: "department", typ: "string", description: "Only the workers of this department"},
+		{name: "team", typ: "string", description: "Only the workers of this team"},
+		{name: "manager", typ: "string", description: "Only the direct reports of this worker"},
+	}
+	currencyParams = []apiParam{
+		{name: "currency", typ: "string", description: "Convert the salaries to this currency"},
+		{name: "date", typ: "string", description: "Convert at the rates of this date, YYYY-MM-DD, today by default"},
+	}
+	statsFilterParams = []apiParam{
+		{name: "group_by", typ: "string", description: "Comma separated fields to group the workers by: division, city, position, department, team"},
+		{name: "division", typ: "string", description: "Only the workers of this division"},
+		{name: "city", typ: "string", description: "Only the workers of this city"},
+		{name: "position", typ: "string", description: "Only the workers of this position"},
+		{name: "department", typ: "string", description: "Only the workers of this department"},
+		{name: "team", typ: "string", description: "Only the workers of this team"},
+	}
+	reassignParam = apiParam{name: "reassign_to", typ: "string", description: "The worker who takes over the reports, or the members, of the deleted one"}
+)
+
+func params(lists ...[]apiParam) []apiParam {
+	var all []apiParam
+	for _, list := range lists {
+		all = append(all, list...)
+	}
+	return all
+}
+
+// apiOperations documents every route, keyed by operationKey. A route
+// registered without an entry here fails the tests.
+var apiOperations = map[string]apiOperation{
+	"GET /": {
+		summary: "Tell that the server is up",
+		tag:     "general",
+		result:  "",
+	},
+	"GET /openapi.json": {
+		summary:     "The OpenAPI document of the API",
+		tag:         "general",
+		resultTypes: []string{mediaJSON},
+		public:      true,
+	},
+	"GET /docs": {
+		summary:     "The documentation of the API",
+		tag:         "general",
+		resultTypes: []string{"text/html"},
+		public:      true,
+	},
+	"GET /graphql": {
+		summary: "Run a GraphQL query over the worker directory",
+		tag:     "graphql",
+		query: []apiParam{
+			{name: "query", typ: "string", description: "The GraphQL document, it can be left out for a persisted query"},
+			{name: "operationName", typ: "string", description: "The operation of the document to run"},
+			{name: "variables", typ: "string", description: "The variables of the operation, as a JSON object"},
+			{name: "extensions", typ: "string", description: "The persisted query, as a JSON object like {\"persistedQuery\": {\"version\": 1, \"sha256Hash\": \"...\"}}"},
+		},
+		resultTypes: []string{mediaJSON},
+		errors:      []int{http.StatusBadRequest, http.StatusMethodNotAllowed, http.StatusUnprocessableEntity},
+	},
+	"POST /graphql": {
+		summary:     "Run a GraphQL query or mutation over the worker directory",
+		tag:         "graphql",
+		body:        graphQLRequest{},
+		resultTypes: []string{mediaJSON},
+	},
+	"GET /appscode": {
+		summary: "List the resources of the API",
+		tag:     "general",
+		result:  "",
+	},
+
+	"GET /appscode/workers": {
+		summary: "List the workers",
+		tag:     "workers",
+		query: params([]apiParam{
+			{name: "limit", typ: "integer", description: "List a page of up to this many workers, ordered by username, and link to the next page in the Link header"},
+			{name: "after", typ: "string", description: "List the workers after this username"},
+		}, workerFilterParams, currencyParams),
+		result: []Worker{},
+		errors: []int{http.StatusUnprocessableEntity},
+	},
+	"GET /appscode/workers/export": {
+		summary: "Export the workers as CSV, NDJSON or XLSX",
+		tag:     "workers",
+		query: params([]apiParam{
+			{name: "format", typ: "string", description: "The format of the file", enum: []string{ExportCSV, ExportNDJSON, ExportXLSX}},
+			{name: "columns", typ: "string", description: "Comma separated columns in their order, all of the ones the user may see by default"},
+		}, workerFilterParams, currencyParams),
+		resultTypes: []string{"text/csv", "application/x-ndjson", xlsxContentType},
+		errors:      []int{http.StatusForbidden, http.StatusUnprocessableEntity},
+	},
+	"GET /appscode/workers/watch": {
+		summary: "Watch the changes of the workers as server-sent events, or over a WebSocket",
+		tag:     "workers",
+		query: params(workerFilterParams, []apiParam{
+			{name: "resource_version", typ: "integer", description: "Resume after the event of this resource version, the Last-Event-ID header wins over it. The watch starts from now by default."},
+		}),
+		resultTypes: []string{"text/event-stream"},
+		errors:      []int{http.StatusBadRequest, http.StatusForbidden, http.StatusGone, http.StatusUnprocessableEntity},
+	},
+	"GET /appscode/workers/:username": {
+		summary: "Show a worker",
+		tag:     "workers",
+		query:   currencyParams,
+		result:  Worker{},
+		errors:  []int{http.StatusUnprocessableEntity},
+	},
+	"POST /appscode/workers": {
+		summary:  "Add a worker",
+		tag:      "workers",
+		body:     Worker{},
+		statuses: []int{http.StatusCreated},
+		result:   Worker{},
+		errors:   []int{http.StatusForbidden, http.StatusConflict},
+	},
+	"PUT /appscode/workers/:username": {
+		summary:  "Update a worker",
+		tag:      "workers",
+		body:     Worker{},
+		statuses: []int{http.StatusCreated},
+		result:   Worker{},
+		errors:   []int{http.StatusForbidden, http.StatusConflict},
+	},
+	"DELETE /appscode/workers/:username": {
+		summary: "Delete a worker",
+		tag:     "workers",
+		query:   []apiParam{reassignParam},
+		result:  StatusMessage{},
+		errors:  []int{http.StatusConflict, http.StatusUnprocessableEntity},
+	},
+	"POST /appscode/workers/:username/restore": {
+		summary: "Bring a deleted worker back",
+		tag:     "workers",
+		result:  Worker{},
+		errors:  []int{http.StatusConflict, http.StatusUnprocessableEntity},
+	},
+	"POST /appscode/workers/import": {
+		summary: "Import workers from CSV or NDJSON",
+		tag:     "workers",
+		query: []apiParam{
+			{name: "mode", typ: "string", description: "Write every row or none of them, or the valid rows only", enum: []string{ImportAll, ImportBestEffort}},
+			{name: "dry_run", typ: "boolean", description: "Validate the rows without writing them"},
+			{name: "async", typ: "boolean", description: "Import in the background, the default for large files"},
+			{name: "map", typ: "string", description: "Comma separated header:field pairs mapping CSV columns to worker fields"},
+		},
+		bodyTypes: []string{"text/csv", "application/x-ndjson"},
+		statuses:  []int{http.StatusCreated, http.StatusOK, http.StatusAccepted},
+		result:    ImportJob{},
+	},
+	"POST /appscode/workers/batch": {
+		summary: "Create, update and delete several workers at once",
+		tag:     "workers",
+		query: []apiParam{
+			{name: "mode", typ: "string", description: "Write every operation or none of them, or each one that succeeds", enum: []string{ImportAll, ImportBestEffort}},
+		},
+		body:     Batch{},
+		statuses: []int{http.StatusMultiStatus},
+		result:   BatchReport{},
+	},
+	"GET /appscode/workers/import/:id": {
+		summary: "Show the progress of an import",
+		tag:     "workers",
+		result:  ImportJob{},
+	},
+
+	"GET /appscode/workers/:username/reports": {
+		summary: "List the reports of a worker",
+		tag:     "org chart",
+		query:   []apiParam{{name: "depth", typ: "integer", description: "How many levels down, 1 by default"}},
+		result:  []report{},
+		errors:  []int{http.StatusUnprocessableEntity},
+	},
+	"GET /appscode/workers/:username/chain": {
+		summary: "List a worker and their managers up to the top",
+		tag:     "org chart",
+		result:  []*Worker{},
+	},
+	"GET /appscode/orgchart": {
+		summary: "The org chart as a tree",
+		tag:     "org chart",
+		query: []apiParam{
+			{name: "root", typ: "string", description: "Only the part of the chart under this worker"},
+			{name: "format", typ: "string", description: "A JSON tree, or Graphviz", enum: []string{"json", "dot"}},
+		},
+		result:      []*orgNode{},
+		resultTypes: []string{"text/vnd.graphviz"},
+		errors:      []int{http.StatusNotFound, http.StatusUnprocessableEntity},
+	},
+
+	"GET /appscode/workers/:username/employment": {
+		summary: "List the employment records of a worker",
+		tag:     "employment",
+		result:  []EmploymentRecord{},
+	},
+	"POST /appscode/workers/:username/employment": {
+		summary:  "Schedule a change of a worker's employment",
+		tag:      "employment",
+		body:     employmentChange{},
+		statuses: []int{http.StatusCreated},
+		result:   EmploymentRecord{},
+	},
+	"DELETE /appscode/workers/:username/employment/:id": {
+		summary: "Cancel a scheduled change",
+		tag:     "employment",
+		result:  StatusMessage{},
+		errors:  []int{http.StatusConflict},
+	},
+
+	"GET /appscode/departments": {
+		summary: "List the departments",
+		tag:     "departments",
+		result:  []Department{},
+	},
+	"GET /appscode/departments/:id": {
+		summary: "Show a department",
+		tag:     "departments",
+		result:  Department{},
+	},
+	"GET /appscode/departments/:id/workers": {
+		summary: "List the workers of a department",
+		tag:     "departments",
+		query:   currencyParams,
+		result:  []Worker{},
+		errors:  []int{http.StatusUnprocessableEntity},
+	},
+	"GET /appscode/departments/:id/teams": {
+		summary: "List the teams of a department",
+		tag:     "departments",
+		result:  []Team{},
+	},
+	"POST /appscode/departments": {
+		summary:  "Add a department",
+		tag:      "departments",
+		body:     Department{},
+		statuses: []int{http.StatusCreated},
+		result:   Department{},
+		errors:   []int{http.StatusConflict},
+	},
+	"PUT /appscode/departments/:id": {
+		summary: "Update a department",
+		tag:     "departments",
+		body:    Department{},
+		result:  Department{},
+		errors:  []int{http.StatusConflict},
+	},
+	"DELETE /appscode/departments/:id": {
+		summary: "Delete a department",
+		tag:     "departments",
+		query:   []apiParam{reassignParam},
+		result:  StatusMessage{},
+		errors:  []int{http.StatusConflict, http.StatusUnprocessableEntity},
+	},
+
+	"GET /appscode/teams": {
+		summary: "List the teams",
+		tag:     "teams",
+		query:   []apiParam{{name: "department", typ: "string", description: "Only the teams of this department"}},
+		result:  []Team{},
+	},
+	"GET /appscode/teams/:id": {
+		summary: "Show a team",
+		tag:     "teams",
+		result:  Team{},
+	},
+	"GET /appscode/teams/:id/workers": {
+		summary: "List the workers of a team",
+		tag:     "teams",
+		query:   currencyParams,
+		result:  []Worker{},
+		errors:  []int{http.StatusUnprocessableEntity},
+	},
+	"POST /appscode/teams": {
+		summary:  "Add a team",
+		tag:      "teams",
+		body:     Team{},
+		statuses: []int{http.StatusCreated},
+		result:   Team{},
+		errors:   []int{http.StatusConflict},
+	},
+	"PUT /appscode/teams/:id": {
+		summary: "Update a team",
+		tag:     "teams",
+		body:    Team{},
+		result:  Team{},
+		errors:  []int{http.StatusConflict},
+	},
+	"DELETE /appscode/teams/:id": {
+		summary: "Delete a team",
+		tag:     "teams",
+		result:  StatusMessage{},
+		errors:  []int{http.StatusConflict},
+	},
+
+	"GET /appscode/positions": {
+		summary: "List the positions and their salary bands",
+		tag:     "positions",
+		result:  []Position{},
+	},
+	"GET /appscode/positions/:id": {
+		summary: "Show a position",
+		tag:     "positions",
+		result:  Position{},
+	},
+	"POST /appscode/positions": {
+		summary:  "Add a position",
+		tag:      "positions",
+		body:     Position{},
+		statuses: []int{http.StatusCreated},
+		result:   Position{},
+		errors:   []int{http.StatusConflict},
+	},
+	"PUT /appscode/positions/:id": {
+		summary: "Update a position",
+		tag:     "positions",
+		body:    Position{},
+		result:  Position{},
+		errors:  []int{http.StatusConflict},
+	},
+	"DELETE /appscode/positions/:id": {
+		summary: "Delete a position nobody holds",
+		tag:     "positions",
+		result:  StatusMessage{},
+		errors:  []int{http.StatusConflict},
+	},
+	"GET /appscode/reports/out-of-band": {
+		summary: "List the workers paid outside of their position's band",
+		tag:     "positions",
+		result:  []outOfBand{},
+	},
+
+	"GET /appscode/exchange-rates": {
+		summary: "List the exchange rates",
+		tag:     "currencies",
+		query: []apiParam{
+			{name: "base", typ: "string", description: "Only the rates of this currency"},
+			{name: "quote", typ: "string", description: "Only the rates in this currency"},
+		},
+		result: []ExchangeRate{},
+	},
+	"POST /appscode/exchange-rates": {
+		summary:   "Add exchange rates",
+		tag:       "currencies",
+		body:      []ExchangeRate{},
+		bodyTypes: []string{"text/csv"},
+		statuses:  []int{http.StatusCreated},
+		result:    []ExchangeRate{},
+	},
+	"DELETE /appscode/exchange-rates/:base/:quote/:date": {
+		summary: "Delete an exchange rate",
+		tag:     "currencies",
+		result:  StatusMessage{},
+	},
+
+	"GET /appscode/webhooks": {
+		summary: "List the webhooks, for admins",
+		tag:     "webhooks",
+		result:  []Webhook{},
+		errors:  []int{http.StatusForbidden},
+	},
+	"GET /appscode/webhooks/:id": {
+		summary: "Show a webhook, for admins",
+		tag:     "webhooks",
+		result:  Webhook{},
+		errors:  []int{http.StatusForbidden},
+	},
+	"POST /appscode/webhooks": {
+		summary:  "Subscribe a URL to the changes of the workers, the secret is shown only here",
+		tag:      "webhooks",
+		body:     Webhook{},
+		statuses: []int{http.StatusCreated},
+		result:   Webhook{},
+		errors:   []int{http.StatusForbidden},
+	},
+	"PUT /appscode/webhooks/:id": {
+		summary: "Update a webhook, the secret is kept unless a new one is given",
+		tag:     "webhooks",
+		body:    Webhook{},
+		result:  Webhook{},
+		errors:  []int{http.StatusForbidden, http.StatusConflict},
+	},
+	"DELETE /appscode/webhooks/:id": {
+		summary: "Delete a webhook along with its deliveries",
+		tag:     "webhooks",
+		result:  StatusMessage{},
+		errors:  []int{http.StatusForbidden},
+	},
+	"GET /appscode/webhooks/:id/deliveries": {
+		summary: "The delivery log of a webhook, newest first",
+		tag:     "webhooks",
+		query: []apiParam{
+			{name: "state", typ: "string", description: "Only the deliveries in this state", enum: []string{DeliveryPending, DeliveryDelivered, DeliveryDead}},
+		},
+		result: []WebhookDelivery{},
+		errors: []int{http.StatusForbidden, http.StatusUnprocessableEntity},
+	},
+	"POST /appscode/webhooks/:id/deliveries/:delivery/retry": {
+		summary: "Send a dead delivery again",
+		tag:     "webhooks",
+		result:  WebhookDelivery{},
+		errors:  []int{http.StatusForbidden, http.StatusConflict},
+	},
+	"GET /appscode/webhooks/dead-letters": {
+		summary: "The deliveries of every webhook that failed all of their attempts",
+		tag:     "webhooks",
+		result:  []WebhookDelivery{},
+		errors:  []int{http.StatusForbidden},
+	},
+
+	"GET /appscode/stats": {
+		summary: "Salary statistics of groups of workers",
+		tag:     "statistics",
+		query: params(statsFilterParams, []apiParam{
+			{name: "percentiles", typ: "string", description: "Comma separated percentiles, 25,75,90 by default"},
+		}, currencyParams),
+		result: []SalaryStats{},
+		errors: []int{http.StatusUnprocessableEntity},
+	},
+	"GET /appscode/stats/headcount": {
+		summary: "The headcount at the end of each month",
+		tag:     "statistics",
+		query: params(statsFilterParams, []apiParam{
+			{name: "from", typ: "string", description: "The first month, YYYY-MM, 11 months ago by default"},
+			{name: "to", typ: "string", description: "The last month, YYYY-MM, this month by default"},
+		}),
+		result: []HeadcountPoint{},
+		errors: []int{http.StatusUnprocessableEntity},
+	},
+	"GET /appscode/cache": {
+		summary: "Count the hits and misses of the cached worker reads, for admins",
+		tag:     "general",
+		result:  CacheStats{},
+		errors:  []int{http.StatusForbidden},
+	},
+
+	"GET /locations": {
+		summary: "List the countries",
+		tag:     "locations",
+		result:  []Country{},
+	},
+	"GET /locations/:country": {
+		summary: "List the divisions of a country",
+		tag:     "locations",
+		result:  []Division{},
+	},
+	"GET /locations/:country/:division": {
+		summary: "Show a division with its cities",
+		tag:     "locations",
+		result:  divisionWithCities{},
+	},
+}
+
+// docsPage lists the operations of /openapi.json with their parameters,
+// bodies and responses, without any script from outside of the server
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>apiserver API</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 60em; color: #222; }
+h2 { border-bottom: 1px solid #ccc; text-transform: capitalize; }
+details { margin: .5em 0; border: 1px solid #ddd; border-radius: 4px; padding: .4em .8em; }
+summary { cursor: pointer; }
+.method { display: inline-block; width: 4.5em; font-weight: bold; }
+.get { color: #1565c0; } .post { color: #2e7d32; } .put { color: #ef6c00; } .delete { color: #c62828; }
+code, pre { background: #f5f5f5; }
+pre { padding: .5em; overflow: auto; }
+table { border-collapse: collapse; }
+td, th { text-align: left; padding: .2em .8em .2em 0; vertical-align: top; }
+</style>
+</head>
+<body>
+<h1>apiserver API</h1>
+<p id="info">Loading <a href="/openapi.json">/openapi.json</a>...</p>
+<div id="operations"></div>
+<script>
+function el(tag, attrs, children) {
+  var e = document.createElement(tag);
+  Object.keys(attrs || {}).forEach(function (k) { e.setAttribute(k, attrs[k]); });
+  (children || []).forEach(function (c) { e.appendChild(typeof c === "string" ? document.createTextNode(c) : c); });
+  return e;
+}
+function resolve(doc, schema) {
+  while (schema && schema.$ref) {
+    schema = doc.components.schemas[schema.$ref.split("/").pop()];
+  }
+  return schema || {};
+}
+function describe(doc, schema, depth) {
+  var ref = schema && schema.$ref ? schema.$ref.split("/").pop() : "";
+  schema = resolve(doc, schema);
+  if (schema.type === "array") {
+    return "[" + describe(doc, schema.items, depth) + "]";
+  }
+  if (schema.type === "object" && schema.properties && depth < 2) {
+    var required = schema.required || [];
+    var lines = Object.keys(schema.properties).map(function (name) {
+      var p = schema.properties[name];
+      return "  ".repeat(depth + 1) + name + (required.indexOf(name) >= 0 ? "*" : "") + ": " + describe(doc, p, depth + 1);
+    });
+    return (ref ? ref + " " : "") + "{\n" + lines.join("\n") + "\n" + "  ".repeat(depth) + "}";
+  }
+  return ref || schema.type || "any";
+}
+fetch("/openapi.json").then(function (r) { return r.json(); }).then(function (doc) {
+  document.getElementById("info").textContent = doc.info.description + " Version " + doc.info.version + ".";
+  var byTag = {};
+  Object.keys(doc.paths).sort().forEach(function (path) {
+    Object.keys(doc.paths[path]).forEach(function (method) {
+      var op = doc.paths[path][method];
+      (byTag[op.tags[0]] = byTag[op.tags[0]] || []).push({ path: path, method: method, op: op });
+    });
+  });
+  var root = document.getElementById("operations");
+  Object.keys(byTag).sort().forEach(function (tag) {
+    root.appendChild(el("h2", {}, [tag]));
+    byTag[tag].forEach(function (o) {
+      var body = [];
+      if (o.op.parameters) {
+        body.push(el("table", {}, [el("tr", {}, [el("th", {}, ["Parameter"]), el("th", {}, ["In"]), el("th", {}, ["Description"])])].concat(
+          o.op.parameters.map(function (p) {
+            return el("tr", {}, [el("td", {}, [el("code", {}, [p.name])]), el("td", {}, [p.in]), el("td", {}, [p.description || ""])]);
+          }))));
+      }
+      if (o.op.requestBody) {
+        var types = Object.keys(o.op.requestBody.content);
+        body.push(el("p", {}, ["Body: " + types.join(", ")]));
+        body.push(el("pre", {}, [describe(doc, o.op.requestBody.content[types[0]].schema, 0)]));
+      }
+      Object.keys(o.op.responses).sort().forEach(function (status) {
+        var response = o.op.responses[status];
+        var types = Object.keys(response.content || {});
+        var text = status + " " + response.description + (types.length ? " - " + types.join(", ") : "");
+        body.push(el("p", {}, [text]));
+        if (status < 300 && types.length) {
+          body.push(el("pre", {}, [describe(doc, response.content[types[0]].schema, 0)]));
+        }
+      });
+      root.appendChild(el("details", {}, [el("summary", {}, [
+        el("span", { "class": "method " + o.method }, [o.method.toUpperCase()]),
+        el("code", {}, [o.path]), " " + o.op.summary + (o.op.security ? " (public)" : "")
+      ])].concat(body)));
+    });
+  });
+}).catch(function (err) {
+  document.getElementById("info").textContent = "Can't load /openapi.json: " + err;
+});
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
+	"gopkg.in/macaron.v1"
+)
+
+// AuthProvider checks the credentials sent with a request
+type AuthProvider interface {
+	Authenticate(username, password string) bool
+}
+
+// StaticAuth authenticates against a fixed username to password map
+type StaticAuth map[string]string
+
+func (a StaticAuth) Authenticate(username, password string) bool {
+	pass, exist := a[username]
+	return exist && pass == password
+}
+
+// Roles grant users extra rights
+const (
+	// RoleHR lets a user put a salary outside of its position's band,
+	// and export the salaries of the workers
+	RoleHR = "hr"
+	// RoleAdmin lets a user manage the webhooks
+	RoleAdmin = "admin"
+)
+
+// DefaultRoles returns the roles granted when WithRoles isn't given
+func DefaultRoles() map[string][]string {
+	return map[string][]string{
+		"admin": {RoleHR, RoleAdmin},
+	}
+}
+
+// DefaultAuth returns the users the server accepts when no AuthProvider is given
+func DefaultAuth() StaticAuth {
+	return StaticAuth{
+		"masud": "pass",
+		"admin": "admin",
+	}
+}
+
+// APIKeyHeader is the header the API keys are sent in
+const APIKeyHeader = "X-API-Key"
+
+// checkCredentials checks the Basic or Bearer Authorization header of a
+// request, or else its API key, and returns the username they are for.
+// For an API key it returns the key's ID as well, see apiKeyID.
+func (s *Server) checkCredentials(authHeader, apiKey string) (string, string, error) {
+	if authHeader == "" && apiKey != "" {
+		username, exist := s.apiKeys[apiKey]
+		if !exist {
+			return "", "", unauthorized("Unknown API key")
+		}
+		return username, apiKeyID(apiKey), nil
+	}
+
+	authInfo := strings.SplitN(authHeader, " ", 2)
+	if len(authInfo) == 2 && strings.EqualFold(authInfo[0], "Bearer") {
+		username, exist := s.tokens[authInfo[1]]
+		if !exist {
+			return "", "", unauthorized("Unknown token")
+		}
+		return username, "", nil
+	}
+	username, err := s.checkBasicAuth(authHeader)
+	return username, "", err
+}
+
+// apiKeyID tells the API keys apart without holding on to them
+func apiKeyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// checkBasicAuth checks the credentials of a Basic Authorization header,
+// and returns the username they are for
+func (s *Server) checkBasicAuth(authHeader string) (string, error) {
+	if authHeader == "" {
+		return "", unauthorized("Authorization Needed...!")
+	}
+
+	authInfo := strings.SplitN(authHeader, " ", 2)
+	if len(authInfo) != 2 || !strings.EqualFold(authInfo[0], "Basic") {
+		return "", unauthorized("Authorization failed...!")
+	}
+
+	userInfo, err := base64.StdEncoding.DecodeString(authInfo[1])
+	if err != nil {
+		return "", unauthorized("Error while decoding...!")
+	}
+	userPass := strings.SplitN(string(userInfo), ":", 2)
+
+	if len(userPass) != 2 {
+		return "", unauthorized("Authorization failed...!")
+	}
+
+	if !s.auth.Authenticate(userPass[0], userPass[1]) {
+		return "", unauthorized("Unauthorized User")
+	}
+	return userPass[0], nil
+}
+
+// hasRole tells if the user of the request was granted role,
+// every request has all roles when authentication is bypassed
+func (s *Server) hasRole(ctx *macaron.Context, role string) bool {
+	return s.userHasRole(user(ctx), role)
+}
+
+// userHasRole tells if the user was granted role
+func (s *Server) userHasRole(user, role string) bool {
+	if s.bypassAuth {
+		return true
+	}
+	return contains(s.roles[user], role)
+}
+
+// user returns the username the request was authenticated with
+func user(ctx *macaron.Context) string {
+	user, _ := ctx.Data["User"].(string)
+	return user
+}
+
+// isPublic tells if the route of a request needs no credentials, the
+// public routes are the ones apiOperations marks so
+func isPublic(method, path string) bool {
+	op, ok := apiOperations[operationKey(method, path)]
+	return ok && op.public
+}
+
+// identify checks the credentials of a request, keeping the user they are
+// for or the error authenticate answers with. The requests are rate
+// limited in between, so the ones with wrong credentials count as well.
+func (s *Server) identify(ctx *macaron.Context) {
+	if s.bypassAuth || isPublic(ctx.Req.Method, ctx.Req.URL.Path) {
+		return
+	}
+	username, key, err := s.checkCredentials(ctx.Req.Header.Get("Authorization"), ctx.Req.Header.Get(APIKeyHeader))
+	if err != nil {
+		ctx.Data["AuthError"] = err
+		return
+	}
+	ctx.Data["User"] = username
+	if key != "" {
+		ctx.Data["APIKey"] = key
+	}
+}
+
+// authenticate turns away the requests whose credentials identify refused
+func (s *Server) authenticate(ctx *macaron.Context) {
+	if err, ok := ctx.Data["AuthError"].(error); ok {
+		ctx.Resp.Header().Set("WWW-Authenticate", `Basic realm="apiserver"`)
+		s.renderError(ctx, err)
+	}
+}
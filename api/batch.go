@@ -0,0 +1,200 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"gopkg.in/macaron.v1"
+)
+
+// Batch operations
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+// maxBatchOperations is the most operations a batch may hold
+const maxBatchOperations = 100
+
+// Batch is a list of changes to the workers made in one request
+type Batch struct {
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchOperation creates, updates or deletes a worker. Worker is the
+// worker to create, or the new profile of the one to update, which
+// replaces the old one as PUT does. ReassignTo takes over the reports of
+// the worker deleted.
+type BatchOperation struct {
+	Op         string  `json:"op" validate:"required,oneof=create update delete"`
+	Username   string  `json:"username,omitempty" validate:"max=32"`
+	Worker     *Worker `json:"worker,omitempty"`
+	ReassignTo string  `json:"reassign_to,omitempty" validate:"max=32"`
+}
+
+// BatchResult is the outcome of one operation, with the status and the
+// body the operation would have got as a request of its own
+type BatchResult struct {
+	Op       string  `json:"op"`
+	Username string  `json:"username,omitempty"`
+	Status   int     `json:"status"`
+	Worker   *Worker `json:"worker,omitempty"`
+	Error    *Error  `json:"error,omitempty"`
+}
+
+// BatchReport lists the results of the operations of a batch in their
+// order. Succeeded counts the operations written, in the all mode none of
+// them are once one fails.
+type BatchReport struct {
+	Mode      string        `json:"mode"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Results   []BatchResult `json:"results"`
+}
+
+// runBatchOperation makes the change of the operation sent by user, and
+// returns its result. Only the errors that aren't the operation's fault
+// are returned, they stop the batch.
+func (s *Server) runBatchOperation(user string, op *BatchOperation) (BatchResult, error) {
+	result := BatchResult{Op: op.Op, Username: op.Username}
+	if op.Op == BatchCreate && op.Worker != nil {
+		result.Username = op.Worker.Username
+	}
+
+	var err error
+	if errs := validateBatchOperation(op); len(errs) > 0 {
+		err = validationFailed(errs...)
+	} else {
+		switch op.Op {
+		case BatchCreate:
+			worker := *op.Worker
+			if err = s.addWorker(user, &worker); err == nil {
+				result.Status, result.Worker = http.StatusCreated, &worker
+			}
+		case BatchUpdate:
+			worker := *op.Worker
+			var updated *Worker
+			if updated, err = s.updateWorker(user, op.Username, &worker); err == nil {
+				result.Status, result.Worker = http.StatusOK, updated
+			}
+		case BatchDelete:
+			if err = s.removeWorker(op.Username, op.ReassignTo); err == nil {
+				result.Status = http.StatusOK
+			}
+		}
+	}
+
+	if e, ok := err.(*Error); ok && e.Status < http.StatusInternalServerError {
+		result.Status, result.Error = e.Status, e
+		return result, nil
+	}
+	return result, err
+}
+
+// validateBatchOperation checks that the operation names what it changes
+func validateBatchOperation(op *BatchOperation) []FieldError {
+	errs := validate(op, opCreate)
+	if hasFieldError(errs, "op") {
+		return errs
+	}
+	if op.Op != BatchCreate && op.Username == "" {
+		errs = append(errs, FieldError{Field: "username", Message: "is required to " + op.Op + " a worker"})
+	}
+	if op.Op != BatchDelete && op.Worker == nil {
+		errs = append(errs, FieldError{Field: "worker", Message: "is required to " + op.Op + " a worker"})
+	}
+	if op.Op != BatchDelete && op.ReassignTo != "" {
+		errs = append(errs, FieldError{Field: "reassign_to", Message: "can only be given to delete a worker"})
+	}
+	if op.Op == BatchDelete && op.Worker != nil {
+		errs = append(errs, FieldError{Field: "worker", Message: "can't be given to delete a worker"})
+	}
+	return errs
+}
+
+// errBatchRollback rolls back the transaction of a batch that failed
+var errBatchRollback = fmt.Errorf("batch rolled back")
+
+// Batch handlers
+
+// runBatch makes the changes of several operations in one request. In the
+// all mode, the default, they are made in one transaction and written only
+// if every one of them succeeds, the others are reported as rolled back
+// with 424. In the best-effort mode each is written on its own. Either way
+// every operation is run, so a later one sees the changes of the earlier
+// ones, and the results are answered with 207.
+func (s *Server) runBatch(ctx *macaron.Context) error {
+	mode := ctx.QueryTrim("mode")
+	if mode == "" {
+		mode = ImportAll
+	}
+	if mode != ImportAll && mode != ImportBestEffort {
+		return validationFailed(FieldError{Field: "mode", Message: "must be one of all, best-effort"})
+	}
+	var batch Batch
+	if err := s.decode(ctx, &batch); err != nil {
+		return err
+	}
+	switch {
+	case len(batch.Operations) == 0:
+		return validationFailed(FieldError{Field: "operations", Message: "must hold at least one operation"})
+	case len(batch.Operations) > maxBatchOperations:
+		return validationFailed(FieldError{Field: "operations", Message: fmt.Sprintf("must hold at most %d operations", maxBatchOperations)})
+	}
+
+	report := BatchReport{Mode: mode, Results: make([]BatchResult, len(batch.Operations))}
+	count := func() {
+		report.Succeeded, report.Failed = 0, 0
+		for _, result := range report.Results {
+			if result.Error == nil {
+				report.Succeeded++
+			} else if result.Status != http.StatusFailedDependency {
+				report.Failed++
+			}
+		}
+	}
+
+	if mode == ImportBestEffort {
+		for i := range batch.Operations {
+			result, err := s.runBatchOperation(user(ctx), &batch.Operations[i])
+			if err != nil {
+				return err
+			}
+			report.Results[i] = result
+		}
+		count()
+		return s.render(ctx, http.StatusMultiStatus, report)
+	}
+
+	err := s.store.InTransaction(func(tx Store) error {
+		bound := s.withStore(tx)
+		for i := range batch.Operations {
+			result, err := bound.runBatchOperation(user(ctx), &batch.Operations[i])
+			if err != nil {
+				return err
+			}
+			report.Results[i] = result
+		}
+		count()
+		if report.Failed > 0 {
+			return errBatchRollback
+		}
+		return nil
+	})
+	if err == errBatchRollback {
+		rolledBack := NewError(http.StatusFailedDependency, ProblemFailedDependency, "Rolled back, as another operation of the batch failed")
+		for i := range report.Results {
+			if report.Results[i].Error == nil {
+				report.Results[i].Status, report.Results[i].Worker, report.Results[i].Error = http.StatusFailedDependency, nil, rolledBack
+			}
+		}
+		count()
+	} else if err != nil {
+		return err
+	}
+	if report.Succeeded > 0 {
+		s.notifyChange()
+	}
+	return s.render(ctx, http.StatusMultiStatus, report)
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatch(t *testing.T) {
+	srvr := newTestServer(t)
+
+	// Rahim joins under masud, takes over jenny, and fahim leaves
+	reorganisation := `{"operations":[
+	{"op":"create","worker":{"username":"rahim","firstname":"Rahim","lastname":"Uddin","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":6000,"manager":"masud"}},
+	{"op":"update","username":"jenny","worker":{"firstname":"Jannatul","lastname":"Ferdows","city":"Chattogram","division":"Chattogram","position":"Software Engineer","salary":6000,"manager":"rahim"}},
+	{"op":"delete","username":"fahim"}
+]}`
+	// Karim can't be kept, as tahsin's manager doesn't exist and masud has
+	// rahim reporting to them
+	failing := `{"operations":[
+	{"op":"create","worker":{"username":"karim","firstname":"Karim","lastname":"Uddin","city":"Khulna","division":"Khulna","position":"Software Engineer","salary":6000}},
+	{"op":"update","username":"tahsin","worker":{"firstname":"Tahsin","lastname":"Rahman","city":"Chattogram","division":"Chattogram","position":"Software Engineer","salary":5500,"manager":"nobody"}},
+	{"op":"delete","username":"masud"}
+]}`
+
+	for _, data := range []testData{
+		{"batch_workers", "POST", "/appscode/workers/batch", 207, strings.NewReader(reorganisation)},
+		{"show_worker_batch_updated", "GET", "/appscode/workers/jenny", 200, nil},
+		{"batch_workers_rolled_back", "POST", "/appscode/workers/batch", 207, strings.NewReader(failing)},
+		{"show_worker_batch_rolled_back", "GET", "/appscode/workers/karim", 404, nil},
+		{"batch_workers_best_effort", "POST", "/appscode/workers/batch?mode=best-effort", 207, strings.NewReader(failing)},
+		{"batch_workers_invalid_operations", "POST", "/appscode/workers/batch?mode=best-effort", 207, strings.NewReader(`{"operations":[
+	{"op":"move","username":"jenny"},
+	{"op":"update","worker":{"firstname":"Jannatul"}},
+	{"op":"delete","username":"jenny","worker":{"firstname":"Jannatul"}},
+	{"op":"create","reassign_to":"masud"}
+]}`)},
+		{"batch_workers_empty", "POST", "/appscode/workers/batch", 422, strings.NewReader(`{"operations":[]}`)},
+		{"batch_workers_bad_mode", "POST", "/appscode/workers/batch?mode=some", 422, strings.NewReader(reorganisation)},
+	} {
+		runTest(t, srvr, data)
+	}
+
+	// Karim was kept by the best-effort batch
+	serveTest(t, srvr, testData{"", "GET", "/appscode/workers/karim", 200, nil})
+	serveTest(t, srvr, testData{"", "GET", "/appscode/workers/fahim", 404, nil})
+}
+
+func TestBatchTooLarge(t *testing.T) {
+	srvr := newTestServer(t)
+	ops := strings.Repeat(`{"op":"delete","username":"jenny"},`, maxBatchOperations)
+	runTest(t, srvr, testData{"batch_workers_too_many", "POST", "/appscode/workers/batch", 422, strings.NewReader(`{"operations":[` + ops + `{"op":"delete","username":"jenny"}]}`)})
+}
+
+func TestBatchInvalidatesOnCommit(t *testing.T) {
+	srvr := newTestServer(t, WithResponseCache(NewLRUCache(1<<20), time.Minute))
+	invalidations := srvr.CacheStats().Invalidations
+
+	// A batch rolled back changed nothing, one committed is one change
+	for _, test := range []struct {
+		ops      string
+		expected int64
+	}{
+		{`{"op":"delete","username":"jenny"},{"op":"delete","username":"nobody"}`, 0},
+		{`{"op":"delete","username":"jenny"},{"op":"delete","username":"fahim"}`, 1},
+	} {
+		serveTest(t, srvr, testData{"", "POST", "/appscode/workers/batch", 207, strings.NewReader(`{"operations":[` + test.ops + `]}`)})
+		if got := srvr.CacheStats().Invalidations - invalidations; got != test.expected {
+			t.Errorf("%s: got %d invalidations expected %d", test.ops, got, test.expected)
+		}
+		invalidations += test.expected
+	}
+}
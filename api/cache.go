@@ -0,0 +1,375 @@
+package api
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"gopkg.in/macaron.v1"
+)
+
+// cacheHeader tells if a response was served from the cache
+const cacheHeader = "X-Cache"
+
+// CachedResponse is a response kept in a ResponseCache
+type CachedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// ResponseCache keeps the responses of the worker reads by their keys. The
+// LRUCache keeps them in memory, a cache shared by the servers can be
+// plugged in instead. It must be safe for concurrent use.
+type ResponseCache interface {
+	// Get returns the response kept for key, unless its ttl passed
+	Get(key string) (*CachedResponse, bool)
+	// Set keeps the response for ttl at most, or until it is purged
+	Set(key string, response *CachedResponse, ttl time.Duration)
+	// Purge drops every response, as the workers changed
+	Purge()
+}
+
+// LRUCache is a ResponseCache in memory holding up to a size in bytes,
+// dropping the least recently used responses beyond it
+type LRUCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	order    *list.List
+	entries  map[string]*list.Element
+	// now tells the time the responses expire by
+	now func() time.Time
+}
+
+type lruEntry struct {
+	key      string
+	response *CachedResponse
+	size     int64
+	expires  time.Time
+}
+
+// NewLRUCache returns an empty cache holding up to maxBytes of responses
+func NewLRUCache(maxBytes int64) *LRUCache {
+	return &LRUCache{maxBytes: maxBytes, order: list.New(), entries: make(map[string]*list.Element), now: time.Now}
+}
+
+// Get returns the response kept for key, dropping it if it expired
+func (c *LRUCache) Get(key string) (*CachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*lruEntry)
+	if !c.now().Before(entry.expires) {
+		c.remove(e)
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return entry.response, true
+}
+
+// Set keeps the response for ttl. The expired responses are dropped when
+// read, or before the ones still fresh when making room for this one.
+func (c *LRUCache) Set(key string, response *CachedResponse, ttl time.Duration) {
+	size := int64(len(key) + len(response.Body))
+	for name, values := range response.Header {
+		size += int64(len(name))
+		for _, v := range values {
+			size += int64(len(v))
+		}
+	}
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	now := c.now()
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, response: response, size: size, expires: now.Add(ttl)})
+	c.bytes += size
+	if c.bytes > c.maxBytes {
+		for e := c.order.Back(); e != nil; {
+			prev := e.Prev()
+			if !now.Before(e.Value.(*lruEntry).expires) {
+				c.remove(e)
+			}
+			e = prev
+		}
+	}
+	for c.bytes > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *LRUCache) remove(e *list.Element) {
+	entry := c.order.Remove(e).(*lruEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.size
+}
+
+func (c *LRUCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.bytes = 0
+}
+
+// Len returns how many responses the cache holds
+func (c *LRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// CacheStats counts how the cached reads of the workers were served
+type CacheStats struct {
+	Enabled       bool  `json:"enabled"`
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	Invalidations int64 `json:"invalidations"`
+	// Entries is how many responses are cached, for caches that tell
+	Entries int `json:"entries,omitempty"`
+}
+
+// responseCache serves the worker reads from a ResponseCache. Every purge
+// starts a new generation, and a response read in an older one isn't
+// kept, as the workers may have changed while it was read.
+type responseCache struct {
+	cache ResponseCache
+	ttl   time.Duration
+
+	mu         sync.Mutex
+	generation int64
+	// version is the resource version of the last event seen, known once
+	// the store was checked for changes
+	version      int64
+	versionKnown bool
+	stats        CacheStats
+}
+
+func (c *responseCache) get(key string) (*CachedResponse, int64, bool) {
+	response, ok := c.cache.Get(key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ok {
+		c.stats.Hits++
+		return response, c.generation, true
+	}
+	c.stats.Misses++
+	return nil, c.generation, false
+}
+
+func (c *responseCache) set(key string, response *CachedResponse, generation int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation == c.generation {
+		c.cache.Set(key, response, c.ttl)
+	}
+}
+
+func (c *responseCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.stats.Invalidations++
+	c.cache.Purge()
+}
+
+// cached serves the GET requests of h from the cache, keyed by the URL,
+// the formats the client accepts and the roles of the user, which decide
+// what the user may see. The salaries converted with ?currency= depend on
+// the exchange rates as well, those requests always go to h.
+func (s *Server) cached(h func(*macaron.Context) error) func(*macaron.Context) error {
+	return func(ctx *macaron.Context) error {
+		if s.cache == nil || ctx.Query("currency") != "" || ctx.Req.Header.Get("Cache-Control") == "no-cache" {
+			return h(ctx)
+		}
+		key := s.cacheScope(ctx) + "\n" + ctx.Req.Header.Get("Accept") + "\n" + ctx.Req.URL.RequestURI()
+		response, generation, ok := s.cache.get(key)
+		if ok {
+			for name, values := range response.Header {
+				ctx.Resp.Header()[name] = append([]string(nil), values...)
+			}
+			ctx.Resp.Header().Set(cacheHeader, "hit")
+			ctx.Resp.WriteHeader(response.Status)
+			if _, err := ctx.Resp.Write(response.Body); err != nil {
+				s.logger.Println(err)
+			}
+			return nil
+		}
+
+		// Only the headers set by h are kept, not the ones of this request
+		ctx.Resp.Header().Set(cacheHeader, "miss")
+		before := make(http.Header)
+		for name, values := range ctx.Resp.Header() {
+			before[name] = values
+		}
+		recorder := &responseRecorder{ResponseWriter: ctx.Resp}
+		ctx.Resp = recorder
+		err := h(ctx)
+		ctx.Resp = recorder.ResponseWriter
+		if err != nil || recorder.Status() != http.StatusOK {
+			return err
+		}
+
+		response = &CachedResponse{Status: recorder.Status(), Header: make(http.Header), Body: recorder.body.Bytes()}
+		for name, values := range recorder.Header() {
+			if strings.Join(before[name], "\n") != strings.Join(values, "\n") {
+				response.Header[name] = values
+			}
+		}
+		s.cache.set(key, response, generation)
+		return nil
+	}
+}
+
+// cacheScope is what the user may see, told by their roles
+func (s *Server) cacheScope(ctx *macaron.Context) string {
+	if s.bypassAuth {
+		return "*"
+	}
+	roles := append([]string(nil), s.roles[user(ctx)]...)
+	sort.Strings(roles)
+	return strings.Join(roles, ",")
+}
+
+// invalidateCache drops the cached responses after this server changed
+// workers
+func (s *Server) invalidateCache() {
+	if s.cache != nil {
+		s.cache.purge()
+	}
+}
+
+// InvalidateCache drops the cached responses if the workers changed since
+// it last looked, by this server or another one sharing the database, and
+// tells if they did. Run looks every event poll interval, and right away
+// when Postgres notifies of a change.
+func (s *Server) InvalidateCache() (bool, error) {
+	if s.cache == nil {
+		return false, nil
+	}
+	_, last, err := s.store.EventVersions()
+	if err != nil {
+		return false, err
+	}
+	s.cache.mu.Lock()
+	changed := !s.cache.versionKnown || last != s.cache.version
+	s.cache.version, s.cache.versionKnown = last, true
+	s.cache.mu.Unlock()
+	if changed {
+		s.cache.purge()
+	}
+	return changed, nil
+}
+
+// CacheStats returns the counts of the cached reads since the server
+// started
+func (s *Server) CacheStats() CacheStats {
+	if s.cache == nil {
+		return CacheStats{}
+	}
+	s.cache.mu.Lock()
+	stats := s.cache.stats
+	s.cache.mu.Unlock()
+	stats.Enabled = true
+	if c, ok := s.cache.cache.(interface{ Len() int }); ok {
+		stats.Entries = c.Len()
+	}
+	return stats
+}
+
+// EventNotifier is implemented by the stores telling of the events as the
+// servers sharing the database commit them
+type EventNotifier interface {
+	// NotifyEvents calls fn after events were committed, until ctx is
+	// done. It returns ErrNoNotifications if the database can't tell.
+	NotifyEvents(ctx context.Context, fn func()) error
+}
+
+// ErrNoNotifications is returned by the stores whose database doesn't
+// notify of the events
+var ErrNoNotifications = errors.New("the database doesn't notify of events")
+
+// eventChannel is the Postgres channel notified of the committed events
+const eventChannel = "worker_event"
+
+// NotifyEvents listens to the notifications of the events on Postgres,
+// reconnecting when the connection is lost
+func (s *XormStore) NotifyEvents(ctx context.Context, fn func()) error {
+	if !s.postgres() {
+		return ErrNoNotifications
+	}
+	listener := pq.NewListener(s.engine.DataSourceName(), time.Second, time.Minute, nil)
+	defer listener.Close()
+	if err := listener.Listen(eventChannel); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-listener.Notify:
+			// A nil notification after reconnecting is a change too, as
+			// the ones sent meanwhile were missed
+			fn()
+		case <-time.After(90 * time.Second):
+			go listener.Ping()
+		}
+	}
+}
+
+// runCacheInvalidation invalidates the cache after the changes of the
+// other servers until ctx is done
+func (s *Server) runCacheInvalidation(ctx context.Context) {
+	wake := make(chan struct{}, 1)
+	if notifier, ok := s.store.(EventNotifier); ok {
+		go func() {
+			err := notifier.NotifyEvents(ctx, func() {
+				select {
+				case wake <- struct{}{}:
+				default:
+				}
+			})
+			if err != nil && err != ErrNoNotifications {
+				s.logger.Println("listening to the events:", err)
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(s.eventPollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.InvalidateCache(); err != nil {
+			s.logger.Println("invalidating the cache:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-wake:
+		}
+	}
+}
+
+// Cache handlers
+
+func (s *Server) showCacheStats(ctx *macaron.Context) error {
+	if err := s.checkAdmin(ctx); err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusOK, s.CacheStats())
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	clock := &movingClock{now: testTime.Now()}
+	cache := NewLRUCache(1 << 20)
+	cache.now = clock.Now
+	srvr := newTestServer(t, WithClock(clock), WithResponseCache(cache, time.Minute))
+	// Seeding the workers invalidated the cache already
+	seeded := srvr.CacheStats().Invalidations
+
+	rec := serveTest(t, srvr, testData{"cache_miss", "GET", "/appscode/workers/jenny", 200, nil})
+	checkGolden(t, "cache_miss", dumpResponse(rec))
+	rec = serveTest(t, srvr, testData{"cache_hit", "GET", "/appscode/workers/jenny", 200, nil})
+	checkGolden(t, "cache_hit", dumpResponse(rec))
+
+	// The lists are cached by their query, and the errors aren't cached
+	for _, data := range []testData{
+		{"", "GET", "/appscode/workers?limit=2", 200, nil},
+		{"", "GET", "/appscode/workers?limit=3", 200, nil},
+		{"", "GET", "/appscode/workers/nobody", 404, nil},
+	} {
+		serveTest(t, srvr, data)
+	}
+	rec = serveTest(t, srvr, testData{"cache_hit_list", "GET", "/appscode/workers?limit=2", 200, nil})
+	checkGolden(t, "cache_hit_list", dumpResponse(rec))
+	expectCacheHeader(t, srvr, "/appscode/workers/nobody", 404, "miss")
+	expectCacheHeader(t, srvr, "/appscode/workers/jenny?currency=USD", 422, "")
+
+	// Every change of the workers drops the cached responses
+	rahim := `{"username":"rahim","firstname":"Rahim","lastname":"Uddin","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500}`
+	updated := `{"firstname":"Jannatul","lastname":"Ferdows","city":"Dhaka","division":"Dhaka","position":"Software Engineer","salary":5500}`
+	for _, change := range []testData{
+		{"", "POST", "/appscode/workers", 201, strings.NewReader(rahim)},
+		{"", "PUT", "/appscode/workers/jenny", 201, strings.NewReader(updated)},
+		{"", "DELETE", "/appscode/workers/rahim", 200, nil},
+	} {
+		expectCacheHeader(t, srvr, "/appscode/workers/jenny", 200, "hit")
+		serveTest(t, srvr, change)
+		expectCacheHeader(t, srvr, "/appscode/workers/jenny", 200, "miss")
+	}
+	rec = serveTest(t, srvr, testData{"cache_hit_updated", "GET", "/appscode/workers/jenny", 200, nil})
+	checkGolden(t, "cache_hit_updated", dumpResponse(rec))
+
+	// The responses expire after the TTL
+	clock.now = clock.now.Add(time.Minute)
+	expectCacheHeader(t, srvr, "/appscode/workers/jenny", 200, "miss")
+
+	rec = serveTest(t, srvr, testData{"", "GET", "/appscode/cache", 200, nil})
+	var stats CacheStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	expected := CacheStats{Enabled: true, Hits: 6, Misses: 9, Invalidations: seeded + 3, Entries: 1}
+	if stats != expected {
+		t.Errorf("got the stats %+v expected %+v", stats, expected)
+	}
+}
+
+func TestCacheScope(t *testing.T) {
+	srvr := newTestServer(t, WithBypassAuth(false), WithAuthProvider(StaticAuth{"masud": "pass", "admin": "admin"}),
+		WithResponseCache(NewLRUCache(1<<20), time.Minute))
+	masud := http.Header{"Authorization": {"Basic bWFzdWQ6cGFzcw=="}}
+	admin := http.Header{"Authorization": {"Basic YWRtaW46YWRtaW4="}}
+
+	// The users with other roles don't share the responses
+	for _, test := range []struct {
+		header http.Header
+		cache  string
+	}{
+		{masud, "miss"},
+		{masud, "hit"},
+		{admin, "miss"},
+		{admin, "hit"},
+		{http.Header{"Authorization": {"Basic YWRtaW46YWRtaW4="}, "Cache-Control": {"no-cache"}}, ""},
+	} {
+		rec := serveTestWithHeader(t, srvr, testData{"", "GET", "/appscode/workers/jenny", 200, nil}, test.header)
+		if got := rec.Header().Get(cacheHeader); got != test.cache {
+			t.Errorf("got X-Cache %q expected %q", got, test.cache)
+		}
+	}
+	serveTestWithHeader(t, srvr, testData{"", "GET", "/appscode/cache", 403, nil}, masud)
+}
+
+func TestCacheAcrossServers(t *testing.T) {
+	srvr := newTestServer(t)
+	other := NewServer(WithStore(srvr.store), WithBypassAuth(true), WithClock(testTime), WithLogger(log.New(ioutil.Discard, "", 0)),
+		WithResponseCache(NewLRUCache(1<<20), time.Hour))
+
+	if changed, err := other.InvalidateCache(); err != nil || !changed {
+		t.Errorf("got %v, %v, expected the first look to drop the cache", changed, err)
+	}
+	expectCacheHeader(t, other, "/appscode/workers/jenny", 200, "miss")
+	expectCacheHeader(t, other, "/appscode/workers/jenny", 200, "hit")
+	if changed, err := other.InvalidateCache(); err != nil || changed {
+		t.Errorf("got %v, %v, expected no change", changed, err)
+	}
+
+	// The other server sees the change in the events
+	serveTest(t, srvr, testData{"", "DELETE", "/appscode/workers/jenny", 200, nil})
+	expectCacheHeader(t, other, "/appscode/workers/jenny", 200, "hit")
+	if changed, err := other.InvalidateCache(); err != nil || !changed {
+		t.Errorf("got %v, %v, expected the deletion to drop the cache", changed, err)
+	}
+	serveTest(t, other, testData{"", "GET", "/appscode/workers/jenny", 404, nil})
+}
+
+func TestLRUCache(t *testing.T) {
+	cache := NewLRUCache(30)
+	response := func(body string) *CachedResponse { return &CachedResponse{Status: 200, Body: []byte(body)} }
+	cache.Set("a", response("0123456789"), time.Minute)
+	cache.Set("b", response("0123456789"), time.Minute)
+	cache.Get("a")
+	// b was used the least recently, so it makes room for c
+	cache.Set("c", response("0123456789"), time.Minute)
+	for key, expected := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := cache.Get(key); ok != expected {
+			t.Errorf("got %q cached %v expected %v", key, ok, expected)
+		}
+	}
+	cache.Set("d", response(strings.Repeat("0", 30)), time.Minute)
+	if _, ok := cache.Get("d"); ok || cache.Len() != 2 {
+		t.Errorf("expected a response larger than the cache to be left out")
+	}
+	cache.Purge()
+	if cache.Len() != 0 {
+		t.Errorf("got %d responses after purging", cache.Len())
+	}
+
+	now := testTime.Now()
+	cache.now = func() time.Time { return now }
+	cache.Set("a", response("0123456789"), time.Minute)
+	cache.Set("b", response("0123456789"), 2*time.Minute)
+	now = now.Add(time.Minute)
+	if _, ok := cache.Get("a"); ok || cache.Len() != 1 {
+		t.Errorf("expected a response to be dropped once its ttl passed")
+	}
+	cache.Set("c", response("0123456789"), 2*time.Minute)
+	cache.Get("b")
+	now = now.Add(time.Minute)
+	// b expired, so it makes room for d rather than c, used less recently
+	cache.Set("d", response("0123456789"), time.Minute)
+	for key, expected := range map[string]bool{"b": false, "c": true, "d": true} {
+		if _, ok := cache.Get(key); ok != expected {
+			t.Errorf("got %q cached %v expected %v", key, ok, expected)
+		}
+	}
+}
+
+func expectCacheHeader(t *testing.T, srvr *Server, url string, status int, expected string) {
+	t.Helper()
+	rec := serveTest(t, srvr, testData{"", "GET", url, status, nil})
+	if got := rec.Header().Get(cacheHeader); got != expected {
+		t.Errorf("GET %s got X-Cache %q expected %q", url, got, expected)
+	}
+}
@@ -0,0 +1,480 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/big"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-xorm/xorm"
+	"gopkg.in/macaron.v1"
+)
+
+// DefaultCurrency is the currency of the salaries stored before they had one
+const DefaultCurrency = "BDT"
+
+// minorUnits holds the ISO 4217 exponent of the currencies that don't
+// have two decimal places, salaries are stored in their minor unit
+var minorUnits = map[string]int{
+	"BHD": 3, "CLP": 0, "IQD": 3, "ISK": 0, "JOD": 3, "JPY": 0, "KRW": 0,
+	"KWD": 3, "LYD": 3, "OMR": 3, "PYG": 0, "TND": 3, "UGX": 0, "VND": 0,
+}
+
+func minorExponent(currency string) int {
+	if exp, ok := minorUnits[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+// minorFactor is the number of minor units in one unit of the currency
+func minorFactor(currency string) int64 {
+	factor := int64(1)
+	for i := 0; i < minorExponent(currency); i++ {
+		factor *= 10
+	}
+	return factor
+}
+
+// formatAmount writes an amount of minor units as "30000.00 BDT"
+func formatAmount(amount int64, currency string) string {
+	r := new(big.Rat).SetFrac64(amount, minorFactor(currency))
+	return r.FloatString(minorExponent(currency)) + " " + currency
+}
+
+// ExchangeRate tells that one unit of Base was worth Rate units of Quote
+// from Date on, until the next rate of the pair
+type ExchangeRate struct {
+	Base  string `json:"base" xorm:"pk varchar(3)" validate:"required,charset=currency"`
+	Quote string `json:"quote" xorm:"pk varchar(3)" validate:"required,charset=currency"`
+	Date  string `json:"date" xorm:"pk varchar(10)" validate:"required,date"`
+	// Rate is a decimal number, kept as text so it converts exactly
+	Rate string `json:"rate" xorm:"not null varchar(32)" validate:"required,max=32"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (r *ExchangeRate) rat() (*big.Rat, bool) {
+	rate, ok := new(big.Rat).SetString(r.Rate)
+	if !ok || rate.Sign() <= 0 {
+		return nil, false
+	}
+	return rate, true
+}
+
+// ExchangeRateFilter selects rates by the non-empty fields
+type ExchangeRateFilter struct {
+	Base  string
+	Quote string
+}
+
+// ExchangeRateStore persists the exchange rates
+type ExchangeRateStore interface {
+	ListExchangeRates(filter ExchangeRateFilter) ([]ExchangeRate, error)
+	// GetExchangeRate returns the rate of the pair in effect on date,
+	// or ErrNotFound if there was none yet
+	GetExchangeRate(base, quote, date string) (*ExchangeRate, error)
+	// SaveExchangeRates adds the rates, replacing the ones of the same pair and date
+	SaveExchangeRates(rates []ExchangeRate) error
+	DeleteExchangeRate(base, quote, date string) error
+}
+
+func (s *XormStore) ListExchangeRates(filter ExchangeRateFilter) ([]ExchangeRate, error) {
+	rates := make([]ExchangeRate, 0)
+	cond := &ExchangeRate{Base: filter.Base, Quote: filter.Quote}
+	if err := s.db.Asc("base", "quote", "date").Find(&rates, cond); err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+func (s *XormStore) GetExchangeRate(base, quote, date string) (*ExchangeRate, error) {
+	rate := new(ExchangeRate)
+	exist, err := s.db.Where("base = ? AND quote = ? AND date <= ?", base, quote, date).Desc("date").Get(rate)
+	if err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, ErrNotFound
+	}
+	return rate, nil
+}
+
+func (s *XormStore) SaveExchangeRates(rates []ExchangeRate) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		for i := range rates {
+			cond := &ExchangeRate{Base: rates[i].Base, Quote: rates[i].Quote, Date: rates[i].Date}
+			if err := upsert(session, &rates[i], cond); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *XormStore) DeleteExchangeRate(base, quote, date string) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		affected, err := session.Delete(&ExchangeRate{Base: base, Quote: quote, Date: date})
+		if err != nil {
+			return err
+		} else if affected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// noRateError tells that an amount can't be converted for lack of a rate
+type noRateError struct {
+	from, to, date string
+}
+
+func (e *noRateError) Error() string {
+	return fmt.Sprintf("no exchange rate from %s to %s on %s", e.from, e.to, e.date)
+}
+
+// converter converts amounts of minor units into one currency with the
+// rates in effect on a date, looking each pair up once
+type converter struct {
+	store Store
+	to    string
+	date  string
+	rates map[string]*big.Rat
+}
+
+func newConverter(store Store, to, date string) *converter {
+	return &converter{store: store, to: to, date: date, rates: make(map[string]*big.Rat)}
+}
+
+// rate returns how many units of c.to one unit of from is worth, from the
+// rate of the pair or else from the inverse of the opposite pair
+func (c *converter) rate(from string) (*big.Rat, error) {
+	if rate, ok := c.rates[from]; ok {
+		return rate, nil
+	}
+
+	rate := big.NewRat(1, 1)
+	if from != c.to {
+		stored, err := c.store.GetExchangeRate(from, c.to, c.date)
+		inverse := false
+		if err == ErrNotFound {
+			stored, err = c.store.GetExchangeRate(c.to, from, c.date)
+			inverse = true
+		}
+		if err == ErrNotFound {
+			return nil, &noRateError{from: from, to: c.to, date: c.date}
+		} else if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if rate, ok = stored.rat(); !ok {
+			return nil, fmt.Errorf("exchange rate %s/%s of %s is invalid: %q", stored.Base, stored.Quote, stored.Date, stored.Rate)
+		}
+		if inverse {
+			rate.Inv(rate)
+		}
+	}
+	c.rates[from] = rate
+	return rate, nil
+}
+
+// convert returns the amount of minor units of from in minor units of c.to
+func (c *converter) convert(amount int64, from string) (int64, error) {
+	rate, err := c.rate(from)
+	if err != nil {
+		return 0, err
+	}
+	r := new(big.Rat).SetFrac64(amount, minorFactor(from))
+	r.Mul(r, rate)
+	r.Mul(r, new(big.Rat).SetInt64(minorFactor(c.to)))
+	return roundRat(r), nil
+}
+
+// roundRat rounds half away from zero
+func roundRat(r *big.Rat) int64 {
+	q, m := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	if new(big.Int).Mul(m.Abs(m), big.NewInt(2)).Cmp(r.Denom()) >= 0 {
+		if r.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q.Int64()
+}
+
+// currencyQuery reads the currency and date query parameters that ask for
+// the salaries in a response to be converted, it returns nil if they don't
+func (s *Server) currencyQuery(ctx *macaron.Context) (*converter, error) {
+	return s.converterFor(ctx.Query("currency"), ctx.Query("date"))
+}
+
+// converterFor returns a converter into currency at the rates of date,
+// today when it is empty, or nil when currency is empty
+func (s *Server) converterFor(currency, date string) (*converter, error) {
+	if currency == "" {
+		if date != "" {
+			return nil, validationFailed(FieldError{Field: "date", Message: "needs the currency parameter"})
+		}
+		return nil, nil
+	}
+	if !charsets["currency"].MatchString(currency) {
+		return nil, validationFailed(FieldError{Field: "currency", Message: "must be an ISO 4217 code like USD"})
+	}
+	if date == "" {
+		date = s.today()
+	} else if _, err := time.Parse(dateLayout, date); err != nil {
+		return nil, validationFailed(FieldError{Field: "date", Message: "must be a date formatted as YYYY-MM-DD"})
+	}
+	return newConverter(s.store, currency, date), nil
+}
+
+// convertWorkers converts the salaries of the workers as asked by the
+// query parameters of the request
+func (s *Server) convertWorkers(ctx *macaron.Context, workers []Worker) error {
+	conv, err := s.currencyQuery(ctx)
+	if err != nil || conv == nil {
+		return err
+	}
+	for i := range workers {
+		if err := convertSalary(conv, &workers[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convertSalary converts the salary of the worker with conv
+func convertSalary(conv *converter, worker *Worker) error {
+	salary, err := conv.convert(worker.Salary, worker.Currency)
+	if e, ok := err.(*noRateError); ok {
+		return validationFailed(FieldError{Field: "currency", Message: e.Error()})
+	} else if err != nil {
+		return err
+	}
+	worker.Salary, worker.Currency = salary, conv.to
+	return nil
+}
+
+// loadRates loads the rates conv needs for the salaries of the workers
+// selected by filter, so they can be converted while the store is busy
+// reading the workers. It does nothing for a nil conv.
+func (s *Server) loadRates(conv *converter, filter WorkerFilter) error {
+	if conv == nil {
+		return nil
+	}
+	currencies, err := s.store.SalaryCurrencies(StatsFilter{Department: filter.Department, Team: filter.Team})
+	if err != nil {
+		return err
+	}
+	for _, from := range currencies {
+		if _, err := conv.rate(from); err != nil {
+			if e, ok := err.(*noRateError); ok {
+				return validationFailed(FieldError{Field: "currency", Message: e.Error()})
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// SalaryReport is the outcome of MigrateSalaries
+type SalaryReport struct {
+	Workers   int
+	Positions int
+	Records   int
+}
+
+// MigrateSalaries converts the salaries stored before they had a currency
+// from whole units to minor units of their position's currency. Those
+// salaries are the ones with no currency, and the bands of the positions
+// not written since, so running it again changes nothing.
+func (s *Server) MigrateSalaries(dryRun bool) (*SalaryReport, error) {
+	report := new(SalaryReport)
+	err := s.store.InTransaction(func(tx Store) error {
+		positions, err := tx.ListPositions()
+		if err != nil {
+			return err
+		}
+		currencyOf := func(position string) string {
+			if p, ok := findPosition(positions, position); ok {
+				return p.Currency
+			}
+			return DefaultCurrency
+		}
+
+		workers, err := tx.ListWorkers(WorkerFilter{})
+		if err != nil {
+			return err
+		}
+		for i := range workers {
+			if workers[i].Currency != "" {
+				continue
+			}
+			workers[i].Currency = currencyOf(workers[i].Position)
+			workers[i].Salary *= minorFactor(workers[i].Currency)
+			if err := tx.UpdateWorker(&workers[i]); err != nil {
+				return err
+			}
+			if err := s.recordEvents(tx, WorkerUpdated, workers[i]); err != nil {
+				return err
+			}
+			report.Workers++
+		}
+
+		records, err := tx.ListEmployment("")
+		if err != nil {
+			return err
+		}
+		for i := range records {
+			if records[i].Currency != "" {
+				continue
+			}
+			records[i].Currency = currencyOf(records[i].Position)
+			records[i].Salary *= minorFactor(records[i].Currency)
+			if err := tx.UpdateEmployment(&records[i]); err != nil {
+				return err
+			}
+			report.Records++
+		}
+
+		for i := range positions {
+			if positions[i].MinorUnits {
+				continue
+			}
+			factor := minorFactor(positions[i].Currency)
+			positions[i].MinSalary *= factor
+			positions[i].MaxSalary *= factor
+			if err := tx.UpdatePosition(&positions[i]); err != nil {
+				return err
+			}
+			report.Positions++
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err == errDryRun {
+		return report, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if *report != (SalaryReport{}) {
+		s.notifyChange()
+	}
+	return report, nil
+}
+
+// errDryRun rolls back the transaction of a dry run
+var errDryRun = fmt.Errorf("dry run")
+
+// Exchange rate handlers
+
+func (s *Server) showExchangeRates(ctx *macaron.Context) error {
+	rates, err := s.store.ListExchangeRates(ExchangeRateFilter{
+		Base:  strings.ToUpper(ctx.Query("base")),
+		Quote: strings.ToUpper(ctx.Query("quote")),
+	})
+	if err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusOK, rates)
+}
+
+// addExchangeRates saves a JSON array of rates, or a CSV file of them
+// with a base,quote,date,rate header, all of them or none
+func (s *Server) addExchangeRates(ctx *macaron.Context) error {
+	var rates []ExchangeRate
+	label := func(i int) string { return fmt.Sprintf("[%d]", i) }
+
+	mediaType, _, _ := mime.ParseMediaType(ctx.Req.Header.Get("Content-Type"))
+	if mediaType == "text/csv" {
+		var err error
+		if rates, err = s.decodeRatesCSV(ctx); err != nil {
+			return err
+		}
+		// Point at the line of the file, after the header
+		label = func(i int) string { return fmt.Sprintf("line %d", i+2) }
+	} else if err := s.decode(ctx, &rates); err != nil {
+		return err
+	}
+	if len(rates) == 0 {
+		return validationFailed(FieldError{Field: "rates", Message: "must hold at least one rate"})
+	}
+
+	var errs []FieldError
+	for i := range rates {
+		rates[i].Base = strings.ToUpper(strings.TrimSpace(rates[i].Base))
+		rates[i].Quote = strings.ToUpper(strings.TrimSpace(rates[i].Quote))
+		rateErrs := validate(rates[i], opCreate)
+		if !hasFieldError(rateErrs, "rate") {
+			if _, ok := rates[i].rat(); !ok {
+				rateErrs = append(rateErrs, FieldError{Field: "rate", Message: "must be a positive decimal number"})
+			}
+		}
+		if rates[i].Base == rates[i].Quote && !hasFieldError(rateErrs, "base", "quote") {
+			rateErrs = append(rateErrs, FieldError{Field: "quote", Message: "must differ from base"})
+		}
+		for _, e := range rateErrs {
+			errs = append(errs, FieldError{Field: label(i) + "." + e.Field, Message: e.Message})
+		}
+		rates[i].CreatedAt = s.clock.Now()
+	}
+	if len(errs) > 0 {
+		return validationFailed(errs...)
+	}
+
+	if err := s.store.SaveExchangeRates(rates); err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusCreated, rates)
+}
+
+func (s *Server) decodeRatesCSV(ctx *macaron.Context) ([]ExchangeRate, error) {
+	body := &limitedBody{r: ctx.Req.Request.Body, remaining: s.maxBodyBytes + 1}
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+
+	var rates []ExchangeRate
+	header, err := reader.Read()
+	if err == nil && strings.ToLower(strings.Join(header, ",")) != "base,quote,date,rate" {
+		return nil, badRequest("The CSV header must be base,quote,date,rate")
+	}
+	for err == nil {
+		var row []string
+		if row, err = reader.Read(); err == nil {
+			rates = append(rates, ExchangeRate{Base: row[0], Quote: row[1], Date: row[2], Rate: row[3]})
+		}
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	if err == nil && body.remaining <= 0 {
+		err = errBodyTooLarge
+	}
+	if err == errBodyTooLarge {
+		return nil, decodeError(err, s.maxBodyBytes)
+	} else if err != nil {
+		return nil, badRequest("Error decoding provided data: %v", err)
+	}
+	return rates, nil
+}
+
+func (s *Server) deleteExchangeRate(ctx *macaron.Context) error {
+	if err := checkAcceptable(ctx, StatusMessage{}); err != nil {
+		return err
+	}
+	base, quote, date := strings.ToUpper(ctx.Params("base")), strings.ToUpper(ctx.Params("quote")), ctx.Params("date")
+	if err := s.store.DeleteExchangeRate(base, quote, date); err == ErrNotFound {
+		return notFound("Exchange rate %s/%s of %s does not exist", base, quote, date)
+	} else if err != nil {
+		return err
+	}
+	return s.renderDeleted(ctx)
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExchangeRates(t *testing.T) {
+	srvr := newTestServer(t)
+	for _, data := range []testData{
+		{
+			"add_exchange_rates",
+			"POST",
+			"/appscode/exchange-rates",
+			201,
+			strings.NewReader(`[{"base":"USD","quote":"BDT","date":"2019-01-01","rate":"84.25"},{"base":"usd","quote":"bdt","date":"2019-03-01","rate":"84.5"}]`),
+		},
+		{
+			"add_exchange_rates_invalid",
+			"POST",
+			"/appscode/exchange-rates",
+			422,
+			strings.NewReader(`[{"base":"USD","quote":"USD","date":"2019-01-01","rate":"1"},{"base":"EUR","quote":"BDT","date":"March","rate":"-2"}]`),
+		},
+		{
+			"add_worker_paid_in_usd",
+			"POST",
+			"/appscode/workers",
+			201,
+			strings.NewReader(`{"username":"john","firstname":"John","lastname":"Doe","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":100,"currency":"USD"}`),
+		},
+		{
+			"add_worker_no_exchange_rate",
+			"POST",
+			"/appscode/workers",
+			422,
+			strings.NewReader(`{"username":"jane","firstname":"Jane","lastname":"Doe","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":100,"currency":"EUR"}`),
+		},
+		{"show_workers_in_usd", "GET", "/appscode/workers?currency=USD", 200, nil},
+		{"show_worker_in_usd_on_date", "GET", "/appscode/workers/masud?currency=USD&date=2019-02-01", 200, nil},
+		{"show_workers_before_first_rate", "GET", "/appscode/workers?currency=USD&date=2018-12-31", 422, nil},
+		{"show_workers_bad_currency", "GET", "/appscode/workers?currency=dollar", 422, nil},
+		{"show_exchange_rates", "GET", "/appscode/exchange-rates?base=usd", 200, nil},
+		{"delete_exchange_rate", "DELETE", "/appscode/exchange-rates/USD/BDT/2019-01-01", 200, nil},
+		{"delete_exchange_rate_not_found", "DELETE", "/appscode/exchange-rates/USD/BDT/2019-01-01", 404, nil},
+	} {
+		runTest(t, srvr, data)
+	}
+
+	for _, data := range []testData{
+		{
+			"import_exchange_rates_csv",
+			"POST",
+			"/appscode/exchange-rates",
+			201,
+			strings.NewReader("base,quote,date,rate\nEUR,BDT,2019-03-01,95.1\nUSD,BDT,2019-03-15,84.6\n"),
+		},
+		{
+			"import_exchange_rates_csv_invalid",
+			"POST",
+			"/appscode/exchange-rates",
+			422,
+			strings.NewReader("base,quote,date,rate\nEUR,BDT,2019-03-01,95.1\nEUR,BDT,2019-03-02,\n"),
+		},
+		{
+			"import_exchange_rates_csv_bad_header",
+			"POST",
+			"/appscode/exchange-rates",
+			400,
+			strings.NewReader("from,to,on,rate\nEUR,BDT,2019-03-01,95.1\n"),
+		},
+	} {
+		checkGolden(t, data.name, dumpResponse(serveTestWithType(t, srvr, data, "text/csv")))
+	}
+}
+
+func TestConvert(t *testing.T) {
+	srvr := newTestServer(t)
+	if err := srvr.store.SaveExchangeRates([]ExchangeRate{
+		{Base: "USD", Quote: "BDT", Date: "2019-01-01", Rate: "84.25"},
+		{Base: "USD", Quote: "JPY", Date: "2019-01-01", Rate: "110.5"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		amount   int64
+		from, to string
+		expected int64
+	}{
+		{10000, "USD", "BDT", 842500},
+		{842500, "BDT", "USD", 10000},
+		{1, "BDT", "USD", 0},
+		{100, "BDT", "USD", 1},
+		{100, "USD", "JPY", 111},
+		{111, "JPY", "USD", 100},
+		{5500, "BDT", "BDT", 5500},
+	} {
+		got, err := newConverter(srvr.store, test.to, "2019-03-20").convert(test.amount, test.from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.expected {
+			t.Errorf("%d %s in %s: got %d expected %d", test.amount, test.from, test.to, got, test.expected)
+		}
+	}
+
+	if _, err := newConverter(srvr.store, "BDT", "2018-12-31").convert(100, "USD"); err == nil {
+		t.Error("converted with a rate of a later date")
+	}
+	if got := formatAmount(300000, "BDT"); got != "3000.00 BDT" {
+		t.Errorf("got %q expected 3000.00 BDT", got)
+	}
+}
+
+func TestMigrateSalaries(t *testing.T) {
+	srvr := newTestServer(t)
+	// Rows stored before salaries had a currency, next to a position added
+	// since, whose band is in minor units already
+	legacy := Worker{Username: "rahim", FirstName: "Rahim", LastName: "Uddin", City: "Madaripur", Division: "Dhaka", Position: "Software Engineer", Salary: 55}
+	if err := srvr.store.CreateWorker(&legacy); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srvr.store.(*XormStore).engine.Exec("UPDATE position SET min_salary = 30, max_salary = 100, minor_units = ?", false); err != nil {
+		t.Fatal(err)
+	}
+	serveTest(t, srvr, testData{"", "POST", "/appscode/positions", 201, strings.NewReader(`{"id":"designer","title":"Designer","min_salary":3000,"max_salary":9000,"currency":"USD"}`)})
+
+	if report, err := srvr.MigrateSalaries(true); err != nil || report.Workers != 1 {
+		t.Fatalf("dry run: got report %+v, err %v", report, err)
+	}
+	if rahim, _ := srvr.store.GetWorker("rahim"); rahim.Salary != 55 {
+		t.Errorf("dry run changed the salary to %d", rahim.Salary)
+	}
+
+	report, err := srvr.MigrateSalaries(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *report != (SalaryReport{Workers: 1, Positions: 1}) {
+		t.Errorf("got report %+v", report)
+	}
+	rahim, _ := srvr.store.GetWorker("rahim")
+	position, _ := srvr.store.GetPosition("software-engineer")
+	if rahim.Salary != 5500 || rahim.Currency != "BDT" || position.MinSalary != 3000 || position.MaxSalary != 10000 {
+		t.Errorf("got salary %d %s and band %d-%d", rahim.Salary, rahim.Currency, position.MinSalary, position.MaxSalary)
+	}
+	if designer, _ := srvr.store.GetPosition("designer"); designer.MinSalary != 3000 || designer.MaxSalary != 9000 {
+		t.Errorf("got the band %d-%d of the new position expected it unchanged", designer.MinSalary, designer.MaxSalary)
+	}
+
+	if report, err := srvr.MigrateSalaries(false); err != nil || *report != (SalaryReport{}) {
+		t.Errorf("second run: got report %+v, err %v", report, err)
+	}
+}
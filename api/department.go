@@ -0,0 +1,279 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-xorm/xorm"
+	"gopkg.in/macaron.v1"
+)
+
+// Department groups workers and teams, its ID is chosen by the client
+type Department struct {
+	ID          string `json:"id" xorm:"pk 'id'" validate:"required_on=create,min=2,max=32,charset=slug"`
+	Name        string `json:"name" xorm:"not null" validate:"required,max=64,charset=title"`
+	Description string `json:"description" validate:"max=256"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int       `json:"version" xorm:"version"`
+}
+
+// DepartmentStore persists departments
+type DepartmentStore interface {
+	ListDepartments() ([]Department, error)
+	// GetDepartment returns ErrNotFound if no department has the id
+	GetDepartment(id string) (*Department, error)
+	// CreateDepartment returns ErrAlreadyExists if the id is taken
+	CreateDepartment(department *Department) error
+	UpdateDepartment(department *Department) error
+	// DeleteDepartment deletes the department only, its workers and
+	// teams must have been moved before
+	DeleteDepartment(id string) error
+}
+
+func (s *XormStore) ListDepartments() ([]Department, error) {
+	departments := make([]Department, 0)
+	if err := s.db.Asc("id").Find(&departments); err != nil {
+		return nil, err
+	}
+	return departments, nil
+}
+
+func (s *XormStore) GetDepartment(id string) (*Department, error) {
+	department := &Department{ID: id}
+	exist, err := s.db.Get(department)
+	if err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, ErrNotFound
+	}
+	return department, nil
+}
+
+func (s *XormStore) CreateDepartment(department *Department) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		exist, err := session.Exist(&Department{ID: department.ID})
+		if err != nil {
+			return err
+		} else if exist {
+			return ErrAlreadyExists
+		}
+		_, err = session.Insert(department)
+		return err
+	})
+}
+
+func (s *XormStore) UpdateDepartment(department *Department) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		affected, err := session.ID(department.ID).AllCols().Update(department)
+		if err != nil {
+			return err
+		} else if affected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (s *XormStore) DeleteDepartment(id string) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		affected, err := session.ID(id).Delete(new(Department))
+		if err != nil {
+			return err
+		} else if affected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// Department handlers
+
+func (s *Server) showAllDepartments(ctx *macaron.Context) error {
+	departments, err := s.store.ListDepartments()
+	if err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusOK, departments)
+}
+
+func (s *Server) showDepartment(ctx *macaron.Context) error {
+	department, err := s.getDepartment(s.store, ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusOK, department)
+}
+
+func (s *Server) showDepartmentWorkers(ctx *macaron.Context) error {
+	if _, err := s.getDepartment(s.store, ctx.Params("id")); err != nil {
+		return err
+	}
+	workers, err := s.store.ListWorkers(WorkerFilter{Department: ctx.Params("id")})
+	if err != nil {
+		return err
+	}
+	if err := s.convertWorkers(ctx, workers); err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusOK, workers)
+}
+
+func (s *Server) showDepartmentTeams(ctx *macaron.Context) error {
+	if _, err := s.getDepartment(s.store, ctx.Params("id")); err != nil {
+		return err
+	}
+	teams, err := s.store.ListTeams(TeamFilter{Department: ctx.Params("id")})
+	if err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusOK, teams)
+}
+
+func (s *Server) addDepartment(ctx *macaron.Context) error {
+	var department Department
+	if err := s.decode(ctx, &department); err != nil {
+		return err
+	}
+	if errs := validate(department, opCreate); len(errs) > 0 {
+		return validationFailed(errs...)
+	}
+
+	department.CreatedAt = s.clock.Now()
+	department.UpdatedAt = department.CreatedAt
+	department.Version = 0
+
+	if err := s.store.CreateDepartment(&department); err == ErrAlreadyExists {
+		return conflict("Department %q already exists", department.ID)
+	} else if err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusCreated, department)
+}
+
+func (s *Server) updateDepartment(ctx *macaron.Context) error {
+	department, err := s.getDepartment(s.store, ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	newDepartment := new(Department)
+	if err := s.decode(ctx, newDepartment); err != nil {
+		return err
+	}
+	errs := validate(newDepartment, opUpdate)
+	if newDepartment.ID != "" && newDepartment.ID != department.ID {
+		errs = append(errs, FieldError{Field: "id", Message: "can't be changed"})
+	}
+	if len(errs) > 0 {
+		return validationFailed(errs...)
+	}
+
+	department.Name = newDepartment.Name
+	department.Description = newDepartment.Description
+	department.UpdatedAt = s.clock.Now()
+
+	if err := s.store.UpdateDepartment(department); err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusOK, department)
+}
+
+// deleteDepartment refuses to delete a department that still has workers
+// or teams, unless the reassign_to query parameter names the department
+// they move to. The move and the deletion happen in one transaction.
+func (s *Server) deleteDepartment(ctx *macaron.Context) error {
+	if err := checkAcceptable(ctx, StatusMessage{}); err != nil {
+		return err
+	}
+	id, reassignTo := ctx.Params("id"), ctx.Query("reassign_to")
+
+	err := s.store.InTransaction(func(tx Store) error {
+		if _, err := s.getDepartment(tx, id); err != nil {
+			return err
+		}
+		workers, err := tx.ListWorkers(WorkerFilter{Department: id})
+		if err != nil {
+			return err
+		}
+		teams, err := tx.ListTeams(TeamFilter{Department: id})
+		if err != nil {
+			return err
+		}
+
+		if len(workers) > 0 || len(teams) > 0 {
+			if reassignTo == "" {
+				return conflict("Department %q still has %d workers and %d teams, reassign them with the reassign_to parameter", id, len(workers), len(teams))
+			}
+			if reassignTo == id {
+				return validationFailed(FieldError{Field: "reassign_to", Message: "must be another department"})
+			}
+			if _, err := tx.GetDepartment(reassignTo); err == ErrNotFound {
+				return validationFailed(FieldError{Field: "reassign_to", Message: "is not a known department"})
+			} else if err != nil {
+				return err
+			}
+
+			now := s.clock.Now()
+			for i := range workers {
+				workers[i].Department = reassignTo
+				workers[i].UpdatedAt = now
+				if err := s.saveWorker(tx, &workers[i], "department "+id+" deleted"); err != nil {
+					return err
+				}
+			}
+			for i := range teams {
+				teams[i].Department = reassignTo
+				teams[i].UpdatedAt = now
+				if err := tx.UpdateTeam(&teams[i]); err != nil {
+					return err
+				}
+			}
+		}
+		return tx.DeleteDepartment(id)
+	})
+	if err != nil {
+		return err
+	}
+	s.notifyChange()
+	return s.renderDeleted(ctx)
+}
+
+// getDepartment is GetDepartment with the 404 worded for the client
+func (s *Server) getDepartment(store Store, id string) (*Department, error) {
+	department, err := store.GetDepartment(id)
+	if err == ErrNotFound {
+		return nil, notFound("Department %q does not exist", id)
+	}
+	return department, err
+}
+
+// checkMembership verifies that the worker's department and team exist
+// and that the team belongs to the department. A worker given only a
+// team joins the team's department.
+func (s *Server) checkMembership(worker *Worker) ([]FieldError, error) {
+	if worker.Department != "" {
+		if _, err := s.store.GetDepartment(worker.Department); err == ErrNotFound {
+			return []FieldError{{Field: "department", Message: "is not a known department"}}, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if worker.Team == "" {
+		return nil, nil
+	}
+
+	team, err := s.store.GetTeam(worker.Team)
+	if err == ErrNotFound {
+		return []FieldError{{Field: "team", Message: "is not a known team"}}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if worker.Department == "" {
+		worker.Department = team.Department
+	} else if worker.Department != team.Department {
+		return []FieldError{{Field: "team", Message: "is not a team of the " + worker.Department + " department"}}, nil
+	}
+	return nil, nil
+}
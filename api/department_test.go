@@ -0,0 +1,148 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDepartments(t *testing.T) {
+	srvr := newTestServer(t)
+	test := []testData{
+		{
+			"add_department",
+			"POST",
+			"/appscode/departments",
+			201,
+			strings.NewReader(`{"id":"engineering","name":"Engineering","description":"Builds the products"}`),
+		},
+		{
+			"add_department_conflict",
+			"POST",
+			"/appscode/departments",
+			409,
+			strings.NewReader(`{"id":"engineering","name":"Engineering"}`),
+		},
+		{
+			"add_department_invalid_fields",
+			"POST",
+			"/appscode/departments",
+			422,
+			strings.NewReader(`{"id":"Human Resources","name":""}`),
+		},
+		{
+			"add_second_department",
+			"POST",
+			"/appscode/departments",
+			201,
+			strings.NewReader(`{"id":"research","name":"Research & Development"}`),
+		},
+		{
+			"show_all_departments",
+			"GET",
+			"/appscode/departments",
+			200,
+			nil,
+		},
+		{
+			"update_department",
+			"PUT",
+			"/appscode/departments/research",
+			200,
+			strings.NewReader(`{"name":"Research","description":"Tries new things"}`),
+		},
+		{
+			"update_department_id_changed",
+			"PUT",
+			"/appscode/departments/research",
+			422,
+			strings.NewReader(`{"id":"rnd","name":"Research"}`),
+		},
+		{
+			"show_department",
+			"GET",
+			"/appscode/departments/research",
+			200,
+			nil,
+		},
+		{
+			"show_department_not_found",
+			"GET",
+			"/appscode/departments/sales",
+			404,
+			nil,
+		},
+		{
+			"update_worker_department",
+			"PUT",
+			"/appscode/workers/masud",
+			201,
+			strings.NewReader(`{"firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500,"department":"engineering"}`),
+		},
+		{
+			"update_worker_unknown_department",
+			"PUT",
+			"/appscode/workers/fahim",
+			422,
+			strings.NewReader(`{"firstname":"Fahim","lastname":"Abrar","city":"Chattogram","division":"Chattogram","position":"Software Engineer","salary":5500,"department":"sales"}`),
+		},
+		{
+			"show_department_workers",
+			"GET",
+			"/appscode/departments/engineering/workers",
+			200,
+			nil,
+		},
+		{
+			"show_workers_by_department",
+			"GET",
+			"/appscode/workers?department=engineering",
+			200,
+			nil,
+		},
+		{
+			"delete_department_with_workers",
+			"DELETE",
+			"/appscode/departments/engineering",
+			409,
+			nil,
+		},
+		{
+			"delete_department_reassign_to_itself",
+			"DELETE",
+			"/appscode/departments/engineering?reassign_to=engineering",
+			422,
+			nil,
+		},
+		{
+			"delete_department_reassign_to_unknown",
+			"DELETE",
+			"/appscode/departments/engineering?reassign_to=sales",
+			422,
+			nil,
+		},
+		{
+			"delete_department_reassigned",
+			"DELETE",
+			"/appscode/departments/engineering?reassign_to=research",
+			200,
+			nil,
+		},
+		{
+			"show_reassigned_workers",
+			"GET",
+			"/appscode/departments/research/workers",
+			200,
+			nil,
+		},
+		{
+			"delete_department_not_found",
+			"DELETE",
+			"/appscode/departments/engineering",
+			404,
+			nil,
+		},
+	}
+	for _, data := range test {
+		runTest(t, srvr, data)
+	}
+}
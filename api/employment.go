@@ -0,0 +1,555 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-xorm/xorm"
+	"gopkg.in/macaron.v1"
+)
+
+// dateLayout is the format of the dates of the employment timeline
+const dateLayout = "2006-01-02"
+
+// Statuses of an employment record
+const (
+	EmploymentPast      = "past"
+	EmploymentCurrent   = "current"
+	EmploymentScheduled = "scheduled"
+)
+
+// EmploymentRecord holds the terms a worker was employed on from StartDate
+// until EndDate, which is empty for the current record. A scheduled record
+// is a change that takes effect on StartDate, only the fields listed in
+// Changes are set on it until then.
+type EmploymentRecord struct {
+	ID        int64    `json:"id" xorm:"pk autoincr 'id'"`
+	Username  string   `json:"username" xorm:"not null index"`
+	StartDate string   `json:"start_date" xorm:"not null index varchar(10)"`
+	EndDate   string   `json:"end_date,omitempty" xorm:"varchar(10)"`
+	Scheduled bool     `json:"-" xorm:"not null index"`
+	Status    string   `json:"status" xorm:"-"`
+	Reason    string   `json:"reason,omitempty"`
+	Changes   []string `json:"changes,omitempty"`
+	// Error tells why a scheduled change couldn't be applied on its date
+	Error string `json:"error,omitempty"`
+
+	Position         string `json:"position,omitempty"`
+	Salary           int64  `json:"salary,omitempty"`
+	Currency         string `json:"currency,omitempty"`
+	SalaryOverride   string `json:"salary_override,omitempty"`
+	SalaryOverrideBy string `json:"salary_override_by,omitempty"`
+	Department       string `json:"department,omitempty"`
+	Team             string `json:"team,omitempty"`
+	City             string `json:"city,omitempty"`
+	Division         string `json:"division,omitempty"`
+	Manager          string `json:"manager,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (r *EmploymentRecord) status() string {
+	switch {
+	case r.Scheduled:
+		return EmploymentScheduled
+	case r.EndDate == "":
+		return EmploymentCurrent
+	}
+	return EmploymentPast
+}
+
+// setTerms copies the employment terms of the worker into the record
+func (r *EmploymentRecord) setTerms(w *Worker) {
+	r.Position, r.Salary, r.Currency = w.Position, w.Salary, w.Currency
+	r.SalaryOverride, r.SalaryOverrideBy = w.SalaryOverride, w.SalaryOverrideBy
+	r.Department, r.Team = w.Department, w.Team
+	r.City, r.Division = w.City, w.Division
+	r.Manager = w.Manager
+}
+
+// applyTo sets the terms listed in Changes on the worker. The salary
+// override goes along with the salary and position it was given for.
+func (r *EmploymentRecord) applyTo(w *Worker) {
+	for _, field := range r.Changes {
+		switch field {
+		case "position":
+			w.Position = r.Position
+		case "salary":
+			w.Salary = r.Salary
+		case "currency":
+			w.Currency = r.Currency
+		case "department":
+			w.Department = r.Department
+		case "team":
+			w.Team = r.Team
+		case "city":
+			w.City = r.City
+		case "division":
+			w.Division = r.Division
+		case "manager":
+			w.Manager = r.Manager
+		}
+	}
+	if contains(r.Changes, "position") || contains(r.Changes, "salary") || contains(r.Changes, "currency") || contains(r.Changes, "salary_override") {
+		w.SalaryOverride, w.SalaryOverrideBy = r.SalaryOverride, r.SalaryOverrideBy
+	}
+}
+
+// changedTerms lists the employment terms that differ between two profiles
+func changedTerms(old, new *Worker) []string {
+	var changes []string
+	for _, term := range []struct {
+		field    string
+		old, new interface{}
+	}{
+		{"position", old.Position, new.Position},
+		{"salary", old.Salary, new.Salary},
+		{"currency", old.Currency, new.Currency},
+		{"salary_override", old.SalaryOverride, new.SalaryOverride},
+		{"department", old.Department, new.Department},
+		{"team", old.Team, new.Team},
+		{"city", old.City, new.City},
+		{"division", old.Division, new.Division},
+		{"manager", old.Manager, new.Manager},
+	} {
+		if term.old != term.new {
+			changes = append(changes, term.field)
+		}
+	}
+	return changes
+}
+
+// EmploymentStore persists the employment timelines
+type EmploymentStore interface {
+	// ListEmployment returns the records of a worker, oldest first, or
+	// the records of every worker if username is empty
+	ListEmployment(username string) ([]EmploymentRecord, error)
+	// ListDueEmployment returns the scheduled records starting on date or before
+	ListDueEmployment(date string) ([]EmploymentRecord, error)
+	// ClaimEmployment takes a scheduled record off the schedule, so the
+	// other servers don't apply it too. It returns ErrNotFound if the
+	// record isn't scheduled anymore.
+	ClaimEmployment(id int64) error
+	CreateEmployment(record *EmploymentRecord) error
+	UpdateEmployment(record *EmploymentRecord) error
+	DeleteEmployment(id int64) error
+}
+
+func (s *XormStore) ListEmployment(username string) ([]EmploymentRecord, error) {
+	records := make([]EmploymentRecord, 0)
+	if err := s.db.Asc("start_date", "id").Find(&records, &EmploymentRecord{Username: username}); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (s *XormStore) ListDueEmployment(date string) ([]EmploymentRecord, error) {
+	records := make([]EmploymentRecord, 0)
+	if err := s.db.Where("scheduled = ? AND start_date <= ?", true, date).Asc("start_date", "id").Find(&records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (s *XormStore) ClaimEmployment(id int64) error {
+	affected, err := s.db.Where("id = ? AND scheduled = ?", id, true).Cols("scheduled").Update(&EmploymentRecord{Scheduled: false})
+	if err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *XormStore) CreateEmployment(record *EmploymentRecord) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		_, err := session.Insert(record)
+		return err
+	})
+}
+
+func (s *XormStore) UpdateEmployment(record *EmploymentRecord) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		affected, err := session.ID(record.ID).AllCols().Update(record)
+		if err != nil {
+			return err
+		} else if affected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (s *XormStore) DeleteEmployment(id int64) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		affected, err := session.ID(id).Delete(new(EmploymentRecord))
+		if err != nil {
+			return err
+		} else if affected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (s *Server) today() string {
+	return s.clock.Now().Format(dateLayout)
+}
+
+// createWorker stores a new worker and opens their employment timeline
+func (s *Server) createWorker(store Store, worker *Worker) error {
+	err := store.InTransaction(func(tx Store) error {
+		if err := tx.CreateWorker(worker); err != nil {
+			return err
+		}
+		record := &EmploymentRecord{
+			Username:  worker.Username,
+			StartDate: worker.CreatedAt.Format(dateLayout),
+			CreatedAt: s.clock.Now(),
+		}
+		record.setTerms(worker)
+		if err := tx.CreateEmployment(record); err != nil {
+			return err
+		}
+		return s.recordEvents(tx, WorkerCreated, *worker)
+	})
+	if err != nil {
+		return err
+	}
+	s.notifyCommitted(store)
+	return nil
+}
+
+// saveWorker stores the worker, and records the change of their
+// employment terms in their timeline from today
+func (s *Server) saveWorker(store Store, worker *Worker, reason string) error {
+	return s.changeEmployment(store, worker, &EmploymentRecord{StartDate: s.today(), Reason: reason})
+}
+
+// changeEmployment stores the worker and makes next, which is either new
+// or a scheduled record coming due, their current employment record
+func (s *Server) changeEmployment(store Store, worker *Worker, next *EmploymentRecord) error {
+	err := store.InTransaction(func(tx Store) error {
+		old, err := tx.GetWorker(worker.Username)
+		if err != nil {
+			return err
+		}
+		if err := tx.UpdateWorker(worker); err != nil {
+			return err
+		}
+		if err := s.recordEvents(tx, WorkerUpdated, *worker); err != nil {
+			return err
+		}
+		if old.Salary != worker.Salary || old.Currency != worker.Currency {
+			if err := s.queueWebhooks(tx, WebhookWorkerSalaryChanged, *worker, old); err != nil {
+				return err
+			}
+		}
+		changes := changedTerms(old, worker)
+		if len(changes) == 0 && !next.Scheduled {
+			return nil
+		}
+
+		records, err := tx.ListEmployment(worker.Username)
+		if err != nil {
+			return err
+		}
+		var current *EmploymentRecord
+		for i := range records {
+			// A claimed change is off the schedule already, but not current yet
+			if records[i].ID != next.ID && records[i].status() == EmploymentCurrent {
+				current = &records[i]
+			}
+		}
+		if current == nil {
+			// Workers stored before the timeline existed start with their old terms
+			current = &EmploymentRecord{
+				Username:  old.Username,
+				StartDate: old.CreatedAt.Format(dateLayout),
+				CreatedAt: s.clock.Now(),
+			}
+			current.setTerms(old)
+			if err := tx.CreateEmployment(current); err != nil {
+				return err
+			}
+		}
+
+		if current.StartDate >= next.StartDate {
+			// A second change on the same day amends the current record
+			for _, change := range changes {
+				if !contains(current.Changes, change) {
+					current.Changes = append(current.Changes, change)
+				}
+			}
+			current.setTerms(worker)
+			if next.Reason != "" {
+				current.Reason = next.Reason
+			}
+			if next.ID != 0 {
+				if err := tx.DeleteEmployment(next.ID); err != nil {
+					return err
+				}
+			}
+			return tx.UpdateEmployment(current)
+		}
+
+		current.EndDate = next.StartDate
+		if err := tx.UpdateEmployment(current); err != nil {
+			return err
+		}
+
+		next.Username = worker.Username
+		next.Scheduled = false
+		next.Error = ""
+		next.Changes = changes
+		next.setTerms(worker)
+		if next.ID != 0 {
+			return tx.UpdateEmployment(next)
+		}
+		next.CreatedAt = s.clock.Now()
+		return tx.CreateEmployment(next)
+	})
+	if err != nil {
+		return err
+	}
+	s.notifyCommitted(store)
+	return nil
+}
+
+// endEmployment closes the current record of a deleted worker and drops
+// the changes scheduled for them
+func (s *Server) endEmployment(tx Store, username string) error {
+	records, err := tx.ListEmployment(username)
+	if err != nil {
+		return err
+	}
+	for i := range records {
+		switch records[i].status() {
+		case EmploymentCurrent:
+			records[i].EndDate = s.today()
+			if err := tx.UpdateEmployment(&records[i]); err != nil {
+				return err
+			}
+		case EmploymentScheduled:
+			if err := tx.DeleteEmployment(records[i].ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// errEmploymentClaimed rolls back a scheduled change another server
+// applied meanwhile
+var errEmploymentClaimed = fmt.Errorf("scheduled change claimed by another server")
+
+// ApplyScheduledChanges makes the scheduled changes due today current. A
+// change that isn't valid anymore, say its manager has left, stays
+// scheduled with the reason in its Error. Each change is claimed in the
+// transaction applying it, so of the servers sharing the database only one
+// applies it. A change failing otherwise is logged and left scheduled, and
+// the others are applied still. It returns how many were applied.
+func (s *Server) ApplyScheduledChanges() (int, error) {
+	due, err := s.store.ListDueEmployment(s.today())
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for i := range due {
+		record := &due[i]
+		changed := false
+		err := s.store.InTransaction(func(tx Store) error {
+			if err := tx.ClaimEmployment(record.ID); err == ErrNotFound {
+				return errEmploymentClaimed
+			} else if err != nil {
+				return err
+			}
+			bound := s.withStore(tx)
+			worker, err := tx.GetWorker(record.Username)
+			if err != nil {
+				return err
+			}
+			record.applyTo(worker)
+
+			errs, err := bound.validateWorker(worker, opUpdate)
+			if err != nil {
+				return err
+			}
+			if len(errs) > 0 {
+				var msgs []string
+				for _, e := range errs {
+					msgs = append(msgs, e.Field+" "+e.Message)
+				}
+				record.Error = strings.Join(msgs, "; ")
+				return tx.UpdateEmployment(record)
+			}
+
+			worker.UpdatedAt = s.clock.Now()
+			changed = true
+			return bound.changeEmployment(tx, worker, record)
+		})
+		switch {
+		case err == errEmploymentClaimed:
+		case err != nil:
+			s.logger.Println("applying the employment change", record.ID, "of", record.Username+":", err)
+		case changed:
+			applied++
+		}
+	}
+	if applied > 0 {
+		s.notifyChange()
+	}
+	return applied, nil
+}
+
+// Employment handlers
+
+func (s *Server) showEmployment(ctx *macaron.Context) error {
+	username := ctx.Params("username")
+	if _, err := s.store.GetWorker(username); err == ErrNotFound {
+		return notFound("Worker %q does not exist", username)
+	} else if err != nil {
+		return err
+	}
+
+	records, err := s.store.ListEmployment(username)
+	if err != nil {
+		return err
+	}
+	for i := range records {
+		records[i].Status = records[i].status()
+	}
+	return s.render(ctx, http.StatusOK, records)
+}
+
+// employmentChange is the body of a scheduled change, the fields left
+// out keep their value
+type employmentChange struct {
+	StartDate      string  `json:"start_date" validate:"required,date"`
+	Reason         string  `json:"reason" validate:"max=256"`
+	Position       *string `json:"position"`
+	Salary         *int64  `json:"salary"`
+	Currency       *string `json:"currency"`
+	SalaryOverride *string `json:"salary_override"`
+	Department     *string `json:"department"`
+	Team           *string `json:"team"`
+	City           *string `json:"city"`
+	Division       *string `json:"division"`
+	Manager        *string `json:"manager"`
+}
+
+// record turns the change into a scheduled record of the fields it sets
+func (c *employmentChange) record() *EmploymentRecord {
+	r := &EmploymentRecord{StartDate: c.StartDate, Reason: c.Reason, Scheduled: true}
+	set := func(field string, dst *string, src *string) {
+		if src != nil {
+			*dst = *src
+			r.Changes = append(r.Changes, field)
+		}
+	}
+	set("position", &r.Position, c.Position)
+	if c.Salary != nil {
+		r.Salary = *c.Salary
+		r.Changes = append(r.Changes, "salary")
+	}
+	set("currency", &r.Currency, c.Currency)
+	set("salary_override", &r.SalaryOverride, c.SalaryOverride)
+	set("department", &r.Department, c.Department)
+	set("team", &r.Team, c.Team)
+	set("city", &r.City, c.City)
+	set("division", &r.Division, c.Division)
+	set("manager", &r.Manager, c.Manager)
+	return r
+}
+
+// scheduleEmploymentChange validates a change against the worker's profile
+// of today and stores it to be applied on its start date, or applies it
+// right away if that is today
+func (s *Server) scheduleEmploymentChange(ctx *macaron.Context) error {
+	worker, err := s.store.GetWorker(ctx.Params("username"))
+	if err == ErrNotFound {
+		return notFound("Worker %q does not exist", ctx.Params("username"))
+	} else if err != nil {
+		return err
+	}
+
+	var change employmentChange
+	if err := s.decode(ctx, &change); err != nil {
+		return err
+	}
+	if errs := validate(change, opCreate); len(errs) > 0 {
+		return validationFailed(errs...)
+	}
+	if change.StartDate < s.today() {
+		return validationFailed(FieldError{Field: "start_date", Message: "can't be in the past"})
+	}
+	record := change.record()
+	if len(record.Changes) == 0 {
+		return validationFailed(FieldError{Field: "changes", Message: "must set at least one field"})
+	}
+
+	updated := *worker
+	record.applyTo(&updated)
+	if err := s.applySalaryOverride(user(ctx), worker, &updated); err != nil {
+		return err
+	}
+	errs, err := s.validateWorker(&updated, opUpdate)
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return validationFailed(errs...)
+	}
+	// Keep the values as canonicalized by the validation
+	record.setTerms(&updated)
+
+	if record.StartDate == s.today() {
+		updated.UpdatedAt = s.clock.Now()
+		record.Scheduled = false
+		if err := s.changeEmployment(s.store, &updated, record); err != nil {
+			return err
+		}
+	} else {
+		record.Username = worker.Username
+		record.CreatedAt = s.clock.Now()
+		if err := s.store.CreateEmployment(record); err != nil {
+			return err
+		}
+	}
+	record.Status = record.status()
+	return s.render(ctx, http.StatusCreated, record)
+}
+
+// cancelEmploymentChange deletes a change that is still scheduled
+func (s *Server) cancelEmploymentChange(ctx *macaron.Context) error {
+	if err := checkAcceptable(ctx, StatusMessage{}); err != nil {
+		return err
+	}
+	username := ctx.Params("username")
+	id, err := strconv.ParseInt(ctx.Params("id"), 10, 64)
+	if err != nil {
+		return notFound("Employment record %q does not exist", ctx.Params("id"))
+	}
+
+	records, err := s.store.ListEmployment(username)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if record.ID != id {
+			continue
+		}
+		if !record.Scheduled {
+			return conflict("Employment record %d already took effect", id)
+		}
+		if err := s.store.DeleteEmployment(id); err != nil {
+			return err
+		}
+		return s.renderDeleted(ctx)
+	}
+	return notFound("Employment record %d of %q does not exist", id, username)
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// movingClock is a clock tests can move forward
+type movingClock struct {
+	now time.Time
+}
+
+func (c *movingClock) Now() time.Time { return c.now }
+
+func TestEmployment(t *testing.T) {
+	clock := &movingClock{now: testTime.Now()}
+	srvr := newTestServer(t, WithClock(clock))
+
+	for _, data := range []testData{
+		{
+			"schedule_employment_change",
+			"POST",
+			"/appscode/workers/masud/employment",
+			201,
+			strings.NewReader(`{"start_date":"2019-04-01","reason":"Yearly raise","salary":8000,"city":"Dhaka"}`),
+		},
+		{
+			"schedule_employment_change_in_past",
+			"POST",
+			"/appscode/workers/masud/employment",
+			422,
+			strings.NewReader(`{"start_date":"2019-03-01","salary":8000}`),
+		},
+		{
+			"schedule_employment_change_bad_date",
+			"POST",
+			"/appscode/workers/masud/employment",
+			422,
+			strings.NewReader(`{"start_date":"01/04/2019","salary":8000}`),
+		},
+		{
+			"schedule_employment_change_empty",
+			"POST",
+			"/appscode/workers/masud/employment",
+			422,
+			strings.NewReader(`{"start_date":"2019-04-01"}`),
+		},
+		{
+			"schedule_employment_change_invalid",
+			"POST",
+			"/appscode/workers/masud/employment",
+			422,
+			strings.NewReader(`{"start_date":"2019-05-01","manager":"nobody"}`),
+		},
+		{
+			"schedule_employment_change_today",
+			"POST",
+			"/appscode/workers/fahim/employment",
+			201,
+			strings.NewReader(`{"start_date":"2019-03-20","manager":"masud"}`),
+		},
+		{
+			"show_employment_scheduled",
+			"GET",
+			"/appscode/workers/masud/employment",
+			200,
+			nil,
+		},
+		{
+			"show_employment_unknown_worker",
+			"GET",
+			"/appscode/workers/nobody/employment",
+			404,
+			nil,
+		},
+	} {
+		runTest(t, srvr, data)
+	}
+
+	// Nothing is due before the start date
+	if n, err := srvr.ApplyScheduledChanges(); err != nil || n != 0 {
+		t.Fatalf("applied %d changes before their date, err %v", n, err)
+	}
+	clock.now = clock.now.AddDate(0, 0, 12)
+	if n, err := srvr.ApplyScheduledChanges(); err != nil || n != 1 {
+		t.Fatalf("applied %d changes on their date, err %v", n, err)
+	}
+
+	for _, data := range []testData{
+		{"show_worker_after_scheduled_change", "GET", "/appscode/workers/masud", 200, nil},
+		{"show_employment_applied", "GET", "/appscode/workers/masud/employment", 200, nil},
+		{
+			"update_worker_records_employment",
+			"PUT",
+			"/appscode/workers/tahsin",
+			201,
+			strings.NewReader(`{"firstname":"Tahsin","lastname":"Rahman","city":"Chattogram","division":"Chattogram","position":"Software Engineer","salary":6000}`),
+		},
+		{"show_employment_after_update", "GET", "/appscode/workers/tahsin/employment", 200, nil},
+		{"cancel_applied_employment_change", "DELETE", "/appscode/workers/masud/employment/1", 409, nil},
+		{"cancel_unknown_employment_change", "DELETE", "/appscode/workers/masud/employment/999", 404, nil},
+	} {
+		runTest(t, srvr, data)
+	}
+}
+
+// TestScheduledChangeGoneInvalid checks that a change which isn't valid
+// anymore on its date stays scheduled with the reason
+func TestScheduledChangeGoneInvalid(t *testing.T) {
+	clock := &movingClock{now: testTime.Now()}
+	srvr := newTestServer(t, WithClock(clock))
+
+	serveTest(t, srvr, testData{
+		"schedule_manager_change",
+		"POST",
+		"/appscode/workers/masud/employment",
+		201,
+		strings.NewReader(`{"start_date":"2019-04-01","manager":"fahim"}`),
+	})
+	serveTest(t, srvr, testData{"delete_future_manager", "DELETE", "/appscode/workers/fahim", 200, nil})
+
+	clock.now = clock.now.AddDate(0, 0, 12)
+	if n, err := srvr.ApplyScheduledChanges(); err != nil || n != 0 {
+		t.Fatalf("applied %d invalid changes, err %v", n, err)
+	}
+	records, err := srvr.store.ListEmployment("masud")
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := records[len(records)-1]
+	if !last.Scheduled || last.Error != "manager is not a known worker" {
+		t.Errorf("got scheduled %v error %q, expected the change to stay scheduled with an error", last.Scheduled, last.Error)
+	}
+
+	serveTest(t, srvr, testData{"cancel_employment_change", "DELETE", "/appscode/workers/masud/employment/" + strconv.FormatInt(last.ID, 10), 200, nil})
+	if records, _ := srvr.store.ListEmployment("masud"); len(records) != 1 {
+		t.Errorf("got %d records after cancelling, expected 1", len(records))
+	}
+}
+
+// TestScheduledChangesKeepGoing checks that a change failing, or applied by
+// another server meanwhile, doesn't hold back the others
+func TestScheduledChangesKeepGoing(t *testing.T) {
+	clock := &movingClock{now: testTime.Now()}
+	srvr := newTestServer(t, WithClock(clock))
+	for _, username := range []string{"masud", "tahsin"} {
+		serveTest(t, srvr, testData{"", "POST", "/appscode/workers/" + username + "/employment", 201, strings.NewReader(`{"start_date":"2019-04-01","salary":6000}`)})
+	}
+	orphan := &EmploymentRecord{Username: "nobody", StartDate: "2019-03-25", Scheduled: true, Changes: []string{"salary"}, Salary: 6000, CreatedAt: clock.now}
+	if err := srvr.store.CreateEmployment(orphan); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.now = clock.now.AddDate(0, 0, 12)
+	racing := NewServer(WithStore(&racingStore{Store: srvr.store, username: "tahsin"}), WithBypassAuth(true), WithClock(clock), WithLogger(log.New(ioutil.Discard, "", 0)))
+	if n, err := racing.ApplyScheduledChanges(); err != nil || n != 1 {
+		t.Fatalf("applied %d changes, err %v, expected masud's only", n, err)
+	}
+	for username, expected := range map[string]int64{"masud": 6000, "tahsin": 5500} {
+		if worker, err := srvr.store.GetWorker(username); err != nil || worker.Salary != expected {
+			t.Errorf("got %s's salary %v, err %v, expected %d", username, worker, err, expected)
+		}
+	}
+	if records, err := srvr.store.ListEmployment("nobody"); err != nil || len(records) != 1 || !records[0].Scheduled {
+		t.Errorf("got %+v, err %v, expected the failing change to stay scheduled", records, err)
+	}
+}
+
+// racingStore claims the due changes of a worker as another server would,
+// between listing and applying them
+type racingStore struct {
+	Store
+	username string
+}
+
+func (s *racingStore) ListDueEmployment(date string) ([]EmploymentRecord, error) {
+	due, err := s.Store.ListDueEmployment(date)
+	for _, record := range due {
+		if err == nil && record.Username == s.username {
+			err = s.Store.ClaimEmployment(record.ID)
+		}
+	}
+	return due, err
+}
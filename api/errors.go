@@ -0,0 +1,209 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"gopkg.in/macaron.v1"
+)
+
+// Problem types, relative to the server so the documentation can live there
+const (
+	ProblemBadRequest           = "/problems/bad-request"
+	ProblemUnauthorized         = "/problems/unauthorized"
+	ProblemForbidden            = "/problems/forbidden"
+	ProblemNotFound             = "/problems/not-found"
+	ProblemConflict             = "/problems/conflict"
+	ProblemGone                 = "/problems/gone"
+	ProblemValidation           = "/problems/validation"
+	ProblemFailedDependency     = "/problems/failed-dependency"
+	ProblemTooManyRequests      = "/problems/too-many-requests"
+	ProblemPayloadTooLarge      = "/problems/payload-too-large"
+	ProblemNotAcceptable        = "/problems/not-acceptable"
+	ProblemUnsupportedMediaType = "/problems/unsupported-media-type"
+	ProblemInternal             = "/problems/internal"
+)
+
+// Error is an API error, rendered as an RFC 7807 application/problem+json document.
+// Handlers return it instead of writing the status code themselves.
+type Error struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+
+	// cause is logged but never shown to the client
+	cause error
+}
+
+// FieldError tells which field of the request failed validation and why
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%d %s: %v", e.Status, e.Title, e.cause)
+	}
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Title, e.Detail)
+}
+
+// NewError returns an Error of the given problem type, titled by the status
+func NewError(status int, problemType, detail string) *Error {
+	return &Error{
+		Type:   problemType,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+func badRequest(format string, args ...interface{}) *Error {
+	return NewError(http.StatusBadRequest, ProblemBadRequest, fmt.Sprintf(format, args...))
+}
+
+func unauthorized(detail string) *Error {
+	return NewError(http.StatusUnauthorized, ProblemUnauthorized, detail)
+}
+
+func forbidden(detail string) *Error {
+	return NewError(http.StatusForbidden, ProblemForbidden, detail)
+}
+
+func notFound(format string, args ...interface{}) *Error {
+	return NewError(http.StatusNotFound, ProblemNotFound, fmt.Sprintf(format, args...))
+}
+
+func conflict(format string, args ...interface{}) *Error {
+	return NewError(http.StatusConflict, ProblemConflict, fmt.Sprintf(format, args...))
+}
+
+func gone(format string, args ...interface{}) *Error {
+	return NewError(http.StatusGone, ProblemGone, fmt.Sprintf(format, args...))
+}
+
+func tooManyRequests(format string, args ...interface{}) *Error {
+	return NewError(http.StatusTooManyRequests, ProblemTooManyRequests, fmt.Sprintf(format, args...))
+}
+
+func validationFailed(errs ...FieldError) *Error {
+	e := NewError(http.StatusUnprocessableEntity, ProblemValidation, "The request has invalid fields")
+	e.Errors = errs
+	return e
+}
+
+func internalError(cause error) *Error {
+	e := NewError(http.StatusInternalServerError, ProblemInternal, "The server could not complete the request")
+	e.cause = cause
+	return e
+}
+
+// toError turns any error into an Error, mapping the store's errors to their status
+func toError(err error) *Error {
+	switch err {
+	case ErrNotFound:
+		return notFound("The resource does not exist")
+	case ErrAlreadyExists:
+		return conflict("The resource already exists")
+	}
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return internalError(err)
+}
+
+// renderError writes err as a problem document. It is registered as the
+// macaron error handler, so handlers can simply return their errors.
+func (s *Server) renderError(ctx *macaron.Context, err error) {
+	e := toError(err)
+	if e.Status >= http.StatusInternalServerError {
+		s.logger.Println(ctx.Req.Method, ctx.Req.URL.Path, e)
+	}
+
+	if ctx.Written() {
+		// Too late to tell the client, the status line is already out
+		s.logger.Println("response already written, dropping error:", e)
+		return
+	}
+
+	problem := *e
+	problem.Instance = ctx.Req.URL.Path
+	problem.RequestID = requestID(ctx)
+
+	// Problems are sent in the format the client accepts, and as JSON
+	// when it accepts none of them
+	mediaType, ok := negotiate(ctx.Req.Header.Get("Accept"), offersFor(problem))
+	if !ok {
+		mediaType = mediaJSON
+	}
+	body, merr := encodeAs(mediaType, problem)
+	if merr != nil {
+		s.logger.Println(merr)
+		http.Error(ctx.Resp, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	s.writeBody(ctx, e.Status, contentType(mediaType, true), body)
+}
+
+// recovery renders a panicking handler as a 500 problem
+func (s *Server) recovery(ctx *macaron.Context) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Printf("PANIC: %v\n%s", r, debug.Stack())
+			s.renderError(ctx, internalError(fmt.Errorf("panic: %v", r)))
+		}
+	}()
+
+	ctx.Next()
+}
+
+func (s *Server) notFoundRoute(ctx *macaron.Context) {
+	s.renderError(ctx, notFound("No resource at %s", ctx.Req.URL.Path))
+}
+
+const requestIDHeader = "X-Request-ID"
+
+// assignRequestID takes the request ID sent by the client or makes a new one,
+// and echoes it in the response
+func (s *Server) assignRequestID(ctx *macaron.Context) {
+	id := ctx.Req.Header.Get(requestIDHeader)
+	if !validRequestID(id) {
+		id = newID()
+	}
+
+	ctx.Data["RequestID"] = id
+	ctx.Resp.Header().Set(requestIDHeader, id)
+}
+
+// newID returns a random ID of 32 hex digits
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func requestID(ctx *macaron.Context) string {
+	id, _ := ctx.Data["RequestID"].(string)
+	return id
+}
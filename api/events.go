@@ -0,0 +1,412 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-xorm/xorm"
+)
+
+// Worker event types
+const (
+	WorkerCreated = "created"
+	WorkerUpdated = "updated"
+	WorkerDeleted = "deleted"
+)
+
+// WorkerEvent is a change of a worker, Worker is the worker after the
+// change, or as it was when deleted. Events are recorded in the
+// transaction of the change, ResourceVersion orders them.
+type WorkerEvent struct {
+	ResourceVersion int64     `json:"resource_version" xorm:"pk autoincr 'id'"`
+	Type            string    `json:"type" xorm:"not null varchar(16)"`
+	Worker          Worker    `json:"worker" xorm:"-"`
+	CreatedAt       time.Time `json:"-" xorm:"not null index"`
+
+	// Data is the worker as JSON, the way it is stored
+	Data string `json:"-" xorm:"'worker' not null text"`
+}
+
+// EventStore persists the events of workers
+type EventStore interface {
+	// CreateEvent records the event, setting its ResourceVersion. The
+	// versions are taken in the order the events are written, so an event
+	// may be committed after one with a later version, see eventGapTimeout.
+	CreateEvent(event *WorkerEvent) error
+	// ListEvents returns up to limit events coming after the resource
+	// version after, oldest first
+	ListEvents(after int64, limit int) ([]WorkerEvent, error)
+	// EventVersions returns the resource versions of the first and the
+	// last events kept, zeros if there are none
+	EventVersions() (first, last int64, err error)
+	// DeleteEvents deletes the events recorded before t, except the last
+	// one, which tells that the versions before it were deleted
+	DeleteEvents(before time.Time) (int64, error)
+}
+
+func (s *XormStore) CreateEvent(event *WorkerEvent) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		data, err := json.Marshal(event.Worker)
+		if err != nil {
+			return err
+		}
+		event.Data = string(data)
+		if _, err := session.Insert(event); err != nil {
+			return err
+		}
+		if s.postgres() {
+			// Delivered on commit to the servers listening, see
+			// NotifyEvents
+			_, err = session.Exec("NOTIFY " + eventChannel)
+		}
+		return err
+	})
+}
+
+func (s *XormStore) ListEvents(after int64, limit int) ([]WorkerEvent, error) {
+	events := make([]WorkerEvent, 0)
+	if err := s.db.Where("id > ?", after).Asc("id").Limit(limit).Find(&events); err != nil {
+		return nil, err
+	}
+	for i := range events {
+		if err := json.Unmarshal([]byte(events[i].Data), &events[i].Worker); err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+func (s *XormStore) EventVersions() (int64, int64, error) {
+	var first, last WorkerEvent
+	if _, err := s.db.Asc("id").Get(&first); err != nil {
+		return 0, 0, err
+	}
+	if _, err := s.db.Desc("id").Get(&last); err != nil {
+		return 0, 0, err
+	}
+	return first.ResourceVersion, last.ResourceVersion, nil
+}
+
+func (s *XormStore) DeleteEvents(before time.Time) (int64, error) {
+	_, last, err := s.EventVersions()
+	if err != nil {
+		return 0, err
+	}
+	return s.db.Where("created_at < ? AND id < ?", before, last).Delete(new(WorkerEvent))
+}
+
+// recordEvents records the changes of the workers in tx, and queues them
+// for the webhooks subscribed to them
+func (s *Server) recordEvents(tx Store, typ string, workers ...Worker) error {
+	for _, worker := range workers {
+		if err := tx.CreateEvent(&WorkerEvent{Type: typ, Worker: worker, CreatedAt: s.clock.Now()}); err != nil {
+			return err
+		}
+		if err := s.queueWebhooks(tx, "worker."+typ, worker, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyChange wakes the watches and the webhooks up after changes of
+// workers were committed
+func (s *Server) notifyChange() {
+	s.events.notify()
+	s.wakeWebhooks()
+	s.invalidateCache()
+}
+
+// notifyCommitted notifies of the changes made in store once they are
+// committed. A store bound to a transaction leaves them to the caller
+// that began it, to notify once it commits, as a rolled back change must
+// not be seen and a read made before the commit must not be cached.
+func (s *Server) notifyCommitted(store Store) {
+	if tx, ok := store.(interface{ boundToTransaction() bool }); ok && tx.boundToTransaction() {
+		return
+	}
+	s.notifyChange()
+}
+
+// PruneEvents deletes the events older than the event retention, a watch
+// can't resume from before them anymore. Run prunes them along with
+// applying the scheduled changes.
+func (s *Server) PruneEvents() (int64, error) {
+	return s.store.DeleteEvents(s.clock.Now().Add(-s.eventRetention))
+}
+
+// watchBuffer is how many events a watcher can fall behind before it is
+// dropped
+const watchBuffer = 64
+
+// eventBatch is how many events are read from the store at once
+const eventBatch = 100
+
+// eventGapTimeout is how long the events after a missing resource version
+// are held back. The writers don't wait for each other, so the version
+// may belong to a transaction that is still to commit; one missing for
+// longer belongs to a transaction rolled back.
+const eventGapTimeout = 10 * time.Second
+
+// workerEvents fans the events recorded in the store out to the watchers
+// of this process. It polls the store while anyone watches, so the
+// changes made by every server sharing the database are seen, and polls
+// right away when woken by a change made by this one.
+type workerEvents struct {
+	store    EventStore
+	clock    Clock
+	interval time.Duration
+	logger   *log.Logger
+	wake     chan struct{}
+
+	mu       sync.Mutex
+	watchers map[chan WorkerEvent]struct{}
+	closed   bool
+	polling  bool
+	// last is the resource version of the last event fanned out, the
+	// versions after it have been missing since gapSince if it is set
+	last     int64
+	gapSince time.Time
+}
+
+func newWorkerEvents(store EventStore, clock Clock, interval time.Duration, logger *log.Logger) *workerEvents {
+	return &workerEvents{
+		store:    store,
+		clock:    clock,
+		interval: interval,
+		logger:   logger,
+		wake:     make(chan struct{}, 1),
+		watchers: make(map[chan WorkerEvent]struct{}),
+	}
+}
+
+// watch returns a channel receiving the events coming after the resource
+// version it returns, and a function to stop watching. The channel is
+// closed when the watcher is stopped, dropped for falling behind, or when
+// the events are closed.
+func (w *workerEvents) watch() (<-chan WorkerEvent, int64, func(), error) {
+	ch := make(chan WorkerEvent, watchBuffer)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		close(ch)
+		return ch, w.last, func() {}, nil
+	}
+	if !w.polling {
+		_, last, err := w.store.EventVersions()
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		w.last = last
+		w.polling = true
+		go w.poll()
+	}
+	w.watchers[ch] = struct{}{}
+	return ch, w.last, func() { w.drop(ch) }, nil
+}
+
+// notify wakes the poller up after a change was committed
+func (w *workerEvents) notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close ends every watch, for shutting the server down
+func (w *workerEvents) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	for ch := range w.watchers {
+		delete(w.watchers, ch)
+		close(ch)
+	}
+	w.notify()
+}
+
+func (w *workerEvents) isClosed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.closed
+}
+
+func (w *workerEvents) drop(ch chan WorkerEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.watchers[ch]; ok {
+		delete(w.watchers, ch)
+		close(ch)
+	}
+}
+
+// poll fans the new events out every interval, or when woken, until no
+// one watches anymore
+func (w *workerEvents) poll() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.wake:
+		}
+		if !w.fetch() {
+			return
+		}
+	}
+}
+
+// fetch fans the events recorded since the last one out, and tells
+// whether to keep polling
+func (w *workerEvents) fetch() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed || len(w.watchers) == 0 {
+		w.polling = false
+		return false
+	}
+	for {
+		events, err := w.store.ListEvents(w.last, eventBatch)
+		if err != nil {
+			w.logger.Println("reading the worker events:", err)
+			return true
+		}
+		for _, event := range events {
+			if event.ResourceVersion > w.last+1 && !w.gapTimedOut() {
+				return true
+			}
+			w.last = event.ResourceVersion
+			w.gapSince = time.Time{}
+			for ch := range w.watchers {
+				select {
+				case ch <- event:
+				default:
+					delete(w.watchers, ch)
+					close(ch)
+				}
+			}
+		}
+		if len(events) < eventBatch {
+			return true
+		}
+	}
+}
+
+// gapTimedOut tells whether the versions missing after the last event have
+// been missing for eventGapTimeout, and starts timing them otherwise
+func (w *workerEvents) gapTimedOut() bool {
+	if w.gapSince.IsZero() {
+		w.gapSince = w.clock.Now()
+	}
+	return w.clock.Now().Sub(w.gapSince) >= eventGapTimeout
+}
+
+var (
+	errWatchClosed  = errors.New("the server is shutting down")
+	errWatchDropped = errors.New("the watch fell behind the changes")
+)
+
+// workerWatch is a watch of the events of the workers selected by filter,
+// by their state after the change
+type workerWatch struct {
+	s      *Server
+	filter WorkerFilter
+	events <-chan WorkerEvent
+	stop   func()
+	// from and until are the resource versions of the events to read
+	// from the store before the ones fanned out
+	from, until int64
+}
+
+// watchWorkers starts a watch of the events after the resource version
+// from, or of the ones from now on if from is zero. It fails with 410 if
+// the events after from were pruned.
+func (s *Server) watchWorkers(from int64, filter WorkerFilter) (*workerWatch, error) {
+	events, last, stop, err := s.events.watch()
+	if err != nil {
+		return nil, err
+	}
+	watch := &workerWatch{s: s, filter: filter, events: events, stop: stop, from: last, until: last}
+	if from > 0 {
+		first, _, err := s.store.EventVersions()
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		if from < first-1 {
+			stop()
+			return nil, gone("The events after resource version %d were pruned, list the workers again", from)
+		}
+		watch.from = from
+	}
+	return watch, nil
+}
+
+// run calls send with the events of the watch until done is closed, the
+// watch ends or send fails, and with nil every keepAlive if it isn't zero
+func (w *workerWatch) run(done <-chan struct{}, keepAlive time.Duration, send func(*WorkerEvent) error) error {
+	defer w.stop()
+
+replay:
+	for w.from < w.until {
+		events, err := w.s.store.ListEvents(w.from, eventBatch)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			break
+		}
+		for i := range events {
+			if events[i].ResourceVersion > w.until {
+				break replay
+			}
+			w.from = events[i].ResourceVersion
+			if w.selects(&events[i].Worker) {
+				if err := send(&events[i]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	var tick <-chan time.Time
+	if keepAlive > 0 {
+		ticker := time.NewTicker(keepAlive)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-tick:
+			if err := send(nil); err != nil {
+				return err
+			}
+		case event, ok := <-w.events:
+			if !ok {
+				if w.s.events.isClosed() {
+					return errWatchClosed
+				}
+				return errWatchDropped
+			}
+			// A watch resumed from a version the server hasn't seen yet
+			// skips the events up to it
+			if event.ResourceVersion <= w.from || !w.selects(&event.Worker) {
+				continue
+			}
+			if err := send(&event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (w *workerWatch) selects(worker *Worker) bool {
+	return (w.filter.Department == "" || worker.Department == w.filter.Department) &&
+		(w.filter.Team == "" || worker.Team == w.filter.Team) &&
+		(w.filter.Manager == "" || worker.Manager == w.filter.Manager)
+}
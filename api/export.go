@@ -0,0 +1,266 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/macaron.v1"
+)
+
+// Export formats
+const (
+	ExportCSV    = "csv"
+	ExportNDJSON = "ndjson"
+	ExportXLSX   = "xlsx"
+)
+
+// exportFlushRows is how many rows are written between flushes of the response
+const exportFlushRows = 100
+
+// exportColumn is a worker field that can be exported
+type exportColumn struct {
+	name string
+	// hrOnly columns are exported to the users with the hr role only
+	hrOnly bool
+	// value returns a string, an int64 or a time.Time
+	value func(w *Worker) interface{}
+}
+
+// exportColumns are the exportable fields in their default order, named
+// like the worker's JSON fields so an exported CSV can be imported again
+var exportColumns = []exportColumn{
+	{"username", false, func(w *Worker) interface{} { return w.Username }},
+	{"firstname", false, func(w *Worker) interface{} { return w.FirstName }},
+	{"lastname", false, func(w *Worker) interface{} { return w.LastName }},
+	{"city", false, func(w *Worker) interface{} { return w.City }},
+	{"division", false, func(w *Worker) interface{} { return w.Division }},
+	{"position", false, func(w *Worker) interface{} { return w.Position }},
+	{"salary", true, func(w *Worker) interface{} { return w.Salary }},
+	{"currency", true, func(w *Worker) interface{} { return w.Currency }},
+	{"salary_override", true, func(w *Worker) interface{} { return w.SalaryOverride }},
+	{"salary_override_by", true, func(w *Worker) interface{} { return w.SalaryOverrideBy }},
+	{"department", false, func(w *Worker) interface{} { return w.Department }},
+	{"team", false, func(w *Worker) interface{} { return w.Team }},
+	{"manager", false, func(w *Worker) interface{} { return w.Manager }},
+	{"created_at", false, func(w *Worker) interface{} { return w.CreatedAt }},
+	{"updated_at", false, func(w *Worker) interface{} { return w.UpdatedAt }},
+}
+
+// selectColumns returns the columns named by the comma separated list, or
+// all of the columns the user may see when the list is empty
+func (s *Server) selectColumns(ctx *macaron.Context, list string) ([]exportColumn, error) {
+	hr := s.hasRole(ctx, RoleHR)
+	if strings.TrimSpace(list) == "" {
+		var columns []exportColumn
+		for _, c := range exportColumns {
+			if hr || !c.hrOnly {
+				columns = append(columns, c)
+			}
+		}
+		return columns, nil
+	}
+
+	var columns []exportColumn
+	var hidden []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		c, ok := findExportColumn(name)
+		if !ok {
+			return nil, validationFailed(FieldError{Field: "columns", Message: fmt.Sprintf("%q is not a worker field", name)})
+		}
+		if c.hrOnly && !hr {
+			hidden = append(hidden, name)
+		}
+		columns = append(columns, c)
+	}
+	if len(hidden) > 0 {
+		return nil, forbidden(fmt.Sprintf("Only HR can export the %s columns", strings.Join(hidden, ", ")))
+	}
+	return columns, nil
+}
+
+func findExportColumn(name string) (exportColumn, bool) {
+	for _, c := range exportColumns {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return exportColumn{}, false
+}
+
+// rowWriter writes the exported workers in one of the formats
+type rowWriter interface {
+	writeHeader(columns []exportColumn) error
+	writeRow(values []interface{}) error
+	flush() error
+	close() error
+}
+
+type csvRowWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvRowWriter) writeHeader(columns []exportColumn) error {
+	names := make([]string, len(columns))
+	for i, col := range columns {
+		names[i] = col.name
+	}
+	return c.w.Write(names)
+}
+
+func (c *csvRowWriter) writeRow(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		switch v := v.(type) {
+		case int64:
+			record[i] = strconv.FormatInt(v, 10)
+		case time.Time:
+			record[i] = v.Format(time.RFC3339)
+		default:
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvRowWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvRowWriter) close() error { return c.flush() }
+
+// ndjsonRowWriter writes a JSON object per row with the keys in the
+// order of the columns
+type ndjsonRowWriter struct {
+	w     io.Writer
+	names [][]byte
+	buf   bytes.Buffer
+}
+
+func (n *ndjsonRowWriter) writeHeader(columns []exportColumn) error {
+	for _, c := range columns {
+		name, err := json.Marshal(c.name)
+		if err != nil {
+			return err
+		}
+		n.names = append(n.names, name)
+	}
+	return nil
+}
+
+func (n *ndjsonRowWriter) writeRow(values []interface{}) error {
+	n.buf.Reset()
+	n.buf.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			n.buf.WriteByte(',')
+		}
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		n.buf.Write(n.names[i])
+		n.buf.WriteByte(':')
+		n.buf.Write(value)
+	}
+	n.buf.WriteString("}\n")
+	_, err := n.w.Write(n.buf.Bytes())
+	return err
+}
+
+func (n *ndjsonRowWriter) flush() error { return nil }
+func (n *ndjsonRowWriter) close() error { return nil }
+
+// exportWorkers streams the workers selected like showAllWorkers does as
+// CSV, NDJSON or XLSX, reading them from the database while writing them.
+// Once the first row is written the status can't change anymore, so an
+// error past that point is logged and cuts the response short.
+func (s *Server) exportWorkers(ctx *macaron.Context) error {
+	format := ctx.QueryTrim("format")
+	if format == "" {
+		format = ExportCSV
+	}
+	var contentType string
+	switch format {
+	case ExportCSV:
+		contentType = "text/csv; charset=utf-8"
+	case ExportNDJSON:
+		contentType = "application/x-ndjson"
+	case ExportXLSX:
+		contentType = xlsxContentType
+	default:
+		return validationFailed(FieldError{Field: "format", Message: "must be one of csv, ndjson, xlsx"})
+	}
+	columns, err := s.selectColumns(ctx, ctx.Query("columns"))
+	if err != nil {
+		return err
+	}
+
+	// Load the exchange rates up front, the store is busy reading the
+	// workers while they are converted
+	conv, err := s.currencyQuery(ctx)
+	if err != nil {
+		return err
+	}
+	filter := workerFilter(ctx)
+	if err := s.loadRates(conv, filter); err != nil {
+		return err
+	}
+
+	var rw rowWriter
+	switch format {
+	case ExportCSV:
+		rw = &csvRowWriter{w: csv.NewWriter(ctx.Resp)}
+	case ExportNDJSON:
+		rw = &ndjsonRowWriter{w: ctx.Resp}
+	case ExportXLSX:
+		rw = newXLSXWriter(ctx.Resp, "Workers")
+	}
+
+	ctx.Resp.Header().Set("Content-Type", contentType)
+	ctx.Resp.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="workers.%s"`, format))
+	ctx.Resp.WriteHeader(http.StatusOK)
+
+	rows := 0
+	err = rw.writeHeader(columns)
+	if err == nil {
+		err = s.store.IterateWorkers(filter, func(w *Worker) error {
+			if conv != nil {
+				salary, err := conv.convert(w.Salary, w.Currency)
+				if err != nil {
+					return err
+				}
+				w.Salary, w.Currency = salary, conv.to
+			}
+			values := make([]interface{}, len(columns))
+			for i, c := range columns {
+				values[i] = c.value(w)
+			}
+			if err := rw.writeRow(values); err != nil {
+				return err
+			}
+			if rows++; rows%exportFlushRows == 0 {
+				if err := rw.flush(); err != nil {
+					return err
+				}
+				ctx.Resp.Flush()
+			}
+			return nil
+		})
+	}
+	if err == nil {
+		err = rw.close()
+	}
+	if err != nil {
+		s.logger.Printf("export of workers stopped after %d rows: %v", rows, err)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExportWorkers(t *testing.T) {
+	srvr := newTestServer(t)
+
+	test := []testData{
+		{
+			"add_worker_with_manager",
+			"POST",
+			"/appscode/workers",
+			201,
+			strings.NewReader(`{"username":"rahim","firstname":"Rahim","lastname":"Uddin","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":6000,"manager":"masud"}`),
+		},
+		{
+			"add_rates_for_export",
+			"POST",
+			"/appscode/exchange-rates",
+			201,
+			strings.NewReader(`[{"base":"USD","quote":"BDT","date":"2019-03-01","rate":"84.5"}]`),
+		},
+		{"export_workers_csv", "GET", "/appscode/workers/export", 200, nil},
+		{"export_workers_ndjson", "GET", "/appscode/workers/export?format=ndjson&manager=masud&columns=username,manager,created_at", 200, nil},
+		{"export_workers_converted", "GET", "/appscode/workers/export?columns=username,salary,currency&currency=USD", 200, nil},
+		{"export_workers_no_rate", "GET", "/appscode/workers/export?currency=EUR", 422, nil},
+		{"export_workers_bad_format", "GET", "/appscode/workers/export?format=pdf", 422, nil},
+		{"export_workers_unknown_column", "GET", "/appscode/workers/export?columns=username,password", 422, nil},
+	}
+	for _, data := range test {
+		runTest(t, srvr, data)
+	}
+}
+
+func TestExportWorkersXLSX(t *testing.T) {
+	srvr := newTestServer(t)
+
+	rec := serveTest(t, srvr, testData{"export_workers_xlsx", "GET", "/appscode/workers/export?format=xlsx&columns=username,firstname,salary", 200, nil})
+	if ct := rec.Header().Get("Content-Type"); ct != xlsxContentType {
+		t.Errorf("got Content-Type %q", ct)
+	}
+	body := rec.Body.Bytes()
+	r, err := zip.NewReader(strings.NewReader(string(body)), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sheet struct {
+		Rows []struct {
+			Cells []struct {
+				Type   string `xml:"t,attr"`
+				Value  string `xml:"v"`
+				Inline string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			if err := xml.Unmarshal(content, &sheet); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := xml.Unmarshal(content, new(interface{})); err != nil {
+			t.Errorf("%s: %v", f.Name, err)
+		}
+	}
+
+	var got []string
+	for _, row := range sheet.Rows {
+		var cells []string
+		for _, c := range row.Cells {
+			cells = append(cells, c.Type+":"+c.Value+c.Inline)
+		}
+		got = append(got, strings.Join(cells, ","))
+	}
+	expected := []string{
+		"inlineStr:username,inlineStr:firstname,inlineStr:salary",
+		"inlineStr:fahim,inlineStr:Fahim,n:5500",
+		"inlineStr:jenny,inlineStr:Jannatul,n:5500",
+		"inlineStr:masud,inlineStr:Masudur,n:5500",
+		"inlineStr:tahsin,inlineStr:Tahsin,n:5500",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("got rows\n%s\nexpected\n%s", strings.Join(got, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestExportSalariesNeedHR(t *testing.T) {
+	srvr := newTestServer(t, WithBypassAuth(false), WithAuthProvider(StaticAuth{"masud": "pass", "admin": "admin"}))
+
+	for _, data := range []struct {
+		name   string
+		user   string
+		pass   string
+		url    string
+		status int
+	}{
+		{"export_workers_without_hr", "masud", "pass", "/appscode/workers/export", 200},
+		{"export_salaries_without_hr", "masud", "pass", "/appscode/workers/export?columns=username,salary,salary_override", 403},
+		{"export_workers_as_hr", "admin", "admin", "/appscode/workers/export", 200},
+		{"export_salaries_as_hr", "admin", "admin", "/appscode/workers/export?columns=username,salary", 200},
+	} {
+		req := httptest.NewRequest("GET", data.url, nil)
+		req.Header.Set("X-Request-ID", data.name)
+		req.SetBasicAuth(data.user, data.pass)
+		rec := httptest.NewRecorder()
+		srvr.Handler().ServeHTTP(rec, req)
+		if rec.Code != data.status {
+			t.Errorf("%s: got status %v expected %v", data.name, rec.Code, data.status)
+		}
+		checkGolden(t, data.name, dumpResponse(rec))
+	}
+}
@@ -0,0 +1,478 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The JSON tags are the only field names of the API. The other formats
+// are encoded from the JSON of a value, and decoded by turning them into
+// JSON first, so the names and the validation of the fields are the same
+// in every format.
+
+// object is a JSON object that keeps the order of its members, so the
+// other formats list the fields in the same order as JSON does
+type object []member
+
+type member struct {
+	key   string
+	value interface{}
+}
+
+func (o object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(m.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(m.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func encodeJSON(v interface{}) ([]byte, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(body, '\n'), nil
+}
+
+// toTree returns the JSON of v as an object, []interface{}, string,
+// json.Number, bool or nil
+func toTree(v interface{}) (interface{}, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	return readTree(decoder)
+}
+
+func readTree(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		o := object{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := readTree(decoder)
+			if err != nil {
+				return nil, err
+			}
+			o = append(o, member{key.(string), value})
+		}
+		_, err := decoder.Token()
+		return o, err
+	case json.Delim('['):
+		a := []interface{}{}
+		for decoder.More() {
+			value, err := readTree(decoder)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, value)
+		}
+		_, err := decoder.Token()
+		return a, err
+	}
+	return token, nil
+}
+
+// number returns n as an int64 when it is a whole number, as a float64 otherwise
+func number(n json.Number) interface{} {
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	f, _ := n.Float64()
+	return f
+}
+
+func encodeYAML(v interface{}) ([]byte, error) {
+	tree, err := toTree(v)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(yamlValue(tree))
+}
+
+func yamlValue(tree interface{}) interface{} {
+	switch t := tree.(type) {
+	case object:
+		m := make(yaml.MapSlice, len(t))
+		for i, member := range t {
+			m[i] = yaml.MapItem{Key: member.key, Value: yamlValue(member.value)}
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(t))
+		for i := range t {
+			a[i] = yamlValue(t[i])
+		}
+		return a
+	case json.Number:
+		return number(t)
+	}
+	return tree
+}
+
+// xmlName returns the element name of a value of type t: the snake case
+// name of its type, or "response" for the unnamed ones
+func xmlName(t reflect.Type) string {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Name() == "" || t.PkgPath() == "" {
+		return "response"
+	}
+	var name []rune
+	for i, r := range t.Name() {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				name = append(name, '_')
+			}
+			r = unicode.ToLower(r)
+		}
+		name = append(name, r)
+	}
+	return string(name)
+}
+
+// encodeXML writes an element per member of an object. A list of structs
+// is a <list> of elements named after the struct, lists inside objects are
+// elements holding an <item> per value.
+func encodeXML(v interface{}) ([]byte, error) {
+	tree, err := toTree(v)
+	if err != nil {
+		return nil, err
+	}
+	root, item := xmlName(reflect.TypeOf(v)), "item"
+	if isList(v) {
+		root, item = "list", xmlName(reflect.TypeOf(v).Elem())
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buf)
+	if err := writeXML(encoder, root, item, tree); err != nil {
+		return nil, err
+	}
+	if err := encoder.Flush(); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+func writeXML(encoder *xml.Encoder, name, item string, tree interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if !validXMLName(name) {
+		// Map keys can be any text, they are kept in an attribute
+		start = xml.StartElement{
+			Name: xml.Name{Local: "entry"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: name}},
+		}
+	}
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+
+	var err error
+	switch t := tree.(type) {
+	case object:
+		for _, m := range t {
+			if err = writeXML(encoder, m.key, "item", m.value); err != nil {
+				break
+			}
+		}
+	case []interface{}:
+		for _, value := range t {
+			if err = writeXML(encoder, item, "item", value); err != nil {
+				break
+			}
+		}
+	case nil:
+	default:
+		err = encoder.EncodeToken(xml.CharData(fmt.Sprint(t)))
+	}
+	if err != nil {
+		return err
+	}
+	return encoder.EncodeToken(start.End())
+}
+
+func validXMLName(name string) bool {
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+	for i, r := range name {
+		if !unicode.IsLetter(r) && r != '_' && (i == 0 || !unicode.IsDigit(r) && r != '-' && r != '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeCSV writes a list of objects as CSV with a column per member,
+// values that are objects or lists are written as JSON
+func encodeCSV(v interface{}) ([]byte, error) {
+	tree, err := toTree(v)
+	if err != nil {
+		return nil, err
+	}
+	rows, ok := tree.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("only lists can be written as CSV, not %T", v)
+	}
+
+	var header []string
+	index := make(map[string]int)
+	for _, row := range rows {
+		o, _ := row.(object)
+		for _, m := range o {
+			if _, ok := index[m.key]; !ok {
+				index[m.key] = len(header)
+				header = append(header, m.key)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(header)
+	for _, row := range rows {
+		record := make([]string, len(header))
+		o, _ := row.(object)
+		for _, m := range o {
+			switch value := m.value.(type) {
+			case nil:
+			case string:
+				record[index[m.key]] = value
+			case object, []interface{}:
+				cell, err := json.Marshal(value)
+				if err != nil {
+					return nil, err
+				}
+				record[index[m.key]] = string(cell)
+			default:
+				record[index[m.key]] = fmt.Sprint(value)
+			}
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// yamlToJSON converts a YAML document to JSON
+func yamlToJSON(body []byte) ([]byte, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+	doc, err := jsonValue(doc)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// jsonValue turns the maps decoded from YAML or MessagePack, which may
+// have keys of any type, into maps with string keys
+func jsonValue(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for key, value := range t {
+			k, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("the key %v isn't a string", key)
+			}
+			var err error
+			if m[k], err = jsonValue(value); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case []interface{}:
+		a := make([]interface{}, len(t))
+		for i := range t {
+			var err error
+			if a[i], err = jsonValue(t[i]); err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	case []byte:
+		return string(t), nil
+	}
+	return v, nil
+}
+
+// xmlNode is an element of an XML document
+type xmlNode struct {
+	name     string
+	text     string
+	children []*xmlNode
+}
+
+func readXML(r io.Reader) (*xmlNode, error) {
+	decoder := xml.NewDecoder(r)
+	var stack []*xmlNode
+	var root *xmlNode
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &xmlNode{name: t.Name.Local}
+			if t.Name.Local == "entry" {
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "key" {
+						node.name = attr.Value
+					}
+				}
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			} else if root != nil {
+				return nil, fmt.Errorf("unexpected element <%s> after the document", t.Name.Local)
+			} else {
+				root = node
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(t)
+			}
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("the document has no element")
+	}
+	return root, nil
+}
+
+var (
+	timeType        = reflect.TypeOf(time.Time{})
+	unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// xmlToJSON converts an XML document to the JSON of a value of type t.
+// XML has no types, so the type tells which elements are lists and which
+// text is a number or a boolean.
+func xmlToJSON(r io.Reader, t reflect.Type) ([]byte, error) {
+	root, err := readXML(r)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(xmlValue(root, t))
+}
+
+func xmlValue(node *xmlNode, t reflect.Type) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeType || reflect.PtrTo(t).Implements(unmarshalerType) {
+		return node.text
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		fields := jsonFields(t)
+		o := make(map[string]interface{}, len(node.children))
+		for _, child := range node.children {
+			if ft, ok := fields[child.name]; ok {
+				o[child.name] = xmlValue(child, ft)
+			} else {
+				// Left for the decoder to report as an unknown field
+				o[child.name] = child.text
+			}
+		}
+		return o
+	case reflect.Map:
+		o := make(map[string]interface{}, len(node.children))
+		for _, child := range node.children {
+			o[child.name] = xmlValue(child, t.Elem())
+		}
+		return o
+	case reflect.Slice, reflect.Array:
+		a := make([]interface{}, len(node.children))
+		for i, child := range node.children {
+			a[i] = xmlValue(child, t.Elem())
+		}
+		return a
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(strings.TrimSpace(node.text)); err == nil {
+			return b
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		text := strings.TrimSpace(node.text)
+		if text != "" && strings.IndexByte("-0123456789", text[0]) >= 0 && json.Valid([]byte(text)) {
+			return json.Number(text)
+		}
+	case reflect.Interface:
+		if len(node.children) > 0 {
+			return xmlValue(node, reflect.TypeOf(map[string]interface{}{}))
+		}
+	}
+	return node.text
+}
+
+// jsonFields returns the types of the fields of a struct by their JSON names
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag := f.Tag.Get("json"); tag == "-" {
+			continue
+		} else if n := strings.Split(tag, ",")[0]; n != "" {
+			name = n
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
@@ -0,0 +1,557 @@
+package api
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/location"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"gopkg.in/macaron.v1"
+)
+
+// The GraphQL endpoint serves the worker directory in one schema, see
+// graphQLSchema, run by graphql-go. The managers and reports of the
+// workers are fetched a level of the response at a time by a
+// workerLoader. Every request is authenticated as the REST ones are, and
+// the mutations go through the same validation and roles.
+
+// graphQLRequest is the body of a POST /graphql, or the parameters of a
+// GET. Its fields are named as GraphQL clients send them.
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	Extensions    *graphQLExtensions     `json:"extensions,omitempty"`
+}
+
+type graphQLExtensions struct {
+	PersistedQuery *persistedQueryRef `json:"persistedQuery,omitempty"`
+}
+
+// persistedQueryRef names a persisted query by the SHA-256 hash of its
+// document, in hex
+type persistedQueryRef struct {
+	Version    int    `json:"version"`
+	SHA256Hash string `json:"sha256Hash"`
+}
+
+// graphQLResponse is the body of a response of /graphql. Data is missing
+// when the operation could not run.
+type graphQLResponse struct {
+	Errors []*gqlError            `json:"errors,omitempty"`
+	Data   map[string]interface{} `json:"data,omitempty"`
+}
+
+// gqlError is an error of a GraphQL response. The errors of the API are
+// given with their status and problem type in the extensions.
+type gqlError struct {
+	Message    string                    `json:"message"`
+	Locations  []location.SourceLocation `json:"locations,omitempty"`
+	Path       []interface{}             `json:"path,omitempty"`
+	Extensions map[string]interface{}    `json:"extensions,omitempty"`
+}
+
+func toGQLErrors(errs []gqlerrors.FormattedError) []*gqlError {
+	converted := make([]*gqlError, len(errs))
+	for i, e := range errs {
+		converted[i] = &gqlError{Message: e.Message, Locations: e.Locations, Path: e.Path, Extensions: e.Extensions}
+	}
+	return converted
+}
+
+// Default limits of a GraphQL operation
+const (
+	defaultGraphQLMaxDepth      = 10
+	defaultGraphQLMaxComplexity = 10000
+)
+
+// workersPageSize is the size of a page of workers when none is asked for
+const workersPageSize = 100
+
+// reportsEstimate is the number of reports a worker is expected to have,
+// for the complexity of operations
+const reportsEstimate = 10
+
+// maxPersistedQueries bounds the persisted queries a server keeps
+const maxPersistedQueries = 1000
+
+func (s *Server) serveGraphQL(ctx *macaron.Context) error {
+	var req graphQLRequest
+	if ctx.Req.Method == http.MethodGet {
+		if err := graphQLParams(ctx, &req); err != nil {
+			return err
+		}
+	} else if err := s.decode(ctx, &req); err != nil {
+		return err
+	}
+
+	query := req.Query
+	var ref *persistedQueryRef
+	if req.Extensions != nil && req.Extensions.PersistedQuery != nil {
+		ref = req.Extensions.PersistedQuery
+		if ref.Version != 1 {
+			return validationFailed(FieldError{Field: "extensions.persistedQuery.version", Message: "must be 1"})
+		}
+		if query == "" {
+			var ok bool
+			if query, ok = s.persistedQueries.get(ref.SHA256Hash); !ok {
+				// Clients send the query along with the hash once they get this
+				return s.writeGraphQL(ctx, http.StatusOK, &gqlError{
+					Message:    "PersistedQueryNotFound",
+					Extensions: map[string]interface{}{"code": "PERSISTED_QUERY_NOT_FOUND"},
+				})
+			}
+			ref = nil
+		} else if sum := sha256.Sum256([]byte(query)); !strings.EqualFold(hex.EncodeToString(sum[:]), ref.SHA256Hash) {
+			return validationFailed(FieldError{Field: "extensions.persistedQuery.sha256Hash", Message: "is not the SHA-256 hash of the query"})
+		}
+	}
+	if query == "" {
+		return validationFailed(FieldError{Field: "query", Message: "is required"})
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(query), Name: "GraphQL request"})})
+	if err != nil {
+		return s.writeGraphQL(ctx, http.StatusBadRequest, toGQLErrors(gqlerrors.FormatErrors(err))...)
+	}
+	if ref != nil {
+		s.persistedQueries.add(ref.SHA256Hash, query)
+	}
+
+	schema := graphQLSchema()
+	if result := graphql.ValidateDocument(schema, doc, graphql.SpecifiedRules); !result.IsValid {
+		return s.writeGraphQL(ctx, http.StatusBadRequest, toGQLErrors(result.Errors)...)
+	}
+	if op := findOperation(doc, req.OperationName); op != nil && op.Operation == ast.OperationTypeMutation && ctx.Req.Method == http.MethodGet {
+		ctx.Resp.Header().Set("Allow", http.MethodPost)
+		return s.writeGraphQL(ctx, http.StatusMethodNotAllowed, &gqlError{
+			Message:   "Mutations can only be sent with POST",
+			Locations: []location.SourceLocation{location.GetLocation(doc.Loc.Source, op.Loc.Start)},
+		})
+	}
+	if errs := s.checkGraphQLLimits(schema, doc, req.OperationName, req.Variables); len(errs) > 0 {
+		return s.writeGraphQL(ctx, http.StatusBadRequest, errs...)
+	}
+
+	r := &gqlRequest{s: s, ctx: ctx, loader: newWorkerLoader(s.store)}
+	result := graphql.Execute(graphql.ExecuteParams{
+		Schema:        *schema,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       context.WithValue(ctx.Req.Context(), gqlRequestKey{}, r),
+	})
+	response := graphQLResponse{Errors: toGQLErrors(result.Errors)}
+	response.Data, _ = result.Data.(map[string]interface{})
+	if response.Data == nil && !raisedByField(response.Errors) {
+		// The variables or the operation name were wrong, nothing ran
+		return s.writeGraphQL(ctx, http.StatusBadRequest, response.Errors...)
+	}
+	body := object{}
+	if len(response.Errors) > 0 {
+		body = append(body, member{"errors", response.Errors})
+	}
+	body = append(body, member{"data", response.Data})
+	return s.writeGraphQLBody(ctx, http.StatusOK, body)
+}
+
+// raisedByField tells if one of the errors was raised by a field
+func raisedByField(errs []*gqlError) bool {
+	for _, e := range errs {
+		if len(e.Path) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// findOperation returns the operation of the document to run by name, the
+// name can be left out when there is only one. It is nil if there is none.
+func findOperation(doc *ast.Document, name string) *ast.OperationDefinition {
+	var found *ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if name == "" && found != nil {
+			return nil
+		}
+		if name == "" || op.Name != nil && op.Name.Value == name {
+			found = op
+		}
+	}
+	return found
+}
+
+// graphQLParams reads a GET request, its variables and extensions are JSON
+func graphQLParams(ctx *macaron.Context, req *graphQLRequest) error {
+	req.Query = ctx.Query("query")
+	req.OperationName = ctx.Query("operationName")
+	for _, param := range []struct {
+		name string
+		dst  interface{}
+	}{
+		{"variables", &req.Variables},
+		{"extensions", &req.Extensions},
+	} {
+		if v := ctx.Query(param.name); v != "" {
+			if err := decodeJSON(strings.NewReader(v), param.dst); err != nil {
+				return validationFailed(FieldError{Field: param.name, Message: "must be a JSON object"})
+			}
+		}
+	}
+	return nil
+}
+
+// writeGraphQL writes a response of errors alone
+func (s *Server) writeGraphQL(ctx *macaron.Context, status int, errs ...*gqlError) error {
+	return s.writeGraphQLBody(ctx, status, graphQLResponse{Errors: errs})
+}
+
+// writeGraphQLBody writes a GraphQL response, always as JSON
+func (s *Server) writeGraphQLBody(ctx *macaron.Context, status int, response interface{}) error {
+	body, err := encodeAs(mediaJSON, response)
+	if err != nil {
+		return internalError(err)
+	}
+	s.writeBody(ctx, status, mediaJSON, body)
+	return nil
+}
+
+// persistedQueries holds the queries clients persisted by their hashes,
+// so they can send the hash alone afterwards. The hashes are hex, in
+// either case. The least recently used ones are dropped beyond the limit.
+type persistedQueries struct {
+	mu      sync.Mutex
+	limit   int
+	order   *list.List
+	queries map[string]*list.Element
+}
+
+type persistedQuery struct {
+	hash, query string
+}
+
+func newPersistedQueries(limit int) *persistedQueries {
+	return &persistedQueries{limit: limit, order: list.New(), queries: make(map[string]*list.Element)}
+}
+
+func (p *persistedQueries) get(hash string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.queries[strings.ToLower(hash)]
+	if !ok {
+		return "", false
+	}
+	p.order.MoveToFront(e)
+	return e.Value.(*persistedQuery).query, true
+}
+
+func (p *persistedQueries) add(hash, query string) {
+	hash = strings.ToLower(hash)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.queries[hash]; ok {
+		p.order.MoveToFront(e)
+		return
+	}
+	p.queries[hash] = p.order.PushFront(&persistedQuery{hash, query})
+	for p.order.Len() > p.limit {
+		oldest := p.order.Back()
+		p.order.Remove(oldest)
+		delete(p.queries, oldest.Value.(*persistedQuery).hash)
+	}
+}
+
+// The limits
+
+// gqlLimits measures the depth and complexity of an operation. The
+// complexity is the number of fields the operation may resolve, with the
+// selections of list fields counted once per expected item, see
+// gqlListSizes.
+type gqlLimits struct {
+	s         *Server
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+
+	depth      int
+	complexity int
+	// spreading are the fragments being walked, the validation caught
+	// their cycles already but a walk mustn't hang on one
+	spreading map[string]bool
+}
+
+// checkGraphQLLimits returns the errors of an operation nested deeper or
+// more complex than the server allows
+func (s *Server) checkGraphQLLimits(schema *graphql.Schema, doc *ast.Document, operationName string, variables map[string]interface{}) []*gqlError {
+	op := findOperation(doc, operationName)
+	if op == nil {
+		return nil
+	}
+	l := &gqlLimits{
+		s:         s,
+		fragments: make(map[string]*ast.FragmentDefinition),
+		variables: variables,
+		spreading: make(map[string]bool),
+	}
+	for _, def := range doc.Definitions {
+		if f, ok := def.(*ast.FragmentDefinition); ok {
+			l.fragments[f.Name.Value] = f
+		}
+	}
+	root := schema.QueryType()
+	if op.Operation == ast.OperationTypeMutation {
+		root = schema.MutationType()
+	}
+	l.walk(root, op.SelectionSet, 1, 1)
+
+	var errs []*gqlError
+	if l.depth > s.graphQLMaxDepth {
+		errs = append(errs, &gqlError{
+			Message:    fmt.Sprintf("The operation is nested deeper than the limit of %d", s.graphQLMaxDepth),
+			Extensions: map[string]interface{}{"limit": s.graphQLMaxDepth},
+		})
+	}
+	if l.complexity > s.graphQLMaxComplexity {
+		errs = append(errs, &gqlError{
+			Message:    fmt.Sprintf("The operation is more complex than the limit of %d", s.graphQLMaxComplexity),
+			Extensions: map[string]interface{}{"limit": s.graphQLMaxComplexity},
+		})
+	}
+	return errs
+}
+
+// walk measures the selections of typ at depth, each resolved times times
+func (l *gqlLimits) walk(typ *graphql.Object, set *ast.SelectionSet, depth, times int) {
+	// Past the limits the rest of the operation doesn't matter, and
+	// walking it could take forever
+	if set == nil || depth > l.s.graphQLMaxDepth+1 || l.complexity > l.s.graphQLMaxComplexity {
+		return
+	}
+	for _, sel := range set.Selections {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			l.field(typ, sel, depth, times)
+		case *ast.FragmentSpread:
+			name := sel.Name.Value
+			if f, ok := l.fragments[name]; ok && !l.spreading[name] {
+				l.spreading[name] = true
+				l.walk(typ, f.SelectionSet, depth, times)
+				delete(l.spreading, name)
+			}
+		case *ast.InlineFragment:
+			l.walk(typ, sel.SelectionSet, depth, times)
+		}
+	}
+}
+
+func (l *gqlLimits) field(typ *graphql.Object, node *ast.Field, depth, times int) {
+	name := node.Name.Value
+	// The introspection isn't limited, the usual query of the tools is deep
+	if strings.HasPrefix(name, "__") {
+		return
+	}
+	def, ok := typ.Fields()[name]
+	if !ok {
+		return
+	}
+	l.complexity += times
+	if depth > l.depth {
+		l.depth = depth
+	}
+
+	obj, ok := graphql.GetNamed(def.Type).(*graphql.Object)
+	if !ok {
+		return
+	}
+	if size, ok := gqlListSizes[typ.Name()+"."+name]; ok {
+		times *= size(l.arguments(node))
+		if max := l.s.graphQLMaxComplexity + 1; times > max {
+			times = max
+		}
+	}
+	l.walk(obj, node.SelectionSet, depth+1, times)
+}
+
+// arguments returns the values of the arguments of a field that are
+// integers, given as literals or variables
+func (l *gqlLimits) arguments(node *ast.Field) map[string]int {
+	args := make(map[string]int)
+	for _, arg := range node.Arguments {
+		var value interface{}
+		switch v := arg.Value.(type) {
+		case *ast.IntValue:
+			value = graphql.Int.ParseLiteral(v)
+		case *ast.Variable:
+			value = graphql.Int.ParseValue(l.variables[v.Name.Value])
+		}
+		if n, ok := value.(int); ok {
+			args[arg.Name.Value] = n
+		}
+	}
+	return args
+}
+
+// gqlListSizes tell how many items the list fields are expected to hold,
+// by their object type and name, for the complexity of operations
+var gqlListSizes = map[string]func(args map[string]int) int{
+	"Query.workers": func(args map[string]int) int {
+		limit, ok := args["limit"]
+		if !ok {
+			return workersPageSize
+		}
+		if limit < 1 || limit > maxPageSize {
+			return maxPageSize
+		}
+		return limit
+	},
+	"Query.salary_stats": func(map[string]int) int { return reportsEstimate },
+	"Worker.reports":     func(map[string]int) int { return reportsEstimate },
+}
+
+// The requests
+
+type gqlRequestKey struct{}
+
+// gqlRequest is the state of one GraphQL operation, given to the
+// resolvers in the context of the execution
+type gqlRequest struct {
+	s      *Server
+	ctx    *macaron.Context
+	loader *workerLoader
+}
+
+// requestOf returns the request a field is resolved for
+func requestOf(p graphql.ResolveParams) *gqlRequest {
+	return p.Context.Value(gqlRequestKey{}).(*gqlRequest)
+}
+
+// gqlFieldError is an error of the API raised by a field, its status and
+// problem type are given in the extensions of the GraphQL error
+type gqlFieldError struct {
+	err *Error
+}
+
+func (e gqlFieldError) Error() string { return e.err.Detail }
+
+func (e gqlFieldError) Extensions() map[string]interface{} {
+	ext := map[string]interface{}{"status": e.err.Status, "type": e.err.Type}
+	if len(e.err.Errors) > 0 {
+		ext["errors"] = e.err.Errors
+	}
+	return ext
+}
+
+// resolver adapts a resolver of the API to graphql-go, logging the server
+// errors it returns and giving all of them as a gqlFieldError
+func resolver(resolve func(r *gqlRequest, p graphql.ResolveParams) (interface{}, error)) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		r := requestOf(p)
+		v, err := resolve(r, p)
+		if err != nil {
+			return nil, r.fieldError(p, err)
+		}
+		return v, nil
+	}
+}
+
+func (r *gqlRequest) fieldError(p graphql.ResolveParams, err error) error {
+	e := toError(err)
+	if e.Status >= http.StatusInternalServerError {
+		r.s.logger.Println("graphql", p.Info.Path.AsArray(), e)
+	}
+	return gqlFieldError{e}
+}
+
+// workerLoader batches and caches the lookups of workers made while
+// running one operation. The resolvers of a level of the response ask for
+// the workers they need and get thunks, graphql-go calls them once the
+// level is resolved, so the first one looks up the workers of all the
+// others with one query.
+type workerLoader struct {
+	store Store
+	// workers are the workers looked up, nil for the ones that don't exist
+	workers map[string]*Worker
+	// reports are the direct reports of the managers looked up
+	reports map[string][]*Worker
+	// pending are the workers and the managers of the reports to look up
+	// with the next batch
+	pending         []string
+	pendingManagers []string
+}
+
+func newWorkerLoader(store Store) *workerLoader {
+	l := &workerLoader{store: store}
+	l.reset()
+	return l
+}
+
+// worker returns a thunk of the worker with the username, nil if there is
+// no such worker
+func (l *workerLoader) worker(username string) func() (*Worker, error) {
+	if _, ok := l.workers[username]; !ok && !contains(l.pending, username) {
+		l.pending = append(l.pending, username)
+	}
+	return func() (*Worker, error) {
+		if len(l.pending) > 0 {
+			workers, err := l.store.ListWorkers(WorkerFilter{Usernames: l.pending})
+			if err != nil {
+				return nil, err
+			}
+			for _, username := range l.pending {
+				l.workers[username] = nil
+			}
+			l.pending = nil
+			l.prime(workers)
+		}
+		return l.workers[username], nil
+	}
+}
+
+// directReports returns a thunk of the direct reports of the manager
+func (l *workerLoader) directReports(manager string) func() ([]*Worker, error) {
+	if _, ok := l.reports[manager]; !ok && !contains(l.pendingManagers, manager) {
+		l.pendingManagers = append(l.pendingManagers, manager)
+	}
+	return func() ([]*Worker, error) {
+		if len(l.pendingManagers) > 0 {
+			workers, err := l.store.ListWorkers(WorkerFilter{Managers: l.pendingManagers})
+			if err != nil {
+				return nil, err
+			}
+			for _, manager := range l.pendingManagers {
+				l.reports[manager] = []*Worker{}
+			}
+			l.pendingManagers = nil
+			l.prime(workers)
+			for i := range workers {
+				l.reports[workers[i].Manager] = append(l.reports[workers[i].Manager], l.workers[workers[i].Username])
+			}
+		}
+		return l.reports[manager], nil
+	}
+}
+
+func (l *workerLoader) prime(workers []Worker) {
+	for i := range workers {
+		l.workers[workers[i].Username] = &workers[i]
+	}
+}
+
+// reset forgets the workers, once a mutation may have changed them
+func (l *workerLoader) reset() {
+	l.workers = make(map[string]*Worker)
+	l.reports = make(map[string][]*Worker)
+	l.pending, l.pendingManagers = nil, nil
+}
@@ -0,0 +1,543 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+var (
+	graphQLSchemaOnce sync.Once
+	graphQLSchemaData graphql.Schema
+)
+
+// graphQLSchema returns the schema, built on first use
+func graphQLSchema() *graphql.Schema {
+	graphQLSchemaOnce.Do(func() {
+		schema, err := newWorkerSchema()
+		if err != nil {
+			panic("graphql: " + err.Error())
+		}
+		graphQLSchemaData = schema
+	})
+	return &graphQLSchemaData
+}
+
+// gqlInt64 is a 64-bit integer, for the salaries, which outgrow the 32
+// bits of Int
+var gqlInt64 = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "Int64",
+	Description: "A 64-bit integer",
+	Serialize: func(value interface{}) interface{} {
+		switch v := value.(type) {
+		case int64:
+			return v
+		case int:
+			return int64(v)
+		}
+		return nil
+	},
+	ParseValue: func(value interface{}) interface{} {
+		switch v := value.(type) {
+		case int64:
+			return v
+		case int:
+			return int64(v)
+		case float64:
+			if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+				return int64(v)
+			}
+		}
+		return nil
+	},
+	ParseLiteral: func(value ast.Value) interface{} {
+		if v, ok := value.(*ast.IntValue); ok {
+			if n, err := strconv.ParseInt(v.Value, 10, 64); err == nil {
+				return n
+			}
+		}
+		return nil
+	},
+})
+
+// workerPage is a page of workers, and whether there are more after it
+type workerPage struct {
+	workers []Worker
+	more    bool
+}
+
+func newWorkerSchema() (graphql.Schema, error) {
+	worker, input := gqlWorkerTypes()
+
+	pageInfo := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "PageInfo",
+		Description: "Where a page of a list is",
+		Fields: graphql.Fields{
+			"has_next_page": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*workerPage).more, nil
+				},
+			},
+			"end_cursor": &graphql.Field{
+				Type:        graphql.String,
+				Description: "The cursor to pass as after for the next page",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					page := p.Source.(*workerPage)
+					if len(page.workers) == 0 {
+						return nil, nil
+					}
+					return page.workers[len(page.workers)-1].Username, nil
+				},
+			},
+		},
+	})
+	connection := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "WorkerConnection",
+		Description: "A page of workers, ordered by username",
+		Fields: graphql.Fields{
+			"nodes": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(worker))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					page := p.Source.(*workerPage)
+					nodes := make([]*Worker, len(page.workers))
+					for i := range page.workers {
+						nodes[i] = &page.workers[i]
+					}
+					return nodes, nil
+				},
+			},
+			"page_info": &graphql.Field{
+				Type: graphql.NewNonNull(pageInfo),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source, nil
+				},
+			},
+		},
+	})
+
+	statsFieldValues := graphql.EnumValueConfigMap{}
+	statsGroupFields := graphql.Fields{}
+	for _, field := range statsFields {
+		field := field
+		statsFieldValues[field] = &graphql.EnumValueConfig{Value: field}
+		statsGroupFields[field] = &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if v, ok := p.Source.(map[string]string)[field]; ok {
+					return v, nil
+				}
+				return nil, nil
+			},
+		}
+	}
+	statsFieldEnum := graphql.NewEnum(graphql.EnumConfig{
+		Name:        "StatsField",
+		Description: "A field workers can be grouped by",
+		Values:      statsFieldValues,
+	})
+	statsGroup := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "StatsGroup",
+		Description: "The values of the fields a group of workers was grouped by",
+		Fields:      statsGroupFields,
+	})
+	percentileType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Percentile",
+		Description: "A percentile of the salaries of a group",
+		Fields: graphql.Fields{
+			"percentile": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"value":      &graphql.Field{Type: graphql.NewNonNull(gqlInt64)},
+		},
+	})
+	statsType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "SalaryStats",
+		Description: "The salary statistics of a group of workers, in minor units of the currency",
+		Fields: graphql.Fields{
+			"group": &graphql.Field{
+				Type: statsGroup,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if group := p.Source.(*SalaryStats).Group; len(group) > 0 {
+						return group, nil
+					}
+					return nil, nil
+				},
+			},
+			"currency":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"headcount": &graphql.Field{Type: graphql.NewNonNull(gqlInt64)},
+			"min":       &graphql.Field{Type: graphql.NewNonNull(gqlInt64)},
+			"max":       &graphql.Field{Type: graphql.NewNonNull(gqlInt64)},
+			"avg":       &graphql.Field{Type: graphql.NewNonNull(gqlInt64)},
+			"median":    &graphql.Field{Type: graphql.NewNonNull(gqlInt64)},
+			"percentiles": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(percentileType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return gqlPercentiles(p.Source.(*SalaryStats)), nil
+				},
+			},
+		},
+	})
+
+	usernameArg := &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)}
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Query",
+		Description: "The worker directory",
+		Fields: graphql.Fields{
+			"worker": &graphql.Field{
+				Description: "The worker with the username, null if there is none",
+				Type:        worker,
+				Args:        graphql.FieldConfigArgument{"username": usernameArg},
+				Resolve:     resolver(resolveWorker),
+			},
+			"workers": &graphql.Field{
+				Description: "A page of the workers, ordered by username",
+				Type:        graphql.NewNonNull(connection),
+				Args: graphql.FieldConfigArgument{
+					"department": {Type: graphql.String, Description: "Only the workers of this department"},
+					"team":       {Type: graphql.String, Description: "Only the workers of this team"},
+					"manager":    {Type: graphql.String, Description: "Only the direct reports of this worker"},
+					"position":   {Type: graphql.String, Description: "Only the workers of this position"},
+					"limit":      {Type: graphql.Int, DefaultValue: workersPageSize, Description: fmt.Sprintf("The size of the page, up to %d", maxPageSize)},
+					"after":      {Type: graphql.String, Description: "The end_cursor of the previous page"},
+				},
+				Resolve: resolver(resolveWorkers),
+			},
+			"salary_stats": &graphql.Field{
+				Description: "The salary statistics of the selected workers, per group",
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(statsType))),
+				Args: graphql.FieldConfigArgument{
+					"group_by":    {Type: graphql.NewList(graphql.NewNonNull(statsFieldEnum)), Description: "The fields to group the workers by"},
+					"division":    {Type: graphql.String, Description: "Only the workers of this division"},
+					"city":        {Type: graphql.String, Description: "Only the workers of this city"},
+					"position":    {Type: graphql.String, Description: "Only the workers of this position"},
+					"department":  {Type: graphql.String, Description: "Only the workers of this department"},
+					"team":        {Type: graphql.String, Description: "Only the workers of this team"},
+					"percentiles": {Type: graphql.NewList(graphql.NewNonNull(graphql.Int)), DefaultValue: []interface{}{25, 75, 90}, Description: "The percentiles to report, from 1 to 99"},
+					"currency":    {Type: graphql.String, Description: "Convert the salaries to this currency"},
+					"date":        {Type: graphql.String, Description: "Convert at the rates of this date, YYYY-MM-DD, today by default"},
+				},
+				Resolve: resolver(resolveSalaryStats),
+			},
+		},
+	})
+
+	workerArg := &graphql.ArgumentConfig{Type: graphql.NewNonNull(input)}
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Mutation",
+		Description: "Changes to the worker directory",
+		Fields: graphql.Fields{
+			"create_worker": &graphql.Field{
+				Description: "Add a worker",
+				Type:        graphql.NewNonNull(worker),
+				Args:        graphql.FieldConfigArgument{"worker": workerArg},
+				Resolve:     resolver(resolveCreateWorker),
+			},
+			"update_worker": &graphql.Field{
+				Description: "Update the given fields of a worker, an empty string clears a field",
+				Type:        graphql.NewNonNull(worker),
+				Args:        graphql.FieldConfigArgument{"username": usernameArg, "worker": workerArg},
+				Resolve:     resolver(resolveUpdateWorker),
+			},
+			"delete_worker": &graphql.Field{
+				Description: "Delete a worker, moving their reports to reassign_to",
+				Type:        graphql.NewNonNull(graphql.Boolean),
+				Args: graphql.FieldConfigArgument{
+					"username":    usernameArg,
+					"reassign_to": {Type: graphql.String, Description: "The worker who takes over the reports of the deleted one"},
+				},
+				Resolve: resolver(resolveDeleteWorker),
+			},
+			"restore_worker": &graphql.Field{
+				Description: "Bring a deleted worker back",
+				Type:        graphql.NewNonNull(worker),
+				Args:        graphql.FieldConfigArgument{"username": usernameArg},
+				Resolve:     resolver(resolveRestoreWorker),
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
+
+// gqlWorkerTypes generates the Worker type and its WorkerInput from the
+// JSON fields of Worker. The fields a worker may be without are nullable.
+func gqlWorkerTypes() (*graphql.Object, *graphql.InputObject) {
+	worker := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Worker",
+		Description: "A worker of the directory",
+		Fields:      graphql.Fields{},
+	})
+	inputFields := graphql.InputObjectConfigFieldMap{}
+
+	t := reflect.TypeOf(Worker{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Tag.Get("json") == "-" {
+			continue
+		}
+		name := jsonName(f)
+		var typ graphql.Output
+		switch {
+		case f.Type == timeReflectType:
+			typ = graphql.DateTime
+		case f.Type.Kind() == reflect.Int64:
+			typ = gqlInt64
+		case f.Type.Kind() == reflect.Int:
+			typ = graphql.Int
+		case f.Type.Kind() == reflect.String:
+			typ = graphql.String
+		default:
+			panic("graphql: no type for the worker field " + name)
+		}
+
+		index := i
+		optional := strings.Contains(f.Tag.Get("json"), ",omitempty")
+		field := &graphql.Field{
+			Type: graphql.NewNonNull(typ),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				v := reflect.ValueOf(p.Source).Elem().Field(index)
+				if optional && v.Interface() == reflect.Zero(v.Type()).Interface() {
+					return nil, nil
+				}
+				return v.Interface(), nil
+			},
+		}
+		if optional {
+			field.Type = typ
+		}
+		worker.AddFieldConfig(name, field)
+
+		if !readOnlyFields[name] {
+			inputFields[name] = &graphql.InputObjectFieldConfig{Type: typ.(graphql.Input)}
+		}
+	}
+
+	worker.AddFieldConfig("reports_to", &graphql.Field{
+		Description: "The manager of the worker",
+		Type:        worker,
+		Resolve:     resolver(resolveReportsTo),
+	})
+	worker.AddFieldConfig("reports", &graphql.Field{
+		Description: "The direct reports of the worker, ordered by username",
+		Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(worker))),
+		Resolve:     resolver(resolveReports),
+	})
+
+	input := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "WorkerInput",
+		Description: "The fields of a worker, checked as the REST API checks them",
+		Fields:      inputFields,
+	})
+	return worker, input
+}
+
+// gqlPercentile is one of the percentiles of SalaryStats
+type gqlPercentile struct {
+	Percentile int   `json:"percentile"`
+	Value      int64 `json:"value"`
+}
+
+func gqlPercentiles(stats *SalaryStats) []gqlPercentile {
+	percentiles := make([]gqlPercentile, 0, len(stats.Percentiles))
+	for p := 1; p < 100; p++ {
+		if v, ok := stats.Percentiles[fmt.Sprintf("p%d", p)]; ok {
+			percentiles = append(percentiles, gqlPercentile{p, v})
+		}
+	}
+	return percentiles
+}
+
+// Resolvers
+
+func argString(args map[string]interface{}, name string) string {
+	s, _ := args[name].(string)
+	return s
+}
+
+func resolveWorker(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+	return r.thunk(p, r.loader.worker(argString(p.Args, "username"))), nil
+}
+
+func resolveWorkers(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+	limit, ok := p.Args["limit"].(int)
+	if !ok || limit < 1 || limit > maxPageSize {
+		return nil, validationFailed(FieldError{Field: "limit", Message: fmt.Sprintf("must be a number from 1 to %d", maxPageSize)})
+	}
+	// One more than the page tells if there is a next one
+	workers, err := r.s.store.ListWorkers(WorkerFilter{
+		Department: argString(p.Args, "department"),
+		Team:       argString(p.Args, "team"),
+		Manager:    argString(p.Args, "manager"),
+		Position:   argString(p.Args, "position"),
+		After:      argString(p.Args, "after"),
+		Limit:      limit + 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	page := &workerPage{workers: workers}
+	if len(workers) > limit {
+		page.workers, page.more = workers[:limit], true
+	}
+	r.loader.prime(page.workers)
+	return page, nil
+}
+
+func resolveReportsTo(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+	manager := p.Source.(*Worker).Manager
+	if manager == "" {
+		return nil, nil
+	}
+	return r.thunk(p, r.loader.worker(manager)), nil
+}
+
+func resolveReports(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+	reports := r.loader.directReports(p.Source.(*Worker).Username)
+	return func() (interface{}, error) {
+		workers, err := reports()
+		if err != nil {
+			return nil, r.fieldError(p, err)
+		}
+		return workers, nil
+	}, nil
+}
+
+// thunk adapts a lookup of a worker by the loader to graphql-go, which
+// takes an untyped nil for a worker that doesn't exist
+func (r *gqlRequest) thunk(p graphql.ResolveParams, load func() (*Worker, error)) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		w, err := load()
+		if err != nil {
+			return nil, r.fieldError(p, err)
+		}
+		if w == nil {
+			return nil, nil
+		}
+		return w, nil
+	}
+}
+
+func resolveSalaryStats(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+	filter := StatsFilter{
+		Division:   argString(p.Args, "division"),
+		City:       argString(p.Args, "city"),
+		Position:   argString(p.Args, "position"),
+		Department: argString(p.Args, "department"),
+		Team:       argString(p.Args, "team"),
+	}
+	var groupBy []string
+	if fields, ok := p.Args["group_by"].([]interface{}); ok {
+		for _, field := range fields {
+			if !contains(groupBy, field.(string)) {
+				groupBy = append(groupBy, field.(string))
+			}
+		}
+	}
+	var percentiles []int
+	if values, ok := p.Args["percentiles"].([]interface{}); ok {
+		for _, v := range values {
+			if p, ok := v.(int); !ok || p < 1 || p > 99 {
+				return nil, validationFailed(FieldError{Field: "percentiles", Message: "must be a list of numbers from 1 to 99"})
+			}
+			percentiles = append(percentiles, v.(int))
+		}
+	}
+
+	conv, err := r.s.converterFor(argString(p.Args, "currency"), argString(p.Args, "date"))
+	if err != nil {
+		return nil, err
+	}
+	stats, err := r.s.salaryStats(filter, groupBy, percentiles, conv)
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]*SalaryStats, len(stats))
+	for i := range stats {
+		groups[i] = &stats[i]
+	}
+	return groups, nil
+}
+
+// workerFromInput overlays the fields of a WorkerInput on base, and
+// decodes the result as strictly as a request body
+func workerFromInput(base *Worker, input map[string]interface{}) (*Worker, error) {
+	fields := make(map[string]interface{})
+	if base != nil {
+		data, err := json.Marshal(base)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+	}
+	for name, value := range input {
+		fields[name] = value
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	worker := new(Worker)
+	if err := decodeJSON(bytes.NewReader(data), worker); err != nil {
+		return nil, decodeError(err, 0)
+	}
+	return worker, nil
+}
+
+func resolveCreateWorker(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+	worker, err := workerFromInput(nil, p.Args["worker"].(map[string]interface{}))
+	if err != nil {
+		return nil, err
+	}
+	if err := r.s.addWorker(user(r.ctx), worker); err != nil {
+		return nil, err
+	}
+	r.loader.reset()
+	return worker, nil
+}
+
+func resolveUpdateWorker(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+	username := argString(p.Args, "username")
+	current, err := r.s.store.GetWorker(username)
+	if err == ErrNotFound {
+		return nil, notFound("Worker %q does not exist", username)
+	} else if err != nil {
+		return nil, err
+	}
+	worker, err := workerFromInput(current, p.Args["worker"].(map[string]interface{}))
+	if err != nil {
+		return nil, err
+	}
+	updated, err := r.s.updateWorker(user(r.ctx), username, worker)
+	if err != nil {
+		return nil, err
+	}
+	r.loader.reset()
+	return updated, nil
+}
+
+func resolveDeleteWorker(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+	if err := r.s.removeWorker(argString(p.Args, "username"), argString(p.Args, "reassign_to")); err != nil {
+		return nil, err
+	}
+	r.loader.reset()
+	return true, nil
+}
+
+func resolveRestoreWorker(r *gqlRequest, p graphql.ResolveParams) (interface{}, error) {
+	worker, err := r.s.reinstateWorker(argString(p.Args, "username"))
+	if err != nil {
+		return nil, err
+	}
+	r.loader.reset()
+	return worker, nil
+}
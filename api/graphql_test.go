@@ -0,0 +1,380 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// graphQLBody is the body of a POST /graphql
+func graphQLBody(t *testing.T, query string, variables map[string]interface{}) io.Reader {
+	t.Helper()
+	body, err := json.Marshal(graphQLRequest{Query: query, Variables: variables})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.NewReader(string(body))
+}
+
+// setManagers makes masud lead fahim and tahsin, and jenny report to fahim
+func setManagers(t *testing.T, srvr *Server) {
+	t.Helper()
+	for username, manager := range map[string]string{"fahim": "masud", "tahsin": "masud", "jenny": "fahim"} {
+		worker, err := srvr.store.GetWorker(username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		worker.Manager = manager
+		if err := srvr.store.UpdateWorker(worker); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestGraphQLQueries(t *testing.T) {
+	srvr := newTestServer(t)
+	setManagers(t, srvr)
+
+	test := []testData{
+		{
+			"graphql_worker",
+			"POST",
+			"/graphql",
+			200,
+			graphQLBody(t, `{ worker(username: "fahim") { username firstname salary currency created_at reports_to { username } reports { username manager } } }`, nil),
+		},
+		{
+			"graphql_worker_not_found",
+			"POST",
+			"/graphql",
+			200,
+			graphQLBody(t, `query { worker(username: "nobody") { username } }`, nil),
+		},
+		{
+			"graphql_workers_first_page",
+			"POST",
+			"/graphql",
+			200,
+			graphQLBody(t, `query Page($after: String) { workers(limit: 3, after: $after) { nodes { username } page_info { has_next_page end_cursor } } }`, nil),
+		},
+		{
+			"graphql_workers_last_page",
+			"POST",
+			"/graphql",
+			200,
+			graphQLBody(t, `query Page($after: String) { workers(limit: 3, after: $after) { nodes { username } page_info { has_next_page end_cursor } } }`, map[string]interface{}{"after": "masud"}),
+		},
+		{
+			"graphql_workers_filtered",
+			"POST",
+			"/graphql",
+			200,
+			graphQLBody(t, `{ workers(manager: "masud") { nodes { username } } }`, nil),
+		},
+		{
+			"graphql_workers_bad_limit",
+			"POST",
+			"/graphql",
+			200,
+			graphQLBody(t, `{ workers(limit: 0) { nodes { username } } }`, nil),
+		},
+		{
+			"graphql_fragments",
+			"POST",
+			"/graphql",
+			200,
+			graphQLBody(t, `
+				query Chain($withReports: Boolean!) {
+					boss: worker(username: "masud") { ...names reports @include(if: $withReports) { ...names } }
+					worker(username: "jenny") { __typename ... on Worker { reports_to { ...names reports_to { ...names } } } }
+				}
+				fragment names on Worker { username firstname lastname }`, map[string]interface{}{"withReports": true}),
+		},
+		{
+			"graphql_salary_stats",
+			"POST",
+			"/graphql",
+			200,
+			graphQLBody(t, `{ salary_stats(group_by: [division], percentiles: [50]) { group { division city } currency headcount min max avg median percentiles { percentile value } } }`, nil),
+		},
+		{
+			"graphql_get",
+			"GET",
+			"/graphql?" + url.Values{"query": {`query Worker($username: String!) { worker(username: $username) { username } }`}, "variables": {`{"username": "masud"}`}}.Encode(),
+			200,
+			nil,
+		},
+		{
+			"graphql_introspection",
+			"POST",
+			"/graphql",
+			200,
+			graphQLBody(t, `{ __type(name: "Worker") { kind name fields { name type { kind name ofType { name } } } } __schema { queryType { name } mutationType { name } } }`, nil),
+		},
+		{
+			"graphql_syntax_error",
+			"POST",
+			"/graphql",
+			400,
+			graphQLBody(t, "{ worker(username: \"masud\") {\n  username\n", nil),
+		},
+		{
+			"graphql_unknown_field",
+			"POST",
+			"/graphql",
+			400,
+			graphQLBody(t, `{ worker(username: "masud") { username shoe_size } workers { nodes } }`, nil),
+		},
+		{
+			"graphql_bad_variables",
+			"POST",
+			"/graphql",
+			400,
+			graphQLBody(t, `query ($limit: Int!, $name: String) { workers(limit: $limit, after: $name) { nodes { username } } }`, map[string]interface{}{"limit": "ten"}),
+		},
+		{
+			"graphql_no_query",
+			"POST",
+			"/graphql",
+			422,
+			strings.NewReader(`{}`),
+		},
+	}
+	for _, data := range test {
+		runTest(t, srvr, data)
+	}
+}
+
+func TestGraphQLMutations(t *testing.T) {
+	srvr := newTestServer(t)
+	setManagers(t, srvr)
+
+	test := []testData{
+		{
+			"graphql_create_worker",
+			"POST",
+			"/graphql",
+			200,
+			graphQLBody(t, `mutation Add($worker: WorkerInput!) { create_worker(worker: $worker) { username city manager reports_to { username } version } }`, map[string]interface{}{
+				"worker": map[string]interface{}{
+					"username": "masudur", "firstname": "Masudur", "lastname": "Rahman", "city": "Madaripur",
+					"division": "Dhaka", "position": "Software Engineer", "salary": 5500, "manager": "masud",
+				},
+			}),
+		},
+		{
+			"graphql_create_worker_invalid",
+			"POST",
+			"/graphql",
+			200,
+			graphQLBody(t, `mutation { create_worker(worker: {username: "masud", firstname: "Masudur", city: "Nowhere"}) { username } }`, nil),
+		},
+		{
+			"graphql_update_worker",
+			"POST",
+			"/graphql",
+			200,
+			graphQLBody(t, `mutation { update_worker(username: "jenny", worker: {city: "Cox's Bazar", manager: ""}) { username city division manager reports_to { username } version updated_at } }`, nil),
+		},
+		{
+			"graphql_delete_worker",
+			"POST",
+			"/graphql",
+			200,
+			graphQLBody(t, `mutation { delete_worker(username: "masud", reassign_to: "jenny") }`, nil),
+		},
+		{
+			"graphql_reassigned_reports",
+			"POST",
+			"/graphql",
+			200,
+			graphQLBody(t, `{ worker(username: "jenny") { reports { username } } }`, nil),
+		},
+		{
+			"graphql_delete_worker_not_found",
+			"POST",
+			"/graphql",
+			200,
+			graphQLBody(t, `mutation { delete_worker(username: "masud") }`, nil),
+		},
+		{
+			"graphql_restore_worker",
+			"POST",
+			"/graphql",
+			200,
+			graphQLBody(t, `mutation { restore_worker(username: "masud") { username manager } }`, nil),
+		},
+		{
+			"graphql_mutation_over_get",
+			"GET",
+			"/graphql?" + url.Values{"query": {`mutation { delete_worker(username: "tahsin") }`}}.Encode(),
+			405,
+			nil,
+		},
+	}
+	for _, data := range test {
+		runTest(t, srvr, data)
+	}
+}
+
+func TestGraphQLAuthorization(t *testing.T) {
+	srvr := newTestServer(t,
+		WithBypassAuth(false),
+		WithAuthProvider(StaticAuth{"admin": "admin", "user": "user"}),
+		WithRoles(map[string][]string{"admin": {RoleHR}}),
+	)
+
+	query := `{ workers(limit: 2) { nodes { username position salary } } }`
+	for _, data := range []struct {
+		test       testData
+		user, pass string
+	}{
+		{testData{"graphql_unauthorized", "POST", "/graphql", 401, graphQLBody(t, query, nil)}, "", ""},
+		{testData{"graphql_salary", "POST", "/graphql", 200, graphQLBody(t, query, nil)}, "user", "user"},
+		{testData{"graphql_override_forbidden", "POST", "/graphql", 200, graphQLBody(t,
+			`mutation { update_worker(username: "masud", worker: {salary: 99999999, salary_override: "Promoted"}) { username } }`, nil)}, "user", "user"},
+	} {
+		header := make(http.Header)
+		if data.user != "" {
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.SetBasicAuth(data.user, data.pass)
+			header.Set("Authorization", req.Header.Get("Authorization"))
+		}
+		checkGolden(t, data.test.name, dumpResponse(serveTestWithHeader(t, srvr, data.test, header)))
+	}
+}
+
+func TestGraphQLLimits(t *testing.T) {
+	srvr := newTestServer(t, WithGraphQLLimits(4, 50))
+
+	test := []testData{
+		{
+			"graphql_too_deep",
+			"POST",
+			"/graphql",
+			400,
+			graphQLBody(t, `{ worker(username: "masud") { reports_to { reports_to { reports_to { username } } } } }`, nil),
+		},
+		{
+			"graphql_too_complex",
+			"POST",
+			"/graphql",
+			400,
+			graphQLBody(t, `{ workers(limit: 10) { nodes { username reports { username } } } }`, nil),
+		},
+		{
+			"graphql_within_limits",
+			"POST",
+			"/graphql",
+			200,
+			graphQLBody(t, `{ workers(limit: 10) { nodes { username } } }`, nil),
+		},
+	}
+	for _, data := range test {
+		runTest(t, srvr, data)
+	}
+
+	// The introspection isn't limited, the usual query of the tools is deep
+	introspection := `{ __schema { types { name fields { name type { name ofType { name ofType { name ofType { name } } } } } } } }`
+	serveTest(t, srvr, testData{"graphql_introspection_unlimited", "POST", "/graphql", 200, graphQLBody(t, introspection, nil)})
+}
+
+func TestGraphQLPersistedQueries(t *testing.T) {
+	srvr := newTestServer(t)
+
+	query := `{ worker(username: "masud") { username firstname } }`
+	sum := sha256.Sum256([]byte(query))
+	hash := hex.EncodeToString(sum[:])
+	persisted := func(query, hash string) io.Reader {
+		body, err := json.Marshal(graphQLRequest{
+			Query:      query,
+			Extensions: &graphQLExtensions{PersistedQuery: &persistedQueryRef{Version: 1, SHA256Hash: hash}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.NewReader(string(body))
+	}
+
+	test := []testData{
+		{"graphql_persisted_not_found", "POST", "/graphql", 200, persisted("", hash)},
+		{"graphql_persisted_wrong_hash", "POST", "/graphql", 422, persisted(query, strings.Repeat("0", 64))},
+		{"graphql_persisted_register", "POST", "/graphql", 200, persisted(query, hash)},
+		{"graphql_persisted_by_hash", "POST", "/graphql", 200, persisted("", hash)},
+		{
+			"graphql_persisted_get",
+			"GET",
+			"/graphql?" + url.Values{"extensions": {`{"persistedQuery": {"version": 1, "sha256Hash": "` + hash + `"}}`}}.Encode(),
+			200,
+			nil,
+		},
+	}
+	for _, data := range test {
+		runTest(t, srvr, data)
+	}
+
+	queries := newPersistedQueries(2)
+	queries.add("a", "{ a }")
+	queries.add("B", "{ b }")
+	queries.get("A")
+	queries.add("c", "{ c }")
+	if _, ok := queries.get("b"); ok {
+		t.Error("the least recently used query was kept")
+	}
+	for _, hash := range []string{"a", "C"} {
+		if _, ok := queries.get(hash); !ok {
+			t.Errorf("query %s was dropped", hash)
+		}
+	}
+}
+
+// countingStore counts the lookups of workers
+type countingStore struct {
+	Store
+	lookups int
+}
+
+func (s *countingStore) ListWorkers(filter WorkerFilter) ([]Worker, error) {
+	s.lookups++
+	return s.Store.ListWorkers(filter)
+}
+
+func (s *countingStore) GetWorker(username string) (*Worker, error) {
+	s.lookups++
+	return s.Store.GetWorker(username)
+}
+
+func TestGraphQLBatching(t *testing.T) {
+	srvr := newTestServer(t)
+	setManagers(t, srvr)
+	for _, username := range []string{"anika", "babul", "chandni", "dipu"} {
+		body := `{"username":"` + username + `","firstname":"Test","lastname":"Worker","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500,"manager":"tahsin"}`
+		serveTest(t, srvr, testData{"add_" + username, "POST", "/appscode/workers", 201, strings.NewReader(body)})
+	}
+
+	store := &countingStore{Store: srvr.store}
+	srvr.store = store
+	query := `{ workers(manager: "tahsin") { nodes { username reports_to { username reports_to { username } } reports { username } } } }`
+	rec := serveTest(t, srvr, testData{"graphql_batching", "POST", "/graphql", 200, graphQLBody(t, query, nil)})
+
+	var response graphQLResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Errors) > 0 {
+		t.Fatalf("got errors %+v", response.Errors[0])
+	}
+	if nodes := response.Data["workers"].(map[string]interface{})["nodes"].([]interface{}); len(nodes) != 4 {
+		t.Fatalf("got %d workers expected 4", len(nodes))
+	}
+	// The page, then one lookup for the managers and one for the reports
+	// of all the workers, and one for the managers of the managers
+	if store.lookups != 4 {
+		t.Errorf("the query took %d lookups expected 4", store.lookups)
+	}
+}
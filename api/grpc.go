@@ -0,0 +1,374 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/masudur-rahman/apiserver/workerpb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// workerServiceName is the name the health of the worker service is
+// checked by
+const workerServiceName = "apiserver.v1.WorkerService"
+
+// publicGRPCServices need no credentials, so load balancers and tools
+// like grpcurl can use them
+var publicGRPCServices = []string{
+	"/grpc.health.v1.Health/",
+	"/grpc.reflection.v1alpha.ServerReflection/",
+}
+
+func (s *Server) newGRPCServer() *grpc.Server {
+	gs := grpc.NewServer(
+		grpc.UnaryInterceptor(s.grpcUnary),
+		grpc.StreamInterceptor(s.grpcStream),
+	)
+	workerpb.RegisterWorkerServiceServer(gs, &workerService{s: s})
+
+	s.health = health.NewServer()
+	s.health.SetServingStatus(workerServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(gs, s.health)
+	reflection.Register(gs)
+	return gs
+}
+
+// GRPCServer returns the gRPC server of the API, for serving it on a
+// listener of one's own
+func (s *Server) GRPCServer() *grpc.Server {
+	return s.grpc
+}
+
+type grpcUserKey struct{}
+
+// grpcUser returns the username the call was authenticated with
+func grpcUser(ctx context.Context) string {
+	user, _ := ctx.Value(grpcUserKey{}).(string)
+	return user
+}
+
+func isPublicGRPCMethod(method string) bool {
+	for _, prefix := range publicGRPCServices {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// grpcAuthenticate checks the credentials of the authorization or the
+// x-api-key metadata of a call, the same way identify checks the headers
+// of a request. The call is counted against the rate limit before wrong
+// credentials are refused, by the client rateLimitKey tells.
+func (s *Server) grpcAuthenticate(ctx context.Context, method string) (context.Context, error) {
+	if isPublicGRPCMethod(method) {
+		return ctx, nil
+	}
+	var user, keyID string
+	var authErr error
+	if !s.bypassAuth {
+		var authHeader, apiKey string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if len(md["authorization"]) > 0 {
+				authHeader = md["authorization"][0]
+			}
+			if values := md[strings.ToLower(APIKeyHeader)]; len(values) > 0 {
+				apiKey = values[0]
+			}
+		}
+		user, keyID, authErr = s.checkCredentials(authHeader, apiKey)
+	}
+
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+	}
+	if err := s.grpcLimitRate(rateLimitKey(keyID, user, addr)); err != nil {
+		return nil, err
+	}
+	if authErr != nil {
+		return nil, authErr
+	}
+	return context.WithValue(ctx, grpcUserKey{}, user), nil
+}
+
+// grpcLimitRate takes a request of the client from its bucket of the rate
+// limit of the API, shared with its REST requests. A client that spent
+// its limit is turned away with ResourceExhausted, and the delay to retry
+// after in a RetryInfo.
+func (s *Server) grpcLimitRate(client string) error {
+	if s.rateLimit.Requests == 0 {
+		return nil
+	}
+	result, err := s.rateLimiter.take("* "+client, s.rateLimit, s.clock.Now())
+	if err != nil {
+		s.logger.Println("rate limiting:", err)
+		return nil
+	}
+	if result.allowed {
+		return nil
+	}
+	st := status.New(codes.ResourceExhausted, fmt.Sprintf("Too many requests, retry in %d seconds", ceilSeconds(result.retryAfter)))
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: ptypes.DurationProto(result.retryAfter)}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+func (s *Server) grpcUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer s.grpcRecovery(info.FullMethod, &err)
+
+	if ctx, err = s.grpcAuthenticate(ctx, info.FullMethod); err != nil {
+		return nil, s.grpcError(info.FullMethod, err)
+	}
+	resp, err = handler(ctx, req)
+	if err != nil {
+		return nil, s.grpcError(info.FullMethod, err)
+	}
+	return resp, nil
+}
+
+func (s *Server) grpcStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer s.grpcRecovery(info.FullMethod, &err)
+
+	ctx, err := s.grpcAuthenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return s.grpcError(info.FullMethod, err)
+	}
+	if err := handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx}); err != nil {
+		return s.grpcError(info.FullMethod, err)
+	}
+	return nil
+}
+
+// authenticatedStream carries the user of the call in its context
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context { return s.ctx }
+
+// grpcRecovery turns a panicking call into an internal error, as
+// recovery does for the requests
+func (s *Server) grpcRecovery(method string, err *error) {
+	if r := recover(); r != nil {
+		s.logger.Printf("PANIC: %v\n%s", r, debug.Stack())
+		*err = s.grpcError(method, internalError(fmt.Errorf("panic: %v", r)))
+	}
+}
+
+// grpcCodes are the codes of the statuses of the errors, chosen so that
+// grpc-gateway maps them back to the same status
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:            codes.InvalidArgument,
+	http.StatusUnauthorized:          codes.Unauthenticated,
+	http.StatusForbidden:             codes.PermissionDenied,
+	http.StatusNotFound:              codes.NotFound,
+	http.StatusConflict:              codes.AlreadyExists,
+	http.StatusGone:                  codes.OutOfRange,
+	http.StatusRequestEntityTooLarge: codes.InvalidArgument,
+	http.StatusUnprocessableEntity:   codes.InvalidArgument,
+}
+
+// grpcError turns err into a gRPC status. The invalid fields of a
+// validation error are sent as the field violations of a BadRequest.
+func (s *Server) grpcError(method string, err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	e := toError(err)
+	if e.Status >= http.StatusInternalServerError {
+		s.logger.Println(method, e)
+	}
+
+	code, ok := grpcCodes[e.Status]
+	if !ok {
+		code = codes.Internal
+	}
+	st := status.New(code, e.Detail)
+	if len(e.Errors) > 0 {
+		violations := make([]*errdetails.BadRequest_FieldViolation, len(e.Errors))
+		for i, fe := range e.Errors {
+			violations[i] = &errdetails.BadRequest_FieldViolation{Field: fe.Field, Description: fe.Message}
+		}
+		if detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations}); err == nil {
+			st = detailed
+		}
+	}
+	return st.Err()
+}
+
+// workerService serves workerpb.WorkerService with the same checks as the
+// worker handlers
+type workerService struct {
+	s *Server
+}
+
+var _ workerpb.WorkerServiceServer = &workerService{}
+
+func (ws *workerService) GetWorker(ctx context.Context, req *workerpb.GetWorkerRequest) (*workerpb.Worker, error) {
+	conv, err := ws.s.converterFor(req.Currency, req.Date)
+	if err != nil {
+		return nil, err
+	}
+	worker, err := ws.s.store.GetWorker(req.Username)
+	if err == ErrNotFound {
+		return nil, notFound("Worker %q does not exist", req.Username)
+	} else if err != nil {
+		return nil, err
+	}
+	if conv != nil {
+		if err := convertSalary(conv, worker); err != nil {
+			return nil, err
+		}
+	}
+	return workerProto(worker), nil
+}
+
+func (ws *workerService) ListWorkers(req *workerpb.ListWorkersRequest, stream workerpb.WorkerService_ListWorkersServer) error {
+	conv, err := ws.s.converterFor(req.Currency, req.Date)
+	if err != nil {
+		return err
+	}
+	filter := WorkerFilter{Department: req.Department, Team: req.Team, Manager: req.Manager}
+	if err := ws.s.loadRates(conv, filter); err != nil {
+		return err
+	}
+	return ws.s.store.IterateWorkers(filter, func(w *Worker) error {
+		if conv != nil {
+			if err := convertSalary(conv, w); err != nil {
+				return err
+			}
+		}
+		return stream.Send(workerProto(w))
+	})
+}
+
+func (ws *workerService) CreateWorker(ctx context.Context, req *workerpb.CreateWorkerRequest) (*workerpb.Worker, error) {
+	worker := workerFromProto(req.Worker)
+	if err := ws.s.addWorker(grpcUser(ctx), worker); err != nil {
+		return nil, err
+	}
+	return workerProto(worker), nil
+}
+
+func (ws *workerService) UpdateWorker(ctx context.Context, req *workerpb.UpdateWorkerRequest) (*workerpb.Worker, error) {
+	worker, err := ws.s.updateWorker(grpcUser(ctx), req.Username, workerFromProto(req.Worker))
+	if err != nil {
+		return nil, err
+	}
+	return workerProto(worker), nil
+}
+
+func (ws *workerService) DeleteWorker(ctx context.Context, req *workerpb.DeleteWorkerRequest) (*empty.Empty, error) {
+	if err := ws.s.removeWorker(req.Username, req.ReassignTo); err != nil {
+		return nil, err
+	}
+	return &empty.Empty{}, nil
+}
+
+var workerEventTypes = map[string]workerpb.WorkerEvent_Type{
+	WorkerCreated: workerpb.WorkerEvent_CREATED,
+	WorkerUpdated: workerpb.WorkerEvent_UPDATED,
+	WorkerDeleted: workerpb.WorkerEvent_DELETED,
+}
+
+// WatchWorkers sends the events of the workers selected by the request,
+// by their state after the change
+func (ws *workerService) WatchWorkers(req *workerpb.WatchWorkersRequest, stream workerpb.WorkerService_WatchWorkersServer) error {
+	if req.ResourceVersion < 0 {
+		return validationFailed(FieldError{Field: "resource_version", Message: "must be a resource version"})
+	}
+	watch, err := ws.s.watchWorkers(req.ResourceVersion, WorkerFilter{Department: req.Department, Team: req.Team, Manager: req.Manager})
+	if err != nil {
+		return err
+	}
+	err = watch.run(stream.Context().Done(), 0, func(event *WorkerEvent) error {
+		return stream.Send(&workerpb.WorkerEvent{
+			Type:            workerEventTypes[event.Type],
+			Worker:          workerProto(&event.Worker),
+			ResourceVersion: event.ResourceVersion,
+		})
+	})
+	switch err {
+	case errWatchClosed:
+		return status.Error(codes.Unavailable, "The server is shutting down")
+	case errWatchDropped:
+		return status.Error(codes.ResourceExhausted, "The watch fell behind the changes, resume from the last resource version")
+	}
+	return err
+}
+
+func workerProto(w *Worker) *workerpb.Worker {
+	return &workerpb.Worker{
+		Username:         w.Username,
+		Firstname:        w.FirstName,
+		Lastname:         w.LastName,
+		City:             w.City,
+		Division:         w.Division,
+		Position:         w.Position,
+		Salary:           w.Salary,
+		Currency:         w.Currency,
+		SalaryOverride:   w.SalaryOverride,
+		SalaryOverrideBy: w.SalaryOverrideBy,
+		Department:       w.Department,
+		Team:             w.Team,
+		Manager:          w.Manager,
+		CreatedAt:        timestampProto(w.CreatedAt),
+		UpdatedAt:        timestampProto(w.UpdatedAt),
+		Version:          int64(w.Version),
+	}
+}
+
+// workerFromProto takes the fields a client can write, the others are
+// set by the server
+func workerFromProto(p *workerpb.Worker) *Worker {
+	if p == nil {
+		return new(Worker)
+	}
+	return &Worker{
+		Username:       p.Username,
+		FirstName:      p.Firstname,
+		LastName:       p.Lastname,
+		City:           p.City,
+		Division:       p.Division,
+		Position:       p.Position,
+		Salary:         p.Salary,
+		Currency:       p.Currency,
+		SalaryOverride: p.SalaryOverride,
+		Department:     p.Department,
+		Team:           p.Team,
+		Manager:        p.Manager,
+	}
+}
+
+func timestampProto(t time.Time) *timestamp.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	ts, err := ptypes.TimestampProto(t)
+	if err != nil {
+		return nil
+	}
+	return ts
+}
@@ -0,0 +1,428 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/descriptor"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/masudur-rahman/apiserver/workerpb"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// dialTest serves the gRPC server of srvr on an in-memory listener, and
+// returns a client connection to it
+func dialTest(t *testing.T, srvr *Server) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	go srvr.GRPCServer().Serve(lis)
+
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithInsecure(),
+		grpc.WithDialer(func(string, time.Duration) (net.Conn, error) { return lis.Dial() }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// withBasicAuth adds the credentials to the metadata of the calls made with ctx
+func withBasicAuth(ctx context.Context, username, password string) context.Context {
+	creds := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Basic "+creds)
+}
+
+// checkGRPC checks the messages or the status of a call against a golden file
+func checkGRPC(t *testing.T, name string, err error, msgs ...proto.Message) {
+	t.Helper()
+	checkGolden(t, name, dumpGRPC(t, err, msgs...))
+}
+
+// dumpGRPC writes the code and message of the status of a call, its field
+// violations, and the messages it returned as JSON
+func dumpGRPC(t *testing.T, err error, msgs ...proto.Message) []byte {
+	t.Helper()
+
+	st := status.Convert(err)
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, strings.TrimSpace(st.Code().String()+" "+st.Message()))
+	for _, detail := range st.Details() {
+		if br, ok := detail.(*errdetails.BadRequest); ok {
+			for _, v := range br.FieldViolations {
+				fmt.Fprintf(&buf, "%s: %s\n", v.Field, v.Description)
+			}
+		}
+	}
+	m := jsonpb.Marshaler{OrigName: true, Indent: "  "}
+	for _, msg := range msgs {
+		buf.WriteString("\n")
+		if err := m.Marshal(&buf, msg); err != nil {
+			t.Fatal(err)
+		}
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+// listWorkers reads the whole stream of ListWorkers
+func listWorkers(ctx context.Context, client workerpb.WorkerServiceClient, req *workerpb.ListWorkersRequest) ([]proto.Message, error) {
+	stream, err := client.ListWorkers(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	var workers []proto.Message
+	for {
+		w, err := stream.Recv()
+		if err == io.EOF {
+			return workers, nil
+		} else if err != nil {
+			return workers, err
+		}
+		workers = append(workers, w)
+	}
+}
+
+func newTestWorker() *workerpb.Worker {
+	return &workerpb.Worker{
+		Username:  "rahim",
+		Firstname: "Rahim",
+		Lastname:  "Uddin",
+		City:      "Madaripur",
+		Division:  "Dhaka",
+		Position:  "Software Engineer",
+		Salary:    5500,
+	}
+}
+
+func TestGRPCWorkers(t *testing.T) {
+	srvr := newTestServer(t)
+	conn := dialTest(t, srvr)
+	defer conn.Close()
+	client := workerpb.NewWorkerServiceClient(conn)
+	ctx := context.Background()
+
+	w, err := client.GetWorker(ctx, &workerpb.GetWorkerRequest{Username: "masud"})
+	checkGRPC(t, "grpc_get_worker", err, w)
+
+	_, err = client.GetWorker(ctx, &workerpb.GetWorkerRequest{Username: "nobody"})
+	checkGRPC(t, "grpc_get_worker_not_found", err)
+
+	_, err = client.GetWorker(ctx, &workerpb.GetWorkerRequest{Username: "masud", Currency: "usd"})
+	checkGRPC(t, "grpc_get_worker_bad_currency", err)
+
+	workers, err := listWorkers(ctx, client, &workerpb.ListWorkersRequest{})
+	checkGRPC(t, "grpc_list_workers", err, workers...)
+
+	w, err = client.CreateWorker(ctx, &workerpb.CreateWorkerRequest{Worker: newTestWorker()})
+	checkGRPC(t, "grpc_create_worker", err, w)
+
+	_, err = client.CreateWorker(ctx, &workerpb.CreateWorkerRequest{Worker: newTestWorker()})
+	checkGRPC(t, "grpc_create_worker_exists", err)
+
+	_, err = client.CreateWorker(ctx, &workerpb.CreateWorkerRequest{Worker: &workerpb.Worker{Username: "ab", Firstname: "Rahim"}})
+	checkGRPC(t, "grpc_create_worker_invalid", err)
+
+	update := newTestWorker()
+	update.Username = ""
+	update.City = "Chittagong"
+	update.Division = "Chattogram"
+	update.Manager = "masud"
+	w, err = client.UpdateWorker(ctx, &workerpb.UpdateWorkerRequest{Username: "rahim", Worker: update})
+	checkGRPC(t, "grpc_update_worker", err, w)
+
+	workers, err = listWorkers(ctx, client, &workerpb.ListWorkersRequest{Manager: "masud"})
+	checkGRPC(t, "grpc_list_workers_filtered", err, workers...)
+
+	_, err = client.DeleteWorker(ctx, &workerpb.DeleteWorkerRequest{Username: "masud"})
+	checkGRPC(t, "grpc_delete_worker_with_reports", err)
+
+	_, err = client.DeleteWorker(ctx, &workerpb.DeleteWorkerRequest{Username: "rahim"})
+	checkGRPC(t, "grpc_delete_worker", err)
+
+	// Both transports share the store
+	runTest(t, srvr, testData{"grpc_deleted_worker_over_rest", "GET", "/appscode/workers/rahim", 404, nil})
+}
+
+func TestGRPCAuthentication(t *testing.T) {
+	srvr := newTestServer(t, WithBypassAuth(false), WithTokens(map[string]string{"s3cr3t": "masud"}), WithAPIKeys(map[string]string{"k3y": "masud"}))
+	conn := dialTest(t, srvr)
+	defer conn.Close()
+	client := workerpb.NewWorkerServiceClient(conn)
+	ctx := context.Background()
+
+	_, err := client.GetWorker(ctx, &workerpb.GetWorkerRequest{Username: "masud"})
+	checkGRPC(t, "grpc_no_credentials", err)
+
+	_, err = client.GetWorker(withBasicAuth(ctx, "masud", "wrong"), &workerpb.GetWorkerRequest{Username: "masud"})
+	checkGRPC(t, "grpc_wrong_credentials", err)
+
+	_, err = listWorkers(ctx, client, &workerpb.ListWorkersRequest{})
+	checkGRPC(t, "grpc_stream_no_credentials", err)
+
+	// Bearer tokens and API keys are taken as well
+	for _, md := range [][]string{{"authorization", "Bearer s3cr3t"}, {"x-api-key", "k3y"}} {
+		if _, err := client.GetWorker(metadata.AppendToOutgoingContext(ctx, md...), &workerpb.GetWorkerRequest{Username: "masud"}); err != nil {
+			t.Errorf("%s: %v", md[0], err)
+		}
+	}
+
+	// Only HR can override a salary band, and the override records who did
+	worker := newTestWorker()
+	worker.Salary = 1
+	worker.SalaryOverride = "Intern"
+	_, err = client.CreateWorker(withBasicAuth(ctx, "masud", "pass"), &workerpb.CreateWorkerRequest{Worker: worker})
+	checkGRPC(t, "grpc_salary_override_forbidden", err)
+
+	w, err := client.CreateWorker(withBasicAuth(ctx, "admin", "admin"), &workerpb.CreateWorkerRequest{Worker: worker})
+	checkGRPC(t, "grpc_salary_override_hr", err, w)
+
+	// Health checks need no credentials
+	health := healthpb.NewHealthClient(conn)
+	for _, service := range []string{"", workerServiceName} {
+		resp, err := health.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Errorf("health of %q is %s, expected SERVING", service, resp.Status)
+		}
+	}
+}
+
+func TestGRPCRateLimit(t *testing.T) {
+	srvr := newTestServer(t, WithBypassAuth(false), WithRateLimit(RateLimit{Requests: 2, Per: time.Minute}))
+	conn := dialTest(t, srvr)
+	defer conn.Close()
+	client := workerpb.NewWorkerServiceClient(conn)
+	ctx := withBasicAuth(context.Background(), "masud", "pass")
+
+	// The calls take from the same bucket as the requests of the user
+	serveTestWithHeader(t, srvr, testData{"", "GET", "/appscode/workers/masud", 200, nil}, http.Header{"Authorization": {"Basic bWFzdWQ6cGFzcw=="}})
+	if _, err := client.GetWorker(ctx, &workerpb.GetWorkerRequest{Username: "masud"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := client.GetWorker(ctx, &workerpb.GetWorkerRequest{Username: "masud"})
+	checkGRPC(t, "grpc_rate_limited", err)
+	var retry *errdetails.RetryInfo
+	for _, detail := range status.Convert(err).Details() {
+		if r, ok := detail.(*errdetails.RetryInfo); ok {
+			retry = r
+		}
+	}
+	if retry == nil || retry.RetryDelay.Seconds != 30 {
+		t.Errorf("got retry info %v expected a delay of 30s", retry)
+	}
+
+	// Wrong credentials are counted by the address of the client
+	wrong := withBasicAuth(context.Background(), "masud", "wrong")
+	for _, code := range []codes.Code{codes.Unauthenticated, codes.Unauthenticated, codes.ResourceExhausted} {
+		if _, err := client.GetWorker(wrong, &workerpb.GetWorkerRequest{Username: "masud"}); status.Code(err) != code {
+			t.Errorf("got %v expected %s", err, code)
+		}
+	}
+}
+
+func TestGRPCServices(t *testing.T) {
+	srvr := newTestServer(t)
+
+	info := srvr.GRPCServer().GetServiceInfo()
+	for _, service := range []string{workerServiceName, "grpc.health.v1.Health", "grpc.reflection.v1alpha.ServerReflection"} {
+		if _, ok := info[service]; !ok {
+			t.Errorf("%s is not served", service)
+		}
+	}
+}
+
+// waitForWatchers waits until n watchers watch the events of srvr
+func waitForWatchers(t *testing.T, srvr *Server, n int) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		srvr.events.mu.Lock()
+		watching := len(srvr.events.watchers)
+		srvr.events.mu.Unlock()
+		if watching == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %d watchers", n)
+}
+
+func TestGRPCWatchWorkers(t *testing.T) {
+	srvr := newTestServer(t)
+	conn := dialTest(t, srvr)
+	defer conn.Close()
+	client := workerpb.NewWorkerServiceClient(conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	all, err := client.WatchWorkers(ctx, &workerpb.WatchWorkersRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reports, err := client.WatchWorkers(ctx, &workerpb.WatchWorkersRequest{Manager: "masud"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForWatchers(t, srvr, 2)
+
+	// The changes made over REST are watched too
+	serveTest(t, srvr, testData{
+		"",
+		"POST",
+		"/appscode/workers",
+		201,
+		strings.NewReader(`{"username":"rahim","firstname":"Rahim","lastname":"Uddin","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500,"manager":"masud"}`),
+	})
+	update := newTestWorker()
+	update.Salary = 6000
+	if _, err := client.UpdateWorker(context.Background(), &workerpb.UpdateWorkerRequest{Username: "rahim", Worker: update}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.DeleteWorker(context.Background(), &workerpb.DeleteWorkerRequest{Username: "rahim"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []proto.Message
+	for i := 0; i < 3; i++ {
+		event, err := all.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	checkGRPC(t, "grpc_watch_workers", nil, events...)
+
+	// rahim only reported to masud when created
+	event, err := reports.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGRPC(t, "grpc_watch_reports", nil, event)
+
+	// Shutting down ends the watches
+	shutdownCtx, stop := context.WithTimeout(context.Background(), time.Second)
+	defer stop()
+	go srvr.Shutdown(shutdownCtx)
+	_, err = all.Recv()
+	checkGRPC(t, "grpc_watch_shutdown", err)
+}
+
+func TestGRPCWatchResume(t *testing.T) {
+	srvr := newTestServer(t)
+	changeRahim(t, srvr)
+	conn := dialTest(t, srvr)
+	defer conn.Close()
+	client := workerpb.NewWorkerServiceClient(conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := client.WatchWorkers(ctx, &workerpb.WatchWorkersRequest{ResourceVersion: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range []int64{6, 7} {
+		event, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.ResourceVersion != version {
+			t.Errorf("got resource version %d expected %d", event.ResourceVersion, version)
+		}
+	}
+
+	if _, err := srvr.store.DeleteEvents(testTime.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	stream, err = client.WatchWorkers(ctx, &workerpb.WatchWorkersRequest{ResourceVersion: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Recv()
+	checkGRPC(t, "grpc_watch_pruned", err)
+}
+
+var httpRuleParam = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// TestGRPCHTTPRules checks that every call of the WorkerService is served
+// by the REST route of its google.api.http option, and that the fields of
+// its request are the parameters of the route
+func TestGRPCHTTPRules(t *testing.T) {
+	srvr := newTestServer(t)
+	routes := make(map[string]bool)
+	for _, r := range srvr.routes {
+		routes[operationKey(r.method, r.path)] = true
+	}
+
+	fd, _ := descriptor.ForMessage(&workerpb.Worker{})
+	fields := make(map[string][]string)
+	for _, m := range fd.MessageType {
+		name := "." + fd.GetPackage() + "." + m.GetName()
+		for _, f := range m.Field {
+			fields[name] = append(fields[name], f.GetName())
+		}
+	}
+
+	for _, method := range fd.Service[0].Method {
+		if method.Options == nil {
+			continue
+		}
+		ext, err := proto.GetExtension(method.Options, annotations.E_Http)
+		if err == proto.ErrMissingExtension {
+			continue
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		rule := ext.(*annotations.HttpRule)
+		var verb, path string
+		switch p := rule.Pattern.(type) {
+		case *annotations.HttpRule_Get:
+			verb, path = "GET", p.Get
+		case *annotations.HttpRule_Post:
+			verb, path = "POST", p.Post
+		case *annotations.HttpRule_Put:
+			verb, path = "PUT", p.Put
+		case *annotations.HttpRule_Delete:
+			verb, path = "DELETE", p.Delete
+		default:
+			t.Fatalf("%s: unexpected pattern %T", method.GetName(), p)
+		}
+		key := operationKey(verb, httpRuleParam.ReplaceAllString(path, ":$1"))
+		if !routes[key] {
+			t.Errorf("%s: no route for %s %s", method.GetName(), verb, path)
+			continue
+		}
+
+		// The fields not bound to the path or the body are query parameters
+		op := apiOperations[key]
+		for _, field := range fields[method.GetInputType()] {
+			if strings.Contains(path, "{"+field+"}") || field == rule.Body {
+				continue
+			}
+			found := false
+			for _, param := range op.query {
+				found = found || param.name == field
+			}
+			if !found {
+				t.Errorf("%s: %s is not a parameter of %s", method.GetName(), field, key)
+			}
+		}
+	}
+}
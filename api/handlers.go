@@ -0,0 +1,315 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"gopkg.in/macaron.v1"
+)
+
+// Handler Functions....
+//
+// Handlers return their errors, which macaron hands to renderError
+
+func (s *Server) welcome(ctx *macaron.Context) error {
+	return s.render(ctx, http.StatusOK, "Congratulations...! Your API Server is up and running... :) ")
+}
+
+func (s *Server) welcomeToAppsCode(ctx *macaron.Context) error {
+	return s.render(ctx, http.StatusOK, "Welcome to AppsCode Ltd.. Available Links are : `/appscode/workers`, `/appscode/workers/{username}`, `/appscode/departments`, `/appscode/teams`, `/appscode/positions`, `/appscode/orgchart`, `/locations`, `/docs`, `/openapi.json`")
+}
+
+// maxPageSize bounds the limit of a page of workers
+const maxPageSize = 1000
+
+// showAllWorkers lists the workers, all of them unless ?limit= asks for a
+// page. A full page links to the next one in its Link header.
+func (s *Server) showAllWorkers(ctx *macaron.Context) error {
+	filter := workerFilter(ctx)
+	if l := ctx.Query("limit"); l != "" {
+		var err error
+		if filter.Limit, err = strconv.Atoi(l); err != nil || filter.Limit < 1 || filter.Limit > maxPageSize {
+			return validationFailed(FieldError{Field: "limit", Message: fmt.Sprintf("must be a number from 1 to %d", maxPageSize)})
+		}
+	}
+	filter.After = ctx.Query("after")
+
+	workers, err := s.store.ListWorkers(filter)
+	if err != nil {
+		return err
+	}
+	if filter.Limit > 0 && len(workers) == filter.Limit {
+		next := *ctx.Req.URL
+		query := next.Query()
+		query.Set("after", workers[len(workers)-1].Username)
+		next.RawQuery = query.Encode()
+		ctx.Resp.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+	}
+	if err := s.convertWorkers(ctx, workers); err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusOK, workers)
+}
+
+// workerFilter selects the workers listed by the query parameters
+func workerFilter(ctx *macaron.Context) WorkerFilter {
+	return WorkerFilter{
+		Department: ctx.Query("department"),
+		Team:       ctx.Query("team"),
+		Manager:    ctx.Query("manager"),
+	}
+}
+
+func (s *Server) showSingleWorker(ctx *macaron.Context) error {
+	worker, err := s.store.GetWorker(ctx.Params("username"))
+	if err == ErrNotFound {
+		return notFound("Worker %q does not exist", ctx.Params("username"))
+	} else if err != nil {
+		return err
+	}
+	workers := []Worker{*worker}
+	if err := s.convertWorkers(ctx, workers); err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusOK, workers[0])
+}
+
+func (s *Server) addNewWorker(ctx *macaron.Context) error {
+	var worker Worker
+	if err := s.decode(ctx, &worker); err != nil {
+		return err
+	}
+	if err := s.addWorker(user(ctx), &worker); err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusCreated, worker)
+}
+
+// addWorker validates a new worker sent by user, and creates it
+func (s *Server) addWorker(user string, worker *Worker) error {
+	if err := s.applySalaryOverride(user, nil, worker); err != nil {
+		return err
+	}
+	errs, err := s.validateWorker(worker, opCreate)
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return validationFailed(errs...)
+	}
+
+	worker.CreatedAt = s.clock.Now()
+	worker.UpdatedAt = worker.CreatedAt
+	worker.Version = 0
+
+	if err := s.createWorker(s.store, worker); err == ErrAlreadyExists {
+		return conflict("Username %q already exists", worker.Username)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) updateWorkerProfile(ctx *macaron.Context) error {
+	if err := checkAcceptable(ctx, Worker{}); err != nil {
+		return err
+	}
+	if _, err := s.store.GetWorker(ctx.Params("username")); err == ErrNotFound {
+		return notFound("Worker %q does not exist", ctx.Params("username"))
+	} else if err != nil {
+		return err
+	}
+
+	newWorker := new(Worker)
+	if err := s.decode(ctx, newWorker); err != nil {
+		return err
+	}
+	worker, err := s.updateWorker(user(ctx), ctx.Params("username"), newWorker)
+	if err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusCreated, worker)
+}
+
+// updateWorker replaces the profile of a worker with the one sent by user,
+// and returns the updated worker
+func (s *Server) updateWorker(user, username string, newWorker *Worker) (*Worker, error) {
+	worker, err := s.store.GetWorker(username)
+	if err == ErrNotFound {
+		return nil, notFound("Worker %q does not exist", username)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if newWorker.Username == "" {
+		newWorker.Username = worker.Username
+	}
+	if err := s.applySalaryOverride(user, worker, newWorker); err != nil {
+		return nil, err
+	}
+	errs, err := s.validateWorker(newWorker, opUpdate)
+	if err != nil {
+		return nil, err
+	}
+	if newWorker.Username != worker.Username {
+		errs = append(errs, FieldError{Field: "username", Message: "can't be changed"})
+	}
+	if len(errs) > 0 {
+		return nil, validationFailed(errs...)
+	}
+
+	// Updated information assignment
+	worker.FirstName = newWorker.FirstName
+	worker.LastName = newWorker.LastName
+	worker.City = newWorker.City
+	worker.Division = newWorker.Division
+	worker.Position = newWorker.Position
+	worker.Salary = newWorker.Salary
+	worker.Currency = newWorker.Currency
+	worker.SalaryOverride = newWorker.SalaryOverride
+	worker.SalaryOverrideBy = newWorker.SalaryOverrideBy
+	worker.Department = newWorker.Department
+	worker.Team = newWorker.Team
+	worker.Manager = newWorker.Manager
+	worker.UpdatedAt = s.clock.Now()
+
+	if err := s.saveWorker(s.store, worker, "profile update"); err != nil {
+		return nil, err
+	}
+	return worker, nil
+}
+
+// deleteWorker also removes the worker as the lead of their teams. A worker
+// with reports can only be deleted along with moving the reports to the
+// worker named by the reassign_to query parameter.
+func (s *Server) deleteWorker(ctx *macaron.Context) error {
+	if err := checkAcceptable(ctx, StatusMessage{}); err != nil {
+		return err
+	}
+	if err := s.removeWorker(ctx.Params("username"), ctx.Query("reassign_to")); err != nil {
+		return err
+	}
+	return s.renderDeleted(ctx)
+}
+
+// removeWorker deletes a worker, moving their reports to reassignTo
+func (s *Server) removeWorker(username, reassignTo string) error {
+	err := s.store.InTransaction(func(tx Store) error {
+		worker, err := tx.GetWorker(username)
+		if err == ErrNotFound {
+			return notFound("Worker %q does not exist", username)
+		} else if err != nil {
+			return err
+		}
+		if err := s.reassignReports(tx, worker, reassignTo); err != nil {
+			return err
+		}
+
+		teams, err := tx.ListTeams(TeamFilter{Lead: username})
+		if err != nil {
+			return err
+		}
+		for i := range teams {
+			teams[i].Lead = ""
+			teams[i].UpdatedAt = s.clock.Now()
+			if err := tx.UpdateTeam(&teams[i]); err != nil {
+				return err
+			}
+		}
+
+		if err := s.endEmployment(tx, username); err != nil {
+			return err
+		}
+		if err := tx.DeleteWorker(username); err != nil {
+			return err
+		}
+		return s.recordEvents(tx, WorkerDeleted, *worker)
+	})
+	if err != nil {
+		return err
+	}
+	s.notifyCommitted(s.store)
+	return nil
+}
+
+// restoreWorker brings a deleted worker back and opens a new record of
+// their employment. The manager, team or department the worker had is
+// dropped if it is gone now, the worker can be given new ones once back.
+func (s *Server) restoreWorker(ctx *macaron.Context) error {
+	worker, err := s.reinstateWorker(ctx.Params("username"))
+	if err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusOK, worker)
+}
+
+// reinstateWorker restores a deleted worker, and returns them
+func (s *Server) reinstateWorker(username string) (*Worker, error) {
+	var worker *Worker
+	err := s.store.InTransaction(func(tx Store) error {
+		err := tx.RestoreWorker(username)
+		if err == ErrNotFound {
+			return notFound("Worker %q does not exist", username)
+		} else if err == ErrAlreadyExists {
+			return conflict("Worker %q is not deleted", username)
+		} else if err != nil {
+			return err
+		}
+		if worker, err = tx.GetWorker(username); err != nil {
+			return err
+		}
+
+		bound := s.withStore(tx)
+		errs, err := bound.validateWorker(worker, opUpdate)
+		if err != nil {
+			return err
+		}
+		for _, e := range errs {
+			switch e.Field {
+			case "manager":
+				worker.Manager = ""
+			case "department", "team":
+				worker.Department, worker.Team = "", ""
+			}
+		}
+		if errs, err = bound.validateWorker(worker, opUpdate); err != nil {
+			return err
+		} else if len(errs) > 0 {
+			return validationFailed(errs...)
+		}
+
+		worker.UpdatedAt = s.clock.Now()
+		if err := tx.UpdateWorker(worker); err != nil {
+			return err
+		}
+		record := &EmploymentRecord{
+			Username:  username,
+			StartDate: s.today(),
+			Reason:    "restored",
+			CreatedAt: s.clock.Now(),
+		}
+		record.setTerms(worker)
+		if err := tx.CreateEmployment(record); err != nil {
+			return err
+		}
+		return s.recordEvents(tx, WorkerCreated, *worker)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.notifyCommitted(s.store)
+	return worker, nil
+}
+
+// StatusMessage is the body of the responses with nothing else to tell,
+// like the ones to deletions
+type StatusMessage struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// renderDeleted tells the client the resource was deleted
+func (s *Server) renderDeleted(ctx *macaron.Context) error {
+	return s.render(ctx, http.StatusOK, StatusMessage{Status: http.StatusOK, Message: "Deleted successfully"})
+}
@@ -0,0 +1,270 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/go-xorm/xorm"
+	"gopkg.in/macaron.v1"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader marks the responses replayed for a retry
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	// maxIdempotencyKey is the longest Idempotency-Key accepted
+	maxIdempotencyKey = 255
+)
+
+// IdempotentResponse is the response to a POST sent with an
+// Idempotency-Key header, kept for answering the retries of the request.
+// Status is zero while the request is still being served.
+type IdempotentResponse struct {
+	// The keys are chosen by the clients, so they are kept per user
+	Username string `xorm:"pk 'username' varchar(64)"`
+	Key      string `xorm:"pk 'idempotency_key' varchar(255)"`
+	// Fingerprint tells the retries from other requests sent with the key
+	Fingerprint string `xorm:"not null varchar(64)"`
+
+	Status int
+	Header string `xorm:"text"`
+	Body   []byte
+
+	CreatedAt time.Time `xorm:"not null"`
+	ExpiresAt time.Time `xorm:"not null index"`
+}
+
+// IdempotencyStore persists the responses to the requests sent with an
+// Idempotency-Key header
+type IdempotencyStore interface {
+	// GetIdempotentResponse returns ErrNotFound if the user sent no
+	// request with the key
+	GetIdempotentResponse(username, key string) (*IdempotentResponse, error)
+	// CreateIdempotentResponse returns ErrAlreadyExists if the user sent
+	// a request with the key already
+	CreateIdempotentResponse(response *IdempotentResponse) error
+	UpdateIdempotentResponse(response *IdempotentResponse) error
+	DeleteIdempotentResponse(username, key string) error
+	// DeleteIdempotentResponses deletes the responses expired before t
+	DeleteIdempotentResponses(before time.Time) (int64, error)
+}
+
+func (s *XormStore) GetIdempotentResponse(username, key string) (*IdempotentResponse, error) {
+	response := &IdempotentResponse{Username: username, Key: key}
+	exist, err := s.db.Get(response)
+	if err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, ErrNotFound
+	}
+	return response, nil
+}
+
+// CreateIdempotentResponse inserts the response right away, so of the
+// servers racing for a key only the one whose insert succeeds serves it
+func (s *XormStore) CreateIdempotentResponse(response *IdempotentResponse) error {
+	if _, err := s.db.Insert(response); uniqueViolation(err) {
+		return ErrAlreadyExists
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *XormStore) UpdateIdempotentResponse(response *IdempotentResponse) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		affected, err := session.Where("username = ? AND idempotency_key = ?", response.Username, response.Key).AllCols().Update(response)
+		if err != nil {
+			return err
+		} else if affected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (s *XormStore) DeleteIdempotentResponse(username, key string) error {
+	_, err := s.db.Where("username = ? AND idempotency_key = ?", username, key).Delete(new(IdempotentResponse))
+	return err
+}
+
+func (s *XormStore) DeleteIdempotentResponses(before time.Time) (int64, error) {
+	return s.db.Where("expires_at < ?", before).Delete(new(IdempotentResponse))
+}
+
+// PruneIdempotentResponses deletes the responses kept for the retries of
+// requests once their TTL is over. Run prunes them along with the events.
+func (s *Server) PruneIdempotentResponses() (int64, error) {
+	return s.store.DeleteIdempotentResponses(s.clock.Now())
+}
+
+// idempotency answers the retries of a POST sent with an Idempotency-Key
+// header with the response to the first request, instead of serving it
+// again. The key is taken before the request is served, so a retry sent
+// while it is still served is refused with 409, and one sending another
+// request under the key with 422. Server errors aren't kept, the request
+// can be retried after them.
+func (s *Server) idempotency(ctx *macaron.Context) {
+	key := ctx.Req.Header.Get(idempotencyKeyHeader)
+	if ctx.Req.Method != "POST" || key == "" {
+		return
+	}
+	if len(key) > maxIdempotencyKey || !validIdempotencyKey(key) {
+		s.renderError(ctx, badRequest("The Idempotency-Key header must be at most 255 printable ASCII characters"))
+		return
+	}
+
+	// The body is read for the fingerprint and served from memory, up to
+	// the largest body a route reads. The route rejects a larger one.
+	var body []byte
+	if ctx.Req.Request.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(io.LimitReader(ctx.Req.Request.Body, s.maxImportBytes+1)); err != nil {
+			s.renderError(ctx, badRequest("The request body could not be read"))
+			return
+		}
+		ctx.Req.Request.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), ctx.Req.Request.Body))
+	}
+
+	now := s.clock.Now()
+	response := &IdempotentResponse{
+		Username:    user(ctx),
+		Key:         key,
+		Fingerprint: requestFingerprint(ctx.Req.Request, body),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.idempotencyTTL),
+	}
+	replay, err := s.claimIdempotencyKey(response)
+	if err != nil {
+		s.renderError(ctx, err)
+		return
+	} else if replay != nil {
+		s.replayResponse(ctx, replay)
+		return
+	}
+
+	// Until the response is kept, the key is given back if serving the
+	// request fails or panics
+	kept := false
+	defer func() {
+		if !kept {
+			if err := s.store.DeleteIdempotentResponse(response.Username, response.Key); err != nil {
+				s.logger.Println("releasing the Idempotency-Key:", err)
+			}
+		}
+	}()
+
+	recorder := &responseRecorder{ResponseWriter: ctx.Resp}
+	ctx.Resp = recorder
+	ctx.Next()
+	ctx.Resp = recorder.ResponseWriter
+
+	if recorder.Status() == 0 || recorder.Status() >= http.StatusInternalServerError {
+		return
+	}
+	header := make(http.Header)
+	for name, values := range recorder.Header() {
+		if name != http.CanonicalHeaderKey(requestIDHeader) {
+			header[name] = values
+		}
+	}
+	data, err := json.Marshal(header)
+	if err != nil {
+		s.logger.Println("keeping the response:", err)
+		return
+	}
+	response.Status = recorder.Status()
+	response.Header = string(data)
+	response.Body = recorder.body.Bytes()
+	if err := s.store.UpdateIdempotentResponse(response); err != nil {
+		s.logger.Println("keeping the response:", err)
+		return
+	}
+	kept = true
+}
+
+// claimIdempotencyKey stores the response for the request, taking its
+// key, or returns the response to replay if the request was served. An
+// expired response is replaced.
+func (s *Server) claimIdempotencyKey(response *IdempotentResponse) (*IdempotentResponse, error) {
+	for {
+		err := s.store.CreateIdempotentResponse(response)
+		if err != ErrAlreadyExists {
+			return nil, err
+		}
+		stored, err := s.store.GetIdempotentResponse(response.Username, response.Key)
+		if err == ErrNotFound {
+			// Given back in the meantime
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case !stored.ExpiresAt.After(response.CreatedAt):
+			if err := s.store.DeleteIdempotentResponse(stored.Username, stored.Key); err != nil {
+				return nil, err
+			}
+		case stored.Fingerprint != response.Fingerprint:
+			return nil, validationFailed(FieldError{Field: idempotencyKeyHeader, Message: "was sent with another request, a retry must send the same one"})
+		case stored.Status == 0:
+			return nil, conflict("The request sent with Idempotency-Key %q is still being served, retry it later", response.Key)
+		default:
+			return stored, nil
+		}
+	}
+}
+
+// replayResponse writes the response kept for a request again
+func (s *Server) replayResponse(ctx *macaron.Context, response *IdempotentResponse) {
+	var header http.Header
+	if err := json.Unmarshal([]byte(response.Header), &header); err != nil {
+		s.renderError(ctx, internalError(err))
+		return
+	}
+	for name, values := range header {
+		ctx.Resp.Header()[name] = values
+	}
+	ctx.Resp.Header().Set(idempotentReplayedHeader, "true")
+	ctx.Resp.WriteHeader(response.Status)
+	if _, err := ctx.Resp.Write(response.Body); err != nil {
+		s.logger.Println(err)
+	}
+}
+
+// requestFingerprint hashes what makes a request: its method, URL, the
+// type of its body and the body
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	io.WriteString(h, r.Header.Get("Content-Type")+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func validIdempotencyKey(key string) bool {
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// responseRecorder keeps a copy of the body written to the response
+type responseRecorder struct {
+	macaron.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.body.Write(b[:n])
+	return n, err
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	clock := &movingClock{now: testTime.Now()}
+	srvr := newTestServer(t, WithClock(clock), WithIdempotencyTTL(time.Hour))
+	rahim := `{"username":"rahim","firstname":"Rahim","lastname":"Uddin","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500}`
+	key := http.Header{"Idempotency-Key": {"add-rahim"}}
+
+	for _, data := range []struct {
+		testData
+		header http.Header
+	}{
+		{testData{"idempotent_add_worker", "POST", "/appscode/workers", 201, strings.NewReader(rahim)}, key},
+		// The retry gets the first response instead of a 409
+		{testData{"idempotent_add_worker_replayed", "POST", "/appscode/workers", 201, strings.NewReader(rahim)}, key},
+		{testData{"idempotent_add_worker_other_body", "POST", "/appscode/workers", 422, strings.NewReader(strings.Replace(rahim, "5500", "6000", 1))}, key},
+		{testData{"idempotent_add_worker_other_url", "POST", "/appscode/workers?dry_run=true", 422, strings.NewReader(rahim)}, key},
+		{testData{"idempotent_bad_key", "POST", "/appscode/workers", 400, strings.NewReader(rahim)}, http.Header{"Idempotency-Key": {strings.Repeat("k", 256)}}},
+	} {
+		checkGolden(t, data.name, dumpResponse(serveTestWithHeader(t, srvr, data.testData, data.header)))
+	}
+
+	// Once expired, the key serves the request again
+	clock.now = clock.now.Add(time.Hour)
+	rec := serveTestWithHeader(t, srvr, testData{"idempotent_add_worker_expired", "POST", "/appscode/workers", 409, strings.NewReader(rahim)}, key)
+	checkGolden(t, "idempotent_add_worker_expired", dumpResponse(rec))
+	if _, err := srvr.PruneIdempotentResponses(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srvr.store.GetIdempotentResponse("", "add-rahim"); err != nil {
+		t.Errorf("got %v expected the response of the last request kept", err)
+	}
+}
+
+func TestIdempotencyKeyInProgress(t *testing.T) {
+	srvr := newTestServer(t)
+	body := `{"username":"rahim"}`
+	req, err := http.NewRequest("POST", "/appscode/workers", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first request is still being served
+	response := &IdempotentResponse{
+		Key:         "add-rahim",
+		Fingerprint: requestFingerprint(req, []byte(body)),
+		CreatedAt:   testTime.Now(),
+		ExpiresAt:   testTime.Now().Add(time.Hour),
+	}
+	if err := srvr.store.CreateIdempotentResponse(response); err != nil {
+		t.Fatal(err)
+	}
+	if err := srvr.store.CreateIdempotentResponse(response); err != ErrAlreadyExists {
+		t.Fatalf("got %v creating the key again, expected ErrAlreadyExists", err)
+	}
+	rec := serveTestWithHeader(t, srvr, testData{"idempotent_in_progress", "POST", "/appscode/workers", 409, strings.NewReader(body)}, http.Header{"Idempotency-Key": {"add-rahim"}})
+	checkGolden(t, "idempotent_in_progress", dumpResponse(rec))
+}
+
+func TestIdempotencyKeyPerUser(t *testing.T) {
+	srvr := newTestServer(t, WithBypassAuth(false), WithAuthProvider(StaticAuth{"masud": "pass", "admin": "admin"}))
+	rahim := `{"username":"rahim","firstname":"Rahim","lastname":"Uddin","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500}`
+
+	// The key of masud is no retry of the request of admin
+	for _, data := range []struct {
+		credentials string
+		status      int
+	}{
+		{"bWFzdWQ6cGFzcw==", 201},
+		{"YWRtaW46YWRtaW4=", 409},
+	} {
+		header := http.Header{"Idempotency-Key": {"add-rahim"}, "Authorization": {"Basic " + data.credentials}}
+		serveTestWithHeader(t, srvr, testData{"", "POST", "/appscode/workers", data.status, strings.NewReader(rahim)}, header)
+	}
+}
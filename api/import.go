@@ -0,0 +1,542 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-xorm/xorm"
+	"gopkg.in/macaron.v1"
+)
+
+// Import modes
+const (
+	// ImportAll writes every row or none of them
+	ImportAll = "all"
+	// ImportBestEffort writes the valid rows and reports the others
+	ImportBestEffort = "best-effort"
+)
+
+// States of an import job
+const (
+	ImportRunning = "running"
+	ImportDone    = "done"
+	ImportFailed  = "failed"
+)
+
+// asyncImportRows is the number of rows from which an import runs as a
+// job in the background, unless the request says otherwise
+const asyncImportRows = 200
+
+// importJobTTL is how long the report of a finished job is kept
+const importJobTTL = 24 * time.Hour
+
+// importProgressRows is how often a job run in best-effort mode saves its
+// progress, in rows. A job importing all the rows or none saves it once
+// they are committed or rolled back.
+const importProgressRows = 100
+
+// ImportJob reports the progress and outcome of an import, it can only be
+// read by the user who started it
+type ImportJob struct {
+	ID         string           `json:"id" xorm:"pk 'id' varchar(32)"`
+	User       string           `json:"-" xorm:"not null varchar(64)"`
+	State      string           `json:"state" xorm:"not null varchar(16)"`
+	Mode       string           `json:"mode" xorm:"not null varchar(16)"`
+	DryRun     bool             `json:"dry_run"`
+	Total      int              `json:"total"`
+	Processed  int              `json:"processed"`
+	Created    int              `json:"created"`
+	Failed     int              `json:"failed"`
+	Errors     []ImportRowError `json:"errors,omitempty" xorm:"text"`
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt *time.Time       `json:"finished_at,omitempty" xorm:"index"`
+}
+
+// ImportRowError lists the problems of one row. Row is the line of the row
+// in NDJSON, and its record in CSV counting the header as row 1.
+type ImportRowError struct {
+	Row      int          `json:"row"`
+	Username string       `json:"username,omitempty"`
+	Errors   []FieldError `json:"errors"`
+}
+
+// importRow is a worker read from the file, or the errors found reading it
+type importRow struct {
+	row    int
+	worker Worker
+	errs   []FieldError
+}
+
+// ImportStore persists the import jobs, so any server sharing the database
+// reports them
+type ImportStore interface {
+	// GetImportJob returns ErrNotFound if no job has the ID
+	GetImportJob(id string) (*ImportJob, error)
+	CreateImportJob(job *ImportJob) error
+	UpdateImportJob(job *ImportJob) error
+	// DeleteImportJobs deletes the jobs finished before t
+	DeleteImportJobs(before time.Time) (int64, error)
+}
+
+func (s *XormStore) GetImportJob(id string) (*ImportJob, error) {
+	job := &ImportJob{ID: id}
+	exist, err := s.db.Get(job)
+	if err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, ErrNotFound
+	}
+	return job, nil
+}
+
+func (s *XormStore) CreateImportJob(job *ImportJob) error {
+	_, err := s.db.Insert(job)
+	return err
+}
+
+func (s *XormStore) UpdateImportJob(job *ImportJob) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		affected, err := session.ID(job.ID).AllCols().Update(job)
+		if err != nil {
+			return err
+		} else if affected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (s *XormStore) DeleteImportJobs(before time.Time) (int64, error) {
+	return s.db.Where("finished_at < ?", before).Delete(new(ImportJob))
+}
+
+// PruneImportJobs deletes the reports of the imports finished more than a
+// day ago. Run prunes them along with the events.
+func (s *Server) PruneImportJobs() (int64, error) {
+	return s.store.DeleteImportJobs(s.clock.Now().Add(-importJobTTL))
+}
+
+// importColumns maps the folded CSV headers to the worker fields they fill
+var importColumns = map[string]string{
+	"username":       "username",
+	"login":          "username",
+	"firstname":      "firstname",
+	"givenname":      "firstname",
+	"lastname":       "lastname",
+	"surname":        "lastname",
+	"familyname":     "lastname",
+	"city":           "city",
+	"district":       "city",
+	"division":       "division",
+	"position":       "position",
+	"title":          "position",
+	"salary":         "salary",
+	"currency":       "currency",
+	"salaryoverride": "salary_override",
+	"department":     "department",
+	"team":           "team",
+	"manager":        "manager",
+}
+
+// foldHeader ignores the case, spaces, dashes and underscores of a header
+func foldHeader(h string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '_':
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(h)))
+}
+
+// csvColumns maps each column of the header to a worker field, with the
+// header:field pairs of mapping taking precedence over the known names
+func csvColumns(header []string, mapping string) ([]string, []FieldError) {
+	explicit := make(map[string]string)
+	var errs []FieldError
+	if mapping != "" {
+		for _, pair := range strings.Split(mapping, ",") {
+			i := strings.LastIndex(pair, ":")
+			if i < 0 || importColumns[foldHeader(pair[i+1:])] == "" {
+				errs = append(errs, FieldError{Field: "map", Message: "must be a list of header:field pairs naming worker fields"})
+				return nil, errs
+			}
+			explicit[foldHeader(pair[:i])] = importColumns[foldHeader(pair[i+1:])]
+		}
+	}
+
+	columns := make([]string, len(header))
+	seen := make(map[string]bool)
+	for i, h := range header {
+		field, ok := explicit[foldHeader(h)]
+		if !ok {
+			field, ok = importColumns[foldHeader(h)]
+		}
+		if !ok {
+			errs = append(errs, FieldError{Field: "header", Message: fmt.Sprintf("column %q is not a worker field, map it with the map parameter", h)})
+			continue
+		}
+		if seen[field] {
+			errs = append(errs, FieldError{Field: "header", Message: fmt.Sprintf("column %q fills %s again", h, field)})
+			continue
+		}
+		seen[field] = true
+		columns[i] = field
+	}
+	return columns, errs
+}
+
+func setWorkerField(w *Worker, field, value string) *FieldError {
+	switch field {
+	case "username":
+		w.Username = value
+	case "firstname":
+		w.FirstName = value
+	case "lastname":
+		w.LastName = value
+	case "city":
+		w.City = value
+	case "division":
+		w.Division = value
+	case "position":
+		w.Position = value
+	case "salary":
+		if value == "" {
+			return nil
+		}
+		salary, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return &FieldError{Field: "salary", Message: "must be a whole number of minor units"}
+		}
+		w.Salary = salary
+	case "currency":
+		w.Currency = value
+	case "salary_override":
+		w.SalaryOverride = value
+	case "department":
+		w.Department = value
+	case "team":
+		w.Team = value
+	case "manager":
+		w.Manager = value
+	}
+	return nil
+}
+
+func readImportCSV(r io.Reader, mapping string) ([]importRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	columns, errs := csvColumns(header, mapping)
+	if len(errs) > 0 {
+		return nil, validationFailed(errs...)
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		} else if err != nil {
+			return nil, err
+		}
+		row := importRow{row: len(rows) + 2}
+		for i, value := range record {
+			if e := setWorkerField(&row.worker, columns[i], strings.TrimSpace(value)); e != nil {
+				row.errs = append(row.errs, *e)
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+func readImportNDJSON(r io.Reader, maxLine int) ([]importRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLine)
+
+	var rows []importRow
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		row := importRow{row: line}
+		decoder := json.NewDecoder(bytes.NewReader(text))
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(&row.worker)
+		if err == nil && decoder.More() {
+			err = fmt.Errorf("unexpected data after the JSON value")
+		}
+		if err != nil {
+			if e, ok := decodeError(err, 0).(*Error); ok && len(e.Errors) > 0 {
+				row.errs = e.Errors
+			} else {
+				row.errs = []FieldError{{Field: "line", Message: "is not a JSON object: " + err.Error()}}
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+// errImportRollback rolls back the transaction of an import that must not be kept
+var errImportRollback = fmt.Errorf("import rolled back")
+
+// runImport validates and creates the workers of the rows, reporting to
+// job and saving it in the store as it goes
+func (s *Server) runImport(job *ImportJob, rows []importRow) {
+	record := func(row *importRow, errs []FieldError, created bool) {
+		job.Processed++
+		if len(errs) > 0 {
+			job.Failed++
+			job.Errors = append(job.Errors, ImportRowError{Row: row.row, Username: row.worker.Username, Errors: errs})
+		} else if created {
+			job.Created++
+		}
+	}
+	save := func() {
+		if err := s.store.UpdateImportJob(job); err != nil {
+			s.logger.Println("import", job.ID, err)
+		}
+	}
+
+	// importOne validates the row and creates the worker in tx, it returns
+	// the errors of the row
+	importOne := func(tx Store, row *importRow) ([]FieldError, error) {
+		if len(row.errs) > 0 {
+			return row.errs, nil
+		}
+		ts := s.withStore(tx)
+		w := row.worker
+		errs, err := ts.validateWorker(&w, opCreate)
+		if err != nil || len(errs) > 0 {
+			return errs, err
+		}
+		w.CreatedAt = s.clock.Now()
+		w.UpdatedAt = w.CreatedAt
+		w.Version = 0
+		if err := ts.createWorker(tx, &w); err == ErrAlreadyExists {
+			return []FieldError{{Field: "username", Message: "already exists"}}, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	// The workers created are notified of once committed, a dry run or a
+	// rolled back import changed nothing
+	var err error
+	committed := false
+	if job.Mode == ImportBestEffort && !job.DryRun {
+		// Each row in a transaction of its own
+		for i := range rows {
+			var errs []FieldError
+			err = s.store.InTransaction(func(tx Store) error {
+				var err error
+				if errs, err = importOne(tx, &rows[i]); err == nil && len(errs) > 0 {
+					return errImportRollback
+				}
+				return err
+			})
+			if err == errImportRollback {
+				err = nil
+			}
+			if err != nil {
+				break
+			}
+			committed = committed || len(errs) == 0
+			record(&rows[i], errs, true)
+			if job.Processed%importProgressRows == 0 {
+				save()
+			}
+		}
+	} else {
+		// Later rows may refer to the workers of earlier ones, so a dry run
+		// creates them too and rolls them back at the end
+		failed := false
+		err = s.store.InTransaction(func(tx Store) error {
+			for i := range rows {
+				errs, err := importOne(tx, &rows[i])
+				if err != nil {
+					return err
+				}
+				failed = failed || len(errs) > 0
+				record(&rows[i], errs, !job.DryRun)
+			}
+			if job.DryRun || failed {
+				return errImportRollback
+			}
+			return nil
+		})
+		committed = err == nil && job.Created > 0
+		if err == errImportRollback {
+			err = nil
+		}
+		if failed && job.Mode == ImportAll {
+			job.Created = 0
+			if !job.DryRun {
+				err = errImportRollback
+			}
+		}
+	}
+
+	if err != nil && err != errImportRollback {
+		s.logger.Println("import", job.ID, err)
+	}
+	if committed {
+		s.notifyChange()
+	}
+	now := s.clock.Now()
+	job.FinishedAt = &now
+	job.State = ImportDone
+	if err != nil {
+		job.State = ImportFailed
+	}
+	save()
+}
+
+// Import handlers
+
+// importWorkers reads workers from a CSV file with a header row, or from
+// NDJSON, and imports them in the mode of the mode parameter. With
+// dry_run=true every row is checked and nothing is written. Files of
+// asyncImportRows rows or more, or any with async=true, are imported in
+// the background and the job is reported at its Location.
+func (s *Server) importWorkers(ctx *macaron.Context) error {
+	mode := ctx.QueryTrim("mode")
+	if mode == "" {
+		mode = ImportAll
+	}
+	var errs []FieldError
+	if mode != ImportAll && mode != ImportBestEffort {
+		errs = append(errs, FieldError{Field: "mode", Message: "must be one of all, best-effort"})
+	}
+	dryRun, async := false, false
+	for _, param := range []struct {
+		name string
+		dst  *bool
+	}{
+		{"dry_run", &dryRun},
+		{"async", &async},
+	} {
+		if v := ctx.Query(param.name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, FieldError{Field: param.name, Message: "must be true or false"})
+			}
+			*param.dst = b
+		}
+	}
+	if len(errs) > 0 {
+		return validationFailed(errs...)
+	}
+
+	body := &limitedBody{r: ctx.Req.Request.Body, remaining: s.maxImportBytes + 1}
+	var rows []importRow
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(ctx.Req.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		rows, err = readImportCSV(body, ctx.Query("map"))
+	case "application/x-ndjson", "application/ndjson":
+		rows, err = readImportNDJSON(body, int(s.maxImportBytes))
+	default:
+		return NewError(http.StatusUnsupportedMediaType, ProblemUnsupportedMediaType, "Workers can be imported from text/csv or application/x-ndjson")
+	}
+	if err == nil && body.remaining <= 0 {
+		err = errBodyTooLarge
+	}
+	if err == errBodyTooLarge || err == bufio.ErrTooLong {
+		return decodeError(errBodyTooLarge, s.maxImportBytes)
+	} else if _, ok := err.(*Error); ok {
+		return err
+	} else if err != nil {
+		return badRequest("Error decoding provided data: %v", err)
+	}
+	if len(rows) == 0 {
+		return validationFailed(FieldError{Field: "rows", Message: "must hold at least one worker"})
+	}
+
+	// The salary overrides are checked against the importing user now, as
+	// the request is gone by the time a background job gets to them
+	for i := range rows {
+		if err := s.applySalaryOverride(user(ctx), nil, &rows[i].worker); err != nil {
+			rows[i].errs = append(rows[i].errs, FieldError{Field: "salary_override", Message: "can only be given by HR"})
+		}
+	}
+
+	job := &ImportJob{
+		ID:        newID(),
+		User:      user(ctx),
+		State:     ImportRunning,
+		Mode:      mode,
+		DryRun:    dryRun,
+		Total:     len(rows),
+		StartedAt: s.clock.Now(),
+	}
+	if err := s.store.CreateImportJob(job); err != nil {
+		return err
+	}
+	location := "/appscode/workers/import/" + job.ID
+
+	if ctx.Query("async") == "" {
+		async = len(rows) >= asyncImportRows
+	}
+	if async {
+		// The job runs on its own, so the report is rendered from a copy
+		report := *job
+		s.imports.Add(1)
+		go func() {
+			defer s.imports.Done()
+			s.runImport(job, rows)
+		}()
+		ctx.Resp.Header().Set("Location", location)
+		return s.render(ctx, http.StatusAccepted, report)
+	}
+
+	s.runImport(job, rows)
+	report := job
+	switch {
+	case report.State == ImportFailed && report.Failed > 0:
+		var errs []FieldError
+		for _, row := range report.Errors {
+			for _, e := range row.Errors {
+				errs = append(errs, FieldError{Field: fmt.Sprintf("row %d.%s", row.Row, e.Field), Message: e.Message})
+			}
+		}
+		return validationFailed(errs...)
+	case report.State == ImportFailed:
+		return internalError(fmt.Errorf("import %s failed", job.ID))
+	case dryRun:
+		return s.render(ctx, http.StatusOK, report)
+	}
+	ctx.Resp.Header().Set("Location", location)
+	return s.render(ctx, http.StatusCreated, report)
+}
+
+// showImport reports a job to the user who started it, the jobs of others
+// are not found
+func (s *Server) showImport(ctx *macaron.Context) error {
+	job, err := s.store.GetImportJob(ctx.Params("id"))
+	if err == ErrNotFound || (err == nil && job.User != user(ctx)) {
+		return notFound("Import %q does not exist", ctx.Params("id"))
+	} else if err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusOK, job)
+}
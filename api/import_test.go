@@ -0,0 +1,167 @@
+package api
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportWorkers(t *testing.T) {
+	srvr := newTestServer(t)
+
+	const ndjson = "application/x-ndjson"
+	invalid := `{"username":"rahim","firstname":"Rahim","lastname":"Uddin","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":6000}
+{"username":"karim","firstname":"Karim","lastname":"Uddin","city":"Nowhere","division":"Dhaka","position":"Software Engineer","salary":6000}
+
+{"username":"salam","firstname":"Salam","salary":"much"}
+`
+	for _, data := range []struct {
+		testData
+		contentType string
+	}{
+		{
+			testData{
+				"import_workers_csv",
+				"POST",
+				"/appscode/workers/import",
+				201,
+				strings.NewReader("Username,First Name,Last Name,City,Division,Title,Salary,Manager\n" +
+					"abul,Abul,Kalam,Madaripur,Dhaka,Software Engineer,6000,masud\n" +
+					"babul,Babul,Kalam,Khulna,Khulna,software engineer,7000,abul\n"),
+			},
+			"text/csv",
+		},
+		{
+			testData{
+				"import_workers_csv_mapped",
+				"POST",
+				"/appscode/workers/import?map=Given:firstname,Family:lastname",
+				201,
+				strings.NewReader("username,Given,Family,city,division,position,salary\nchandu,Chandu,Mia,Dhaka,Dhaka,Software Engineer,6000\n"),
+			},
+			"text/csv; charset=utf-8",
+		},
+		{
+			testData{
+				"import_workers_csv_unknown_column",
+				"POST",
+				"/appscode/workers/import",
+				422,
+				strings.NewReader("username,nickname\ndulal,Dulu\n"),
+			},
+			"text/csv",
+		},
+		{
+			testData{"import_workers_dry_run", "POST", "/appscode/workers/import?dry_run=true", 200, strings.NewReader(invalid)},
+			ndjson,
+		},
+		{
+			testData{"import_workers_all_or_nothing", "POST", "/appscode/workers/import", 422, strings.NewReader(invalid)},
+			ndjson,
+		},
+		{
+			testData{"show_worker_not_imported", "GET", "/appscode/workers/rahim", 404, nil},
+			"",
+		},
+		{
+			testData{"import_workers_best_effort", "POST", "/appscode/workers/import?mode=best-effort", 201, strings.NewReader(invalid)},
+			ndjson,
+		},
+		{
+			testData{"show_worker_imported", "GET", "/appscode/workers/rahim", 200, nil},
+			"",
+		},
+		{
+			testData{"import_workers_again", "POST", "/appscode/workers/import?mode=best-effort&dry_run=true", 200, strings.NewReader(invalid)},
+			ndjson,
+		},
+		{
+			testData{"import_workers_bad_mode", "POST", "/appscode/workers/import?mode=some&async=maybe", 422, strings.NewReader(invalid)},
+			ndjson,
+		},
+		{
+			testData{"import_workers_unsupported_type", "POST", "/appscode/workers/import", 415, strings.NewReader(`[]`)},
+			"application/json",
+		},
+		{
+			testData{"import_workers_empty", "POST", "/appscode/workers/import", 422, strings.NewReader("\n\n")},
+			ndjson,
+		},
+		{
+			testData{"show_import_not_found", "GET", "/appscode/workers/import/nothing", 404, nil},
+			"",
+		},
+	} {
+		checkGolden(t, data.name, maskIDs(dumpResponse(serveTestWithType(t, srvr, data.testData, data.contentType))))
+	}
+}
+
+func TestImportInvalidatesOnCommit(t *testing.T) {
+	srvr := newTestServer(t, WithResponseCache(NewLRUCache(1<<20), time.Minute))
+	invalidations := srvr.CacheStats().Invalidations
+
+	// Dry runs and rolled back imports changed nothing, a committed import
+	// is one change however many rows it has
+	rows := "username,firstname,lastname,city,division,position,salary\n" +
+		"abul,Abul,Kalam,Madaripur,Dhaka,Software Engineer,6000\n" +
+		"babul,Babul,Kalam,Khulna,Khulna,Software Engineer,7000\n"
+	for _, test := range []struct {
+		data     testData
+		expected int64
+	}{
+		{testData{"", "POST", "/appscode/workers/import?dry_run=true", 200, strings.NewReader(rows)}, 0},
+		{testData{"", "POST", "/appscode/workers/import", 422, strings.NewReader(rows + "salam,Salam,,,,,\n")}, 0},
+		{testData{"", "POST", "/appscode/workers/import", 201, strings.NewReader(rows)}, 1},
+	} {
+		serveTestWithType(t, srvr, test.data, "text/csv")
+		if got := srvr.CacheStats().Invalidations - invalidations; got != test.expected {
+			t.Errorf("%s: got %d invalidations expected %d", test.data.url, got, test.expected)
+		}
+		invalidations += test.expected
+	}
+}
+
+func TestImportWorkersAsync(t *testing.T) {
+	srvr := newTestServer(t, WithBypassAuth(false), WithAuthProvider(StaticAuth{"masud": "pass", "admin": "admin"}))
+	masud := http.Header{"Authorization": {"Basic bWFzdWQ6cGFzcw=="}, "Content-Type": {"application/x-ndjson"}}
+
+	// The job gets an ID of its own, whatever the request ID
+	rec := serveTestWithHeader(t, srvr, testData{
+		"a/b?c",
+		"POST",
+		"/appscode/workers/import?async=true",
+		202,
+		strings.NewReader(`{"username":"rahim","firstname":"Rahim","lastname":"Uddin","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":6000}`),
+	}, masud)
+	location := rec.Header().Get("Location")
+	if !regexp.MustCompile(`^/appscode/workers/import/[0-9a-f]{32}$`).MatchString(location) {
+		t.Fatalf("got Location %q", location)
+	}
+	id := strings.TrimPrefix(location, "/appscode/workers/import/")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if job, err := srvr.store.GetImportJob(id); err == nil && job.State != ImportRunning {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the import did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	rec = serveTestWithHeader(t, srvr, testData{"show_import", "GET", location, 200, nil}, masud)
+	checkGolden(t, "show_import", maskIDs(dumpResponse(rec)))
+
+	// Another server sharing the database reports the job, to its user only
+	other := NewServer(WithStore(srvr.store), WithAuthProvider(StaticAuth{"masud": "pass", "admin": "admin"}), WithLogger(log.New(ioutil.Discard, "", 0)))
+	serveTestWithHeader(t, other, testData{"", "GET", location, 200, nil}, masud)
+	serveTestWithHeader(t, other, testData{"", "GET", location, 404, nil}, http.Header{"Authorization": {"Basic YWRtaW46YWRtaW4="}})
+
+	if n, err := srvr.PruneImportJobs(); err != nil || n != 0 {
+		t.Errorf("pruned %d jobs, %v, expected none finished a day ago", n, err)
+	}
+}
@@ -0,0 +1,165 @@
+//go:build integration
+// +build integration
+
+package api
+
+import (
+	"encoding/json"
+	"flag"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The integration suite runs the handlers against a Postgres database kept
+// for the tests, its tables are dropped and recreated on every run. It is
+// skipped unless the database is given:
+//
+//	go test -tags integration ./api -database "<postgres-connection-string>"
+var database = flag.String("database", "", "connection string of a postgres database for the integration tests, whose tables are dropped")
+
+func newPostgresServer(t *testing.T) *Server {
+	if *database == "" {
+		t.Skip("no -database given for the integration tests")
+	}
+	engine, err := NewXormEngine("postgres", *database, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.DropTables(tables()...); err != nil {
+		t.Fatal(err)
+	}
+	return newServerWithEngine(t, NewXormStore(engine))
+}
+
+func TestPostgresWorkerLifecycle(t *testing.T) {
+	srvr := newPostgresServer(t)
+	test := []testData{
+		{
+			"add_worker_conflict",
+			"POST",
+			"/appscode/workers",
+			409,
+			strings.NewReader(`{"username":"masud","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500}`),
+		},
+		{
+			"add_worker",
+			"POST",
+			"/appscode/workers",
+			201,
+			strings.NewReader(`{"username":"masudur","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500}`),
+		},
+		{
+			"update_worker",
+			"PUT",
+			"/appscode/workers/masudur",
+			201,
+			strings.NewReader(`{"username":"masudur","firstname":"Masudur","lastname":"Rahman","city":"Shariatpur","division":"Dhaka","position":"Software Engineer","salary":6000}`),
+		},
+		{
+			"delete_worker",
+			"DELETE",
+			"/appscode/workers/fahim",
+			200,
+			nil,
+		},
+		{
+			"show_deleted_worker",
+			"GET",
+			"/appscode/workers/fahim",
+			404,
+			nil,
+		},
+		{
+			"add_deleted_worker",
+			"POST",
+			"/appscode/workers",
+			409,
+			strings.NewReader(`{"username":"fahim","firstname":"Fahim","lastname":"Abrar","city":"Chittagong","division":"Chittagong","position":"Software Engineer","salary":5500}`),
+		},
+	}
+	for _, data := range test {
+		serveTest(t, srvr, data)
+	}
+
+	rec := serveTest(t, srvr, testData{"show_updated_worker", "GET", "/appscode/workers/masudur", 200, nil})
+	var worker Worker
+	if err := json.NewDecoder(rec.Body).Decode(&worker); err != nil {
+		t.Fatal(err)
+	}
+	if worker.City != "Shariatpur" || worker.Salary != 6000 || worker.Version != 2 {
+		t.Errorf("update was not stored, got %+v", worker)
+	}
+	if !worker.CreatedAt.Equal(testTime.Now()) {
+		t.Errorf("got CreatedAt %v expected %v", worker.CreatedAt, testTime.Now())
+	}
+
+	rec = serveTest(t, srvr, testData{"show_all_workers", "GET", "/appscode/workers", 200, nil})
+	var workers []Worker
+	if err := json.NewDecoder(rec.Body).Decode(&workers); err != nil {
+		t.Fatal(err)
+	}
+	if len(workers) != 4 {
+		t.Errorf("got %d workers expected 4", len(workers))
+	}
+}
+
+// TestPostgresStats checks that the statistics computed in SQL match the
+// ones computed in Go from the same workers
+func TestPostgresStats(t *testing.T) {
+	srvr := newPostgresServer(t)
+	seedStats(t, srvr)
+	store := srvr.store.(*XormStore)
+
+	rate, err := newConverter(store, "BDT", "2019-03-20").rate("USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []StatsQuery{
+		{Percentiles: defaultPercentiles},
+		{GroupBy: []string{"division", "department"}, Percentiles: []int{10, 50}},
+		{
+			Filter:      StatsFilter{Division: "Dhaka"},
+			Percentiles: []int{99},
+			Currency:    "BDT",
+			Rates:       map[string]*big.Rat{"BDT": big.NewRat(1, 1), "USD": rate},
+		},
+	} {
+		got, err := store.SalaryStats(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		workers, err := store.ListWorkers(WorkerFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var selected []Worker
+		for _, w := range workers {
+			if query.Filter.Division == "" || w.Division == query.Filter.Division {
+				selected = append(selected, w)
+			}
+		}
+		if expected := salaryStats(selected, query); !reflect.DeepEqual(got, expected) {
+			t.Errorf("query %+v: got %+v expected %+v", query, got, expected)
+		}
+	}
+
+	query := HeadcountQuery{
+		GroupBy: []string{"division"},
+		From:    time.Date(2018, 11, 1, 0, 0, 0, 0, time.UTC),
+		To:      time.Date(2019, 4, 1, 0, 0, 0, 0, time.UTC),
+	}
+	got, err := store.Headcount(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var workers []Worker
+	if err := store.engine.Unscoped().Find(&workers); err != nil {
+		t.Fatal(err)
+	}
+	if expected := headcount(workers, query); !reflect.DeepEqual(got, expected) {
+		t.Errorf("headcount: got %+v expected %+v", got, expected)
+	}
+}
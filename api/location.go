@@ -0,0 +1,349 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/go-xorm/xorm"
+	"gopkg.in/macaron.v1"
+)
+
+// Country groups the divisions of one country, keyed by its ISO 3166-1 alpha-2 code
+type Country struct {
+	Code string `json:"code" xorm:"pk varchar(2)"`
+	Name string `json:"name" xorm:"not null"`
+}
+
+// Division is the first level of administrative division of a country,
+// keyed by its ISO 3166-2 code
+type Division struct {
+	Code      string   `json:"code" xorm:"pk varchar(6)"`
+	Country   string   `json:"country" xorm:"not null index varchar(2)"`
+	Name      string   `json:"name" xorm:"not null"`
+	LocalName string   `json:"local_name"`
+	Aliases   []string `json:"aliases,omitempty"`
+}
+
+// City is a district or city a worker can live in, its name is unique
+// within its division
+type City struct {
+	Division  string   `json:"division" xorm:"pk varchar(6)"`
+	Name      string   `json:"name" xorm:"pk"`
+	LocalName string   `json:"local_name"`
+	Aliases   []string `json:"aliases,omitempty"`
+}
+
+// LocationSet is the reference data of one country
+type LocationSet struct {
+	Country   Country
+	Divisions []Division
+	Cities    []City
+}
+
+// LocationStore persists the location reference data
+type LocationStore interface {
+	ListCountries() ([]Country, error)
+	// ListDivisions lists the divisions of a country, or of every country if it's empty
+	ListDivisions(country string) ([]Division, error)
+	// ListCities lists the cities of a division, or of every division if it's empty
+	ListCities(division string) ([]City, error)
+	// SaveLocations inserts the set, replacing the rows that already exist
+	SaveLocations(set LocationSet) error
+}
+
+func (s *XormStore) ListCountries() ([]Country, error) {
+	countries := make([]Country, 0)
+	if err := s.db.Asc("code").Find(&countries); err != nil {
+		return nil, err
+	}
+	return countries, nil
+}
+
+func (s *XormStore) ListDivisions(country string) ([]Division, error) {
+	divisions := make([]Division, 0)
+	if err := s.db.Asc("name").Find(&divisions, &Division{Country: country}); err != nil {
+		return nil, err
+	}
+	return divisions, nil
+}
+
+func (s *XormStore) ListCities(division string) ([]City, error) {
+	cities := make([]City, 0)
+	if err := s.db.Asc("name").Find(&cities, &City{Division: division}); err != nil {
+		return nil, err
+	}
+	return cities, nil
+}
+
+func (s *XormStore) SaveLocations(set LocationSet) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		if err := upsert(session, &set.Country, &Country{Code: set.Country.Code}); err != nil {
+			return err
+		}
+		for i := range set.Divisions {
+			division := &set.Divisions[i]
+			if err := upsert(session, division, &Division{Code: division.Code}); err != nil {
+				return err
+			}
+		}
+		for i := range set.Cities {
+			city := &set.Cities[i]
+			if err := upsert(session, city, &City{Division: city.Division, Name: city.Name}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// upsert updates the row matching the non-zero fields of cond, or inserts bean if there is none
+func upsert(session *xorm.Session, bean, cond interface{}) error {
+	affected, err := session.AllCols().Update(bean, cond)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		_, err = session.Insert(bean)
+	}
+	return err
+}
+
+// SeedLocations saves the reference data of a country, it can be run on every start
+func (s *Server) SeedLocations(set LocationSet) error {
+	return s.store.SaveLocations(set)
+}
+
+// locationKey folds a location name for matching user input: case,
+// punctuation and spaces are ignored, and the precomposed Bengali
+// letters with nukta are matched with their decomposed form.
+func locationKey(name string) string {
+	name = bengaliNukta.Replace(strings.ToLower(name))
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.M, r) {
+			return r
+		}
+		return -1
+	}, name)
+}
+
+var bengaliNukta = strings.NewReplacer("\u09dc", "\u09a1\u09bc", "\u09dd", "\u09a2\u09bc", "\u09df", "\u09af\u09bc")
+
+// locationIndex finds divisions and cities by any of their names
+type locationIndex struct {
+	divisions map[string][]Division
+	cities    map[string][]City
+	byCode    map[string]Division
+}
+
+func (s *Server) loadLocations() (*locationIndex, error) {
+	divisions, err := s.store.ListDivisions("")
+	if err != nil {
+		return nil, err
+	}
+	cities, err := s.store.ListCities("")
+	if err != nil {
+		return nil, err
+	}
+
+	ix := &locationIndex{
+		divisions: make(map[string][]Division),
+		cities:    make(map[string][]City),
+		byCode:    make(map[string]Division),
+	}
+	for _, division := range divisions {
+		ix.byCode[division.Code] = division
+		for _, key := range locationKeys(append([]string{division.Code, division.Name, division.LocalName}, division.Aliases...)) {
+			ix.divisions[key] = append(ix.divisions[key], division)
+		}
+	}
+	for _, city := range cities {
+		for _, key := range locationKeys(append([]string{city.Name, city.LocalName}, city.Aliases...)) {
+			ix.cities[key] = append(ix.cities[key], city)
+		}
+	}
+	return ix, nil
+}
+
+// locationKeys returns the distinct non-empty keys of names
+func locationKeys(names []string) []string {
+	var keys []string
+	for _, name := range names {
+		if key := locationKey(name); key != "" && !contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// division returns the division called name, if exactly one is
+func (ix *locationIndex) division(name string) (Division, bool) {
+	matches := ix.divisions[locationKey(name)]
+	if len(matches) != 1 {
+		return Division{}, false
+	}
+	return matches[0], true
+}
+
+// city returns the city called name in the division
+func (ix *locationIndex) city(division, name string) (City, bool) {
+	for _, city := range ix.cities[locationKey(name)] {
+		if city.Division == division {
+			return city, true
+		}
+	}
+	return City{}, false
+}
+
+// checkLocation verifies that the worker's division is known and that
+// their city belongs to it, and replaces both with their canonical names
+func (s *Server) checkLocation(worker *Worker) ([]FieldError, error) {
+	if worker.City == "" || worker.Division == "" {
+		return nil, nil
+	}
+	ix, err := s.loadLocations()
+	if err != nil {
+		return nil, err
+	}
+
+	division, ok := ix.division(worker.Division)
+	if !ok {
+		return []FieldError{{Field: "division", Message: "is not a known division"}}, nil
+	}
+	city, ok := ix.city(division.Code, worker.City)
+	if !ok {
+		return []FieldError{{Field: "city", Message: "is not a city of the " + division.Name + " division"}}, nil
+	}
+
+	worker.Division = division.Name
+	worker.City = city.Name
+	return nil, nil
+}
+
+// LocationChange is a worker's location field the migration rewrote or could not match
+type LocationChange struct {
+	Username string `json:"username"`
+	Field    string `json:"field"`
+	From     string `json:"from"`
+	To       string `json:"to,omitempty"`
+}
+
+// LocationReport tells what MigrateLocations did
+type LocationReport struct {
+	Checked   int              `json:"checked"`
+	Updated   int              `json:"updated"`
+	Changes   []LocationChange `json:"changes"`
+	Unmatched []LocationChange `json:"unmatched"`
+}
+
+// MigrateLocations maps the free text city and division of every worker
+// to the names in the reference data. A division that can't be matched
+// is taken from the city when the city name is unique. Values that still
+// don't match are left as they are and listed in the report. With dryRun
+// the report is built but nothing is written.
+func (s *Server) MigrateLocations(dryRun bool) (*LocationReport, error) {
+	ix, err := s.loadLocations()
+	if err != nil {
+		return nil, err
+	}
+	workers, err := s.store.ListWorkers(WorkerFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &LocationReport{Changes: []LocationChange{}, Unmatched: []LocationChange{}}
+	for i := range workers {
+		worker := &workers[i]
+		report.Checked++
+
+		division, divisionOK := ix.division(worker.Division)
+		if cities := ix.cities[locationKey(worker.City)]; !divisionOK && len(cities) == 1 {
+			division, divisionOK = ix.byCode[cities[0].Division]
+		}
+		if !divisionOK {
+			report.Unmatched = append(report.Unmatched, LocationChange{Username: worker.Username, Field: "division", From: worker.Division})
+			report.Unmatched = append(report.Unmatched, LocationChange{Username: worker.Username, Field: "city", From: worker.City})
+			continue
+		}
+
+		var changes []LocationChange
+		if worker.Division != division.Name {
+			changes = append(changes, LocationChange{Username: worker.Username, Field: "division", From: worker.Division, To: division.Name})
+			worker.Division = division.Name
+		}
+		if city, ok := ix.city(division.Code, worker.City); !ok {
+			report.Unmatched = append(report.Unmatched, LocationChange{Username: worker.Username, Field: "city", From: worker.City})
+		} else if worker.City != city.Name {
+			changes = append(changes, LocationChange{Username: worker.Username, Field: "city", From: worker.City, To: city.Name})
+			worker.City = city.Name
+		}
+		if len(changes) == 0 {
+			continue
+		}
+
+		report.Changes = append(report.Changes, changes...)
+		report.Updated++
+		if dryRun {
+			continue
+		}
+		worker.UpdatedAt = s.clock.Now()
+		err := s.store.InTransaction(func(tx Store) error {
+			if err := tx.UpdateWorker(worker); err != nil {
+				return err
+			}
+			return s.recordEvents(tx, WorkerUpdated, *worker)
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// Location handlers
+
+func (s *Server) showCountries(ctx *macaron.Context) error {
+	countries, err := s.store.ListCountries()
+	if err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusOK, countries)
+}
+
+func (s *Server) showDivisions(ctx *macaron.Context) error {
+	divisions, err := s.store.ListDivisions(strings.ToUpper(ctx.Params("country")))
+	if err != nil {
+		return err
+	}
+	if len(divisions) == 0 {
+		return notFound("Country %q does not exist", ctx.Params("country"))
+	}
+	return s.render(ctx, http.StatusOK, divisions)
+}
+
+// divisionWithCities is the body of GET /locations/:country/:division
+type divisionWithCities struct {
+	Division
+	Cities []City `json:"cities"`
+}
+
+// showDivision looks the division up by its code or any of its names
+func (s *Server) showDivision(ctx *macaron.Context) error {
+	divisions, err := s.store.ListDivisions(strings.ToUpper(ctx.Params("country")))
+	if err != nil {
+		return err
+	}
+
+	key := locationKey(ctx.Params("division"))
+	for _, division := range divisions {
+		if !contains(locationKeys(append([]string{division.Code, division.Name, division.LocalName}, division.Aliases...)), key) {
+			continue
+		}
+		cities, err := s.store.ListCities(division.Code)
+		if err != nil {
+			return err
+		}
+		return s.render(ctx, http.StatusOK, divisionWithCities{Division: division, Cities: cities})
+	}
+	return notFound("Division %q does not exist in %q", ctx.Params("division"), ctx.Params("country"))
+}
@@ -0,0 +1,129 @@
+package api
+
+// BangladeshLocations returns the 8 divisions and 64 districts of Bangladesh,
+// under their current official spellings with the older ones as aliases
+func BangladeshLocations() LocationSet {
+	set := LocationSet{Country: Country{Code: "BD", Name: "Bangladesh"}}
+	for _, d := range bangladesh {
+		set.Divisions = append(set.Divisions, d.division)
+		for _, city := range d.districts {
+			city.Division = d.division.Code
+			set.Cities = append(set.Cities, city)
+		}
+	}
+	return set
+}
+
+func district(name, localName string, aliases ...string) City {
+	return City{Name: name, LocalName: localName, Aliases: aliases}
+}
+
+var bangladesh = []struct {
+	division  Division
+	districts []City
+}{
+	{
+		Division{Code: "BD-A", Country: "BD", Name: "Barishal", LocalName: "বরিশাল", Aliases: []string{"Barisal"}},
+		[]City{
+			district("Barguna", "বরগুনা"),
+			district("Barishal", "বরিশাল", "Barisal"),
+			district("Bhola", "ভোলা"),
+			district("Jhalokati", "ঝালকাঠি", "Jhalakati", "Jhalokathi"),
+			district("Patuakhali", "পটুয়াখালী"),
+			district("Pirojpur", "পিরোজপুর"),
+		},
+	},
+	{
+		Division{Code: "BD-B", Country: "BD", Name: "Chattogram", LocalName: "চট্টগ্রাম", Aliases: []string{"Chittagong"}},
+		[]City{
+			district("Bandarban", "বান্দরবান"),
+			district("Brahmanbaria", "ব্রাহ্মণবাড়িয়া"),
+			district("Chandpur", "চাঁদপুর"),
+			district("Chattogram", "চট্টগ্রাম", "Chittagong"),
+			district("Cox's Bazar", "কক্সবাজার"),
+			district("Cumilla", "কুমিল্লা", "Comilla"),
+			district("Feni", "ফেনী"),
+			district("Khagrachhari", "খাগড়াছড়ি", "Khagrachari"),
+			district("Lakshmipur", "লক্ষ্মীপুর", "Laxmipur"),
+			district("Noakhali", "নোয়াখালী"),
+			district("Rangamati", "রাঙ্গামাটি"),
+		},
+	},
+	{
+		Division{Code: "BD-C", Country: "BD", Name: "Dhaka", LocalName: "ঢাকা"},
+		[]City{
+			district("Dhaka", "ঢাকা"),
+			district("Faridpur", "ফরিদপুর"),
+			district("Gazipur", "গাজীপুর"),
+			district("Gopalganj", "গোপালগঞ্জ"),
+			district("Kishoreganj", "কিশোরগঞ্জ", "Kishorganj"),
+			district("Madaripur", "মাদারীপুর"),
+			district("Manikganj", "মানিকগঞ্জ"),
+			district("Munshiganj", "মুন্সিগঞ্জ"),
+			district("Narayanganj", "নারায়ণগঞ্জ"),
+			district("Narsingdi", "নরসিংদী"),
+			district("Rajbari", "রাজবাড়ী"),
+			district("Shariatpur", "শরীয়তপুর"),
+			district("Tangail", "টাঙ্গাইল"),
+		},
+	},
+	{
+		Division{Code: "BD-D", Country: "BD", Name: "Khulna", LocalName: "খুলনা"},
+		[]City{
+			district("Bagerhat", "বাগেরহাট"),
+			district("Chuadanga", "চুয়াডাঙ্গা"),
+			district("Jashore", "যশোর", "Jessore"),
+			district("Jhenaidah", "ঝিনাইদহ"),
+			district("Khulna", "খুলনা"),
+			district("Kushtia", "কুষ্টিয়া"),
+			district("Magura", "মাগুরা"),
+			district("Meherpur", "মেহেরপুর"),
+			district("Narail", "নড়াইল"),
+			district("Satkhira", "সাতক্ষীরা"),
+		},
+	},
+	{
+		Division{Code: "BD-H", Country: "BD", Name: "Mymensingh", LocalName: "ময়মনসিংহ"},
+		[]City{
+			district("Jamalpur", "জামালপুর"),
+			district("Mymensingh", "ময়মনসিংহ"),
+			district("Netrokona", "নেত্রকোণা", "Netrakona"),
+			district("Sherpur", "শেরপুর"),
+		},
+	},
+	{
+		Division{Code: "BD-E", Country: "BD", Name: "Rajshahi", LocalName: "রাজশাহী"},
+		[]City{
+			district("Bogura", "বগুড়া", "Bogra"),
+			district("Chapai Nawabganj", "চাঁপাইনবাবগঞ্জ", "Nawabganj"),
+			district("Joypurhat", "জয়পুরহাট", "Jaipurhat"),
+			district("Naogaon", "নওগাঁ"),
+			district("Natore", "নাটোর"),
+			district("Pabna", "পাবনা"),
+			district("Rajshahi", "রাজশাহী"),
+			district("Sirajganj", "সিরাজগঞ্জ"),
+		},
+	},
+	{
+		Division{Code: "BD-F", Country: "BD", Name: "Rangpur", LocalName: "রংপুর"},
+		[]City{
+			district("Dinajpur", "দিনাজপুর"),
+			district("Gaibandha", "গাইবান্ধা"),
+			district("Kurigram", "কুড়িগ্রাম"),
+			district("Lalmonirhat", "লালমনিরহাট"),
+			district("Nilphamari", "নীলফামারী"),
+			district("Panchagarh", "পঞ্চগড়"),
+			district("Rangpur", "রংপুর"),
+			district("Thakurgaon", "ঠাকুরগাঁও"),
+		},
+	},
+	{
+		Division{Code: "BD-G", Country: "BD", Name: "Sylhet", LocalName: "সিলেট"},
+		[]City{
+			district("Habiganj", "হবিগঞ্জ"),
+			district("Moulvibazar", "মৌলভীবাজার", "Maulvibazar"),
+			district("Sunamganj", "সুনামগঞ্জ"),
+			district("Sylhet", "সিলেট"),
+		},
+	},
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestLocations(t *testing.T) {
+	srvr := newTestServer(t)
+	test := []testData{
+		{
+			"show_countries",
+			"GET",
+			"/locations",
+			200,
+			nil,
+		},
+		{
+			"show_divisions",
+			"GET",
+			"/locations/bd",
+			200,
+			nil,
+		},
+		{
+			"show_divisions_unknown_country",
+			"GET",
+			"/locations/xx",
+			404,
+			nil,
+		},
+		{
+			"show_division_by_code",
+			"GET",
+			"/locations/BD/BD-C",
+			200,
+			nil,
+		},
+		{
+			"show_division_by_old_name",
+			"GET",
+			"/locations/BD/chittagong",
+			200,
+			nil,
+		},
+		{
+			"show_division_not_found",
+			"GET",
+			"/locations/BD/Bengal",
+			404,
+			nil,
+		},
+	}
+	for _, data := range test {
+		runTest(t, srvr, data)
+	}
+}
+
+func TestWorkerLocation(t *testing.T) {
+	srvr := newTestServer(t)
+	test := []testData{
+		{
+			"add_worker_city_of_other_division",
+			"POST",
+			"/appscode/workers",
+			422,
+			strings.NewReader(`{"username":"masudur","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Sylhet","position":"Software Engineer","salary":5500}`),
+		},
+		{
+			"add_worker_unknown_division",
+			"POST",
+			"/appscode/workers",
+			422,
+			strings.NewReader(`{"username":"masudur","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"D","position":"Software Engineer","salary":5500}`),
+		},
+		{
+			"add_worker_old_spelling",
+			"POST",
+			"/appscode/workers",
+			201,
+			strings.NewReader(`{"username":"masudur","firstname":"Masudur","lastname":"Rahman","city":"cox's bazar","division":"Chittagong","position":"Software Engineer","salary":5500}`),
+		},
+		{
+			"update_worker_unknown_city",
+			"PUT",
+			"/appscode/workers/masud",
+			422,
+			strings.NewReader(`{"username":"masud","firstname":"Masudur","lastname":"Rahman","city":"M","division":"Dhaka","position":"Software Engineer","salary":5500}`),
+		},
+	}
+	for _, data := range test {
+		runTest(t, srvr, data)
+	}
+}
+
+func TestMigrateLocations(t *testing.T) {
+	srvr := newTestServer(t)
+	for _, worker := range []Worker{
+		{Username: "rahim", FirstName: "Rahim", LastName: "Uddin", City: "Comilla", Division: "Chittagong", Position: "Engineer"},
+		{Username: "karim", FirstName: "Karim", LastName: "Uddin", City: "jessore", Division: "", Position: "Engineer"},
+		{Username: "salam", FirstName: "Salam", LastName: "Uddin", City: "M", Division: "D", Position: "Engineer"},
+		{Username: "barkat", FirstName: "Barkat", LastName: "Uddin", City: "Nowhere", Division: "dhaka", Position: "Engineer"},
+	} {
+		worker := worker
+		if err := srvr.store.CreateWorker(&worker); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := srvr.MigrateLocations(true); err != nil {
+		t.Fatal(err)
+	}
+	if rahim, _ := srvr.store.GetWorker("rahim"); rahim.City != "Comilla" {
+		t.Errorf("dry run changed the city to %q", rahim.City)
+	}
+
+	report, err := srvr.MigrateLocations(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &LocationReport{
+		Checked: 8,
+		Updated: 3,
+		Changes: []LocationChange{
+			{Username: "barkat", Field: "division", From: "dhaka", To: "Dhaka"},
+			{Username: "karim", Field: "division", From: "", To: "Khulna"},
+			{Username: "karim", Field: "city", From: "jessore", To: "Jashore"},
+			{Username: "rahim", Field: "division", From: "Chittagong", To: "Chattogram"},
+			{Username: "rahim", Field: "city", From: "Comilla", To: "Cumilla"},
+		},
+		Unmatched: []LocationChange{
+			{Username: "barkat", Field: "city", From: "Nowhere"},
+			{Username: "salam", Field: "division", From: "D"},
+			{Username: "salam", Field: "city", From: "M"},
+		},
+	}
+	sortChanges(report)
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("got report %+v expected %+v", report, expected)
+	}
+
+	rahim, err := srvr.store.GetWorker("rahim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rahim.City != "Cumilla" || rahim.Division != "Chattogram" || !rahim.UpdatedAt.Equal(testTime.Now()) {
+		t.Errorf("migration was not stored, got %+v", rahim)
+	}
+}
+
+// sortChanges orders the report by username, as the store lists workers in no set order
+func sortChanges(report *LocationReport) {
+	for _, changes := range [][]LocationChange{report.Changes, report.Unmatched} {
+		changes := changes
+		sort.SliceStable(changes, func(i, j int) bool { return changes[i].Username < changes[j].Username })
+	}
+}
+
+func TestLocationKey(t *testing.T) {
+	test := []struct {
+		a, b string
+	}{
+		{"Cox's Bazar", "coxs bazar"},
+		{"Chapai Nawabganj", "chapai-nawabganj"},
+		{"BD-C", "bd c"},
+		// precomposed and decomposed RRA
+		{"\u09ac\u0997\u09c1\u09dc\u09be", "\u09ac\u0997\u09c1\u09a1\u09bc\u09be"},
+	}
+	for _, test := range test {
+		if locationKey(test.a) != locationKey(test.b) {
+			t.Errorf("%q and %q have different keys %q and %q", test.a, test.b, locationKey(test.a), locationKey(test.b))
+		}
+	}
+}
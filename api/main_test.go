@@ -1,219 +1,535 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
-	"gopkg.in/macaron.v1"
+	_ "github.com/mattn/go-sqlite3"
 )
 
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
 type testData struct {
-	method   string
-	url      string
-	status   int
-	handler  macaron.Handler
-	path     string
-	body     io.Reader
-	response string
+	name   string
+	method string
+	url    string
+	status int
+	body   io.Reader
 }
 
-func init() {
-	StartXormEngine()
-	CreateInitialWorkerProfile()
+// fixedClock always tells the same time, so timestamps in responses are stable
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time { return time.Time(c) }
+
+var testTime = fixedClock(time.Date(2019, 3, 20, 12, 17, 7, 0, time.UTC))
+
+var dbCounter int32
+
+// newTestServer returns a server backed by its own in-memory SQLite
+// database, seeded with the default workers
+func newTestServer(t *testing.T, opts ...Option) *Server {
+	name := fmt.Sprintf("file:apiserver%d?mode=memory&cache=shared", atomic.AddInt32(&dbCounter, 1))
+	engine, err := NewXormEngine("sqlite3", name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newServerWithEngine(t, NewXormStore(engine), opts...)
 }
 
-func TestShowAllWorkers(t *testing.T) {
+func newServerWithEngine(t *testing.T, store *XormStore, opts ...Option) *Server {
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
 
+	opts = append([]Option{
+		WithStore(store),
+		WithBypassAuth(true),
+		WithClock(testTime),
+		WithLogger(log.New(ioutil.Discard, "", 0)),
+	}, opts...)
+	srvr := NewServer(opts...)
+	if err := srvr.SeedLocations(BangladeshLocations()); err != nil {
+		t.Fatal(err)
+	}
+	if err := srvr.SeedPositions(DefaultPositions()); err != nil {
+		t.Fatal(err)
+	}
+	if err := srvr.SeedWorkers(DefaultWorkers()); err != nil {
+		t.Fatal(err)
+	}
+	return srvr
+}
+
+func TestShowAllWorkers(t *testing.T) {
+	srvr := newTestServer(t)
 	test := []testData{
 		{
+			"show_all_workers",
 			"GET",
 			"/appscode/workers",
 			200,
-			ShowAllWorkers,
-			"/appscode/workers",
 			nil,
-			`[{"username":"masud","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":55,"CreatedAt":"2019-03-20T18:17:07+06:00","UpdatedAt":"2019-03-20T18:17:07+06:00","DeletedAt":"0001-01-01T00:00:00Z","Version":1},{"username":"fahim","firstname":"Fahim","lastname":"Abrar","city":"Chittagong","division":"Chittagong","position":"Software Engineer","salary":55,"CreatedAt":"2019-03-20T18:17:07+06:00","UpdatedAt":"2019-03-20T18:17:07+06:00","DeletedAt":"0001-01-01T00:00:00Z","Version":1},{"username":"tahsin","firstname":"Tahsin","lastname":"Rahman","city":"Chittagong","division":"Chittagong","position":"Software Engineer","salary":55,"CreatedAt":"2019-03-20T18:17:07+06:00","UpdatedAt":"2019-03-20T18:17:07+06:00","DeletedAt":"0001-01-01T00:00:00Z","Version":1},{"username":"jenny","firstname":"Jannatul","lastname":"Ferdows","city":"Chittagong","division":"Chittagong","position":"Software Engineer","salary":55,"CreatedAt":"2019-03-20T18:17:07+06:00","UpdatedAt":"2019-03-20T18:17:07+06:00","DeletedAt":"0001-01-01T00:00:00Z","Version":1}]`,
 		},
+		{"show_workers_first_page", "GET", "/appscode/workers?limit=2&currency=BDT", 200, nil},
+		{"show_workers_next_page", "GET", "/appscode/workers?after=jenny&currency=BDT&limit=2", 200, nil},
+		{"show_workers_last_page", "GET", "/appscode/workers?after=masud&limit=2", 200, nil},
+		{"show_workers_bad_limit", "GET", "/appscode/workers?limit=0", 422, nil},
 	}
 
 	for _, data := range test {
-		runTest(data, t)
+		runTest(t, srvr, data)
 	}
-
 }
 
 func TestShowSingleWorker(t *testing.T) {
+	srvr := newTestServer(t)
 	test := []testData{
 		{
+			"show_worker_masud",
 			"GET",
 			"/appscode/workers/masud",
 			200,
-			ShowSingleWorker,
-			"/appscode/workers/:username",
-			nil,
-			`{"username":"masud","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":55,"CreatedAt":"2019-03-20T18:17:07+06:00","UpdatedAt":"2019-03-20T18:17:07+06:00","DeletedAt":"0001-01-01T00:00:00Z","Version":1}`,
-		},
-		{
-			"GET",
-			"/appscode/workers/fahim",
-			200,
-			ShowSingleWorker,
-			"/appscode/workers/:username",
-			nil,
-			`{"username":"fahim","firstname":"Fahim","lastname":"Abrar","city":"Chittagong","division":"Chittagong","position":"Software Engineer","salary":55,"CreatedAt":"2019-03-20T18:17:07+06:00","UpdatedAt":"2019-03-20T18:17:07+06:00","DeletedAt":"0001-01-01T00:00:00Z","Version":1}`,
-		},
-		{
-			"GET",
-			"/appscode/workers/tahsin",
-			200,
-			ShowSingleWorker,
-			"/appscode/workers/:username",
 			nil,
-			`{"username":"tahsin","firstname":"Tahsin","lastname":"Rahman","city":"Chittagong","division":"Chittagong","position":"Software Engineer","salary":55,"CreatedAt":"2019-03-20T18:17:07+06:00","UpdatedAt":"2019-03-20T18:17:07+06:00","DeletedAt":"0001-01-01T00:00:00Z","Version":1}`,
 		},
 		{
+			"show_worker_jenny",
 			"GET",
 			"/appscode/workers/jenny",
 			200,
-			ShowSingleWorker,
-			"/appscode/workers/:username",
 			nil,
-			`{"username":"jenny","firstname":"Jannatul","lastname":"Ferdows","city":"Chittagong","division":"Chittagong","position":"Software Engineer","salary":55,"CreatedAt":"2019-03-20T18:17:07+06:00","UpdatedAt":"2019-03-20T18:17:07+06:00","DeletedAt":"0001-01-01T00:00:00Z","Version":1}`,
 		},
 		{
+			"show_worker_not_found",
 			"GET",
 			"/appscode/workers/abcd",
 			404,
-			ShowSingleWorker,
-			"/appscode/workers/:username",
 			nil,
-			`404 - Content Not Found`,
 		},
 	}
 
 	for _, data := range test {
-		runTest(data, t)
-
+		runTest(t, srvr, data)
 	}
-
 }
 
 func TestAddNewWorker(t *testing.T) {
+	srvr := newTestServer(t)
 	test := []testData{
 		{
+			"add_worker_conflict",
 			"POST",
 			"/appscode/workers",
 			409,
-			AddNewWorker,
+			strings.NewReader(`{"username":"masud","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500}`),
+		},
+		{
+			"add_worker_without_username",
+			"POST",
+			"/appscode/workers",
+			422,
+			strings.NewReader(`{"firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500}`),
+		},
+		{
+			"add_worker_malformed",
+			"POST",
 			"/appscode/workers",
-			strings.NewReader(`{"username":"masud","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":55}`),
-			`409 - username already exists`,
+			400,
+			strings.NewReader(`{"username":"masudur",`),
 		},
 		{
+			"add_worker_invalid_fields",
+			"POST",
+			"/appscode/workers",
+			422,
+			strings.NewReader(`{"username":"Masud Rahman","firstname":"M4sud","lastname":"","city":"Madaripur","division":"Dhaka Division","position":"Software Engineer","salary":-5}`),
+		},
+		{
+			"add_worker_unknown_field",
+			"POST",
+			"/appscode/workers",
+			422,
+			strings.NewReader(`{"username":"masudur","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500,"bonus":10}`),
+		},
+		{
+			"add_worker_wrong_type",
+			"POST",
+			"/appscode/workers",
+			422,
+			strings.NewReader(`{"username":"masudur","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":"55"}`),
+		},
+		{
+			"add_worker_trailing_data",
+			"POST",
+			"/appscode/workers",
+			400,
+			strings.NewReader(`{"username":"masudur","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500} {}`),
+		},
+		{
+			"add_worker_bengali_name",
 			"POST",
 			"/appscode/workers",
 			201,
-			AddNewWorker,
+			strings.NewReader(`{"username":"rahim","firstname":"রহিম","lastname":"উদ্দিন","city":"মাদারীপুর","division":"Dhaka","position":"Software Engineer","salary":5500}`),
+		},
+		{
+			"add_worker",
+			"POST",
 			"/appscode/workers",
-			strings.NewReader(`{"username":"masudur","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":55}`),
-			`{"username":"masudur","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":55,"CreatedAt":"2019-03-20T19:16:49.9130127+06:00","UpdatedAt":"2019-03-20T19:16:49.913024478+06:00","DeletedAt":"0001-01-01T00:00:00Z","Version":1}`,
+			201,
+			strings.NewReader(`{"username":"masudur","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500}`),
+		},
+		{
+			"show_added_worker",
+			"GET",
+			"/appscode/workers/masudur",
+			200,
+			nil,
 		},
 	}
 
 	for _, data := range test {
-		runTest(data, t)
+		runTest(t, srvr, data)
 	}
-
 }
 
 func TestUpdateWorkerProfile(t *testing.T) {
+	srvr := newTestServer(t)
 	test := []testData{
 		{
-			"POST",
+			"update_worker_username_changed",
+			"PUT",
 			"/appscode/workers/masud",
-			405,
-			UpdateWorkerProfile,
-			"/appscode/workers/:username",
-			strings.NewReader(`{"username":"masudd","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":55}`),
-			`405 - Username can't be changed`,
+			422,
+			strings.NewReader(`{"username":"masudd","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500}`),
 		},
 		{
-			"POST",
+			"update_worker_not_found",
+			"PUT",
 			"/appscode/workers/masudd",
 			404,
-			UpdateWorkerProfile,
-			"/appscode/workers/:username",
-			strings.NewReader(`{"username":"masudd","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":55}`),
-			`404 - Content Not Found`,
+			strings.NewReader(`{"username":"masudd","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500}`),
 		},
 		{
-			"POST",
+			"update_worker_missing_fields",
+			"PUT",
+			"/appscode/workers/masud",
+			422,
+			strings.NewReader(`{"username":"masud","city":"Madaripur","division":"Dhaka","salary":5500}`),
+		},
+		{
+			"update_worker",
+			"PUT",
 			"/appscode/workers/masud",
 			201,
-			UpdateWorkerProfile,
-			"/appscode/workers/:username",
-			strings.NewReader(`{"username":"masud","firstname":"Masudur","lastname":"Rahman","city":"M","division":"D","position":"Software Engineer","salary":55}`),
-			`201 - Updated successfully`,
+			strings.NewReader(`{"username":"masud","firstname":"Masudur","lastname":"Rahman","city":"Shariatpur","division":"Dhaka","position":"Software Engineer","salary":6000}`),
+		},
+		{
+			"show_updated_worker",
+			"GET",
+			"/appscode/workers/masud",
+			200,
+			nil,
 		},
 	}
 
 	for _, data := range test {
-		runTest(data, t)
+		runTest(t, srvr, data)
 	}
 }
 
 func TestDeleteWorker(t *testing.T) {
+	srvr := newTestServer(t)
 	test := []testData{
 		{
+			"delete_worker",
 			"DELETE",
 			"/appscode/workers/masud",
 			200,
-			DeleteWorker,
-			"/appscode/workers/:username",
 			nil,
-			`200 - Deleted Successfully`,
 		},
 		{
-			"DELETE",
-			"/appscode/workers/fahim",
-			200,
-			DeleteWorker,
-			"/appscode/workers/:username",
+			"show_deleted_worker",
+			"GET",
+			"/appscode/workers/masud",
+			404,
 			nil,
-			`200 - Deleted Successfully`,
 		},
 		{
+			"delete_worker_not_found",
 			"DELETE",
 			"/appscode/workers/hello",
 			404,
-			DeleteWorker,
-			"/appscode/workers/:username",
 			nil,
-			`404 - Content Not Found`,
+		},
+		{
+			"add_deleted_worker",
+			"POST",
+			"/appscode/workers",
+			409,
+			strings.NewReader(`{"username":"masud","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500}`),
+		},
+	}
+	for _, data := range test {
+		runTest(t, srvr, data)
+	}
+}
+
+func TestRestoreWorker(t *testing.T) {
+	srvr := newTestServer(t)
+	serveTest(t, srvr, testData{"set_manager", "PUT", "/appscode/workers/fahim", 201, strings.NewReader(`{"firstname":"Fahim","lastname":"Abrar","city":"Chattogram","division":"Chattogram","position":"Software Engineer","salary":5500,"manager":"masud"}`)})
+	serveTest(t, srvr, testData{"delete_report", "DELETE", "/appscode/workers/fahim", 200, nil})
+	serveTest(t, srvr, testData{"delete_manager", "DELETE", "/appscode/workers/masud", 200, nil})
+
+	test := []testData{
+		{"restore_worker", "POST", "/appscode/workers/fahim/restore", 200, nil},
+		{"restore_live_worker", "POST", "/appscode/workers/fahim/restore", 409, nil},
+		{"restore_worker_not_found", "POST", "/appscode/workers/hello/restore", 404, nil},
+		{"show_restored_employment", "GET", "/appscode/workers/fahim/employment", 200, nil},
+	}
+	for _, data := range test {
+		runTest(t, srvr, data)
+	}
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	srvr := newTestServer(t, WithMaxBodyBytes(64))
+	test := []testData{
+		{
+			"add_worker_too_large",
+			"POST",
+			"/appscode/workers",
+			413,
+			strings.NewReader(`{"username":"masudur","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500}`),
+		},
+	}
+	for _, data := range test {
+		runTest(t, srvr, data)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	srvr := newTestServer(t, WithBypassAuth(false), WithAuthProvider(StaticAuth{"admin": "admin"}))
+
+	for _, data := range []struct {
+		name       string
+		user, pass string
+		status     int
+	}{
+		{"auth_missing", "", "", 401},
+		{"auth_wrong_password", "admin", "wrong", 401},
+		{"auth_unknown_user", "masud", "pass", 401},
+		{"show_all_workers", "admin", "admin", 200},
+	} {
+		req := httptest.NewRequest("GET", "/appscode/workers", nil)
+		req.Header.Set("X-Request-ID", data.name)
+		if data.user != "" {
+			req.SetBasicAuth(data.user, data.pass)
+		}
+		rec := httptest.NewRecorder()
+		srvr.Handler().ServeHTTP(rec, req)
+		if rec.Code != data.status {
+			t.Errorf("%s: got status %v expected %v", data.name, rec.Code, data.status)
+		}
+		checkGolden(t, data.name, dumpResponse(rec))
+	}
+}
+
+func TestTokenAuth(t *testing.T) {
+	srvr := newTestServer(t, WithBypassAuth(false), WithAuthProvider(StaticAuth{"admin": "admin"}),
+		WithTokens(map[string]string{"s3cr3t": "admin"}), WithAPIKeys(map[string]string{"k3y": "admin"}))
+
+	for _, data := range []struct {
+		name   string
+		header string
+		value  string
+		status int
+	}{
+		{"auth_unknown_token", "Authorization", "Bearer wrong", 401},
+		{"auth_unknown_api_key", APIKeyHeader, "wrong", 401},
+		{"", "Authorization", "Bearer s3cr3t", 200},
+		{"", "Authorization", "bearer s3cr3t", 200},
+		{"", APIKeyHeader, "k3y", 200},
+	} {
+		header := make(http.Header)
+		header.Set(data.header, data.value)
+		rec := serveTestWithHeader(t, srvr, testData{data.name, "GET", "/appscode/workers", data.status, nil}, header)
+		if data.name != "" {
+			checkGolden(t, data.name, dumpResponse(rec))
+		}
+	}
+
+	// The credentials stand for their user, who can see the webhooks
+	// as an admin
+	header := make(http.Header)
+	header.Set(APIKeyHeader, "k3y")
+	serveTestWithHeader(t, srvr, testData{"", "GET", "/appscode/webhooks", 200, nil}, header)
+}
+
+func TestProblemResponses(t *testing.T) {
+	srvr := newTestServer(t)
+	test := []testData{
+		{
+			"unknown_route",
+			"GET",
+			"/appscode/nothing/here",
+			404,
+			nil,
 		},
 	}
 	for _, data := range test {
-		runTest(data, t)
+		runTest(t, srvr, data)
+	}
+
+	// Without an X-Request-ID from the client the server makes one up
+	rec := httptest.NewRecorder()
+	srvr.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/appscode/workers/nobody", nil))
+
+	var problem Error
+	if err := json.NewDecoder(rec.Body).Decode(&problem); err != nil {
+		t.Fatal(err)
+	}
+	if id := rec.Header().Get("X-Request-ID"); id == "" || id != problem.RequestID {
+		t.Errorf("got request ID %q in header and %q in body", id, problem.RequestID)
+	}
+}
+
+func TestIsolatedServers(t *testing.T) {
+	first := newTestServer(t)
+	second := newTestServer(t)
+
+	runTest(t, first, testData{
+		"add_worker",
+		"POST",
+		"/appscode/workers",
+		201,
+		strings.NewReader(`{"username":"masudur","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500}`),
+	})
+	runTest(t, second, testData{
+		"show_worker_of_other_server",
+		"GET",
+		"/appscode/workers/masudur",
+		404,
+		nil,
+	})
+}
+
+func TestRunAndShutdown(t *testing.T) {
+	srvr := newTestServer(t, WithAddr("127.0.0.1:0"), WithGracefulTimeout(time.Second))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srvr.Run(ctx) }()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil && err != http.ErrServerClosed {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+}
+
+// serveTest serves the request and checks the status code of the response
+func serveTest(t *testing.T, srvr *Server, test testData) *httptest.ResponseRecorder {
+	t.Helper()
+	return serveTestWithType(t, srvr, test, "")
+}
+
+// serveTestWithType is serveTest with a request body of contentType
+func serveTestWithType(t *testing.T, srvr *Server, test testData, contentType string) *httptest.ResponseRecorder {
+	t.Helper()
+	header := make(http.Header)
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
 	}
+	return serveTestWithHeader(t, srvr, test, header)
 }
 
-func runTest(test testData, t *testing.T) {
+// serveTestWithHeader is serveTest with extra request headers
+func serveTestWithHeader(t *testing.T, srvr *Server, test testData, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+
 	req, err := http.NewRequest(test.method, test.url, test.body)
 	if err != nil {
 		t.Fatal(err)
 	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("X-Request-ID", test.name)
 	responseRecorder := httptest.NewRecorder()
+	srvr.Handler().ServeHTTP(responseRecorder, req)
+
+	if status := responseRecorder.Code; status != test.status {
+		t.Errorf("%s: handler returned wrong status code: got %v expected %v", test.name, status, test.status)
+	}
+	return responseRecorder
+}
 
-	m := macaron.Classic()
+// runTest serves the request and compares the response against
+// testdata/<name>.golden, run with -update to rewrite the golden files
+func runTest(t *testing.T, srvr *Server, test testData) {
+	t.Helper()
 
-	m.Handle(test.method, test.path, []macaron.Handler{test.handler})
-	m.ServeHTTP(responseRecorder, req)
+	checkGolden(t, test.name, dumpResponse(serveTest(t, srvr, test)))
+}
 
-	if status := responseRecorder.Code; status != test.status {
-		t.Errorf("handler returned wrong status code: got %v expected %v", status, test.status)
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+
+	golden := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, expected) {
+		t.Errorf("%s: response does not match %s\ngot:\n%s\nexpected:\n%s", name, golden, got, expected)
+	}
+}
+
+// dumpResponse writes the status, the sorted headers and the body
+func dumpResponse(rec *httptest.ResponseRecorder) []byte {
+	header := rec.Result().Header
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d %s\n", rec.Code, http.StatusText(rec.Code))
+
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range header[key] {
+			fmt.Fprintf(&buf, "%s: %s\n", key, value)
+		}
 	}
 
+	buf.WriteString("\n")
+	buf.Write(rec.Body.Bytes())
+	return buf.Bytes()
 }
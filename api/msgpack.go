@@ -0,0 +1,274 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// The MessagePack codec covers the values JSON can hold, which is all the
+// API needs: nil, booleans, integers, floats, strings, arrays and maps.
+// Binary strings are read as strings, extension types are rejected.
+
+func encodeMsgpack(v interface{}) ([]byte, error) {
+	tree, err := toTree(v)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	writeMsgpack(&buf, tree)
+	return buf.Bytes(), nil
+}
+
+func writeMsgpack(buf *bytes.Buffer, tree interface{}) {
+	switch t := tree.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if t {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		switch n := number(t).(type) {
+		case int64:
+			writeMsgpackInt(buf, n)
+		case float64:
+			buf.WriteByte(0xcb)
+			binary.Write(buf, binary.BigEndian, math.Float64bits(n))
+		}
+	case string:
+		writeMsgpackHeader(buf, len(t), 0xa0, 32, 0xd9, 0xda, 0xdb)
+		buf.WriteString(t)
+	case []interface{}:
+		writeMsgpackHeader(buf, len(t), 0x90, 16, 0, 0xdc, 0xdd)
+		for _, value := range t {
+			writeMsgpack(buf, value)
+		}
+	case object:
+		writeMsgpackHeader(buf, len(t), 0x80, 16, 0, 0xde, 0xdf)
+		for _, m := range t {
+			writeMsgpack(buf, m.key)
+			writeMsgpack(buf, m.value)
+		}
+	}
+}
+
+func writeMsgpackInt(buf *bytes.Buffer, n int64) {
+	switch {
+	case n >= 0 && n < 128, n < 0 && n >= -32:
+		buf.WriteByte(byte(n))
+	case n >= 0 && n <= math.MaxUint8:
+		buf.Write([]byte{0xcc, byte(n)})
+	case n >= 0 && n <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	case n >= 0 && n <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	case n >= 0:
+		buf.WriteByte(0xcf)
+		binary.Write(buf, binary.BigEndian, uint64(n))
+	case n >= math.MinInt8:
+		buf.Write([]byte{0xd0, byte(n)})
+	case n >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(n))
+	case n >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(n))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+// writeMsgpackHeader writes the type and length of a string, array or
+// map: fix holds lengths below fixMax, the others 8, 16 and 32 bit ones
+func writeMsgpackHeader(buf *bytes.Buffer, n int, fix byte, fixMax int, code8, code16, code32 byte) {
+	switch {
+	case n < fixMax:
+		buf.WriteByte(fix | byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		buf.Write([]byte{code8, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(code16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(code32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+var errMsgpackShort = errors.New("msgpack: unexpected end of data")
+
+// msgpackToJSON converts a MessagePack value to JSON
+func msgpackToJSON(body []byte) ([]byte, error) {
+	r := &msgpackReader{data: body}
+	value, err := r.read(0)
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(r.data) {
+		return nil, fmt.Errorf("msgpack: unexpected data after the value")
+	}
+	return json.Marshal(value)
+}
+
+type msgpackReader struct {
+	data []byte
+	pos  int
+}
+
+// msgpackMaxDepth bounds the nesting of arrays and maps
+const msgpackMaxDepth = 64
+
+func (r *msgpackReader) next(n int) ([]byte, error) {
+	if n < 0 || len(r.data)-r.pos < n {
+		return nil, errMsgpackShort
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+// uint reads a big endian unsigned integer of size bytes
+func (r *msgpackReader) uint(size int) (uint64, error) {
+	b, err := r.next(size)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n, nil
+}
+
+func (r *msgpackReader) read(depth int) (interface{}, error) {
+	if depth > msgpackMaxDepth {
+		return nil, fmt.Errorf("msgpack: nested too deep")
+	}
+	b, err := r.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return r.readMap(int(c&0x0f), depth)
+	case c&0xf0 == 0x90:
+		return r.readArray(int(c&0x0f), depth)
+	case c&0xe0 == 0xa0:
+		return r.readString(int(c & 0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xd9:
+		return r.readString8()
+	case 0xc5, 0xda, 0xc6, 0xdb:
+		size := 2
+		if c == 0xc6 || c == 0xdb {
+			size = 4
+		}
+		n, err := r.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		return r.readString(int(n))
+	case 0xca:
+		n, err := r.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := r.uint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return r.uint(1 << (c - 0xcc))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		n, err := r.uint(size)
+		// Sign extend from the size of the integer
+		shift := uint(64 - 8*size)
+		return int64(n<<shift) >> shift, err
+	case 0xdc, 0xdd, 0xde, 0xdf:
+		size := 2
+		if c == 0xdd || c == 0xdf {
+			size = 4
+		}
+		n, err := r.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		if c <= 0xdd {
+			return r.readArray(int(n), depth)
+		}
+		return r.readMap(int(n), depth)
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type 0x%02x", c)
+}
+
+func (r *msgpackReader) readString8() (interface{}, error) {
+	n, err := r.uint(1)
+	if err != nil {
+		return nil, err
+	}
+	return r.readString(int(n))
+}
+
+func (r *msgpackReader) readString(n int) (interface{}, error) {
+	b, err := r.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (r *msgpackReader) readArray(n int, depth int) (interface{}, error) {
+	// Every value takes a byte at least, so a bogus length fails early
+	if n > len(r.data)-r.pos {
+		return nil, errMsgpackShort
+	}
+	a := make([]interface{}, n)
+	for i := range a {
+		var err error
+		if a[i], err = r.read(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+func (r *msgpackReader) readMap(n int, depth int) (interface{}, error) {
+	if n > len(r.data)-r.pos {
+		return nil, errMsgpackShort
+	}
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := r.read(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		k, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("msgpack: the key %v isn't a string", key)
+		}
+		if m[k], err = r.read(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,444 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/macaron.v1"
+)
+
+// Version is the version of the API, as told by the OpenAPI document
+const Version = "2.0"
+
+// route is a method and path registered with macaron, the path keeps
+// macaron's :name parameters
+type route struct {
+	method string
+	path   string
+}
+
+// router registers the routes with macaron and keeps a list of them, the
+// OpenAPI document is built from that list
+type router struct {
+	m      *macaron.Macaron
+	prefix string
+	routes []route
+}
+
+func (r *router) handle(method, path string, h macaron.Handler) {
+	full := strings.TrimSuffix(r.prefix+path, "/")
+	if full == "" {
+		full = "/"
+	}
+	r.routes = append(r.routes, route{method, full})
+	r.m.Handle(method, path, []macaron.Handler{h})
+}
+
+func (r *router) get(path string, h macaron.Handler)    { r.handle("GET", path, h) }
+func (r *router) post(path string, h macaron.Handler)   { r.handle("POST", path, h) }
+func (r *router) put(path string, h macaron.Handler)    { r.handle("PUT", path, h) }
+func (r *router) delete(path string, h macaron.Handler) { r.handle("DELETE", path, h) }
+
+func (r *router) group(prefix string, fn func()) {
+	outer := r.prefix
+	r.prefix += prefix
+	r.m.Group(prefix, fn)
+	r.prefix = outer
+}
+
+// apiParam is a query parameter of an operation
+type apiParam struct {
+	name        string
+	typ         string
+	description string
+	enum        []string
+}
+
+// apiOperation documents a route. The body and result are zero values of
+// the types the handler decodes and renders.
+type apiOperation struct {
+	summary string
+	tag     string
+	query   []apiParam
+	body    interface{}
+	// bodyTypes are the media types of the body, requestTypes by default
+	bodyTypes []string
+	// statuses are the success statuses, 200 by default
+	statuses []int
+	result   interface{}
+	// resultTypes are media types written by the handler itself
+	resultTypes []string
+	// errors are the statuses of problems beyond the ones every
+	// operation of its kind can answer with
+	errors []int
+	// public operations need no credentials
+	public bool
+}
+
+// operationKey is the key of a route in apiOperations
+func operationKey(method, path string) string {
+	return method + " " + path
+}
+
+var pathParamPattern = regexp.MustCompile(`:([a-z_]+)`)
+
+// openAPIPath turns macaron's :name parameters into {name}
+func openAPIPath(path string) string {
+	return pathParamPattern.ReplaceAllString(path, "{$1}")
+}
+
+// OpenAPI returns the OpenAPI 3.1 document of the routes of the server
+func (s *Server) OpenAPI() ([]byte, error) {
+	g := &schemaGenerator{schemas: make(map[string]interface{})}
+	paths := make(map[string]map[string]interface{})
+	tags := make(map[string]bool)
+
+	for _, r := range s.routes {
+		op, ok := apiOperations[operationKey(r.method, r.path)]
+		if !ok {
+			continue
+		}
+		tags[op.tag] = true
+		path := openAPIPath(r.path)
+		if paths[path] == nil {
+			paths[path] = make(map[string]interface{})
+		}
+		_, _, limited := s.routeRateLimit(r.method, r.path)
+		paths[path][strings.ToLower(r.method)] = g.operation(r, op, limited)
+	}
+
+	tagList := make([]map[string]string, 0, len(tags))
+	for tag := range tags {
+		tagList = append(tagList, map[string]string{"name": tag})
+	}
+	sort.Slice(tagList, func(i, j int) bool { return tagList[i]["name"] < tagList[j]["name"] })
+
+	doc := map[string]interface{}{
+		"openapi": "3.1.0",
+		"info": map[string]interface{}{
+			"title":       "apiserver",
+			"version":     Version,
+			"description": "The worker profiles of AppsCode Ltd., with their departments, teams, positions and employment history.",
+		},
+		"servers":  []map[string]string{{"url": "/"}},
+		"security": []map[string][]string{{"basicAuth": {}}, {"bearerAuth": {}}, {"apiKey": {}}},
+		"tags":     tagList,
+		"paths":    paths,
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]interface{}{
+				"basicAuth":  map[string]string{"type": "http", "scheme": "basic"},
+				"bearerAuth": map[string]string{"type": "http", "scheme": "bearer"},
+				"apiKey":     map[string]string{"type": "apiKey", "in": "header", "name": APIKeyHeader},
+			},
+		},
+	}
+	body, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(body, '\n'), nil
+}
+
+func (g *schemaGenerator) operation(r route, op apiOperation, limited bool) map[string]interface{} {
+	operation := map[string]interface{}{
+		"operationId": operationID(r),
+		"summary":     op.summary,
+		"tags":        []string{op.tag},
+	}
+	if op.public {
+		operation["security"] = []interface{}{}
+	}
+
+	var params []map[string]interface{}
+	for _, m := range pathParamPattern.FindAllStringSubmatch(r.path, -1) {
+		params = append(params, map[string]interface{}{
+			"name":        m[1],
+			"in":          "path",
+			"required":    true,
+			"description": pathParams[m[1]],
+			"schema":      map[string]string{"type": "string"},
+		})
+	}
+	for _, p := range op.query {
+		schema := map[string]interface{}{"type": p.typ}
+		if p.enum != nil {
+			schema["enum"] = p.enum
+		}
+		params = append(params, map[string]interface{}{
+			"name":        p.name,
+			"in":          "query",
+			"description": p.description,
+			"schema":      schema,
+		})
+	}
+	if r.method == "POST" {
+		params = append(params, map[string]interface{}{
+			"name":        idempotencyKeyHeader,
+			"in":          "header",
+			"description": "Retries sent with the same key get the response to the first request, for a day by default",
+			"schema":      map[string]interface{}{"type": "string", "maxLength": maxIdempotencyKey},
+		})
+	}
+	if params != nil {
+		operation["parameters"] = params
+	}
+
+	errors := append([]int{http.StatusInternalServerError}, op.errors...)
+	if r.method == "POST" {
+		errors = append(errors, http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity)
+	}
+	if !op.public {
+		errors = append(errors, http.StatusUnauthorized)
+	}
+	if strings.Contains(r.path, ":") {
+		errors = append(errors, http.StatusNotFound)
+	}
+	if limited {
+		errors = append(errors, http.StatusTooManyRequests)
+	}
+
+	if op.body != nil || op.bodyTypes != nil {
+		content := make(map[string]interface{})
+		if op.body != nil {
+			schema := g.schema(reflect.TypeOf(op.body))
+			for _, mediaType := range requestTypes {
+				content[mediaType] = map[string]interface{}{"schema": schema}
+			}
+		}
+		for _, mediaType := range op.bodyTypes {
+			content[mediaType] = map[string]interface{}{"schema": mediaSchema(mediaType)}
+		}
+		operation["requestBody"] = map[string]interface{}{"required": true, "content": content}
+		errors = append(errors, http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity)
+	}
+
+	content := make(map[string]interface{})
+	if op.result != nil {
+		schema := g.schema(reflect.TypeOf(op.result))
+		for _, mediaType := range offersFor(op.result) {
+			content[mediaType] = map[string]interface{}{"schema": schema}
+		}
+		errors = append(errors, http.StatusNotAcceptable)
+	}
+	for _, mediaType := range op.resultTypes {
+		content[mediaType] = map[string]interface{}{"schema": mediaSchema(mediaType)}
+	}
+
+	responses := make(map[string]interface{})
+	statuses := op.statuses
+	if statuses == nil {
+		statuses = []int{http.StatusOK}
+	}
+	for _, status := range statuses {
+		responses[strconv.Itoa(status)] = map[string]interface{}{
+			"description": http.StatusText(status),
+			"content":     content,
+		}
+	}
+	problem := map[string]interface{}{
+		"application/problem+json": map[string]interface{}{"schema": g.schema(reflect.TypeOf(Error{}))},
+	}
+	for _, status := range errors {
+		responses[strconv.Itoa(status)] = map[string]interface{}{
+			"description": http.StatusText(status),
+			"content":     problem,
+		}
+	}
+	operation["responses"] = responses
+	return operation
+}
+
+// operationID names an operation after its method and path, like
+// getWorkersUsernameReports
+func operationID(r route) string {
+	id := strings.ToLower(r.method)
+	for _, part := range strings.FieldsFunc(r.path, func(c rune) bool { return c == '/' || c == '-' || c == '_' || c == ':' || c == '.' }) {
+		if part == "appscode" {
+			continue
+		}
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+// mediaSchema is the schema of a body the handler reads or writes itself
+func mediaSchema(mediaType string) map[string]interface{} {
+	switch mediaType {
+	case mediaJSON:
+		return map[string]interface{}{"type": "object"}
+	case xlsxContentType:
+		return map[string]interface{}{"type": "string", "format": "binary"}
+	}
+	return map[string]interface{}{"type": "string"}
+}
+
+// schemaGenerator turns Go types into JSON schemas, the named structs
+// become components referenced by the operations
+type schemaGenerator struct {
+	schemas map[string]interface{}
+}
+
+var timeReflectType = reflect.TypeOf(time.Time{})
+
+// readOnlyFields are stamped by the server, whatever the client sends
+var readOnlyFields = map[string]bool{"created_at": true, "updated_at": true, "version": true, "salary_override_by": true}
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == timeReflectType {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, ok := g.schemas[name]; !ok {
+			// Claim the name first, the struct may refer to itself
+			g.schemas[name] = nil
+			g.schemas[name] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	g.addFields(t, properties, &required)
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if required != nil {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// addFields adds the JSON fields of t, including the ones of its
+// embedded structs, to properties
+func (g *schemaGenerator) addFields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && f.Tag.Get("json") == "" {
+			g.addFields(f.Type, properties, required)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		name := jsonName(f)
+		if f.Tag.Get("json") == "-" {
+			continue
+		}
+
+		schema := g.schema(f.Type)
+		if _, ref := schema["$ref"]; !ref {
+			rules := f.Tag.Get("validate")
+			if ruleApplies(rules, "required") {
+				*required = append(*required, name)
+			}
+			applyRules(schema, rules)
+			if readOnlyFields[name] {
+				schema["readOnly"] = true
+			}
+		}
+		properties[name] = schema
+	}
+}
+
+func ruleApplies(rules, rule string) bool {
+	return contains(strings.Split(rules, ","), rule)
+}
+
+// scriptPattern matches the Unicode scripts of Go patterns, which the
+// ECMA-262 patterns of JSON schema spell as \p{Script=Name}
+var scriptPattern = regexp.MustCompile(`\\p\{([A-Z][a-z]{2,})\}`)
+
+// applyRules describes the validate rules of a field in its schema. Empty
+// values skip the rules of fields that aren't required, so the bounds and
+// patterns of those allow the empty string too.
+func applyRules(schema map[string]interface{}, rules string) {
+	required := ruleApplies(rules, "required")
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, arg = rule[:i], rule[i+1:]
+		}
+		switch name {
+		case "min", "max":
+			limit, _ := strconv.ParseInt(arg, 10, 64)
+			if schema["type"] == "string" {
+				if name == "max" {
+					schema["maxLength"] = limit
+				} else if required {
+					schema["minLength"] = limit
+				}
+			} else if name == "max" {
+				schema["maximum"] = limit
+			} else {
+				schema["minimum"] = limit
+			}
+		case "oneof":
+			options := strings.Fields(arg)
+			if !required {
+				options = append(options, "")
+			}
+			schema["enum"] = options
+		case "charset":
+			pattern := scriptPattern.ReplaceAllString(charsets[arg].String(), `\p{Script=$1}`)
+			if !required {
+				pattern = "^(?:" + strings.TrimSuffix(strings.TrimPrefix(pattern, "^"), "$") + ")?$"
+			}
+			schema["pattern"] = pattern
+		case "date":
+			schema["format"] = "date"
+		}
+	}
+}
+
+// showDocs serves the page rendering the OpenAPI document in the browser
+func (s *Server) showDocs(ctx *macaron.Context) error {
+	ctx.Resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+	ctx.Resp.WriteHeader(http.StatusOK)
+	if _, err := ctx.Resp.Write([]byte(docsPage)); err != nil {
+		s.logger.Println(err)
+	}
+	return nil
+}
+
+func (s *Server) showOpenAPI(ctx *macaron.Context) error {
+	doc, err := s.OpenAPI()
+	if err != nil {
+		return err
+	}
+	ctx.Resp.Header().Set("Content-Type", "application/json")
+	ctx.Resp.WriteHeader(http.StatusOK)
+	if _, err := ctx.Resp.Write(doc); err != nil {
+		s.logger.Println(err)
+	}
+	return nil
+}
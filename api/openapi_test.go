@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPI(t *testing.T) {
+	srvr := newTestServer(t)
+	runTest(t, srvr, testData{"openapi", "GET", "/openapi.json", 200, nil})
+}
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	srvr := newTestServer(t)
+
+	registered := make(map[string]bool)
+	for _, r := range srvr.routes {
+		key := operationKey(r.method, r.path)
+		registered[key] = true
+		if _, ok := apiOperations[key]; !ok {
+			t.Errorf("%s isn't documented in apiOperations", key)
+		}
+		for _, match := range pathParamPattern.FindAllStringSubmatch(r.path, -1) {
+			if _, ok := pathParams[match[1]]; !ok {
+				t.Errorf("%s: the path parameter %s isn't described in pathParams", key, match[1])
+			}
+		}
+	}
+	for key := range apiOperations {
+		if !registered[key] {
+			t.Errorf("%s is documented but isn't a route", key)
+		}
+	}
+}
+
+func TestOpenAPIReferences(t *testing.T) {
+	srvr := newTestServer(t)
+	body, err := srvr.OpenAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		Components struct {
+			Schemas map[string]interface{} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	var refs []string
+	var collect func(v interface{})
+	collect = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for key, value := range v {
+				if ref, ok := value.(string); ok && key == "$ref" {
+					refs = append(refs, ref)
+				}
+				collect(value)
+			}
+		case []interface{}:
+			for _, value := range v {
+				collect(value)
+			}
+		}
+	}
+	var tree interface{}
+	if err := json.Unmarshal(body, &tree); err != nil {
+		t.Fatal(err)
+	}
+	collect(tree)
+
+	if len(refs) == 0 {
+		t.Fatal("the document has no references")
+	}
+	for _, ref := range refs {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		if _, ok := doc.Components.Schemas[name]; !ok || name == ref {
+			t.Errorf("%s doesn't resolve", ref)
+		}
+	}
+}
+
+func TestDocsArePublic(t *testing.T) {
+	srvr := newTestServer(t, WithBypassAuth(false), WithAuthProvider(StaticAuth{"admin": "admin"}))
+
+	for _, data := range []struct {
+		url         string
+		status      int
+		contentType string
+	}{
+		{"/docs", 200, "text/html; charset=utf-8"},
+		{"/openapi.json", 200, "application/json"},
+		{"/appscode/workers", 401, "application/problem+json"},
+	} {
+		rec := httptest.NewRecorder()
+		srvr.Handler().ServeHTTP(rec, httptest.NewRequest("GET", data.url, nil))
+		if rec.Code != data.status {
+			t.Errorf("%s: got status %v expected %v", data.url, rec.Code, data.status)
+		}
+		if got := rec.Header().Get("Content-Type"); got != data.contentType {
+			t.Errorf("%s: got Content-Type %q expected %q", data.url, got, data.contentType)
+		}
+	}
+}
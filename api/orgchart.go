@@ -0,0 +1,292 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"gopkg.in/macaron.v1"
+)
+
+// maxOrgDepth bounds the reports a request can walk down to
+const maxOrgDepth = 32
+
+// orgChart is the reporting graph of the live workers
+type orgChart struct {
+	workers map[string]*Worker
+	reports map[string][]string
+}
+
+func (s *Server) loadOrgChart(store Store) (*orgChart, error) {
+	workers, err := store.ListWorkers(WorkerFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	chart := &orgChart{
+		workers: make(map[string]*Worker, len(workers)),
+		reports: make(map[string][]string),
+	}
+	for i := range workers {
+		chart.workers[workers[i].Username] = &workers[i]
+	}
+	for i := range workers {
+		if manager := workers[i].Manager; manager != "" {
+			chart.reports[manager] = append(chart.reports[manager], workers[i].Username)
+		}
+	}
+	for _, usernames := range chart.reports {
+		sort.Strings(usernames)
+	}
+	return chart, nil
+}
+
+// roots returns the workers without a live manager
+func (c *orgChart) roots() []string {
+	var roots []string
+	for username, worker := range c.workers {
+		if _, ok := c.workers[worker.Manager]; !ok {
+			roots = append(roots, username)
+		}
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// chain returns the worker followed by their managers up to the top,
+// stopping at a cycle should the stored data hold one
+func (c *orgChart) chain(username string) []*Worker {
+	var chain []*Worker
+	seen := make(map[string]bool)
+	for worker, ok := c.workers[username]; ok && !seen[worker.Username]; worker, ok = c.workers[worker.Manager] {
+		seen[worker.Username] = true
+		chain = append(chain, worker)
+	}
+	return chain
+}
+
+// report is a worker reporting to another, Depth is 1 for a direct report
+type report struct {
+	Worker
+	Depth int `json:"depth"`
+}
+
+// reportsOf walks the reports of username breadth first down to depth levels
+func (c *orgChart) reportsOf(username string, depth int) []report {
+	reports := make([]report, 0)
+	seen := map[string]bool{username: true}
+	level := []string{username}
+	for d := 1; d <= depth && len(level) > 0; d++ {
+		var next []string
+		for _, manager := range level {
+			for _, r := range c.reports[manager] {
+				if seen[r] {
+					continue
+				}
+				seen[r] = true
+				reports = append(reports, report{Worker: *c.workers[r], Depth: d})
+				next = append(next, r)
+			}
+		}
+		level = next
+	}
+	return reports
+}
+
+// orgNode is a worker in the JSON org chart
+type orgNode struct {
+	Username string     `json:"username"`
+	Name     string     `json:"name"`
+	Position string     `json:"position"`
+	Reports  []*orgNode `json:"reports,omitempty"`
+}
+
+func (c *orgChart) tree(username string, seen map[string]bool) *orgNode {
+	seen[username] = true
+	worker := c.workers[username]
+	node := &orgNode{
+		Username: username,
+		Name:     worker.FirstName + " " + worker.LastName,
+		Position: worker.Position,
+	}
+	for _, r := range c.reports[username] {
+		if !seen[r] {
+			node.Reports = append(node.Reports, c.tree(r, seen))
+		}
+	}
+	return node
+}
+
+// dot writes the chart below roots as a Graphviz digraph
+func (c *orgChart) dot(roots []string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("digraph orgchart {\n\tnode [shape=box];\n")
+
+	seen := make(map[string]bool)
+	var walk func(username string)
+	walk = func(username string) {
+		seen[username] = true
+		worker := c.workers[username]
+		label := worker.FirstName + " " + worker.LastName + "\n" + worker.Position
+		fmt.Fprintf(&buf, "\t%s [label=%s];\n", strconv.Quote(username), strconv.Quote(label))
+		for _, r := range c.reports[username] {
+			if seen[r] {
+				continue
+			}
+			fmt.Fprintf(&buf, "\t%s -> %s;\n", strconv.Quote(username), strconv.Quote(r))
+			walk(r)
+		}
+	}
+	for _, root := range roots {
+		walk(root)
+	}
+
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+// checkManager verifies that the worker's manager is a live worker
+// and that reporting to them doesn't make a cycle
+func (s *Server) checkManager(worker *Worker) ([]FieldError, error) {
+	if worker.Manager == "" {
+		return nil, nil
+	}
+	if worker.Manager == worker.Username {
+		return []FieldError{{Field: "manager", Message: "can't be the worker themselves"}}, nil
+	}
+
+	// Walking up from the manager one worker at a time keeps imports and
+	// batches from loading the whole chart for every worker
+	seen := make(map[string]bool)
+	for username := worker.Manager; username != "" && !seen[username]; {
+		manager, err := s.store.GetWorker(username)
+		if err == ErrNotFound {
+			if username == worker.Manager {
+				return []FieldError{{Field: "manager", Message: "is not a known worker"}}, nil
+			}
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if manager.Username == worker.Username {
+			return []FieldError{{Field: "manager", Message: worker.Manager + " reports to " + worker.Username + ", which would make a cycle"}}, nil
+		}
+		seen[username] = true
+		username = manager.Manager
+	}
+	return nil, nil
+}
+
+// reassignReports moves the direct reports of the worker to the worker
+// named by reassignTo. If that is one of the reports, they are promoted
+// to the worker's place and the others report to them.
+func (s *Server) reassignReports(tx Store, worker *Worker, reassignTo string) error {
+	reports, err := tx.ListWorkers(WorkerFilter{Manager: worker.Username})
+	if err != nil || len(reports) == 0 {
+		return err
+	}
+	if reassignTo == "" {
+		return conflict("Worker %q still has %d reports, reassign them with the reassign_to parameter", worker.Username, len(reports))
+	}
+	if reassignTo == worker.Username {
+		return validationFailed(FieldError{Field: "reassign_to", Message: "must be another worker"})
+	}
+
+	chart, err := s.loadOrgChart(tx)
+	if err != nil {
+		return err
+	}
+	if _, ok := chart.workers[reassignTo]; !ok {
+		return validationFailed(FieldError{Field: "reassign_to", Message: "is not a known worker"})
+	}
+	for _, r := range chart.reportsOf(worker.Username, maxOrgDepth) {
+		if r.Username == reassignTo && r.Depth > 1 {
+			return validationFailed(FieldError{Field: "reassign_to", Message: "reports to " + worker.Username + " through another worker"})
+		}
+	}
+
+	now := s.clock.Now()
+	for i := range reports {
+		if reports[i].Username == reassignTo {
+			reports[i].Manager = worker.Manager
+		} else {
+			reports[i].Manager = reassignTo
+		}
+		reports[i].UpdatedAt = now
+		if err := s.saveWorker(tx, &reports[i], "manager "+worker.Username+" left"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Org chart handlers
+
+func (s *Server) showReports(ctx *macaron.Context) error {
+	depth := 1
+	if d := ctx.Query("depth"); d != "" {
+		var err error
+		if depth, err = strconv.Atoi(d); err != nil || depth < 1 || depth > maxOrgDepth {
+			return validationFailed(FieldError{Field: "depth", Message: fmt.Sprintf("must be a number from 1 to %d", maxOrgDepth)})
+		}
+	}
+
+	chart, err := s.loadOrgChart(s.store)
+	if err != nil {
+		return err
+	}
+	username := ctx.Params("username")
+	if _, ok := chart.workers[username]; !ok {
+		return notFound("Worker %q does not exist", username)
+	}
+	return s.render(ctx, http.StatusOK, chart.reportsOf(username, depth))
+}
+
+func (s *Server) showChain(ctx *macaron.Context) error {
+	chart, err := s.loadOrgChart(s.store)
+	if err != nil {
+		return err
+	}
+	chain := chart.chain(ctx.Params("username"))
+	if len(chain) == 0 {
+		return notFound("Worker %q does not exist", ctx.Params("username"))
+	}
+	return s.render(ctx, http.StatusOK, chain)
+}
+
+// showOrgChart renders the whole chart, or the part below the root
+// query parameter, as a JSON tree or as Graphviz DOT with format=dot
+func (s *Server) showOrgChart(ctx *macaron.Context) error {
+	chart, err := s.loadOrgChart(s.store)
+	if err != nil {
+		return err
+	}
+
+	roots := chart.roots()
+	if root := ctx.Query("root"); root != "" {
+		if _, ok := chart.workers[root]; !ok {
+			return notFound("Worker %q does not exist", root)
+		}
+		roots = []string{root}
+	}
+
+	switch ctx.Query("format") {
+	case "", "json":
+		tree := make([]*orgNode, 0, len(roots))
+		seen := make(map[string]bool)
+		for _, root := range roots {
+			tree = append(tree, chart.tree(root, seen))
+		}
+		return s.render(ctx, http.StatusOK, tree)
+	case "dot":
+		ctx.Resp.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		ctx.Resp.WriteHeader(http.StatusOK)
+		if _, err := ctx.Resp.Write(chart.dot(roots)); err != nil {
+			s.logger.Println(err)
+		}
+		return nil
+	}
+	return validationFailed(FieldError{Field: "format", Message: "must be json or dot"})
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOrgChart(t *testing.T) {
+	srvr := newTestServer(t)
+	// masud leads fahim and tahsin, jenny reports to fahim
+	for username, manager := range map[string]string{"fahim": "masud", "tahsin": "masud", "jenny": "fahim"} {
+		worker, err := srvr.store.GetWorker(username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		worker.Manager = manager
+		if err := srvr.store.UpdateWorker(worker); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	test := []testData{
+		{
+			"update_worker_manager_cycle",
+			"PUT",
+			"/appscode/workers/masud",
+			422,
+			strings.NewReader(`{"firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500,"manager":"jenny"}`),
+		},
+		{
+			"update_worker_own_manager",
+			"PUT",
+			"/appscode/workers/masud",
+			422,
+			strings.NewReader(`{"firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500,"manager":"masud"}`),
+		},
+		{
+			"update_worker_unknown_manager",
+			"PUT",
+			"/appscode/workers/tahsin",
+			422,
+			strings.NewReader(`{"firstname":"Tahsin","lastname":"Rahman","city":"Chattogram","division":"Chattogram","position":"Software Engineer","salary":5500,"manager":"nobody"}`),
+		},
+		{
+			"show_direct_reports",
+			"GET",
+			"/appscode/workers/masud/reports",
+			200,
+			nil,
+		},
+		{
+			"show_transitive_reports",
+			"GET",
+			"/appscode/workers/masud/reports?depth=2",
+			200,
+			nil,
+		},
+		{
+			"show_reports_bad_depth",
+			"GET",
+			"/appscode/workers/masud/reports?depth=0",
+			422,
+			nil,
+		},
+		{
+			"show_chain",
+			"GET",
+			"/appscode/workers/jenny/chain",
+			200,
+			nil,
+		},
+		{
+			"show_chain_not_found",
+			"GET",
+			"/appscode/workers/nobody/chain",
+			404,
+			nil,
+		},
+		{
+			"show_orgchart",
+			"GET",
+			"/appscode/orgchart",
+			200,
+			nil,
+		},
+		{
+			"show_orgchart_dot",
+			"GET",
+			"/appscode/orgchart?format=dot",
+			200,
+			nil,
+		},
+		{
+			"show_orgchart_below_root",
+			"GET",
+			"/appscode/orgchart?root=fahim",
+			200,
+			nil,
+		},
+		{
+			"delete_manager_with_reports",
+			"DELETE",
+			"/appscode/workers/masud",
+			409,
+			nil,
+		},
+		{
+			"delete_manager_reassign_to_indirect_report",
+			"DELETE",
+			"/appscode/workers/masud?reassign_to=jenny",
+			422,
+			nil,
+		},
+		{
+			"delete_manager_promote_report",
+			"DELETE",
+			"/appscode/workers/masud?reassign_to=fahim",
+			200,
+			nil,
+		},
+		{
+			"show_orgchart_after_promotion",
+			"GET",
+			"/appscode/orgchart",
+			200,
+			nil,
+		},
+	}
+	for _, data := range test {
+		runTest(t, srvr, data)
+	}
+}
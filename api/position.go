@@ -0,0 +1,418 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-xorm/xorm"
+	"gopkg.in/macaron.v1"
+)
+
+// Position is an entry of the position catalog, the salary of a worker
+// holding it must be within its band unless HR overrides it
+type Position struct {
+	ID    string `json:"id" xorm:"pk 'id'" validate:"required_on=create,min=2,max=64,charset=slug"`
+	Title string `json:"title" xorm:"not null unique" validate:"required,max=64,charset=title"`
+	Level int    `json:"level" validate:"min=0,max=20"`
+	// MinSalary and MaxSalary are in the minor unit of Currency
+	MinSalary int64  `json:"min_salary" validate:"min=0,max=1000000000000"`
+	MaxSalary int64  `json:"max_salary" validate:"min=0,max=1000000000000"`
+	Currency  string `json:"currency" validate:"required,charset=currency"`
+	// MinorUnits is set on every position the store writes, the bands of
+	// the ones stored before salaries had a currency are in whole units
+	// until MigrateSalaries converts them
+	MinorUnits bool `json:"-" xorm:"'minor_units'"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int       `json:"version" xorm:"version"`
+}
+
+func (p *Position) inBand(salary int64) bool {
+	return salary >= p.MinSalary && salary <= p.MaxSalary
+}
+
+// DefaultPositions returns the catalog the server is seeded with
+func DefaultPositions() []Position {
+	return []Position{
+		{
+			ID:        "software-engineer",
+			Title:     "Software Engineer",
+			Level:     1,
+			MinSalary: 3000,
+			MaxSalary: 10000,
+			Currency:  "BDT",
+		},
+	}
+}
+
+// PositionStore persists the position catalog
+type PositionStore interface {
+	ListPositions() ([]Position, error)
+	// GetPosition returns ErrNotFound if no position has the id
+	GetPosition(id string) (*Position, error)
+	// CreatePosition returns ErrAlreadyExists if the id or title is taken
+	CreatePosition(position *Position) error
+	UpdatePosition(position *Position) error
+	DeletePosition(id string) error
+}
+
+func (s *XormStore) ListPositions() ([]Position, error) {
+	positions := make([]Position, 0)
+	if err := s.db.Asc("title").Find(&positions); err != nil {
+		return nil, err
+	}
+	return positions, nil
+}
+
+func (s *XormStore) GetPosition(id string) (*Position, error) {
+	position := &Position{ID: id}
+	exist, err := s.db.Get(position)
+	if err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, ErrNotFound
+	}
+	return position, nil
+}
+
+func (s *XormStore) CreatePosition(position *Position) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		exist, err := session.Where("id = ? OR title = ?", position.ID, position.Title).Exist(new(Position))
+		if err != nil {
+			return err
+		} else if exist {
+			return ErrAlreadyExists
+		}
+		position.MinorUnits = true
+		_, err = session.Insert(position)
+		return err
+	})
+}
+
+func (s *XormStore) UpdatePosition(position *Position) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		position.MinorUnits = true
+		affected, err := session.ID(position.ID).AllCols().Update(position)
+		if err != nil {
+			return err
+		} else if affected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (s *XormStore) DeletePosition(id string) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		affected, err := session.ID(id).Delete(new(Position))
+		if err != nil {
+			return err
+		} else if affected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// SeedPositions adds the given positions to the catalog, skipping the ones that already exist
+func (s *Server) SeedPositions(positions []Position) error {
+	for _, position := range positions {
+		position.CreatedAt = s.clock.Now()
+		position.UpdatedAt = position.CreatedAt
+		if err := s.store.CreatePosition(&position); err != nil && err != ErrAlreadyExists {
+			return err
+		}
+	}
+	return nil
+}
+
+// findPosition looks a position up by its title, ignoring case, or by its id
+func findPosition(positions []Position, title string) (*Position, bool) {
+	title = strings.TrimSpace(title)
+	for i := range positions {
+		if strings.EqualFold(positions[i].Title, title) || positions[i].ID == title {
+			return &positions[i], true
+		}
+	}
+	return nil, false
+}
+
+// checkPosition verifies that the worker's position is in the catalog and
+// that their salary, converted at today's rate, is within its band or
+// overridden. The position is replaced with its title in the catalog.
+func (s *Server) checkPosition(worker *Worker) ([]FieldError, error) {
+	positions, err := s.store.ListPositions()
+	if err != nil {
+		return nil, err
+	}
+	position, ok := findPosition(positions, worker.Position)
+	if !ok {
+		return []FieldError{{Field: "position", Message: "is not a position of the catalog"}}, nil
+	}
+	worker.Position = position.Title
+	if worker.Currency == "" {
+		worker.Currency = position.Currency
+	}
+
+	salary, err := newConverter(s.store, position.Currency, s.today()).convert(worker.Salary, worker.Currency)
+	if e, ok := err.(*noRateError); ok {
+		return []FieldError{{Field: "currency", Message: e.Error()}}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if position.inBand(salary) {
+		// Nothing to override
+		worker.SalaryOverride, worker.SalaryOverrideBy = "", ""
+		return nil, nil
+	}
+	if worker.SalaryOverride == "" {
+		return []FieldError{{
+			Field: "salary",
+			Message: fmt.Sprintf("must be from %s to %s for a %s, unless HR overrides it with a salary_override reason",
+				formatAmount(position.MinSalary, position.Currency), formatAmount(position.MaxSalary, position.Currency), position.Title),
+		}}, nil
+	}
+	return nil, nil
+}
+
+// applySalaryOverride lets only HR override a salary band, and records who
+// did. An update that leaves the salary, currency and position as they are
+// keeps the override of the old profile, old is nil for a new worker.
+func (s *Server) applySalaryOverride(user string, old, worker *Worker) error {
+	worker.SalaryOverrideBy = ""
+	if worker.SalaryOverride == "" {
+		if old != nil && old.Salary == worker.Salary && (worker.Currency == "" || worker.Currency == old.Currency) &&
+			strings.EqualFold(old.Position, strings.TrimSpace(worker.Position)) {
+			worker.SalaryOverride, worker.SalaryOverrideBy = old.SalaryOverride, old.SalaryOverrideBy
+		}
+		return nil
+	}
+
+	if !s.userHasRole(user, RoleHR) {
+		return forbidden("Only HR can override the salary band of a position")
+	}
+	worker.SalaryOverrideBy = user
+	return nil
+}
+
+// Position handlers
+
+func (s *Server) showAllPositions(ctx *macaron.Context) error {
+	positions, err := s.store.ListPositions()
+	if err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusOK, positions)
+}
+
+func (s *Server) showPosition(ctx *macaron.Context) error {
+	position, err := s.getPosition(s.store, ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusOK, position)
+}
+
+func (s *Server) addPosition(ctx *macaron.Context) error {
+	var position Position
+	if err := s.decode(ctx, &position); err != nil {
+		return err
+	}
+	if errs := validatePosition(&position, opCreate); len(errs) > 0 {
+		return validationFailed(errs...)
+	}
+	positions, err := s.store.ListPositions()
+	if err != nil {
+		return err
+	}
+	if other, ok := findPosition(positions, position.Title); ok {
+		return conflict("Position %q already exists", other.Title)
+	}
+
+	position.CreatedAt = s.clock.Now()
+	position.UpdatedAt = position.CreatedAt
+	position.Version = 0
+
+	if err := s.store.CreatePosition(&position); err == ErrAlreadyExists {
+		return conflict("Position %q or %q already exists", position.ID, position.Title)
+	} else if err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusCreated, position)
+}
+
+// updatePosition renames the position of its workers along with it
+func (s *Server) updatePosition(ctx *macaron.Context) error {
+	newPosition := new(Position)
+	if err := s.decode(ctx, newPosition); err != nil {
+		return err
+	}
+	errs := validatePosition(newPosition, opUpdate)
+	if newPosition.ID != "" && newPosition.ID != ctx.Params("id") {
+		errs = append(errs, FieldError{Field: "id", Message: "can't be changed"})
+	}
+	if len(errs) > 0 {
+		return validationFailed(errs...)
+	}
+
+	var position *Position
+	err := s.store.InTransaction(func(tx Store) error {
+		var err error
+		if position, err = s.getPosition(tx, ctx.Params("id")); err != nil {
+			return err
+		}
+
+		if newPosition.Title != position.Title {
+			positions, err := tx.ListPositions()
+			if err != nil {
+				return err
+			}
+			if other, ok := findPosition(positions, newPosition.Title); ok && other.ID != position.ID {
+				return conflict("Position %q already exists", other.Title)
+			}
+
+			workers, err := tx.ListWorkers(WorkerFilter{Position: position.Title})
+			if err != nil {
+				return err
+			}
+			for i := range workers {
+				workers[i].Position = newPosition.Title
+				workers[i].UpdatedAt = s.clock.Now()
+				if err := tx.UpdateWorker(&workers[i]); err != nil {
+					return err
+				}
+			}
+			if err := s.recordEvents(tx, WorkerUpdated, workers...); err != nil {
+				return err
+			}
+		}
+
+		position.Title = newPosition.Title
+		position.Level = newPosition.Level
+		position.MinSalary = newPosition.MinSalary
+		position.MaxSalary = newPosition.MaxSalary
+		position.Currency = newPosition.Currency
+		position.UpdatedAt = s.clock.Now()
+		return tx.UpdatePosition(position)
+	})
+	if err != nil {
+		return err
+	}
+	s.notifyChange()
+	return s.render(ctx, http.StatusOK, position)
+}
+
+// deletePosition refuses to delete a position some workers still hold
+func (s *Server) deletePosition(ctx *macaron.Context) error {
+	if err := checkAcceptable(ctx, StatusMessage{}); err != nil {
+		return err
+	}
+	err := s.store.InTransaction(func(tx Store) error {
+		position, err := s.getPosition(tx, ctx.Params("id"))
+		if err != nil {
+			return err
+		}
+		workers, err := tx.ListWorkers(WorkerFilter{Position: position.Title})
+		if err != nil {
+			return err
+		}
+		if len(workers) > 0 {
+			return conflict("Position %q is still held by %d workers", position.Title, len(workers))
+		}
+		return tx.DeletePosition(position.ID)
+	})
+	if err != nil {
+		return err
+	}
+	return s.renderDeleted(ctx)
+}
+
+// outOfBand is a worker whose salary doesn't fit their position
+type outOfBand struct {
+	Username       string `json:"username"`
+	Position       string `json:"position"`
+	Salary         int64  `json:"salary"`
+	SalaryCurrency string `json:"salary_currency"`
+	Problem        string `json:"problem"`
+	MinSalary      int64  `json:"min_salary,omitempty"`
+	MaxSalary      int64  `json:"max_salary,omitempty"`
+	Currency       string `json:"currency,omitempty"`
+	SalaryOverride string `json:"salary_override,omitempty"`
+}
+
+// showOutOfBand lists the workers paid outside of their position's band,
+// overridden or not, and the ones whose position isn't in the catalog.
+// Salaries in another currency are compared at today's rate.
+func (s *Server) showOutOfBand(ctx *macaron.Context) error {
+	positions, err := s.store.ListPositions()
+	if err != nil {
+		return err
+	}
+	workers, err := s.store.ListWorkers(WorkerFilter{})
+	if err != nil {
+		return err
+	}
+
+	converters := make(map[string]*converter)
+	report := make([]outOfBand, 0)
+	for _, worker := range workers {
+		entry := outOfBand{
+			Username:       worker.Username,
+			Position:       worker.Position,
+			Salary:         worker.Salary,
+			SalaryCurrency: worker.Currency,
+			SalaryOverride: worker.SalaryOverride,
+		}
+		position, ok := findPosition(positions, worker.Position)
+		var salary int64
+		var convErr error
+		if ok {
+			conv, found := converters[position.Currency]
+			if !found {
+				conv = newConverter(s.store, position.Currency, s.today())
+				converters[position.Currency] = conv
+			}
+			salary, convErr = conv.convert(worker.Salary, worker.Currency)
+			if _, noRate := convErr.(*noRateError); convErr != nil && !noRate {
+				return convErr
+			}
+		}
+		switch {
+		case !ok:
+			entry.Problem = "unknown position"
+		case convErr != nil:
+			entry.Problem = "no exchange rate"
+		case salary < position.MinSalary:
+			entry.Problem = "below band"
+		case salary > position.MaxSalary:
+			entry.Problem = "above band"
+		default:
+			continue
+		}
+		if ok {
+			entry.MinSalary, entry.MaxSalary, entry.Currency = position.MinSalary, position.MaxSalary, position.Currency
+		}
+		report = append(report, entry)
+	}
+	return s.render(ctx, http.StatusOK, report)
+}
+
+// getPosition is GetPosition with the 404 worded for the client
+func (s *Server) getPosition(store Store, id string) (*Position, error) {
+	position, err := store.GetPosition(id)
+	if err == ErrNotFound {
+		return nil, notFound("Position %q does not exist", id)
+	}
+	return position, err
+}
+
+func validatePosition(position *Position, op string) []FieldError {
+	errs := validate(position, op)
+	if position.MaxSalary < position.MinSalary && !hasFieldError(errs, "min_salary", "max_salary") {
+		errs = append(errs, FieldError{Field: "max_salary", Message: "must be at least min_salary"})
+	}
+	return errs
+}
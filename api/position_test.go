@@ -0,0 +1,131 @@
+package api
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPositions(t *testing.T) {
+	srvr := newTestServer(t)
+	test := []testData{
+		{
+			"add_position",
+			"POST",
+			"/appscode/positions",
+			201,
+			strings.NewReader(`{"id":"senior-software-engineer","title":"Senior Software Engineer","level":2,"min_salary":8000,"max_salary":20000,"currency":"BDT"}`),
+		},
+		{
+			"add_position_conflict",
+			"POST",
+			"/appscode/positions",
+			409,
+			strings.NewReader(`{"id":"swe","title":"software engineer","level":1,"min_salary":3000,"max_salary":10000,"currency":"BDT"}`),
+		},
+		{
+			"add_position_invalid_band",
+			"POST",
+			"/appscode/positions",
+			422,
+			strings.NewReader(`{"id":"intern","title":"Intern","min_salary":2000,"max_salary":1000,"currency":"taka"}`),
+		},
+		{
+			"show_all_positions",
+			"GET",
+			"/appscode/positions",
+			200,
+			nil,
+		},
+		{
+			"add_worker_unknown_position",
+			"POST",
+			"/appscode/workers",
+			422,
+			strings.NewReader(`{"username":"masudur","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Astronaut","salary":5500}`),
+		},
+		{
+			"add_worker_out_of_band",
+			"POST",
+			"/appscode/workers",
+			422,
+			strings.NewReader(`{"username":"masudur","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":50000}`),
+		},
+		{
+			"add_worker_band_overridden",
+			"POST",
+			"/appscode/workers",
+			201,
+			strings.NewReader(`{"username":"masudur","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"software engineer","salary":50000,"salary_override":"Retention offer"}`),
+		},
+		{
+			"update_worker_keeps_override",
+			"PUT",
+			"/appscode/workers/masudur",
+			201,
+			strings.NewReader(`{"firstname":"Masud","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":50000}`),
+		},
+		{
+			"show_out_of_band",
+			"GET",
+			"/appscode/reports/out-of-band",
+			200,
+			nil,
+		},
+		{
+			"update_position_title",
+			"PUT",
+			"/appscode/positions/software-engineer",
+			200,
+			strings.NewReader(`{"title":"Software Engineer I","level":1,"min_salary":3000,"max_salary":10000,"currency":"BDT"}`),
+		},
+		{
+			"show_worker_of_renamed_position",
+			"GET",
+			"/appscode/workers/masud",
+			200,
+			nil,
+		},
+		{
+			"delete_held_position",
+			"DELETE",
+			"/appscode/positions/software-engineer",
+			409,
+			nil,
+		},
+		{
+			"delete_position",
+			"DELETE",
+			"/appscode/positions/senior-software-engineer",
+			200,
+			nil,
+		},
+	}
+	for _, data := range test {
+		runTest(t, srvr, data)
+	}
+}
+
+func TestSalaryOverrideNeedsHR(t *testing.T) {
+	srvr := newTestServer(t, WithBypassAuth(false), WithAuthProvider(StaticAuth{"masud": "pass", "admin": "admin"}))
+
+	for _, data := range []struct {
+		name   string
+		user   string
+		pass   string
+		status int
+	}{
+		{"override_band_without_hr", "masud", "pass", 403},
+		{"override_band_as_hr", "admin", "admin", 201},
+	} {
+		req := httptest.NewRequest("POST", "/appscode/workers", strings.NewReader(`{"username":"masudur","firstname":"Masudur","lastname":"Rahman","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":50000,"salary_override":"Retention offer"}`))
+		req.Header.Set("X-Request-ID", data.name)
+		req.SetBasicAuth(data.user, data.pass)
+		rec := httptest.NewRecorder()
+		srvr.Handler().ServeHTTP(rec, req)
+		if rec.Code != data.status {
+			t.Errorf("%s: got status %v expected %v", data.name, rec.Code, data.status)
+		}
+		checkGolden(t, data.name, dumpResponse(rec))
+	}
+}
@@ -0,0 +1,426 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-xorm/xorm"
+	"gopkg.in/macaron.v1"
+)
+
+// rateLimitAttempts is how many times a shared bucket is taken from
+// before giving up, when other servers keep changing it meanwhile
+const rateLimitAttempts = 5
+
+// rateLimitSweep is how often the full buckets are dropped from memory
+const rateLimitSweep = time.Minute
+
+// RateLimit lets a client make Requests requests every Per. It is a token
+// bucket holding up to Requests tokens, refilled steadily over Per, so a
+// client can spend them all at once and then one every Per/Requests.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseRateLimit reads a limit written as requests/period, where the
+// period is s, m, h or a duration, e.g. 10/s, 600/m or 100/30s
+func ParseRateLimit(spec string) (RateLimit, error) {
+	i := strings.Index(spec, "/")
+	if i < 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q is not requests/period, e.g. 600/m", spec)
+	}
+	requests, err := strconv.Atoi(strings.TrimSpace(spec[:i]))
+	period := strings.TrimSpace(spec[i+1:])
+	if period == "s" || period == "m" || period == "h" {
+		period = "1" + period
+	}
+	per, perr := time.ParseDuration(period)
+	if err != nil || perr != nil || requests < 1 || per <= 0 {
+		return RateLimit{}, fmt.Errorf("rate limit %q is not requests/period, e.g. 600/m", spec)
+	}
+	return RateLimit{Requests: requests, Per: per}, nil
+}
+
+// routeParamPattern matches the {name} parameters of the OpenAPI paths
+var routeParamPattern = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// ParseRouteRateLimit reads the limit of a route written as
+// "METHOD /path=requests/period", with the path as the API documents it,
+// e.g. "POST /appscode/workers/import=10/h"
+func ParseRouteRateLimit(spec string) (string, RateLimit, error) {
+	i := strings.LastIndex(spec, "=")
+	if i < 0 {
+		return "", RateLimit{}, fmt.Errorf("route rate limit %q is not METHOD /path=requests/period", spec)
+	}
+	fields := strings.Fields(spec[:i])
+	if len(fields) != 2 {
+		return "", RateLimit{}, fmt.Errorf("route rate limit %q is not METHOD /path=requests/period", spec)
+	}
+	route := operationKey(strings.ToUpper(fields[0]), routeParamPattern.ReplaceAllString(strings.TrimSuffix(fields[1], "/"), ":$1"))
+	if _, ok := apiOperations[route]; !ok {
+		return "", RateLimit{}, fmt.Errorf("route %q is not a route of the API", spec[:i])
+	}
+	limit, err := ParseRateLimit(spec[i+1:])
+	return route, limit, err
+}
+
+// ParseTrustedProxies reads the addresses and CIDR ranges of the proxies
+// whose X-Forwarded-For header is trusted
+func ParseTrustedProxies(list []string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if ip := net.ParseIP(s); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, proxy, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is neither an address nor a CIDR range", s)
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies, nil
+}
+
+// rateLimitResult tells if a request was let through, and when the client
+// can make the next ones
+type rateLimitResult struct {
+	allowed    bool
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+// tokenBucket holds the tokens a client had left at a time
+type tokenBucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// take refills the bucket for the time since it was last taken from, and
+// takes a token out of it if it holds one
+func (b *tokenBucket) take(limit RateLimit, now time.Time) rateLimitResult {
+	capacity := float64(limit.Requests)
+	if now.After(b.at) {
+		b.tokens = math.Min(capacity, b.tokens+float64(now.Sub(b.at))*capacity/float64(limit.Per))
+		b.at = now
+	}
+
+	var result rateLimitResult
+	if b.tokens >= 1 {
+		b.tokens--
+		result.allowed = true
+	} else {
+		result.retryAfter = time.Duration((1 - b.tokens) * float64(limit.Per) / capacity)
+	}
+	result.remaining = int(b.tokens)
+	result.reset = time.Duration((capacity - b.tokens) * float64(limit.Per) / capacity)
+	return result
+}
+
+// rateLimiter keeps the buckets of the clients by their keys
+type rateLimiter interface {
+	take(key string, limit RateLimit, now time.Time) (rateLimitResult, error)
+}
+
+// memoryRateLimiter keeps the buckets in memory, they limit the requests
+// to this server only
+type memoryRateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+	swept   time.Time
+}
+
+// memoryBucket is a bucket and the time it is full again
+type memoryBucket struct {
+	tokenBucket
+	full time.Time
+}
+
+func newMemoryRateLimiter() *memoryRateLimiter {
+	return &memoryRateLimiter{buckets: make(map[string]*memoryBucket)}
+}
+
+func (m *memoryRateLimiter) take(key string, limit RateLimit, now time.Time) (rateLimitResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// A bucket full again is the same as none
+	if now.Sub(m.swept) > rateLimitSweep {
+		for k, b := range m.buckets {
+			if !b.full.After(now) {
+				delete(m.buckets, k)
+			}
+		}
+		m.swept = now
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokenBucket: tokenBucket{tokens: float64(limit.Requests), at: now}}
+		m.buckets[key] = b
+	}
+	result := b.take(limit, now)
+	b.full = now.Add(result.reset)
+	return result, nil
+}
+
+// RateLimitBucket is the token bucket of a client kept in the store, so
+// the servers sharing it hold the client to one limit
+type RateLimitBucket struct {
+	Key    string `xorm:"pk 'bucket_key' varchar(255)"`
+	Tokens float64
+	// TakenAt is in Unix nanoseconds, as some databases keep the times in
+	// whole seconds only
+	TakenAt int64 `xorm:"not null"`
+	// ExpiresAt is when the bucket is full again, it can be dropped then
+	ExpiresAt time.Time `xorm:"not null index"`
+	Version   int       `xorm:"version"`
+}
+
+// RateLimitStore persists the token buckets of the clients
+type RateLimitStore interface {
+	// GetRateLimitBucket returns ErrNotFound if the client has no bucket
+	GetRateLimitBucket(key string) (*RateLimitBucket, error)
+	// CreateRateLimitBucket returns ErrAlreadyExists if the client has a
+	// bucket already
+	CreateRateLimitBucket(bucket *RateLimitBucket) error
+	// UpdateRateLimitBucket returns ErrNotFound if the bucket was changed
+	// since it was read
+	UpdateRateLimitBucket(bucket *RateLimitBucket) error
+	// DeleteRateLimitBuckets deletes the buckets full again before t
+	DeleteRateLimitBuckets(before time.Time) (int64, error)
+}
+
+func (s *XormStore) GetRateLimitBucket(key string) (*RateLimitBucket, error) {
+	bucket := &RateLimitBucket{Key: key}
+	exist, err := s.db.Get(bucket)
+	if err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, ErrNotFound
+	}
+	return bucket, nil
+}
+
+// CreateRateLimitBucket inserts the bucket right away, a server losing
+// the race for it reads the winner's bucket again
+func (s *XormStore) CreateRateLimitBucket(bucket *RateLimitBucket) error {
+	if _, err := s.db.Insert(bucket); uniqueViolation(err) {
+		return ErrAlreadyExists
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *XormStore) UpdateRateLimitBucket(bucket *RateLimitBucket) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		affected, err := session.ID(bucket.Key).AllCols().Update(bucket)
+		if err != nil {
+			return err
+		} else if affected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (s *XormStore) DeleteRateLimitBuckets(before time.Time) (int64, error) {
+	return s.db.Where("expires_at < ?", before).Delete(new(RateLimitBucket))
+}
+
+// storeRateLimiter keeps the buckets in the store. A bucket changed by
+// another server between reading and writing it is read again.
+type storeRateLimiter struct {
+	store RateLimitStore
+}
+
+func (l *storeRateLimiter) take(key string, limit RateLimit, now time.Time) (rateLimitResult, error) {
+	var err error
+	for i := 0; i < rateLimitAttempts; i++ {
+		bucket, gerr := l.store.GetRateLimitBucket(key)
+		if gerr == ErrNotFound {
+			bucket = &RateLimitBucket{Key: key, Tokens: float64(limit.Requests), TakenAt: now.UnixNano()}
+		} else if gerr != nil {
+			return rateLimitResult{}, gerr
+		}
+
+		b := tokenBucket{tokens: bucket.Tokens, at: time.Unix(0, bucket.TakenAt)}
+		result := b.take(limit, now)
+		bucket.Tokens, bucket.TakenAt = b.tokens, b.at.UnixNano()
+		bucket.ExpiresAt = now.Add(result.reset)
+
+		if gerr == ErrNotFound {
+			err = l.store.CreateRateLimitBucket(bucket)
+		} else {
+			err = l.store.UpdateRateLimitBucket(bucket)
+		}
+		if err == nil {
+			return result, nil
+		} else if err != ErrNotFound && err != ErrAlreadyExists {
+			return rateLimitResult{}, err
+		}
+	}
+	return rateLimitResult{}, fmt.Errorf("bucket %q kept changing: %v", key, err)
+}
+
+// PruneRateLimitBuckets deletes the buckets of the clients that are full
+// again from the store. Run prunes them along with the events.
+func (s *Server) PruneRateLimitBuckets() (int64, error) {
+	return s.store.DeleteRateLimitBuckets(s.clock.Now())
+}
+
+// routeRateLimit returns the limit of the request and the route it is
+// counted under: the route given a limit of its own, the most specific one
+// if several match, or "*" for the limit of every other route
+func (s *Server) routeRateLimit(method, path string) (string, RateLimit, bool) {
+	path = strings.TrimSuffix(path, "/")
+	if path == "" {
+		path = "/"
+	}
+	best, bestStatic := "", -1
+	for route := range s.routeRateLimits {
+		fields := strings.SplitN(route, " ", 2)
+		if len(fields) != 2 || fields[0] != method {
+			continue
+		}
+		if static, ok := matchRoute(fields[1], path); ok && (static > bestStatic || static == bestStatic && route < best) {
+			best, bestStatic = route, static
+		}
+	}
+	if best != "" {
+		return best, s.routeRateLimits[best], true
+	}
+	return "*", s.rateLimit, s.rateLimit.Requests > 0
+}
+
+// matchRoute tells if path is one of the route's, and how many of the
+// route's segments are not parameters
+func matchRoute(route, path string) (int, bool) {
+	routeParts, pathParts := strings.Split(route, "/"), strings.Split(path, "/")
+	if len(routeParts) != len(pathParts) {
+		return 0, false
+	}
+	static := 0
+	for i, part := range routeParts {
+		switch {
+		case strings.HasPrefix(part, ":"):
+			if pathParts[i] == "" {
+				return 0, false
+			}
+		case part == pathParts[i]:
+			static++
+		default:
+			return 0, false
+		}
+	}
+	return static, true
+}
+
+// rateLimitClient tells the clients apart: by their API key, by their
+// user, or by their address when they sent no credentials or wrong ones
+func (s *Server) rateLimitClient(ctx *macaron.Context) string {
+	keyID, _ := ctx.Data["APIKey"].(string)
+	return rateLimitKey(keyID, user(ctx), clientIP(ctx.Req.Request, s.trustedProxies))
+}
+
+// rateLimitKey is the client of a request or a gRPC call made with the API
+// key keyID, as the user, from the address addr
+func rateLimitKey(keyID, user, addr string) string {
+	if keyID != "" {
+		return "key:" + keyID
+	}
+	if user != "" {
+		return "user:" + user
+	}
+	return "ip:" + addr
+}
+
+// clientIP is the address of the client of r. Behind trusted proxies it
+// is the last address of X-Forwarded-For that isn't a trusted proxy, the
+// ones before it could have been made up by the client.
+func clientIP(r *http.Request, trusted []*net.IPNet) string {
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+	if !isTrustedProxy(net.ParseIP(addr), trusted) {
+		return addr
+	}
+
+	var hops []string
+	for _, header := range r.Header["X-Forwarded-For"] {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		addr = ip.String()
+		if !isTrustedProxy(ip, trusted) {
+			break
+		}
+	}
+	return addr
+}
+
+func isTrustedProxy(ip net.IP, trusted []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range trusted {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitRate turns away the requests of a client that spent its limit with
+// 429 and a Retry-After header. Every limited response tells the client
+// its limit, the requests it has left, and the seconds until it has them
+// all again in the RateLimit-* headers.
+func (s *Server) limitRate(ctx *macaron.Context) {
+	route, limit, ok := s.routeRateLimit(ctx.Req.Method, ctx.Req.URL.Path)
+	if !ok {
+		return
+	}
+	result, err := s.rateLimiter.take(route+" "+s.rateLimitClient(ctx), limit, s.clock.Now())
+	if err != nil {
+		// Failing to count a request lets it through, rather than turning
+		// every client away while the store is down
+		s.logger.Println("rate limiting:", err)
+		return
+	}
+
+	header := ctx.Resp.Header()
+	header.Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.reset)))
+	header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, ceilSeconds(limit.Per)))
+	if !result.allowed {
+		retryAfter := ceilSeconds(result.retryAfter)
+		header.Set("Retry-After", strconv.Itoa(retryAfter))
+		s.renderError(ctx, tooManyRequests("Too many requests, retry in %d seconds", retryAfter))
+	}
+}
+
+// ceilSeconds rounds d up to whole seconds
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	clock := &movingClock{now: testTime.Now()}
+	srvr := newTestServer(t, WithClock(clock), WithBypassAuth(false), WithAuthProvider(StaticAuth{"masud": "pass", "admin": "admin"}),
+		WithAPIKeys(map[string]string{"k3y": "masud"}),
+		WithRateLimit(RateLimit{Requests: 3, Per: time.Minute}),
+		WithRouteRateLimit("POST /appscode/workers", RateLimit{Requests: 1, Per: time.Hour}))
+	masud := http.Header{"Authorization": {"Basic bWFzdWQ6cGFzcw=="}}
+	admin := http.Header{"Authorization": {"Basic YWRtaW46YWRtaW4="}}
+
+	rec := serveTestWithHeader(t, srvr, testData{"rate_limit_first", "GET", "/appscode/workers/jenny", 200, nil}, masud)
+	checkGolden(t, "rate_limit_first", dumpResponse(rec))
+	serveTestWithHeader(t, srvr, testData{"", "GET", "/appscode/workers/jenny", 200, nil}, masud)
+	serveTestWithHeader(t, srvr, testData{"", "GET", "/appscode/workers", 200, nil}, masud)
+	rec = serveTestWithHeader(t, srvr, testData{"rate_limited", "GET", "/appscode/workers/jenny", 429, nil}, masud)
+	checkGolden(t, "rate_limited", dumpResponse(rec))
+
+	// Every user has a bucket of their own, refilled a token every 20s
+	serveTestWithHeader(t, srvr, testData{"", "GET", "/appscode/workers/jenny", 200, nil}, admin)
+	clock.now = clock.now.Add(19 * time.Second)
+	serveTestWithHeader(t, srvr, testData{"", "GET", "/appscode/workers/jenny", 429, nil}, masud)
+	clock.now = clock.now.Add(time.Second)
+	serveTestWithHeader(t, srvr, testData{"", "GET", "/appscode/workers/jenny", 200, nil}, masud)
+
+	// The route with a limit of its own is counted apart
+	rahim := `{"username":"rahim","firstname":"Rahim","lastname":"Uddin","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500}`
+	serveTestWithHeader(t, srvr, testData{"", "POST", "/appscode/workers", 201, strings.NewReader(rahim)}, admin)
+	rec = serveTestWithHeader(t, srvr, testData{"rate_limited_route", "POST", "/appscode/workers", 429, strings.NewReader(rahim)}, admin)
+	checkGolden(t, "rate_limited_route", dumpResponse(rec))
+	serveTestWithHeader(t, srvr, testData{"", "GET", "/appscode/workers/rahim", 200, nil}, admin)
+
+	// Wrong credentials are counted by the address of the client, so
+	// passwords can't be guessed past the limit
+	wrong := http.Header{"Authorization": {"Basic bWFzdWQ6d3Jvbmc="}}
+	for i := 0; i < 3; i++ {
+		rec = serveTestWithHeader(t, srvr, testData{"", "GET", "/appscode/workers/jenny", 401, nil}, wrong)
+		if rec.Header().Get("RateLimit-Remaining") != strconv.Itoa(2-i) {
+			t.Errorf("got RateLimit-Remaining %q expected %d", rec.Header().Get("RateLimit-Remaining"), 2-i)
+		}
+	}
+	rec = serveTestWithHeader(t, srvr, testData{"rate_limited_credentials", "GET", "/appscode/workers/jenny", 429, nil}, wrong)
+	checkGolden(t, "rate_limited_credentials", dumpResponse(rec))
+
+	// An API key has a bucket of its own, apart from its user's
+	key := make(http.Header)
+	key.Set(APIKeyHeader, "k3y")
+	serveTestWithHeader(t, srvr, testData{"", "GET", "/appscode/workers/jenny", 429, nil}, masud)
+	serveTestWithHeader(t, srvr, testData{"", "GET", "/appscode/workers/jenny", 200, nil}, key)
+
+	doc, err := srvr.OpenAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(doc), `"429"`) {
+		t.Error("expected the limited operations to document 429")
+	}
+}
+
+func TestSharedRateLimit(t *testing.T) {
+	clock := &movingClock{now: testTime.Now()}
+	opts := []Option{WithClock(clock), WithRateLimit(RateLimit{Requests: 2, Per: time.Minute}), WithSharedRateLimits(true)}
+	srvr := newTestServer(t, opts...)
+	other := NewServer(append(opts, WithStore(srvr.store), WithBypassAuth(true), WithLogger(log.New(ioutil.Discard, "", 0)))...)
+
+	// The servers share the bucket of the client
+	serveTest(t, srvr, testData{"", "GET", "/appscode/workers/jenny", 200, nil})
+	serveTest(t, other, testData{"", "GET", "/appscode/workers/jenny", 200, nil})
+	serveTest(t, srvr, testData{"", "GET", "/appscode/workers/jenny", 429, nil})
+	serveTest(t, other, testData{"", "GET", "/appscode/workers/jenny", 429, nil})
+
+	if n, err := srvr.PruneRateLimitBuckets(); err != nil || n != 0 {
+		t.Errorf("pruned %d buckets, %v, expected none before they are full", n, err)
+	}
+	clock.now = clock.now.Add(time.Minute + time.Second)
+	if n, err := srvr.PruneRateLimitBuckets(); err != nil || n != 1 {
+		t.Errorf("pruned %d buckets, %v, expected the full one", n, err)
+	}
+	serveTest(t, other, testData{"", "GET", "/appscode/workers/jenny", 200, nil})
+
+	// A server losing the race for a new bucket is told it exists
+	bucket := &RateLimitBucket{Key: "* 192.0.2.1", Tokens: 1, TakenAt: clock.now.UnixNano(), ExpiresAt: clock.now.Add(time.Minute)}
+	for _, expected := range []error{nil, ErrAlreadyExists} {
+		if err := srvr.store.CreateRateLimitBucket(bucket); err != expected {
+			t.Errorf("got %v creating the bucket, expected %v", err, expected)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		remoteAddr, forwardedFor, expected string
+	}{
+		{"203.0.113.7:4321", "", "203.0.113.7"},
+		// Only the trusted proxies are believed
+		{"203.0.113.7:4321", "198.51.100.1", "203.0.113.7"},
+		{"192.0.2.1:4321", "198.51.100.1", "198.51.100.1"},
+		// The addresses before the client's could be made up by it
+		{"10.1.2.3:4321", "1.1.1.1, 198.51.100.1, 10.0.0.5", "198.51.100.1"},
+		{"10.1.2.3:4321", "not an address, 10.0.0.5", "10.0.0.5"},
+		{"192.0.2.1:4321", "", "192.0.2.1"},
+	} {
+		r := &http.Request{RemoteAddr: test.remoteAddr, Header: make(http.Header)}
+		if test.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", test.forwardedFor)
+		}
+		if ip := clientIP(r, proxies); ip != test.expected {
+			t.Errorf("clientIP(%q, %q) = %q expected %q", test.remoteAddr, test.forwardedFor, ip, test.expected)
+		}
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	for spec, expected := range map[string]RateLimit{
+		"10/s":     {Requests: 10, Per: time.Second},
+		"600/m":    {Requests: 600, Per: time.Minute},
+		"100/30s":  {Requests: 100, Per: 30 * time.Second},
+		"0/s":      {},
+		"10":       {},
+		"many/h":   {},
+		"10/never": {},
+	} {
+		limit, err := ParseRateLimit(spec)
+		if limit != expected || (err != nil) != (expected == RateLimit{}) {
+			t.Errorf("ParseRateLimit(%q) = %v, %v expected %v", spec, limit, err, expected)
+		}
+	}
+
+	route, limit, err := ParseRouteRateLimit("post /appscode/workers/{username}/restore/=5/h")
+	if route != "POST /appscode/workers/:username/restore" || limit != (RateLimit{Requests: 5, Per: time.Hour}) || err != nil {
+		t.Errorf("got %q %v %v", route, limit, err)
+	}
+	if _, _, err := ParseRouteRateLimit("GET /nowhere=5/h"); err == nil {
+		t.Error("expected an unknown route to be refused")
+	}
+}
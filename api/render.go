@@ -0,0 +1,202 @@
+package api
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/macaron.v1"
+)
+
+// Media types the API reads and writes
+const (
+	mediaJSON    = "application/json"
+	mediaYAML    = "application/yaml"
+	mediaXML     = "application/xml"
+	mediaMsgpack = "application/msgpack"
+	mediaCSV     = "text/csv"
+)
+
+// mediaAliases are the other names clients use for the media types
+var mediaAliases = map[string]string{
+	"application/x-yaml":      mediaYAML,
+	"text/yaml":               mediaYAML,
+	"text/x-yaml":             mediaYAML,
+	"text/xml":                mediaXML,
+	"application/x-msgpack":   mediaMsgpack,
+	"application/vnd.msgpack": mediaMsgpack,
+}
+
+// canonicalMedia returns the media type of a Content-Type or Accept entry
+// under the name used by the API, or "" if it can't be parsed
+func canonicalMedia(value string) string {
+	mediaType, _, err := mime.ParseMediaType(value)
+	if err != nil {
+		return ""
+	}
+	if alias, ok := mediaAliases[mediaType]; ok {
+		return alias
+	}
+	return mediaType
+}
+
+// responseTypes are the media types of responses, in the order the server
+// prefers them when the client likes several equally. CSV is only offered
+// for lists.
+var responseTypes = []string{mediaJSON, mediaYAML, mediaXML, mediaMsgpack, mediaCSV}
+
+// requestTypes are the media types request bodies can be sent in
+var requestTypes = []string{mediaJSON, mediaYAML, mediaXML, mediaMsgpack}
+
+// negotiate picks the offer the Accept header likes best. Every offer is
+// acceptable when the header is missing, and none of them when the header
+// only lists other types.
+func negotiate(accept string, offers []string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], true
+	}
+
+	type mediaRange struct {
+		mediaType string
+		q         float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if alias, ok := mediaAliases[mediaType]; ok {
+			mediaType = alias
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType, q})
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		// The most specific range matching the offer sets its quality
+		q, specificity := 0.0, -1
+		for _, r := range ranges {
+			s := -1
+			switch {
+			case r.mediaType == offer:
+				s = 2
+			case strings.HasSuffix(r.mediaType, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(r.mediaType, "*")):
+				s = 1
+			case r.mediaType == "*/*":
+				s = 0
+			}
+			if s > specificity {
+				q, specificity = r.q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best, best != ""
+}
+
+// isList tells if v renders as a list of objects, the only values that
+// can be written as CSV
+func isList(v interface{}) bool {
+	t := reflect.TypeOf(v)
+	if t == nil || t.Kind() != reflect.Slice {
+		return false
+	}
+	elem := t.Elem()
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	return elem.Kind() == reflect.Struct
+}
+
+// offersFor returns the media types v can be rendered in
+func offersFor(v interface{}) []string {
+	if isList(v) {
+		return responseTypes
+	}
+	return responseTypes[:len(responseTypes)-1]
+}
+
+func notAcceptable(offers []string) *Error {
+	return NewError(http.StatusNotAcceptable, ProblemNotAcceptable, "The response can be sent as "+strings.Join(offers, ", "))
+}
+
+// checkAcceptable refuses a request whose response, rendered from a value
+// like v, can't be sent in a type the client accepts, before the handler
+// changes anything
+func checkAcceptable(ctx *macaron.Context, v interface{}) error {
+	offers := offersFor(v)
+	if _, ok := negotiate(ctx.Req.Header.Get("Accept"), offers); !ok {
+		return notAcceptable(offers)
+	}
+	return nil
+}
+
+// render writes v in the media type the client accepts, JSON by default.
+// v is encoded before anything is written, so a value that can't be
+// encoded is still reported as a proper 500.
+func (s *Server) render(ctx *macaron.Context, status int, v interface{}) error {
+	offers := offersFor(v)
+	mediaType, ok := negotiate(ctx.Req.Header.Get("Accept"), offers)
+	if !ok {
+		return notAcceptable(offers)
+	}
+	body, err := encodeAs(mediaType, v)
+	if err != nil {
+		return internalError(err)
+	}
+
+	s.writeBody(ctx, status, contentType(mediaType, false), body)
+	return nil
+}
+
+func (s *Server) writeBody(ctx *macaron.Context, status int, contentType string, body []byte) {
+	ctx.Resp.Header().Set("Content-Type", contentType)
+	ctx.Resp.Header().Add("Vary", "Accept")
+	ctx.Resp.WriteHeader(status)
+	if _, err := ctx.Resp.Write(body); err != nil {
+		s.logger.Println(err)
+	}
+}
+
+// contentType returns the Content-Type of a response body of mediaType,
+// problem tells if the body is an RFC 7807 problem
+func contentType(mediaType string, problem bool) string {
+	switch {
+	case problem && mediaType == mediaJSON:
+		return "application/problem+json"
+	case problem && mediaType == mediaXML:
+		return "application/problem+xml"
+	case mediaType == mediaCSV:
+		return "text/csv; charset=utf-8"
+	}
+	return mediaType
+}
+
+// encodeAs encodes v in one of the responseTypes
+func encodeAs(mediaType string, v interface{}) ([]byte, error) {
+	switch mediaType {
+	case mediaJSON:
+		return encodeJSON(v)
+	case mediaYAML:
+		return encodeYAML(v)
+	case mediaXML:
+		return encodeXML(v)
+	case mediaMsgpack:
+		return encodeMsgpack(v)
+	case mediaCSV:
+		return encodeCSV(v)
+	}
+	return nil, fmt.Errorf("can't encode %s", mediaType)
+}
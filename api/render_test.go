@@ -0,0 +1,209 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestContentNegotiation(t *testing.T) {
+	srvr := newTestServer(t)
+
+	for _, data := range []struct {
+		testData
+		accept      string
+		contentType string
+	}{
+		{testData{"show_worker_yaml", "GET", "/appscode/workers/masud", 200, nil}, "application/yaml", ""},
+		{testData{"show_worker_xml", "GET", "/appscode/workers/masud", 200, nil}, "text/xml", ""},
+		{testData{"show_worker_preferred", "GET", "/appscode/workers/masud", 200, nil}, "application/xml;q=0.5, application/x-yaml", ""},
+		{testData{"show_worker_wildcard", "GET", "/appscode/workers/masud", 200, nil}, "text/html, */*;q=0.1", ""},
+		{testData{"show_worker_csv", "GET", "/appscode/workers/masud", 406, nil}, "text/csv", ""},
+		{testData{"show_workers_csv", "GET", "/appscode/workers?currency=BDT", 200, nil}, "text/csv", ""},
+		{testData{"show_workers_xml", "GET", "/appscode/workers", 200, nil}, "application/xml", ""},
+		{testData{"show_workers_not_acceptable", "GET", "/appscode/workers", 406, nil}, "text/html, application/json;q=0", ""},
+		{testData{"show_missing_worker_xml", "GET", "/appscode/workers/nobody", 404, nil}, "application/xml", ""},
+		{
+			testData{
+				"update_worker_yaml",
+				"PUT",
+				"/appscode/workers/masud",
+				201,
+				strings.NewReader(`{"firstname":"Masudur","lastname":"Rahman","city":"Shariatpur","division":"Dhaka","position":"Software Engineer","salary":6000}`),
+			},
+			"application/yaml",
+			"",
+		},
+		{testData{"delete_worker_xml", "DELETE", "/appscode/workers/fahim", 200, nil}, "application/xml", ""},
+		{testData{"delete_worker_not_acceptable", "DELETE", "/appscode/workers/tahsin", 406, nil}, "text/plain", ""},
+		{testData{"show_worker_not_deleted", "GET", "/appscode/workers/tahsin", 200, nil}, "application/yaml", ""},
+		{
+			testData{
+				"add_worker_yaml",
+				"POST",
+				"/appscode/workers",
+				201,
+				strings.NewReader("username: rahim\nfirstname: Rahim\nlastname: Uddin\ncity: Madaripur\ndivision: Dhaka\nposition: Software Engineer\nsalary: 6000\n"),
+			},
+			"application/yaml",
+			"application/yaml",
+		},
+		{
+			testData{
+				"add_worker_xml",
+				"POST",
+				"/appscode/workers",
+				201,
+				strings.NewReader(`<worker><username>karim</username><firstname>Karim</firstname><lastname>Uddin</lastname><city>Madaripur</city><division>Dhaka</division><position>Software Engineer</position><salary>6000</salary></worker>`),
+			},
+			"",
+			"application/xml; charset=utf-8",
+		},
+		{
+			testData{
+				"add_worker_xml_invalid",
+				"POST",
+				"/appscode/workers",
+				422,
+				strings.NewReader(`<worker><username>salam</username><salary>much</salary></worker>`),
+			},
+			"application/xml",
+			"text/xml",
+		},
+		{
+			testData{
+				"add_worker_yaml_unknown_field",
+				"POST",
+				"/appscode/workers",
+				422,
+				strings.NewReader("username: salam\nnickname: Salu\n"),
+			},
+			"",
+			"text/yaml",
+		},
+		{
+			testData{
+				"add_worker_unsupported_type",
+				"POST",
+				"/appscode/workers",
+				415,
+				strings.NewReader("username=salam"),
+			},
+			"",
+			"application/x-www-form-urlencoded",
+		},
+		{
+			testData{
+				"add_exchange_rates_xml",
+				"POST",
+				"/appscode/exchange-rates",
+				201,
+				strings.NewReader(`<list><exchange_rate><base>USD</base><quote>BDT</quote><date>2019-03-01</date><rate>84.5</rate></exchange_rate></list>`),
+			},
+			"application/xml",
+			"application/xml",
+		},
+	} {
+		header := make(http.Header)
+		if data.accept != "" {
+			header.Set("Accept", data.accept)
+		}
+		if data.contentType != "" {
+			header.Set("Content-Type", data.contentType)
+		}
+		checkGolden(t, data.name, dumpResponse(serveTestWithHeader(t, srvr, data.testData, header)))
+	}
+}
+
+// TestMsgpack checks that a worker round trips through MessagePack with
+// the same fields as JSON
+func TestMsgpack(t *testing.T) {
+	srvr := newTestServer(t)
+
+	header := http.Header{"Accept": {"application/msgpack"}}
+	rec := serveTestWithHeader(t, srvr, testData{"show_worker_msgpack", "GET", "/appscode/workers/masud", 200, nil}, header)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/msgpack" {
+		t.Errorf("got Content-Type %q", ct)
+	}
+	body, err := msgpackToJSON(rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := serveTest(t, srvr, testData{"show_worker_json", "GET", "/appscode/workers/masud", 200, nil})
+	var got, want map[string]interface{}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(expected.Body.Bytes(), &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v expected %v", got, want)
+	}
+
+	worker, err := encodeMsgpack(map[string]interface{}{
+		"username": "rahim", "firstname": "Rahim", "lastname": "Uddin", "city": "Madaripur",
+		"division": "Dhaka", "position": "Software Engineer", "salary": 6000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header = http.Header{"Content-Type": {"application/x-msgpack"}}
+	serveTestWithHeader(t, srvr, testData{"add_worker_msgpack", "POST", "/appscode/workers", 201, bytes.NewReader(worker)}, header)
+}
+
+func TestMsgpackValues(t *testing.T) {
+	for _, value := range []interface{}{
+		nil, true, false, 0, 127, 128, -32, -33, 255, 65536, -129, -40000, 1 << 40, -(1 << 40), 1.5,
+		"", "text", strings.Repeat("a", 40), strings.Repeat("b", 300), strings.Repeat("c", 70000),
+		[]interface{}{}, make([]interface{}, 20), map[string]interface{}{"a": []interface{}{1, "b"}},
+	} {
+		body, err := encodeMsgpack(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := msgpackToJSON(body)
+		if err != nil {
+			t.Errorf("%v: %v", value, err)
+			continue
+		}
+		expected, _ := json.Marshal(value)
+		if !bytes.Equal(got, expected) {
+			t.Errorf("got %s expected %s", got, expected)
+		}
+	}
+
+	for _, body := range [][]byte{{}, {0xa5, 'a'}, {0xdd, 0xff, 0xff, 0xff, 0xff}, {0xc7, 0x01, 0x01, 0x00}, {0x81, 0x01, 0x01}, {0x01, 0x02}} {
+		if _, err := msgpackToJSON(body); err == nil {
+			t.Errorf("% x: expected an error", body)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{mediaJSON, mediaYAML, mediaXML}
+	for _, test := range []struct {
+		accept   string
+		expected string
+	}{
+		{"", mediaJSON},
+		{"*/*", mediaJSON},
+		{"application/*", mediaJSON},
+		{"application/xml", mediaXML},
+		{"text/xml, application/json;q=0.9", mediaXML},
+		{"application/*;q=0.5, application/yaml", mediaYAML},
+		{"*/*;q=0.1, application/json;q=0", mediaYAML},
+		{"application/yaml;q=0.5, application/xml;q=0.5", mediaYAML},
+		{"text/html", ""},
+		{"application/json;q=0", ""},
+		{"application/json;q=x", ""},
+	} {
+		got, ok := negotiate(test.accept, offers)
+		if got != test.expected || ok != (test.expected != "") {
+			t.Errorf("%q: got %q, %v expected %q", test.accept, got, ok, test.expected)
+		}
+	}
+}
@@ -0,0 +1,535 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"gopkg.in/macaron.v1"
+)
+
+// Clock tells the Server what time it is, tests can swap it for a fixed one
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// Server serves the worker API. All of its state is held in the struct,
+// so several servers can run side by side in one process.
+type Server struct {
+	addr     string
+	grpcAddr string
+	store    Store
+	auth     AuthProvider
+	roles    map[string][]string
+	// tokens and apiKeys are the bearer tokens and the API keys the users
+	// can authenticate with, mapped to their usernames
+	tokens          map[string]string
+	apiKeys         map[string]string
+	bypassAuth      bool
+	logger          *log.Logger
+	clock           Clock
+	gracefulTimeout time.Duration
+	maxBodyBytes    int64
+	maxImportBytes  int64
+	// scheduleInterval is how often Run applies the scheduled
+	// employment changes and prunes the old records, zero turns it off
+	scheduleInterval time.Duration
+	// eventPollInterval is how often the watched events are read from
+	// the store, eventRetention how long they are kept for resuming
+	eventPollInterval time.Duration
+	eventRetention    time.Duration
+	// webhookInterval is how often Run sends the webhook deliveries that
+	// are due, a delivery is tried webhookAttempts times waiting
+	// webhookBackoff after the first failure and twice as long after
+	// each next one
+	webhookInterval time.Duration
+	webhookAttempts int
+	webhookBackoff  time.Duration
+	webhookClient   *http.Client
+	webhookWake     chan struct{}
+	// idempotencyTTL is how long the responses to the requests sent with
+	// an Idempotency-Key header are kept
+	idempotencyTTL time.Duration
+	// rateLimit is the limit of every client on the routes without one of
+	// their own in routeRateLimits, the zero RateLimit is none. The
+	// clients are told apart by their address behind trustedProxies.
+	rateLimit        RateLimit
+	routeRateLimits  map[string]RateLimit
+	trustedProxies   []*net.IPNet
+	sharedRateLimits bool
+	rateLimiter      rateLimiter
+	// cache serves the worker reads when set, see WithResponseCache
+	cache *responseCache
+
+	// graphQLMaxDepth and graphQLMaxComplexity limit the GraphQL
+	// operations, see checkGraphQLLimits
+	graphQLMaxDepth      int
+	graphQLMaxComplexity int
+
+	// imports tracks the import jobs running in the background
+	imports          *sync.WaitGroup
+	persistedQueries *persistedQueries
+	events           *workerEvents
+	// routes are the routes of m, in the order they were registered
+	routes []route
+
+	m      *macaron.Macaron
+	srvr   *http.Server
+	grpc   *grpc.Server
+	health *health.Server
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithAddr sets the address the server listens on, ":8080" by default
+func WithAddr(addr string) Option {
+	return func(s *Server) { s.addr = addr }
+}
+
+// WithGRPCAddr sets the address the gRPC server listens on, Run serves
+// only HTTP if none is given
+func WithGRPCAddr(addr string) Option {
+	return func(s *Server) { s.grpcAddr = addr }
+}
+
+// WithStore sets the Store the workers are kept in, it must be given
+func WithStore(store Store) Option {
+	return func(s *Server) { s.store = store }
+}
+
+// WithAuthProvider sets how credentials are checked, DefaultAuth by
+// default
+func WithAuthProvider(auth AuthProvider) Option {
+	return func(s *Server) { s.auth = auth }
+}
+
+// WithRoles grants roles to users, by username
+func WithRoles(roles map[string][]string) Option {
+	return func(s *Server) { s.roles = roles }
+}
+
+// WithTokens lets users authenticate with bearer tokens, given as a map
+// of the tokens to their usernames. There are none by default.
+func WithTokens(tokens map[string]string) Option {
+	return func(s *Server) { s.tokens = tokens }
+}
+
+// WithAPIKeys lets users authenticate with API keys sent in the X-API-Key
+// header, given as a map of the keys to their usernames. Every key has a
+// rate limit of its own. There are none by default.
+func WithAPIKeys(keys map[string]string) Option {
+	return func(s *Server) { s.apiKeys = keys }
+}
+
+// WithBypassAuth lets every request through without credentials
+func WithBypassAuth(bypass bool) Option {
+	return func(s *Server) { s.bypassAuth = bypass }
+}
+
+// WithLogger sets where the server logs to, stderr by default
+func WithLogger(logger *log.Logger) Option {
+	return func(s *Server) { s.logger = logger }
+}
+
+// WithClock sets the Clock stamping the workers, the system clock by
+// default
+func WithClock(clock Clock) Option {
+	return func(s *Server) { s.clock = clock }
+}
+
+// WithGracefulTimeout sets how long Run waits for open connections
+// to finish once its context is done, 15s by default
+func WithGracefulTimeout(timeout time.Duration) Option {
+	return func(s *Server) { s.gracefulTimeout = timeout }
+}
+
+// WithMaxBodyBytes limits the size of request bodies, 1 MiB by default
+func WithMaxBodyBytes(n int64) Option {
+	return func(s *Server) { s.maxBodyBytes = n }
+}
+
+// WithMaxImportBytes limits the size of the files imported with
+// POST /appscode/workers/import, 32 MiB by default
+func WithMaxImportBytes(n int64) Option {
+	return func(s *Server) { s.maxImportBytes = n }
+}
+
+// WithScheduleInterval sets how often Run applies the employment changes
+// that came due and prunes the old events, deliveries, idempotent
+// responses, rate limit buckets and import jobs, hourly by default. Zero
+// leaves it to ApplyScheduledChanges and the Prune methods.
+func WithScheduleInterval(interval time.Duration) Option {
+	return func(s *Server) { s.scheduleInterval = interval }
+}
+
+// WithEventPollInterval sets how often the watches read the changes made
+// by the other servers sharing the database, every second by default.
+// The changes made by this server are seen right away.
+func WithEventPollInterval(interval time.Duration) Option {
+	return func(s *Server) { s.eventPollInterval = interval }
+}
+
+// WithEventRetention sets how long the changes of workers are kept for
+// watches to resume from, a day by default
+func WithEventRetention(retention time.Duration) Option {
+	return func(s *Server) { s.eventRetention = retention }
+}
+
+// WithWebhookInterval sets how often Run sends the webhook deliveries that
+// came due, every 5s by default. The ones of the changes made by this
+// server are sent right away. Zero leaves it to DeliverWebhooks.
+func WithWebhookInterval(interval time.Duration) Option {
+	return func(s *Server) { s.webhookInterval = interval }
+}
+
+// WithWebhookRetries sets how many times a webhook delivery is tried
+// before it is dead, and the wait after its first failure, doubling with
+// each next one up to an hour. It is 8 times from 30s by default.
+func WithWebhookRetries(attempts int, backoff time.Duration) Option {
+	return func(s *Server) {
+		s.webhookAttempts = attempts
+		s.webhookBackoff = backoff
+	}
+}
+
+// WithIdempotencyTTL sets how long the response to a POST sent with an
+// Idempotency-Key header answers its retries, a day by default
+func WithIdempotencyTTL(ttl time.Duration) Option {
+	return func(s *Server) { s.idempotencyTTL = ttl }
+}
+
+// WithRateLimit limits how many requests every client can make, by their
+// API key, their user or their address. There is no limit by default.
+func WithRateLimit(limit RateLimit) Option {
+	return func(s *Server) { s.rateLimit = limit }
+}
+
+// WithRouteRateLimit gives a route a limit of its own, counted apart from
+// the one of the other routes. The route is keyed as in the OpenAPI
+// document, e.g. "POST /appscode/workers/import".
+func WithRouteRateLimit(route string, limit RateLimit) Option {
+	return func(s *Server) {
+		if s.routeRateLimits == nil {
+			s.routeRateLimits = make(map[string]RateLimit)
+		}
+		s.routeRateLimits[route] = limit
+	}
+}
+
+// WithTrustedProxies trusts the X-Forwarded-For header of the requests
+// sent by the proxies, for telling the clients behind them apart
+func WithTrustedProxies(proxies ...*net.IPNet) Option {
+	return func(s *Server) { s.trustedProxies = proxies }
+}
+
+// WithSharedRateLimits keeps the rate limits in the store instead of in
+// memory, so they hold across the servers sharing the database
+func WithSharedRateLimits(shared bool) Option {
+	return func(s *Server) { s.sharedRateLimits = shared }
+}
+
+// WithResponseCache serves the reads of the workers from cache for up to
+// ttl, until a server sharing the database changes the workers. There is
+// no cache by default.
+func WithResponseCache(cache ResponseCache, ttl time.Duration) Option {
+	return func(s *Server) { s.cache = &responseCache{cache: cache, ttl: ttl} }
+}
+
+// WithGraphQLLimits limits how deep GraphQL operations can nest and how
+// many fields they can resolve, counting the fields of lists by their
+// expected length. It is 10 levels and 10000 fields by default.
+func WithGraphQLLimits(depth, complexity int) Option {
+	return func(s *Server) {
+		s.graphQLMaxDepth = depth
+		s.graphQLMaxComplexity = complexity
+	}
+}
+
+// NewServer builds a Server, a Store must be given with WithStore
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		addr:             ":8080",
+		auth:             DefaultAuth(),
+		roles:            DefaultRoles(),
+		logger:           log.New(os.Stderr, "", log.LstdFlags),
+		clock:            systemClock{},
+		gracefulTimeout:  time.Second * 15,
+		maxBodyBytes:     1 << 20,
+		maxImportBytes:   32 << 20,
+		imports:          new(sync.WaitGroup),
+		scheduleInterval: time.Hour,
+
+		eventPollInterval: time.Second,
+		eventRetention:    24 * time.Hour,
+
+		webhookInterval: 5 * time.Second,
+		webhookAttempts: 8,
+		webhookBackoff:  30 * time.Second,
+		webhookClient:   newWebhookClient(),
+		webhookWake:     make(chan struct{}, 1),
+		idempotencyTTL:  24 * time.Hour,
+
+		graphQLMaxDepth:      defaultGraphQLMaxDepth,
+		graphQLMaxComplexity: defaultGraphQLMaxComplexity,
+		persistedQueries:     newPersistedQueries(maxPersistedQueries),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.events = newWorkerEvents(s.store, s.clock, s.eventPollInterval, s.logger)
+	if s.sharedRateLimits {
+		s.rateLimiter = &storeRateLimiter{store: s.store}
+	} else {
+		s.rateLimiter = newMemoryRateLimiter()
+	}
+	s.m = s.newMacaron()
+	s.srvr = &http.Server{
+		Addr:         s.addr,
+		Handler:      s.m,
+		WriteTimeout: time.Second * 15,
+		ReadTimeout:  time.Second * 15,
+		IdleTimeout:  time.Second * 60,
+		ErrorLog:     s.logger,
+	}
+	s.grpc = s.newGRPCServer()
+	return s
+}
+
+func (s *Server) newMacaron() *macaron.Macaron {
+	m := macaron.NewWithLogger(s.logger.Writer())
+	m.Use(macaron.Logger())
+	m.Use(s.assignRequestID)
+	m.Use(s.recovery)
+	m.Use(s.identify)
+	m.Use(s.limitRate)
+	m.Use(s.authenticate)
+	m.Use(s.idempotency)
+	m.NotFound(s.notFoundRoute)
+	m.InternalServerError(s.renderError)
+
+	r := &router{m: m}
+	r.get("/", s.welcome)
+	r.get("/openapi.json", s.showOpenAPI)
+	r.get("/docs", s.showDocs)
+	r.get("/graphql", s.serveGraphQL)
+	r.post("/graphql", s.serveGraphQL)
+	r.group("/appscode", func() {
+		r.get("/", s.welcomeToAppsCode)
+		r.group("/workers", func() {
+			r.get("/", s.cached(s.showAllWorkers))
+			r.get("/export", s.exportWorkers)
+			r.get("/watch", s.watchWorkerChanges)
+			r.get("/:username", s.cached(s.showSingleWorker))
+			r.get("/:username/reports", s.showReports)
+			r.get("/:username/chain", s.showChain)
+			r.get("/:username/employment", s.showEmployment)
+			r.post("/:username/employment", s.scheduleEmploymentChange)
+			r.delete("/:username/employment/:id", s.cancelEmploymentChange)
+			r.post("/", s.addNewWorker)
+			r.post("/import", s.importWorkers)
+			r.post("/batch", s.runBatch)
+			r.get("/import/:id", s.showImport)
+			r.put("/:username", s.updateWorkerProfile)
+			r.delete("/:username", s.deleteWorker)
+			r.post("/:username/restore", s.restoreWorker)
+		})
+		r.get("/orgchart", s.showOrgChart)
+		r.group("/departments", func() {
+			r.get("/", s.showAllDepartments)
+			r.get("/:id", s.showDepartment)
+			r.get("/:id/workers", s.showDepartmentWorkers)
+			r.get("/:id/teams", s.showDepartmentTeams)
+			r.post("/", s.addDepartment)
+			r.put("/:id", s.updateDepartment)
+			r.delete("/:id", s.deleteDepartment)
+		})
+		r.group("/positions", func() {
+			r.get("/", s.showAllPositions)
+			r.get("/:id", s.showPosition)
+			r.post("/", s.addPosition)
+			r.put("/:id", s.updatePosition)
+			r.delete("/:id", s.deletePosition)
+		})
+		r.get("/reports/out-of-band", s.showOutOfBand)
+		r.get("/stats", s.showStats)
+		r.get("/stats/headcount", s.showHeadcount)
+		r.get("/cache", s.showCacheStats)
+		r.group("/exchange-rates", func() {
+			r.get("/", s.showExchangeRates)
+			r.post("/", s.addExchangeRates)
+			r.delete("/:base/:quote/:date", s.deleteExchangeRate)
+		})
+		r.group("/teams", func() {
+			r.get("/", s.showAllTeams)
+			r.get("/:id", s.showTeam)
+			r.get("/:id/workers", s.showTeamWorkers)
+			r.post("/", s.addTeam)
+			r.put("/:id", s.updateTeam)
+			r.delete("/:id", s.deleteTeam)
+		})
+		r.group("/webhooks", func() {
+			r.get("/", s.showWebhooks)
+			r.get("/dead-letters", s.showDeadLetters)
+			r.get("/:id", s.showWebhook)
+			r.get("/:id/deliveries", s.showDeliveries)
+			r.post("/", s.addWebhook)
+			r.post("/:id/deliveries/:delivery/retry", s.retryDelivery)
+			r.put("/:id", s.updateWebhook)
+			r.delete("/:id", s.deleteWebhook)
+		})
+	})
+	r.group("/locations", func() {
+		r.get("/", s.showCountries)
+		r.get("/:country", s.showDivisions)
+		r.get("/:country/:division", s.showDivision)
+	})
+	s.routes = r.routes
+	return m
+}
+
+// withStore returns a copy of the server working on store, for running
+// the checks of a request inside its transaction
+func (s *Server) withStore(store Store) *Server {
+	bound := *s
+	bound.store = store
+	return &bound
+}
+
+// Handler returns the http.Handler serving the API, for mounting it
+// in another server or calling it from tests
+func (s *Server) Handler() http.Handler {
+	return s.m
+}
+
+// Run listens on the configured address until ctx is done,
+// then shuts the server down gracefully
+func (s *Server) Run(ctx context.Context) error {
+	if s.scheduleInterval > 0 {
+		housekeepingCtx, stop := context.WithCancel(ctx)
+		defer stop()
+		go s.runHousekeeping(housekeepingCtx)
+	}
+	if s.webhookInterval > 0 {
+		webhookCtx, stop := context.WithCancel(ctx)
+		defer stop()
+		go s.runWebhooks(webhookCtx)
+	}
+	if s.cache != nil {
+		cacheCtx, stop := context.WithCancel(ctx)
+		defer stop()
+		go s.runCacheInvalidation(cacheCtx)
+	}
+
+	errCh := make(chan error, 2)
+	go func() {
+		s.logger.Println("Starting the server on", s.addr)
+		errCh <- s.srvr.ListenAndServe()
+	}()
+	if s.grpcAddr != "" {
+		lis, err := net.Listen("tcp", s.grpcAddr)
+		if err != nil {
+			s.srvr.Close()
+			return err
+		}
+		go func() {
+			s.logger.Println("Starting the gRPC server on", s.grpcAddr)
+			errCh <- s.grpc.Serve(lis)
+		}()
+	}
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.gracefulTimeout)
+	defer cancel()
+	return s.Shutdown(shutdownCtx)
+}
+
+// runHousekeeping applies the scheduled changes and prunes the old events,
+// webhook deliveries, idempotent responses, rate limit buckets and import
+// jobs every scheduleInterval until ctx is done
+func (s *Server) runHousekeeping(ctx context.Context) {
+	ticker := time.NewTicker(s.scheduleInterval)
+	defer ticker.Stop()
+	for {
+		if n, err := s.ApplyScheduledChanges(); err != nil {
+			s.logger.Println("applying scheduled changes:", err)
+		} else if n > 0 {
+			s.logger.Println("applied", n, "scheduled employment changes")
+		}
+		if _, err := s.PruneEvents(); err != nil {
+			s.logger.Println("pruning the worker events:", err)
+		}
+		if _, err := s.PruneDeliveries(); err != nil {
+			s.logger.Println("pruning the webhook deliveries:", err)
+		}
+		if _, err := s.PruneIdempotentResponses(); err != nil {
+			s.logger.Println("pruning the idempotent responses:", err)
+		}
+		if _, err := s.PruneRateLimitBuckets(); err != nil {
+			s.logger.Println("pruning the rate limit buckets:", err)
+		}
+		if _, err := s.PruneImportJobs(); err != nil {
+			s.logger.Println("pruning the import jobs:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Shutdown stops accepting new connections and waits for the open ones
+// and the import jobs to finish or for ctx to be done, watches are ended
+// right away
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.logger.Println("Shutting down the server...!")
+	s.health.Shutdown()
+	s.events.close()
+
+	grpcStopped := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(grpcStopped)
+	}()
+	if err := s.srvr.Shutdown(ctx); err != nil {
+		s.grpc.Stop()
+		return err
+	}
+	select {
+	case <-grpcStopped:
+	case <-ctx.Done():
+		s.grpc.Stop()
+		return ctx.Err()
+	}
+
+	imported := make(chan struct{})
+	go func() {
+		s.imports.Wait()
+		close(imported)
+	}()
+	select {
+	case <-imported:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.logger.Println("The server has been shut down...!")
+	return nil
+}
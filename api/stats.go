@@ -0,0 +1,577 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-xorm/core"
+	"gopkg.in/macaron.v1"
+)
+
+// monthLayout is the format of the months of the headcount statistics
+const monthLayout = "2006-01"
+
+// maxStatsMonths bounds the months a headcount request can cover
+const maxStatsMonths = 120
+
+// statsFields are the worker fields statistics can be grouped and filtered by
+var statsFields = []string{"division", "city", "position", "department", "team"}
+
+// defaultPercentiles are reported along with the median unless asked otherwise
+var defaultPercentiles = []int{25, 75, 90}
+
+// StatsFilter selects the workers counted by the non-empty fields
+type StatsFilter struct {
+	Division   string
+	City       string
+	Position   string
+	Department string
+	Team       string
+}
+
+func (f StatsFilter) worker() *Worker {
+	return &Worker{Division: f.Division, City: f.City, Position: f.Position, Department: f.Department, Team: f.Team}
+}
+
+// sql returns the filter as SQL conditions on the columns of alias, each
+// starting with AND
+func (f StatsFilter) sql(alias string) (string, []interface{}) {
+	var cond strings.Builder
+	var args []interface{}
+	for _, field := range []struct {
+		column, value string
+	}{
+		{"division", f.Division},
+		{"city", f.City},
+		{"position", f.Position},
+		{"department", f.Department},
+		{"team", f.Team},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(&cond, " AND %s.%s = ?", alias, field.column)
+			args = append(args, field.value)
+		}
+	}
+	return cond.String(), args
+}
+
+// statsField returns the value of one of the statsFields of the worker
+func statsField(w *Worker, field string) string {
+	switch field {
+	case "division":
+		return w.Division
+	case "city":
+		return w.City
+	case "position":
+		return w.Position
+	case "department":
+		return w.Department
+	case "team":
+		return w.Team
+	}
+	panic("stats: unknown field " + field)
+}
+
+// StatsQuery asks for the salary statistics of the workers selected by
+// Filter, in groups of the values of the GroupBy fields. Salaries are
+// converted into Currency with Rates, the factor from the minor units of
+// each currency to the minor units of Currency. Without a Currency the
+// groups are split by currency.
+type StatsQuery struct {
+	Filter      StatsFilter
+	GroupBy     []string
+	Percentiles []int
+	Currency    string
+	Rates       map[string]*big.Rat
+}
+
+// SalaryStats are the statistics of a group of workers, salaries are in
+// minor units of Currency and rounded to whole ones
+type SalaryStats struct {
+	Group       map[string]string `json:"group,omitempty"`
+	Currency    string            `json:"currency"`
+	Headcount   int64             `json:"headcount"`
+	Min         int64             `json:"min"`
+	Max         int64             `json:"max"`
+	Avg         int64             `json:"avg"`
+	Median      int64             `json:"median"`
+	Percentiles map[string]int64  `json:"percentiles,omitempty"`
+}
+
+// HeadcountQuery asks for the number of workers employed at the end of
+// every month from From to To, the first days of the months in UTC
+type HeadcountQuery struct {
+	Filter  StatsFilter
+	GroupBy []string
+	From    time.Time
+	To      time.Time
+}
+
+// monthCount is how many months q.months returns, told without building
+// them, zero or less if To is before From
+func (q *HeadcountQuery) monthCount() int {
+	return (q.To.Year()-q.From.Year())*12 + int(q.To.Month()-q.From.Month()) + 1
+}
+
+func (q *HeadcountQuery) months() []time.Time {
+	var months []time.Time
+	for m := q.From; !m.After(q.To); m = m.AddDate(0, 1, 0) {
+		months = append(months, m)
+	}
+	return months
+}
+
+// HeadcountPoint is the headcount of a group at the end of a month. Groups
+// without workers are left out, months are not when there are no groups.
+type HeadcountPoint struct {
+	Month     string            `json:"month"`
+	Group     map[string]string `json:"group,omitempty"`
+	Headcount int64             `json:"headcount"`
+}
+
+// StatsStore computes the statistics, in the database where it can
+type StatsStore interface {
+	// SalaryCurrencies returns the currencies the selected workers are paid in
+	SalaryCurrencies(filter StatsFilter) ([]string, error)
+	SalaryStats(query StatsQuery) ([]SalaryStats, error)
+	Headcount(query HeadcountQuery) ([]HeadcountPoint, error)
+}
+
+func (s *XormStore) postgres() bool {
+	return s.engine.Dialect().DBType() == core.POSTGRES
+}
+
+func (s *XormStore) SalaryCurrencies(filter StatsFilter) ([]string, error) {
+	currencies := make([]string, 0)
+	err := s.db.Table(new(Worker)).Distinct("currency").Asc("currency").Find(&currencies, filter.worker())
+	if err != nil {
+		return nil, err
+	}
+	return currencies, nil
+}
+
+// SalaryStats aggregates in SQL on Postgres, and in Go on the databases
+// without percentile functions
+func (s *XormStore) SalaryStats(query StatsQuery) ([]SalaryStats, error) {
+	if s.postgres() {
+		return s.salaryStatsSQL(query)
+	}
+	workers := make([]Worker, 0)
+	if err := s.db.Find(&workers, query.Filter.worker()); err != nil {
+		return nil, err
+	}
+	return salaryStats(workers, query), nil
+}
+
+func (s *XormStore) Headcount(query HeadcountQuery) ([]HeadcountPoint, error) {
+	if s.postgres() {
+		return s.headcountSQL(query)
+	}
+	workers := make([]Worker, 0)
+	if err := s.db.Unscoped().Find(&workers, query.Filter.worker()); err != nil {
+		return nil, err
+	}
+	return headcount(workers, query), nil
+}
+
+// statsGroup collects the salaries of a group
+type statsGroup struct {
+	values   []string
+	currency string
+	salaries []int64
+}
+
+func salaryStats(workers []Worker, query StatsQuery) []SalaryStats {
+	groups := make(map[string]*statsGroup)
+	for i := range workers {
+		salary, currency := workers[i].Salary, workers[i].Currency
+		if query.Currency != "" {
+			salary, currency = roundRat(new(big.Rat).Mul(big.NewRat(salary, 1), query.Rates[currency])), query.Currency
+		}
+
+		values := make([]string, len(query.GroupBy))
+		for j, field := range query.GroupBy {
+			values[j] = statsField(&workers[i], field)
+		}
+		key := strings.Join(append(values, currency), "\x00")
+		if groups[key] == nil {
+			groups[key] = &statsGroup{values: values, currency: currency}
+		}
+		groups[key].salaries = append(groups[key].salaries, salary)
+	}
+
+	stats := make([]SalaryStats, 0, len(groups))
+	for _, g := range groups {
+		sort.Slice(g.salaries, func(i, j int) bool { return g.salaries[i] < g.salaries[j] })
+		var sum float64
+		for _, salary := range g.salaries {
+			sum += float64(salary)
+		}
+		n := len(g.salaries)
+		st := SalaryStats{
+			Group:     groupMap(query.GroupBy, g.values),
+			Currency:  g.currency,
+			Headcount: int64(n),
+			Min:       g.salaries[0],
+			Max:       g.salaries[n-1],
+			Avg:       int64(math.Round(sum / float64(n))),
+			Median:    percentile(g.salaries, 50),
+		}
+		for _, p := range query.Percentiles {
+			if st.Percentiles == nil {
+				st.Percentiles = make(map[string]int64)
+			}
+			st.Percentiles["p"+strconv.Itoa(p)] = percentile(g.salaries, p)
+		}
+		stats = append(stats, st)
+	}
+	sortStats(stats, query.GroupBy)
+	return stats
+}
+
+// percentile interpolates between the closest ranks of the sorted values,
+// like percentile_cont of SQL
+func percentile(sorted []int64, p int) int64 {
+	pos := float64(p) / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	if lower+1 >= len(sorted) {
+		return sorted[lower]
+	}
+	frac := pos - float64(lower)
+	return int64(math.Round(float64(sorted[lower]) + frac*float64(sorted[lower+1]-sorted[lower])))
+}
+
+func groupMap(fields, values []string) map[string]string {
+	if len(fields) == 0 {
+		return nil
+	}
+	group := make(map[string]string, len(fields))
+	for i, field := range fields {
+		group[field] = values[i]
+	}
+	return group
+}
+
+func sortStats(stats []SalaryStats, groupBy []string) {
+	sort.Slice(stats, func(i, j int) bool {
+		for _, field := range groupBy {
+			if a, b := stats[i].Group[field], stats[j].Group[field]; a != b {
+				return a < b
+			}
+		}
+		return stats[i].Currency < stats[j].Currency
+	})
+}
+
+func headcount(workers []Worker, query HeadcountQuery) []HeadcountPoint {
+	points := make([]HeadcountPoint, 0)
+	for _, month := range query.months() {
+		end := month.AddDate(0, 1, 0)
+		counts := make(map[string]*HeadcountPoint)
+		var keys []string
+		for i := range workers {
+			w := &workers[i]
+			if !w.CreatedAt.Before(end) || !w.DeletedAt.IsZero() && w.DeletedAt.Before(end) {
+				continue
+			}
+			values := make([]string, len(query.GroupBy))
+			for j, field := range query.GroupBy {
+				values[j] = statsField(w, field)
+			}
+			key := strings.Join(values, "\x00")
+			if counts[key] == nil {
+				counts[key] = &HeadcountPoint{Month: month.Format(monthLayout), Group: groupMap(query.GroupBy, values)}
+				keys = append(keys, key)
+			}
+			counts[key].Headcount++
+		}
+		if len(query.GroupBy) == 0 && len(keys) == 0 {
+			points = append(points, HeadcountPoint{Month: month.Format(monthLayout)})
+			continue
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			points = append(points, *counts[key])
+		}
+	}
+	return points
+}
+
+// liveWorker is the SQL condition of a worker that isn't soft deleted
+const liveWorker = "(%[1]s.deleted_at IS NULL OR %[1]s.deleted_at = '0001-01-01 00:00:00')"
+
+func (s *XormStore) salaryStatsSQL(query StatsQuery) ([]SalaryStats, error) {
+	table := s.engine.TableInfo(new(Worker)).Name
+	filter, args := query.Filter.sql("w")
+
+	salary, currency := "w.salary", "w.currency"
+	var rateArgs []interface{}
+	if query.Currency != "" {
+		var cases strings.Builder
+		for from, rate := range query.Rates {
+			cases.WriteString(" WHEN ? THEN CAST(? AS numeric)")
+			rateArgs = append(rateArgs, from, rate.FloatString(18))
+		}
+		salary = "ROUND(w.salary * CASE w.currency" + cases.String() + " END)"
+		currency = "CAST(? AS varchar)"
+		rateArgs = append(rateArgs, query.Currency)
+	}
+
+	columns := []string{"currency"}
+	for _, field := range query.GroupBy {
+		columns = append(columns, "g_"+field)
+	}
+	var selects []string
+	for _, field := range query.GroupBy {
+		selects = append(selects, fmt.Sprintf("w.%s AS g_%s", field, field))
+	}
+	selects = append(selects, currency+" AS currency", salary+" AS salary")
+
+	aggregates := []string{
+		"COUNT(*) AS headcount",
+		"MIN(salary) AS min",
+		"MAX(salary) AS max",
+		"ROUND(AVG(salary)) AS avg",
+		"ROUND(CAST(percentile_cont(0.5) WITHIN GROUP (ORDER BY salary) AS numeric)) AS median",
+	}
+	for _, p := range query.Percentiles {
+		aggregates = append(aggregates, fmt.Sprintf("ROUND(CAST(percentile_cont(%g) WITHIN GROUP (ORDER BY salary) AS numeric)) AS p%d", float64(p)/100, p))
+	}
+
+	sql := fmt.Sprintf("SELECT %s, %s FROM (SELECT %s FROM %s w WHERE %s%s) s GROUP BY %s",
+		strings.Join(columns, ", "), strings.Join(aggregates, ", "),
+		strings.Join(selects, ", "), s.engine.Quote(table), fmt.Sprintf(liveWorker, "w"), filter,
+		strings.Join(columns, ", "))
+	rows, err := s.db.SQL(sql, append(rateArgs, args...)...).QueryString()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]SalaryStats, 0, len(rows))
+	for _, row := range rows {
+		values := make([]string, len(query.GroupBy))
+		for i, field := range query.GroupBy {
+			values[i] = row["g_"+field]
+		}
+		st := SalaryStats{Group: groupMap(query.GroupBy, values), Currency: row["currency"]}
+		for _, col := range []struct {
+			name string
+			dst  *int64
+		}{
+			{"headcount", &st.Headcount},
+			{"min", &st.Min},
+			{"max", &st.Max},
+			{"avg", &st.Avg},
+			{"median", &st.Median},
+		} {
+			if *col.dst, err = parseSQLInt(row[col.name]); err != nil {
+				return nil, err
+			}
+		}
+		for _, p := range query.Percentiles {
+			if st.Percentiles == nil {
+				st.Percentiles = make(map[string]int64)
+			}
+			name := "p" + strconv.Itoa(p)
+			if st.Percentiles[name], err = parseSQLInt(row[name]); err != nil {
+				return nil, err
+			}
+		}
+		stats = append(stats, st)
+	}
+	sortStats(stats, query.GroupBy)
+	return stats, nil
+}
+
+// headcountSQL counts the workers at the end of each month in one query,
+// joining them to a series of month numbers
+func (s *XormStore) headcountSQL(query HeadcountQuery) ([]HeadcountPoint, error) {
+	table := s.engine.TableInfo(new(Worker)).Name
+	filter, filterArgs := query.Filter.sql("w")
+	months := query.months()
+
+	columns := []string{"m.i"}
+	for _, field := range query.GroupBy {
+		columns = append(columns, "w."+field)
+	}
+	having := ""
+	if len(query.GroupBy) > 0 {
+		having = " HAVING COUNT(w.username) > 0"
+	}
+
+	// The month ends are compared in the time zone the times are stored in
+	end := "CAST(? AS timestamp) + (m.i + 1) * interval '1 month'"
+	sql := fmt.Sprintf("SELECT %s, COUNT(w.username) AS headcount FROM generate_series(0, CAST(? AS integer)) AS m(i)"+
+		" LEFT JOIN %s w ON w.created_at < %s AND (%s OR w.deleted_at >= %s)%s"+
+		" GROUP BY %s%s ORDER BY %s",
+		strings.Join(columns, ", "), s.engine.Quote(table), end, fmt.Sprintf(liveWorker, "w"), end, filter,
+		strings.Join(columns, ", "), having, strings.Join(columns, ", "))
+	from := query.From.In(s.engine.DatabaseTZ).Format("2006-01-02 15:04:05")
+	args := append([]interface{}{len(months) - 1, from, from}, filterArgs...)
+
+	rows, err := s.db.SQL(sql, args...).QueryString()
+	if err != nil {
+		return nil, err
+	}
+	points := make([]HeadcountPoint, 0, len(rows))
+	for _, row := range rows {
+		i, err := strconv.Atoi(row["i"])
+		if err != nil || i < 0 || i >= len(months) {
+			return nil, fmt.Errorf("unexpected month number %q", row["i"])
+		}
+		values := make([]string, len(query.GroupBy))
+		for j, field := range query.GroupBy {
+			values[j] = row[field]
+		}
+		count, err := parseSQLInt(row["headcount"])
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, HeadcountPoint{Month: months[i].Format(monthLayout), Group: groupMap(query.GroupBy, values), Headcount: count})
+	}
+	return points, nil
+}
+
+// parseSQLInt parses an integer the database may have written as a decimal
+func parseSQLInt(s string) (int64, error) {
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		s = s[:i]
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// Statistics handlers
+
+// statsParams reads the filter and group_by query parameters
+func statsParams(ctx *macaron.Context) (StatsFilter, []string, []FieldError) {
+	filter := StatsFilter{
+		Division:   ctx.Query("division"),
+		City:       ctx.Query("city"),
+		Position:   ctx.Query("position"),
+		Department: ctx.Query("department"),
+		Team:       ctx.Query("team"),
+	}
+	var groupBy []string
+	var errs []FieldError
+	if g := ctx.Query("group_by"); g != "" {
+		for _, field := range strings.Split(g, ",") {
+			field = strings.TrimSpace(field)
+			if !contains(statsFields, field) {
+				errs = append(errs, FieldError{Field: "group_by", Message: "must be a list of " + strings.Join(statsFields, ", ")})
+				break
+			}
+			if !contains(groupBy, field) {
+				groupBy = append(groupBy, field)
+			}
+		}
+	}
+	return filter, groupBy, errs
+}
+
+// showStats reports the headcount and salary statistics of the workers
+// selected by the filter parameters, per group of the group_by fields.
+// With the currency parameter the salaries are converted at the rates of
+// date, or else the groups are split by currency.
+func (s *Server) showStats(ctx *macaron.Context) error {
+	filter, groupBy, errs := statsParams(ctx)
+	percentiles := defaultPercentiles
+	if p := ctx.Query("percentiles"); p != "" {
+		percentiles = nil
+		for _, v := range strings.Split(p, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(v))
+			if err != nil || n < 1 || n > 99 {
+				errs = append(errs, FieldError{Field: "percentiles", Message: "must be a list of numbers from 1 to 99"})
+				break
+			}
+			percentiles = append(percentiles, n)
+		}
+	}
+	if len(errs) > 0 {
+		return validationFailed(errs...)
+	}
+
+	conv, err := s.currencyQuery(ctx)
+	if err != nil {
+		return err
+	}
+	stats, err := s.salaryStats(filter, groupBy, percentiles, conv)
+	if err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusOK, stats)
+}
+
+// salaryStats computes the statistics, converting the salaries with conv
+// unless it is nil
+func (s *Server) salaryStats(filter StatsFilter, groupBy []string, percentiles []int, conv *converter) ([]SalaryStats, error) {
+	query := StatsQuery{Filter: filter, GroupBy: groupBy, Percentiles: percentiles}
+	if conv != nil {
+		currencies, err := s.store.SalaryCurrencies(filter)
+		if err != nil {
+			return nil, err
+		}
+		query.Currency = conv.to
+		query.Rates = make(map[string]*big.Rat, len(currencies))
+		for _, from := range currencies {
+			rate, err := conv.rate(from)
+			if e, ok := err.(*noRateError); ok {
+				return nil, validationFailed(FieldError{Field: "currency", Message: e.Error()})
+			} else if err != nil {
+				return nil, err
+			}
+			// Per minor unit of from, in minor units of the currency
+			factor := new(big.Rat).Mul(rate, new(big.Rat).SetFrac64(minorFactor(conv.to), minorFactor(from)))
+			query.Rates[from] = factor
+		}
+	}
+	return s.store.SalaryStats(query)
+}
+
+// showHeadcount reports the number of workers at the end of every month
+// from the from parameter to the to parameter, the last 12 months by default
+func (s *Server) showHeadcount(ctx *macaron.Context) error {
+	filter, groupBy, errs := statsParams(ctx)
+
+	now := s.clock.Now().UTC()
+	to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, -11, 0)
+	for _, param := range []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"from", &from},
+		{"to", &to},
+	} {
+		if v := ctx.Query(param.name); v != "" {
+			month, err := time.Parse(monthLayout, v)
+			if err != nil {
+				errs = append(errs, FieldError{Field: param.name, Message: "must be a month formatted as YYYY-MM"})
+				continue
+			}
+			*param.dst = month
+		}
+	}
+	if len(errs) == 0 {
+		query := HeadcountQuery{From: from, To: to}
+		if n := query.monthCount(); n <= 0 {
+			errs = append(errs, FieldError{Field: "to", Message: "can't be before from"})
+		} else if n > maxStatsMonths {
+			errs = append(errs, FieldError{Field: "to", Message: fmt.Sprintf("must be at most %d months after from", maxStatsMonths)})
+		}
+	}
+	if len(errs) > 0 {
+		return validationFailed(errs...)
+	}
+
+	points, err := s.store.Headcount(HeadcountQuery{Filter: filter, GroupBy: groupBy, From: from, To: to})
+	if err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusOK, points)
+}
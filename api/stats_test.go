@@ -0,0 +1,90 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+// seedStats adds workers paid in two currencies, hired and deleted over a
+// few months, to the default ones hired on testTime
+func seedStats(t *testing.T, srvr *Server) {
+	t.Helper()
+
+	if err := srvr.store.SaveExchangeRates([]ExchangeRate{{Base: "USD", Quote: "BDT", Date: "2019-01-01", Rate: "84.5"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, worker := range []Worker{
+		{Username: "rahim", FirstName: "Rahim", LastName: "Uddin", City: "Madaripur", Division: "Dhaka", Position: "Software Engineer", Salary: 6000, Currency: "BDT", Department: "engineering",
+			CreatedAt: time.Date(2018, 11, 15, 10, 0, 0, 0, time.UTC)},
+		{Username: "karim", FirstName: "Karim", LastName: "Uddin", City: "Khulna", Division: "Khulna", Position: "Software Engineer", Salary: 8000, Currency: "BDT",
+			CreatedAt: time.Date(2019, 1, 10, 10, 0, 0, 0, time.UTC)},
+		{Username: "john", FirstName: "John", LastName: "Doe", City: "Dhaka", Division: "Dhaka", Position: "Software Engineer", Salary: 100, Currency: "USD", Department: "engineering",
+			CreatedAt: time.Date(2019, 2, 1, 10, 0, 0, 0, time.UTC)},
+	} {
+		worker := worker
+		worker.UpdatedAt = worker.CreatedAt
+		if err := srvr.store.CreateWorker(&worker); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Deleted workers keep counting for the months before they left
+	engine := srvr.store.(*XormStore).engine
+	if _, err := engine.Exec("UPDATE worker SET deleted_at = ? WHERE username = ?", time.Date(2019, 2, 10, 0, 0, 0, 0, time.UTC), "karim"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	srvr := newTestServer(t)
+	seedStats(t, srvr)
+
+	for _, data := range []testData{
+		{"show_stats", "GET", "/appscode/stats", 200, nil},
+		{"show_stats_by_division_in_bdt", "GET", "/appscode/stats?group_by=division,department&currency=BDT", 200, nil},
+		{"show_stats_filtered", "GET", "/appscode/stats?division=Dhaka&percentiles=50,99&currency=BDT&date=2019-03-01", 200, nil},
+		{"show_stats_bad_group_by", "GET", "/appscode/stats?group_by=salary", 422, nil},
+		{"show_stats_bad_percentiles", "GET", "/appscode/stats?percentiles=0,100", 422, nil},
+		{"show_stats_no_rate", "GET", "/appscode/stats?currency=EUR", 422, nil},
+		{"show_headcount", "GET", "/appscode/stats/headcount?from=2018-11&to=2019-04", 200, nil},
+		{"show_headcount_by_division", "GET", "/appscode/stats/headcount?from=2019-01&to=2019-03&group_by=division", 200, nil},
+		{"show_headcount_default_range", "GET", "/appscode/stats/headcount?division=Khulna", 200, nil},
+		{"show_headcount_bad_range", "GET", "/appscode/stats/headcount?from=2019-04&to=2019-01", 422, nil},
+		{"show_headcount_too_long", "GET", "/appscode/stats/headcount?from=0001-01&to=9999-12", 422, nil},
+	} {
+		runTest(t, srvr, data)
+	}
+}
+
+func TestHeadcountMonthCount(t *testing.T) {
+	month := func(v string) time.Time {
+		m, err := time.Parse(monthLayout, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	for _, test := range [][2]string{{"2018-11", "2019-04"}, {"2019-03", "2019-03"}, {"2019-04", "2019-01"}, {"2010-01", "2019-12"}} {
+		query := HeadcountQuery{From: month(test[0]), To: month(test[1])}
+		if got, expected := query.monthCount(), len(query.months()); got != expected && (got > 0 || expected > 0) {
+			t.Errorf("%s to %s: got %d months expected %d", test[0], test[1], got, expected)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []int64{10, 20, 30, 40}
+	for _, test := range []struct {
+		p        int
+		expected int64
+	}{
+		{1, 10}, {25, 18}, {50, 25}, {90, 37}, {99, 40},
+	} {
+		if got := percentile(sorted, test.p); got != test.expected {
+			t.Errorf("p%d: got %d expected %d", test.p, got, test.expected)
+		}
+	}
+	if got := percentile([]int64{7}, 90); got != 7 {
+		t.Errorf("p90 of one value: got %d expected 7", got)
+	}
+}
@@ -0,0 +1,288 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-xorm/xorm"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrAlreadyExists = errors.New("already exists")
+)
+
+// uniqueViolation tells if err refused an insert for a key already taken.
+// Postgres tells it by its code, SQLite and MySQL by their messages only.
+func uniqueViolation(err error) bool {
+	if e, ok := err.(*pq.Error); ok {
+		return e.Code.Name() == "unique_violation"
+	}
+	return err != nil && (strings.Contains(err.Error(), "UNIQUE constraint failed") || strings.Contains(err.Error(), "Duplicate entry"))
+}
+
+// Store persists everything the Server serves
+type Store interface {
+	WorkerStore
+	LocationStore
+	DepartmentStore
+	TeamStore
+	PositionStore
+	EmploymentStore
+	ExchangeRateStore
+	StatsStore
+	EventStore
+	WebhookStore
+	IdempotencyStore
+	RateLimitStore
+	ImportStore
+
+	// InTransaction runs fn with a Store bound to a new transaction,
+	// which is committed if fn returns nil and rolled back otherwise
+	InTransaction(fn func(tx Store) error) error
+}
+
+// WorkerFilter selects workers by the non-empty fields
+type WorkerFilter struct {
+	Department string
+	Team       string
+	Manager    string
+	Position   string
+	// Usernames and Managers, when not nil, select the workers with one
+	// of the usernames, or reporting to one of the managers. They are
+	// for ListWorkers only.
+	Usernames []string
+	Managers  []string
+
+	// After and Limit page through the workers in the order of their
+	// usernames: ListWorkers returns up to Limit workers coming after
+	// the username After. Zero Limit lists all of them.
+	After string
+	Limit int
+}
+
+// WorkerStore persists worker profiles
+type WorkerStore interface {
+	ListWorkers(filter WorkerFilter) ([]Worker, error)
+	// IterateWorkers calls fn with the selected workers in the order of
+	// their usernames, reading them from the database as it goes
+	IterateWorkers(filter WorkerFilter, fn func(*Worker) error) error
+	// GetWorker returns ErrNotFound if no live worker has the username
+	GetWorker(username string) (*Worker, error)
+	// CreateWorker returns ErrAlreadyExists if the username was ever taken,
+	// including by a deleted worker
+	CreateWorker(worker *Worker) error
+	UpdateWorker(worker *Worker) error
+	DeleteWorker(username string) error
+	// RestoreWorker brings a deleted worker back. It returns ErrNotFound
+	// if no worker ever had the username, and ErrAlreadyExists if the
+	// worker isn't deleted.
+	RestoreWorker(username string) error
+}
+
+// XormStore is a Store backed by a xorm engine
+type XormStore struct {
+	engine *xorm.Engine
+	// db is the engine, or the session of the transaction the store is bound to
+	db xorm.Interface
+}
+
+var _ Store = &XormStore{}
+
+func NewXormStore(engine *xorm.Engine) *XormStore {
+	return &XormStore{engine: engine, db: engine}
+}
+
+// NewXormEngine connects to the database and writes the SQL log to logPath
+func NewXormEngine(driver, dataSource, logPath string) (*xorm.Engine, error) {
+	engine, err := xorm.NewEngine(driver, dataSource)
+	if err != nil {
+		return nil, err
+	}
+
+	if logPath != "" {
+		logFile, err := os.Create(logPath)
+		if err != nil {
+			log.Println(err)
+		} else {
+			logger := xorm.NewSimpleLogger(logFile)
+			logger.ShowSQL(true)
+			engine.SetLogger(logger)
+		}
+	}
+
+	if loc, err := time.LoadLocation("Asia/Dhaka"); err != nil {
+		log.Println(err)
+	} else {
+		engine.TZLocation = loc
+	}
+	return engine, nil
+}
+
+// tables returns a bean of every table used by the store
+func tables() []interface{} {
+	return []interface{}{
+		new(Worker),
+		new(Country),
+		new(Division),
+		new(City),
+		new(Department),
+		new(Team),
+		new(Position),
+		new(EmploymentRecord),
+		new(ExchangeRate),
+		new(WorkerEvent),
+		new(Webhook),
+		new(WebhookDelivery),
+		new(IdempotentResponse),
+		new(RateLimitBucket),
+		new(ImportJob),
+	}
+}
+
+// Sync creates or updates the tables used by the store
+func (s *XormStore) Sync() error {
+	return s.engine.Sync2(tables()...)
+}
+
+func (s *XormStore) InTransaction(fn func(tx Store) error) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		return fn(&XormStore{engine: s.engine, db: session})
+	})
+}
+
+func (s *XormStore) ListWorkers(filter WorkerFilter) ([]Worker, error) {
+	cond := &Worker{Department: filter.Department, Team: filter.Team, Manager: filter.Manager, Position: filter.Position}
+	workers := make([]Worker, 0)
+	if filter.After == "" && filter.Limit == 0 && filter.Usernames == nil && filter.Managers == nil {
+		if err := s.db.Find(&workers, cond); err != nil {
+			return nil, err
+		}
+		return workers, nil
+	}
+	if filter.Usernames != nil && len(filter.Usernames) == 0 || filter.Managers != nil && len(filter.Managers) == 0 {
+		return workers, nil
+	}
+
+	query := s.db.Asc("username").Where("username > ?", filter.After)
+	if filter.Usernames != nil {
+		query = query.In("username", filter.Usernames)
+	}
+	if filter.Managers != nil {
+		query = query.In("manager", filter.Managers)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	if err := query.Find(&workers, cond); err != nil {
+		return nil, err
+	}
+	return workers, nil
+}
+
+func (s *XormStore) IterateWorkers(filter WorkerFilter, fn func(*Worker) error) error {
+	cond := &Worker{Department: filter.Department, Team: filter.Team, Manager: filter.Manager, Position: filter.Position}
+	return s.db.Asc("username").Iterate(cond, func(_ int, bean interface{}) error {
+		return fn(bean.(*Worker))
+	})
+}
+
+func (s *XormStore) GetWorker(username string) (*Worker, error) {
+	worker := &Worker{Username: username}
+	exist, err := s.db.Get(worker)
+	if err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, ErrNotFound
+	}
+	return worker, nil
+}
+
+func (s *XormStore) CreateWorker(worker *Worker) error {
+	// Check the deleted accounts too, usernames are never reused
+	exist, err := s.db.Unscoped().Exist(&Worker{Username: worker.Username})
+	if err != nil {
+		return err
+	} else if exist {
+		return ErrAlreadyExists
+	}
+
+	return s.inTransaction(func(session *xorm.Session) error {
+		_, err := session.Insert(worker)
+		return err
+	})
+}
+
+func (s *XormStore) UpdateWorker(worker *Worker) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		affected, err := session.ID(worker.Username).AllCols().Update(worker)
+		if err != nil {
+			return err
+		} else if affected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (s *XormStore) DeleteWorker(username string) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		affected, err := session.ID(username).Delete(new(Worker))
+		if err != nil {
+			return err
+		} else if affected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (s *XormStore) RestoreWorker(username string) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		worker := &Worker{Username: username}
+		exist, err := session.Unscoped().Get(worker)
+		if err != nil {
+			return err
+		} else if !exist {
+			return ErrNotFound
+		} else if worker.DeletedAt.IsZero() {
+			return ErrAlreadyExists
+		}
+		_, err = session.Exec("UPDATE worker SET deleted_at = NULL WHERE username = ?", username)
+		return err
+	})
+}
+
+// boundToTransaction tells if the store is bound to a transaction, see
+// notifyCommitted
+func (s *XormStore) boundToTransaction() bool {
+	_, ok := s.db.(*xorm.Session)
+	return ok
+}
+
+// inTransaction runs fn in a new session, committing if it succeeds
+// and rolling back otherwise. A store already bound to a transaction
+// runs fn in it.
+func (s *XormStore) inTransaction(fn func(session *xorm.Session) error) error {
+	if session, ok := s.db.(*xorm.Session); ok {
+		return fn(session)
+	}
+
+	session := s.engine.NewSession()
+	defer session.Close()
+
+	if err := session.Begin(); err != nil {
+		return err
+	}
+	if err := fn(session); err != nil {
+		if rerr := session.Rollback(); rerr != nil {
+			log.Println(rerr)
+		}
+		return err
+	}
+	return session.Commit()
+}
@@ -0,0 +1,261 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-xorm/xorm"
+	"gopkg.in/macaron.v1"
+)
+
+// Team is a group of workers inside a department
+type Team struct {
+	ID         string `json:"id" xorm:"pk 'id'" validate:"required_on=create,min=2,max=32,charset=slug"`
+	Name       string `json:"name" xorm:"not null" validate:"required,max=64,charset=title"`
+	Department string `json:"department" xorm:"not null index" validate:"required,max=32"`
+	// Lead is the username of the worker leading the team
+	Lead string `json:"lead" xorm:"index" validate:"max=32"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int       `json:"version" xorm:"version"`
+}
+
+// TeamFilter selects teams by the non-empty fields
+type TeamFilter struct {
+	Department string
+	Lead       string
+}
+
+// TeamStore persists teams
+type TeamStore interface {
+	ListTeams(filter TeamFilter) ([]Team, error)
+	// GetTeam returns ErrNotFound if no team has the id
+	GetTeam(id string) (*Team, error)
+	// CreateTeam returns ErrAlreadyExists if the id is taken
+	CreateTeam(team *Team) error
+	UpdateTeam(team *Team) error
+	DeleteTeam(id string) error
+}
+
+func (s *XormStore) ListTeams(filter TeamFilter) ([]Team, error) {
+	teams := make([]Team, 0)
+	if err := s.db.Asc("id").Find(&teams, &Team{Department: filter.Department, Lead: filter.Lead}); err != nil {
+		return nil, err
+	}
+	return teams, nil
+}
+
+func (s *XormStore) GetTeam(id string) (*Team, error) {
+	team := &Team{ID: id}
+	exist, err := s.db.Get(team)
+	if err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, ErrNotFound
+	}
+	return team, nil
+}
+
+func (s *XormStore) CreateTeam(team *Team) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		exist, err := session.Exist(&Team{ID: team.ID})
+		if err != nil {
+			return err
+		} else if exist {
+			return ErrAlreadyExists
+		}
+		_, err = session.Insert(team)
+		return err
+	})
+}
+
+func (s *XormStore) UpdateTeam(team *Team) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		affected, err := session.ID(team.ID).AllCols().Update(team)
+		if err != nil {
+			return err
+		} else if affected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (s *XormStore) DeleteTeam(id string) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		affected, err := session.ID(id).Delete(new(Team))
+		if err != nil {
+			return err
+		} else if affected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// Team handlers
+
+func (s *Server) showAllTeams(ctx *macaron.Context) error {
+	teams, err := s.store.ListTeams(TeamFilter{Department: ctx.Query("department")})
+	if err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusOK, teams)
+}
+
+func (s *Server) showTeam(ctx *macaron.Context) error {
+	team, err := s.getTeam(s.store, ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusOK, team)
+}
+
+func (s *Server) showTeamWorkers(ctx *macaron.Context) error {
+	if _, err := s.getTeam(s.store, ctx.Params("id")); err != nil {
+		return err
+	}
+	workers, err := s.store.ListWorkers(WorkerFilter{Team: ctx.Params("id")})
+	if err != nil {
+		return err
+	}
+	if err := s.convertWorkers(ctx, workers); err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusOK, workers)
+}
+
+func (s *Server) addTeam(ctx *macaron.Context) error {
+	var team Team
+	if err := s.decode(ctx, &team); err != nil {
+		return err
+	}
+	errs, err := s.validateTeam(&team, opCreate)
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return validationFailed(errs...)
+	}
+
+	team.CreatedAt = s.clock.Now()
+	team.UpdatedAt = team.CreatedAt
+	team.Version = 0
+
+	if err := s.store.CreateTeam(&team); err == ErrAlreadyExists {
+		return conflict("Team %q already exists", team.ID)
+	} else if err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusCreated, team)
+}
+
+// updateTeam refuses to move a team that has workers to another
+// department, as its workers would be left in the old one
+func (s *Server) updateTeam(ctx *macaron.Context) error {
+	team, err := s.getTeam(s.store, ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	newTeam := new(Team)
+	if err := s.decode(ctx, newTeam); err != nil {
+		return err
+	}
+	errs, err := s.validateTeam(newTeam, opUpdate)
+	if err != nil {
+		return err
+	}
+	if newTeam.ID != "" && newTeam.ID != team.ID {
+		errs = append(errs, FieldError{Field: "id", Message: "can't be changed"})
+	}
+	if len(errs) > 0 {
+		return validationFailed(errs...)
+	}
+
+	if newTeam.Department != team.Department {
+		workers, err := s.store.ListWorkers(WorkerFilter{Team: team.ID})
+		if err != nil {
+			return err
+		}
+		if len(workers) > 0 {
+			return conflict("Team %q still has %d workers in the %s department", team.ID, len(workers), team.Department)
+		}
+	}
+
+	team.Name = newTeam.Name
+	team.Department = newTeam.Department
+	team.Lead = newTeam.Lead
+	team.UpdatedAt = s.clock.Now()
+
+	if err := s.store.UpdateTeam(team); err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusOK, team)
+}
+
+// deleteTeam deletes the team and takes its workers out of it,
+// they stay in the department
+func (s *Server) deleteTeam(ctx *macaron.Context) error {
+	if err := checkAcceptable(ctx, StatusMessage{}); err != nil {
+		return err
+	}
+	id := ctx.Params("id")
+
+	err := s.store.InTransaction(func(tx Store) error {
+		if _, err := s.getTeam(tx, id); err != nil {
+			return err
+		}
+		workers, err := tx.ListWorkers(WorkerFilter{Team: id})
+		if err != nil {
+			return err
+		}
+
+		now := s.clock.Now()
+		for i := range workers {
+			workers[i].Team = ""
+			workers[i].UpdatedAt = now
+			if err := s.saveWorker(tx, &workers[i], "team "+id+" deleted"); err != nil {
+				return err
+			}
+		}
+		return tx.DeleteTeam(id)
+	})
+	if err != nil {
+		return err
+	}
+	s.notifyChange()
+	return s.renderDeleted(ctx)
+}
+
+// getTeam is GetTeam with the 404 worded for the client
+func (s *Server) getTeam(store Store, id string) (*Team, error) {
+	team, err := store.GetTeam(id)
+	if err == ErrNotFound {
+		return nil, notFound("Team %q does not exist", id)
+	}
+	return team, err
+}
+
+// validateTeam checks the team's fields for op, and that its department
+// and lead exist
+func (s *Server) validateTeam(team *Team, op string) ([]FieldError, error) {
+	errs := validate(team, op)
+
+	if team.Department != "" && !hasFieldError(errs, "department") {
+		if _, err := s.store.GetDepartment(team.Department); err == ErrNotFound {
+			errs = append(errs, FieldError{Field: "department", Message: "is not a known department"})
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if team.Lead != "" && !hasFieldError(errs, "lead") {
+		if _, err := s.store.GetWorker(team.Lead); err == ErrNotFound {
+			errs = append(errs, FieldError{Field: "lead", Message: "is not a known worker"})
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return errs, nil
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTeams(t *testing.T) {
+	srvr := newTestServer(t)
+	for _, department := range []Department{{ID: "engineering", Name: "Engineering"}, {ID: "sales", Name: "Sales"}} {
+		department := department
+		if err := srvr.store.CreateDepartment(&department); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	test := []testData{
+		{
+			"add_team_unknown_references",
+			"POST",
+			"/appscode/teams",
+			422,
+			strings.NewReader(`{"id":"platform","name":"Platform","department":"research","lead":"nobody"}`),
+		},
+		{
+			"add_team",
+			"POST",
+			"/appscode/teams",
+			201,
+			strings.NewReader(`{"id":"platform","name":"Platform","department":"engineering","lead":"masud"}`),
+		},
+		{
+			"add_team_conflict",
+			"POST",
+			"/appscode/teams",
+			409,
+			strings.NewReader(`{"id":"platform","name":"Platform","department":"engineering"}`),
+		},
+		{
+			"update_worker_team",
+			"PUT",
+			"/appscode/workers/fahim",
+			201,
+			strings.NewReader(`{"firstname":"Fahim","lastname":"Abrar","city":"Chattogram","division":"Chattogram","position":"Software Engineer","salary":5500,"team":"platform"}`),
+		},
+		{
+			"update_worker_team_of_other_department",
+			"PUT",
+			"/appscode/workers/tahsin",
+			422,
+			strings.NewReader(`{"firstname":"Tahsin","lastname":"Rahman","city":"Chattogram","division":"Chattogram","position":"Software Engineer","salary":5500,"department":"sales","team":"platform"}`),
+		},
+		{
+			"show_team_workers",
+			"GET",
+			"/appscode/teams/platform/workers",
+			200,
+			nil,
+		},
+		{
+			"show_department_teams",
+			"GET",
+			"/appscode/departments/engineering/teams",
+			200,
+			nil,
+		},
+		{
+			"update_team_moving_workers",
+			"PUT",
+			"/appscode/teams/platform",
+			409,
+			strings.NewReader(`{"name":"Platform","department":"sales","lead":"masud"}`),
+		},
+		{
+			"delete_team_lead",
+			"DELETE",
+			"/appscode/workers/masud",
+			200,
+			nil,
+		},
+		{
+			"show_team_without_lead",
+			"GET",
+			"/appscode/teams/platform",
+			200,
+			nil,
+		},
+		{
+			"delete_team",
+			"DELETE",
+			"/appscode/teams/platform",
+			200,
+			nil,
+		},
+		{
+			"show_worker_of_deleted_team",
+			"GET",
+			"/appscode/workers/fahim",
+			200,
+			nil,
+		},
+		{
+			"show_deleted_team",
+			"GET",
+			"/appscode/teams/platform",
+			404,
+			nil,
+		},
+	}
+	for _, data := range test {
+		runTest(t, srvr, data)
+	}
+}
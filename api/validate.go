@@ -0,0 +1,272 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/macaron.v1"
+)
+
+// Validation operations, a rule can be limited to one of them
+const (
+	opCreate = "create"
+	opUpdate = "update"
+)
+
+// Patterns usable with the charset rule
+var charsets = map[string]*regexp.Regexp{
+	// lowercase ASCII, starting with a letter
+	"username": regexp.MustCompile(`^[a-z][a-z0-9._-]*$`),
+	// ISO 4217 currency codes
+	"currency": regexp.MustCompile(`^[A-Z]{3}$`),
+	// lowercase ASCII words joined by dashes, for IDs chosen by the client
+	"slug": regexp.MustCompile(`^[a-z][a-z0-9]*(-[a-z0-9]+)*$`),
+	// Latin and Bengali letters with the punctuation found in names
+	"name": regexp.MustCompile(`^[\p{Latin}\p{Bengali}][\p{Latin}\p{Bengali}\p{M} .'-]*$`),
+	// names plus digits, for titles like "Software Engineer II"
+	"title": regexp.MustCompile(`^[\p{Latin}\p{Bengali}][\p{Latin}\p{Bengali}\p{M}0-9 .,'&()/-]*$`),
+}
+
+// validate checks the struct v against the rules in its validate tags
+// and returns one FieldError per failing field. The rules are
+//
+//	required          the field must not be empty
+//	required_on=op    the field must not be empty when validating for op
+//	min=n, max=n      bounds on the length of a string or the value of a number
+//	oneof=a b c       the field must be one of the space separated values
+//	charset=name      the string must match one of the charsets patterns
+//	date              the string must be a date like 2006-01-02
+//
+// Empty fields skip every rule but the required ones.
+func validate(v interface{}, op string) []FieldError {
+	var errs []FieldError
+
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+		if msg := checkRules(rv.Field(i), tag, op); msg != "" {
+			errs = append(errs, FieldError{Field: jsonName(field), Message: msg})
+		}
+	}
+	return errs
+}
+
+func checkRules(value reflect.Value, tag, op string) string {
+	empty := isZero(value)
+	for _, rule := range strings.Split(tag, ",") {
+		name, arg := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, arg = rule[:i], rule[i+1:]
+		}
+
+		switch name {
+		case "required":
+			if empty {
+				return "must be provided"
+			}
+		case "required_on":
+			if empty && arg == op {
+				return "must be provided"
+			}
+		}
+		if empty {
+			continue
+		}
+
+		switch name {
+		case "min", "max":
+			limit, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				panic(fmt.Sprintf("validate: bad %s rule %q", name, rule))
+			}
+			if msg := checkBound(value, name, limit); msg != "" {
+				return msg
+			}
+		case "oneof":
+			options := strings.Fields(arg)
+			if !contains(options, fmt.Sprint(value.Interface())) {
+				return "must be one of " + strings.Join(options, ", ")
+			}
+		case "charset":
+			pattern, ok := charsets[arg]
+			if !ok {
+				panic(fmt.Sprintf("validate: unknown charset %q", arg))
+			}
+			if !pattern.MatchString(value.String()) {
+				return "contains characters not allowed in a " + arg
+			}
+		case "date":
+			if _, err := time.Parse(dateLayout, value.String()); err != nil {
+				return "must be a date formatted as YYYY-MM-DD"
+			}
+		}
+	}
+	return ""
+}
+
+func checkBound(value reflect.Value, rule string, limit int64) string {
+	switch value.Kind() {
+	case reflect.String:
+		length := int64(utf8.RuneCountInString(value.String()))
+		if rule == "min" && length < limit {
+			return fmt.Sprintf("must be at least %d characters long", limit)
+		} else if rule == "max" && length > limit {
+			return fmt.Sprintf("must be at most %d characters long", limit)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if rule == "min" && value.Int() < limit {
+			return fmt.Sprintf("must be at least %d", limit)
+		} else if rule == "max" && value.Int() > limit {
+			return fmt.Sprintf("must be at most %d", limit)
+		}
+	}
+	return ""
+}
+
+func isZero(value reflect.Value) bool {
+	return reflect.DeepEqual(value.Interface(), reflect.Zero(value.Type()).Interface())
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonKind names the JSON type a Go type is decoded from
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	}
+	return "a string"
+}
+
+func jsonName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// errBodyTooLarge is returned by limitedBody once the limit is crossed
+var errBodyTooLarge = fmt.Errorf("request body too large")
+
+type limitedBody struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// decode strictly decodes the request body into v from the format of its
+// Content-Type, JSON when it has none: unknown fields, trailing data and
+// bodies above the server's limit are all rejected
+func (s *Server) decode(ctx *macaron.Context, v interface{}) error {
+	mediaType := mediaJSON
+	if header := ctx.Req.Header.Get("Content-Type"); header != "" {
+		mediaType = canonicalMedia(header)
+	}
+	if !contains(requestTypes, mediaType) {
+		return NewError(http.StatusUnsupportedMediaType, ProblemUnsupportedMediaType, "The request body can be sent as "+strings.Join(requestTypes, ", "))
+	}
+
+	// One byte over the limit tells a body of exactly maxBodyBytes from a larger one
+	body := &limitedBody{r: ctx.Req.Request.Body, remaining: s.maxBodyBytes + 1}
+	if mediaType == mediaJSON {
+		err := decodeJSON(body, v)
+		if err == nil && body.remaining <= 0 {
+			err = errBodyTooLarge
+		}
+		return decodeError(err, s.maxBodyBytes)
+	}
+
+	// The other formats are turned into JSON, and decoded from it
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		return decodeError(err, s.maxBodyBytes)
+	}
+	var data []byte
+	switch mediaType {
+	case mediaYAML:
+		data, err = yamlToJSON(raw)
+	case mediaXML:
+		data, err = xmlToJSON(bytes.NewReader(raw), reflect.TypeOf(v))
+	case mediaMsgpack:
+		data, err = msgpackToJSON(raw)
+	}
+	if err != nil {
+		return badRequest("Error decoding provided data: %v", err)
+	}
+	return decodeError(decodeJSON(bytes.NewReader(data), v), s.maxBodyBytes)
+}
+
+func decodeJSON(r io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err == nil && decoder.More() {
+		err = fmt.Errorf("unexpected data after the JSON value")
+	}
+	return err
+}
+
+// decodeError turns a JSON decoding error into a 400, 413 or 422 Error
+func decodeError(err error, maxBodyBytes int64) error {
+	if err == nil {
+		return nil
+	}
+	if err == errBodyTooLarge {
+		return NewError(http.StatusRequestEntityTooLarge, ProblemPayloadTooLarge, fmt.Sprintf("The request body is larger than %d bytes", maxBodyBytes))
+	}
+
+	switch e := err.(type) {
+	case *json.UnmarshalTypeError:
+		return validationFailed(FieldError{Field: e.Field, Message: "must be " + jsonKind(e.Type)})
+	case *json.SyntaxError:
+		return badRequest("Error decoding provided data: %v", err)
+	}
+	if strings.HasPrefix(err.Error(), "json: unknown field ") {
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		return validationFailed(FieldError{Field: field, Message: "is not a known field"})
+	}
+	return badRequest("Error decoding provided data: %v", err)
+}
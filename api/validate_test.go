@@ -0,0 +1,57 @@
+package api
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	valid := Worker{
+		Username:  "masud",
+		FirstName: "Masudur",
+		LastName:  "Rahman",
+		City:      "Madaripur",
+		Division:  "Dhaka",
+		Position:  "Software Engineer",
+		Salary:    5500,
+	}
+
+	test := []struct {
+		name   string
+		change func(w *Worker)
+		op     string
+		errs   []FieldError
+	}{
+		{"valid", func(w *Worker) {}, opCreate, nil},
+		{"bengali names", func(w *Worker) { w.FirstName, w.LastName = "মাসুদুর", "রহমান" }, opCreate, nil},
+		{"names with punctuation", func(w *Worker) { w.FirstName, w.LastName = "Mary-Jane", "O'Neil Jr." }, opCreate, nil},
+		{"username optional on update", func(w *Worker) { w.Username = "" }, opUpdate, nil},
+		{"username required on create", func(w *Worker) { w.Username = "" }, opCreate,
+			[]FieldError{{"username", "must be provided"}}},
+		{"username too short", func(w *Worker) { w.Username = "ms" }, opCreate,
+			[]FieldError{{"username", "must be at least 3 characters long"}}},
+		{"username uppercase", func(w *Worker) { w.Username = "Masud" }, opCreate,
+			[]FieldError{{"username", "contains characters not allowed in a username"}}},
+		{"digits in name", func(w *Worker) { w.FirstName = "Masud2" }, opUpdate,
+			[]FieldError{{"firstname", "contains characters not allowed in a name"}}},
+		{"name too long", func(w *Worker) { w.LastName = string(make([]rune, 65)) }, opUpdate,
+			[]FieldError{{"lastname", "must be at most 64 characters long"}}},
+		{"division too long", func(w *Worker) { w.Division = strings.Repeat("Dhaka", 13) }, opCreate,
+			[]FieldError{{"division", "must be at most 64 characters long"}}},
+		{"negative salary", func(w *Worker) { w.Salary = -1 }, opCreate,
+			[]FieldError{{"salary", "must be at least 0"}}},
+		{"salary too high", func(w *Worker) { w.Salary = 1000000000001 }, opCreate,
+			[]FieldError{{"salary", "must be at most 1000000000000"}}},
+		{"several fields", func(w *Worker) { w.City, w.Position = "", "" }, opUpdate,
+			[]FieldError{{"city", "must be provided"}, {"position", "must be provided"}}},
+	}
+
+	for _, data := range test {
+		worker := valid
+		data.change(&worker)
+		if errs := validate(worker, data.op); !reflect.DeepEqual(errs, data.errs) {
+			t.Errorf("%s: got %v expected %v", data.name, errs, data.errs)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/macaron.v1"
+)
+
+const (
+	// watchKeepAlive is how often an idle watch sends a comment or a
+	// ping, so proxies keep it open and a gone client is noticed
+	watchKeepAlive = 15 * time.Second
+	// watchWriteTimeout is how long a client may take to read an event
+	watchWriteTimeout = 10 * time.Second
+)
+
+// watchWorkerChanges streams the changes of the workers as server-sent
+// events, or over a WebSocket when the request asks to upgrade to one. A
+// client resumes after the last event it got with the Last-Event-ID header
+// or the resource_version parameter.
+func (s *Server) watchWorkerChanges(ctx *macaron.Context) error {
+	from, err := watchFrom(ctx)
+	if err != nil {
+		return err
+	}
+	var accept string
+	upgrade := isWebSocketUpgrade(ctx.Req.Request)
+	if upgrade {
+		if accept, err = checkWebSocketUpgrade(ctx); err != nil {
+			return err
+		}
+	}
+	watch, err := s.watchWorkers(from, workerFilter(ctx))
+	if err != nil {
+		return err
+	}
+
+	// The connection is taken over so the watch outlives the write timeout
+	// of the server, which is set on it when the request is read
+	hijacker, ok := ctx.Resp.(http.Hijacker)
+	if !ok {
+		watch.stop()
+		return internalError(fmt.Errorf("the response writer can't be hijacked"))
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		watch.stop()
+		return internalError(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Time{})
+
+	if upgrade {
+		err = s.watchOverWebSocket(ctx, watch, conn, rw, accept)
+	} else {
+		err = s.watchOverSSE(ctx, watch, conn, rw)
+	}
+	if err != nil && err != errWatchClosed && err != errWatchDropped {
+		s.logger.Println("watch", ctx.Data["RequestID"], err)
+	}
+	return nil
+}
+
+// watchFrom reads the resource version a watch resumes from, the
+// Last-Event-ID header EventSource sends on reconnecting wins over the
+// parameter of the URL
+func watchFrom(ctx *macaron.Context) (int64, error) {
+	field, value := "resource_version", ctx.Query("resource_version")
+	if id := ctx.Req.Header.Get("Last-Event-ID"); id != "" {
+		field, value = "Last-Event-ID", id
+	}
+	if value == "" {
+		return 0, nil
+	}
+	from, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || from < 0 {
+		return 0, validationFailed(FieldError{Field: field, Message: "must be a resource version"})
+	}
+	return from, nil
+}
+
+// watchOverSSE writes the events as text/event-stream, the ID of an event
+// is its resource version
+func (s *Server) watchOverSSE(ctx *macaron.Context, watch *workerWatch, conn net.Conn, rw *bufio.ReadWriter) error {
+	header := ctx.Resp.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "close")
+	header.Set("X-Accel-Buffering", "no")
+	if err := writeResponseHead(conn, rw.Writer, http.StatusOK, header); err != nil {
+		return err
+	}
+
+	// The client sends nothing more, reading ends when it goes away
+	done := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, rw.Reader)
+		close(done)
+	}()
+
+	return watch.run(done, watchKeepAlive, func(event *WorkerEvent) error {
+		conn.SetWriteDeadline(time.Now().Add(watchWriteTimeout))
+		if event == nil {
+			rw.WriteString(": keep-alive\n\n")
+		} else {
+			data, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(rw, "id: %d\nevent: %s\ndata: %s\n\n", event.ResourceVersion, event.Type, data)
+		}
+		return rw.Flush()
+	})
+}
+
+// watchOverWebSocket sends every event as a text message, the way it is
+// written in the data of a server-sent event
+func (s *Server) watchOverWebSocket(ctx *macaron.Context, watch *workerWatch, conn net.Conn, rw *bufio.ReadWriter, accept string) error {
+	header := ctx.Resp.Header()
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Accept", accept)
+	if err := writeResponseHead(conn, rw.Writer, http.StatusSwitchingProtocols, header); err != nil {
+		return err
+	}
+
+	ws := &wsConn{conn: conn, rw: rw}
+	done := make(chan struct{})
+	go func() {
+		ws.readFrames()
+		close(done)
+	}()
+
+	err := watch.run(done, watchKeepAlive, func(event *WorkerEvent) error {
+		if event == nil {
+			return ws.writeFrame(wsPing, nil)
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		return ws.writeFrame(wsText, data)
+	})
+	switch err {
+	case nil:
+	case errWatchClosed:
+		ws.writeClose(wsGoingAway, "The server is shutting down")
+	case errWatchDropped:
+		ws.writeClose(wsTryAgainLater, "The watch fell behind the changes, resume from the last resource version")
+	default:
+		ws.writeClose(wsInternalError, "The server could not continue the watch")
+	}
+	return err
+}
+
+// writeResponseHead writes the status line and the header of the response
+// of a hijacked connection
+func writeResponseHead(conn net.Conn, w *bufio.Writer, status int, header http.Header) error {
+	conn.SetWriteDeadline(time.Now().Add(watchWriteTimeout))
+	fmt.Fprintf(w, "HTTP/1.1 %d %s\r\n", status, http.StatusText(status))
+	header.Write(w)
+	w.WriteString("\r\n")
+	return w.Flush()
+}
+
+// isWebSocketUpgrade tells if the request asks to upgrade the connection
+// to a WebSocket
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+func headerHasToken(header http.Header, key, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(key)] {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkWebSocketUpgrade checks the handshake of a WebSocket and returns
+// the key to accept it with. Browsers send the credentials they have
+// for the server along with the handshake of any page, so only the pages
+// of the server itself may open one.
+func checkWebSocketUpgrade(ctx *macaron.Context) (string, error) {
+	r := ctx.Req.Request
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		ctx.Resp.Header().Set("Sec-WebSocket-Version", "13")
+		return "", badRequest("Only version 13 of the WebSocket protocol is supported")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !validWebSocketKey(key) {
+		return "", badRequest("The Sec-WebSocket-Key header must be 16 bytes in base64")
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(u.Host, r.Host) {
+			return "", forbidden(fmt.Sprintf("Pages of %s may not watch the workers", origin))
+		}
+	}
+	return websocketAccept(key), nil
+}
@@ -0,0 +1,394 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// changeRahim creates rahim reporting to masud, raises their salary
+// moving them away from masud, and deletes them
+func changeRahim(t *testing.T, srvr *Server) {
+	t.Helper()
+	serveTest(t, srvr, testData{"", "POST", "/appscode/workers", 201, strings.NewReader(`{"username":"rahim","firstname":"Rahim","lastname":"Uddin","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500,"manager":"masud"}`)})
+	serveTest(t, srvr, testData{"", "PUT", "/appscode/workers/rahim", 201, strings.NewReader(`{"firstname":"Rahim","lastname":"Uddin","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":6000}`)})
+	serveTest(t, srvr, testData{"", "DELETE", "/appscode/workers/rahim", 200, nil})
+}
+
+// openSSE starts a watch of the server behind ts with the extra header
+func openSSE(t *testing.T, ts *httptest.Server, url string, header http.Header) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest("GET", ts.URL+url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %v expected 200", resp.StatusCode)
+	}
+	return resp
+}
+
+// readSSE reads n events of a stream, the keep-alive comments left out
+func readSSE(t *testing.T, r *bufio.Reader, n int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for n > 0 {
+		var event bytes.Buffer
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\n" {
+				break
+			}
+			if !strings.HasPrefix(line, ":") {
+				event.WriteString(line)
+			}
+		}
+		if event.Len() > 0 {
+			buf.Write(event.Bytes())
+			buf.WriteString("\n")
+			n--
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestWatchWorkersSSE(t *testing.T) {
+	srvr := newTestServer(t)
+	ts := httptest.NewServer(srvr.Handler())
+	defer ts.Close()
+
+	resp := openSSE(t, ts, "/appscode/workers/watch", nil)
+	defer resp.Body.Close()
+	waitForWatchers(t, srvr, 1)
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("got Content-Type %q", ct)
+	}
+
+	changeRahim(t, srvr)
+	checkGolden(t, "watch_workers_sse", readSSE(t, bufio.NewReader(resp.Body), 3))
+}
+
+func TestWatchWorkersResume(t *testing.T) {
+	srvr := newTestServer(t)
+	changeRahim(t, srvr)
+	ts := httptest.NewServer(srvr.Handler())
+	defer ts.Close()
+
+	// The seeded workers are the events 1 to 4, rahim the ones after
+	header := http.Header{"Last-Event-Id": {"5"}}
+	resp := openSSE(t, ts, "/appscode/workers/watch?resource_version=1", header)
+	checkGolden(t, "watch_workers_resumed", readSSE(t, bufio.NewReader(resp.Body), 2))
+	resp.Body.Close()
+
+	// Resuming filters the events by the worker after the change too
+	resp = openSSE(t, ts, "/appscode/workers/watch?manager=masud&resource_version=4", nil)
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+	checkGolden(t, "watch_reports_resumed", readSSE(t, r, 1))
+
+	// and goes on with the events from now on
+	waitForWatchers(t, srvr, 1)
+	serveTest(t, srvr, testData{"", "PUT", "/appscode/workers/jenny", 201, strings.NewReader(`{"firstname":"Jannatul","lastname":"Ferdows","city":"Chattogram","division":"Chattogram","position":"Software Engineer","salary":5500,"manager":"masud"}`)})
+	if event := readSSE(t, r, 1); !bytes.HasPrefix(event, []byte("id: 8\nevent: updated\n")) {
+		t.Errorf("got event %s", event)
+	}
+}
+
+func TestWatchWorkersErrors(t *testing.T) {
+	srvr := newTestServer(t)
+	changeRahim(t, srvr)
+	if _, err := srvr.store.DeleteEvents(testTime.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	test := []testData{
+		{"watch_pruned_events", "GET", "/appscode/workers/watch?resource_version=5", 410, nil},
+		{"watch_bad_resource_version", "GET", "/appscode/workers/watch?resource_version=latest", 422, nil},
+	}
+	for _, data := range test {
+		runTest(t, srvr, data)
+	}
+
+	// The last event is kept, so a watch can resume from it
+	first, last, err := srvr.store.EventVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != 7 || last != 7 {
+		t.Errorf("got events %d to %d expected only 7", first, last)
+	}
+}
+
+// TestWatchWorkersReplicas checks that a watch sees the changes made by
+// another server sharing the database
+func TestWatchWorkersReplicas(t *testing.T) {
+	first := newTestServer(t, WithEventPollInterval(10*time.Millisecond))
+	second := NewServer(WithStore(first.store), WithBypassAuth(true), WithClock(testTime), WithLogger(first.logger))
+	ts := httptest.NewServer(first.Handler())
+	defer ts.Close()
+
+	resp := openSSE(t, ts, "/appscode/workers/watch", nil)
+	defer resp.Body.Close()
+	waitForWatchers(t, first, 1)
+
+	changeRahim(t, second)
+	checkGolden(t, "watch_workers_sse", readSSE(t, bufio.NewReader(resp.Body), 3))
+}
+
+// gappedEvents is an EventStore whose events may miss versions, as when
+// transactions commit out of order
+type gappedEvents struct {
+	mu     sync.Mutex
+	events []WorkerEvent
+}
+
+func (g *gappedEvents) add(versions ...int64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, v := range versions {
+		g.events = append(g.events, WorkerEvent{ResourceVersion: v, Type: WorkerUpdated})
+	}
+	sort.Slice(g.events, func(i, j int) bool { return g.events[i].ResourceVersion < g.events[j].ResourceVersion })
+}
+
+func (g *gappedEvents) CreateEvent(*WorkerEvent) error { return nil }
+
+func (g *gappedEvents) ListEvents(after int64, limit int) ([]WorkerEvent, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var events []WorkerEvent
+	for _, e := range g.events {
+		if e.ResourceVersion > after && len(events) < limit {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (g *gappedEvents) EventVersions() (int64, int64, error) { return 0, 0, nil }
+
+func (g *gappedEvents) DeleteEvents(time.Time) (int64, error) { return 0, nil }
+
+func TestWatchWorkersGap(t *testing.T) {
+	store := new(gappedEvents)
+	clock := &movingClock{now: testTime.Now()}
+	events := newWorkerEvents(store, clock, time.Hour, log.New(ioutil.Discard, "", 0))
+	ch, _, stop, err := events.watch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	received := func() []int64 {
+		var versions []int64
+		for {
+			select {
+			case e := <-ch:
+				versions = append(versions, e.ResourceVersion)
+			default:
+				return versions
+			}
+		}
+	}
+
+	// 3 is held back until 2 is committed
+	store.add(1, 3)
+	events.fetch()
+	if got := received(); !reflect.DeepEqual(got, []int64{1}) {
+		t.Errorf("got versions %v expected 1", got)
+	}
+	store.add(2)
+	events.fetch()
+	if got := received(); !reflect.DeepEqual(got, []int64{2, 3}) {
+		t.Errorf("got versions %v expected 2 and 3", got)
+	}
+
+	// 4 never comes, as its transaction was rolled back
+	store.add(5)
+	events.fetch()
+	clock.now = clock.now.Add(eventGapTimeout - time.Second)
+	events.fetch()
+	if got := received(); len(got) != 0 {
+		t.Errorf("got versions %v expected none before the timeout", got)
+	}
+	clock.now = clock.now.Add(time.Second)
+	events.fetch()
+	if got := received(); !reflect.DeepEqual(got, []int64{5}) {
+		t.Errorf("got versions %v expected 5", got)
+	}
+}
+
+// dialWebSocket opens a WebSocket to the watch of the server behind ts
+func dialWebSocket(t *testing.T, ts *httptest.Server, url string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req, err := http.NewRequest("GET", ts.URL+url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Origin", ts.URL)
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %v expected 101", resp.StatusCode)
+	}
+	// The accept of the key of RFC 6455
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("got Sec-WebSocket-Accept %q", accept)
+	}
+	return conn, r
+}
+
+// readWSFrame reads an unmasked frame of the server
+func readWSFrame(t *testing.T, r *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	length := int(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			t.Fatal(err)
+		}
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		t.Fatal("unexpected frame of 64 KiB or more")
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0] & 0x0f, payload
+}
+
+// writeWSFrame writes a masked frame of the client
+func writeWSFrame(t *testing.T, conn net.Conn, opcode byte, payload []byte) {
+	t.Helper()
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchWorkersWebSocket(t *testing.T) {
+	srvr := newTestServer(t)
+	ts := httptest.NewServer(srvr.Handler())
+	defer ts.Close()
+
+	conn, r := dialWebSocket(t, ts, "/appscode/workers/watch?manager=masud")
+	defer conn.Close()
+	waitForWatchers(t, srvr, 1)
+
+	changeRahim(t, srvr)
+	opcode, payload := readWSFrame(t, r)
+	if opcode != wsText {
+		t.Fatalf("got opcode %d expected a text frame", opcode)
+	}
+	checkGolden(t, "watch_workers_websocket", append(payload, '\n'))
+
+	writeWSFrame(t, conn, wsPing, []byte("hello"))
+	if opcode, payload := readWSFrame(t, r); opcode != wsPong || string(payload) != "hello" {
+		t.Errorf("got opcode %d %q expected the pong of the ping", opcode, payload)
+	}
+
+	// Shutting down closes the WebSocket as going away
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	srvr.Shutdown(ctx)
+	opcode, payload = readWSFrame(t, r)
+	if opcode != wsClose || binary.BigEndian.Uint16(payload) != wsGoingAway {
+		t.Errorf("got opcode %d %q expected a close of going away", opcode, payload)
+	}
+}
+
+func TestWatchWorkersWebSocketClose(t *testing.T) {
+	srvr := newTestServer(t)
+	ts := httptest.NewServer(srvr.Handler())
+	defer ts.Close()
+
+	conn, r := dialWebSocket(t, ts, "/appscode/workers/watch")
+	defer conn.Close()
+	waitForWatchers(t, srvr, 1)
+
+	// The server answers the close of the client and ends the watch
+	writeWSFrame(t, conn, wsClose, []byte{0x03, 0xe8})
+	if opcode, payload := readWSFrame(t, r); opcode != wsClose || !bytes.Equal(payload, []byte{0x03, 0xe8}) {
+		t.Errorf("got opcode %d %q expected the close echoed", opcode, payload)
+	}
+	waitForWatchers(t, srvr, 0)
+}
+
+func TestWatchWorkersWebSocketHandshake(t *testing.T) {
+	srvr := newTestServer(t)
+	upgrade := http.Header{
+		"Connection":        {"Upgrade"},
+		"Upgrade":           {"websocket"},
+		"Sec-Websocket-Key": {"dGhlIHNhbXBsZSBub25jZQ=="},
+	}
+	for _, data := range []struct {
+		name   string
+		header http.Header
+		status int
+	}{
+		{"watch_websocket_version", http.Header{"Sec-Websocket-Version": {"8"}}, 400},
+		{"watch_websocket_bad_key", http.Header{"Sec-Websocket-Version": {"13"}, "Sec-Websocket-Key": {"short"}}, 400},
+		{"watch_websocket_other_origin", http.Header{"Sec-Websocket-Version": {"13"}, "Origin": {"https://evil.example"}}, 403},
+	} {
+		header := make(http.Header)
+		for key, values := range upgrade {
+			header[key] = values
+		}
+		for key, values := range data.header {
+			header[key] = values
+		}
+		rec := serveTestWithHeader(t, srvr, testData{data.name, "GET", "/appscode/workers/watch", data.status, nil}, header)
+		checkGolden(t, data.name, dumpResponse(rec))
+	}
+}
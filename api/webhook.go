@@ -0,0 +1,479 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-xorm/xorm"
+	"gopkg.in/macaron.v1"
+)
+
+// Webhook event types
+const (
+	WebhookWorkerCreated       = "worker.created"
+	WebhookWorkerUpdated       = "worker.updated"
+	WebhookWorkerSalaryChanged = "worker.salary_changed"
+	WebhookWorkerDeleted       = "worker.deleted"
+)
+
+// webhookEvents are the event types a webhook can subscribe to, in the
+// order they are listed
+var webhookEvents = []string{WebhookWorkerCreated, WebhookWorkerUpdated, WebhookWorkerSalaryChanged, WebhookWorkerDeleted}
+
+// States of a webhook delivery
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	// DeliveryDead deliveries failed every attempt, they make the dead
+	// letters and are only tried again when retried by an admin
+	DeliveryDead = "dead"
+)
+
+// Webhook is a subscription of a URL to the events of the workers. The
+// deliveries are signed with Secret, which is only shown when the
+// webhook is created.
+type Webhook struct {
+	ID       string   `json:"id" xorm:"pk 'id' varchar(32)"`
+	URL      string   `json:"url" xorm:"'url' not null" validate:"required,max=2048"`
+	Events   []string `json:"events"`
+	Secret   string   `json:"secret,omitempty" xorm:"not null" validate:"min=16,max=128"`
+	Disabled bool     `json:"disabled"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int       `json:"version" xorm:"version"`
+}
+
+func (h *Webhook) subscribes(event string) bool {
+	return !h.Disabled && contains(h.Events, event)
+}
+
+// WebhookDelivery is an event on its way to a webhook. It is queued in
+// the transaction of the change, so a change rolled back is never sent,
+// and Attempts logs every try of sending it.
+type WebhookDelivery struct {
+	ID        int64  `json:"id" xorm:"pk autoincr 'id'"`
+	WebhookID string `json:"webhook_id" xorm:"'webhook_id' not null index varchar(32)"`
+	// EventID is shared by the deliveries of one event to every webhook
+	EventID string          `json:"event_id" xorm:"'event_id' not null varchar(32)"`
+	Event   string          `json:"event" xorm:"not null varchar(32)"`
+	Payload *WebhookPayload `json:"payload" xorm:"-"`
+	State   string          `json:"state" xorm:"not null index varchar(16)"`
+	// Failures counts the failed attempts since the delivery was queued
+	// or retried, NextAttemptAt is when a pending delivery is tried next
+	Failures      int              `json:"failures"`
+	NextAttemptAt time.Time        `json:"next_attempt_at" xorm:"not null index"`
+	Attempts      []WebhookAttempt `json:"attempts" xorm:"text"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int       `json:"-" xorm:"version"`
+
+	// Body is the payload as it is sent and stored
+	Body string `json:"-" xorm:"'payload' not null text"`
+}
+
+// WebhookAttempt is a try of sending a delivery, Status is the status the
+// webhook answered with and Error why it failed
+type WebhookAttempt struct {
+	At     time.Time `json:"at"`
+	Status int       `json:"status,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// DeliveryFilter selects deliveries by the non-empty fields
+type DeliveryFilter struct {
+	WebhookID string
+	State     string
+	// Due selects the deliveries to try by then, the ones due first
+	// come first. The others are listed newest first.
+	Due   time.Time
+	Limit int
+}
+
+// WebhookStore persists the webhooks and their deliveries
+type WebhookStore interface {
+	ListWebhooks() ([]Webhook, error)
+	// GetWebhook returns ErrNotFound if no webhook has the id
+	GetWebhook(id string) (*Webhook, error)
+	CreateWebhook(hook *Webhook) error
+	UpdateWebhook(hook *Webhook) error
+	// DeleteWebhook deletes the webhook along with its deliveries
+	DeleteWebhook(id string) error
+
+	CreateDelivery(delivery *WebhookDelivery) error
+	ListDeliveries(filter DeliveryFilter) ([]WebhookDelivery, error)
+	// GetDelivery returns ErrNotFound if no delivery has the id
+	GetDelivery(id int64) (*WebhookDelivery, error)
+	// UpdateDelivery returns ErrNotFound if the delivery was changed since
+	// it was read, by another server sending it
+	UpdateDelivery(delivery *WebhookDelivery) error
+	// DeleteDeliveries deletes the deliveries sent before t
+	DeleteDeliveries(before time.Time) (int64, error)
+}
+
+func (s *XormStore) ListWebhooks() ([]Webhook, error) {
+	hooks := make([]Webhook, 0)
+	if err := s.db.Asc("created_at", "id").Find(&hooks); err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+func (s *XormStore) GetWebhook(id string) (*Webhook, error) {
+	hook := &Webhook{ID: id}
+	exist, err := s.db.Get(hook)
+	if err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, ErrNotFound
+	}
+	return hook, nil
+}
+
+func (s *XormStore) CreateWebhook(hook *Webhook) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		_, err := session.Insert(hook)
+		return err
+	})
+}
+
+func (s *XormStore) UpdateWebhook(hook *Webhook) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		affected, err := session.ID(hook.ID).AllCols().Update(hook)
+		if err != nil {
+			return err
+		} else if affected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (s *XormStore) DeleteWebhook(id string) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		if _, err := session.Where("webhook_id = ?", id).Delete(new(WebhookDelivery)); err != nil {
+			return err
+		}
+		affected, err := session.ID(id).Delete(new(Webhook))
+		if err != nil {
+			return err
+		} else if affected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (s *XormStore) CreateDelivery(delivery *WebhookDelivery) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		body, err := json.Marshal(delivery.Payload)
+		if err != nil {
+			return err
+		}
+		delivery.Body = string(body)
+		_, err = session.Insert(delivery)
+		return err
+	})
+}
+
+func (s *XormStore) ListDeliveries(filter DeliveryFilter) ([]WebhookDelivery, error) {
+	cond := &WebhookDelivery{WebhookID: filter.WebhookID, State: filter.State}
+	var query *xorm.Session
+	if !filter.Due.IsZero() {
+		query = s.db.Where("next_attempt_at <= ?", filter.Due).Asc("next_attempt_at", "id")
+	} else {
+		query = s.db.Desc("id")
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	deliveries := make([]WebhookDelivery, 0)
+	if err := query.Find(&deliveries, cond); err != nil {
+		return nil, err
+	}
+	for i := range deliveries {
+		if err := deliveries[i].setPayload(); err != nil {
+			return nil, err
+		}
+	}
+	return deliveries, nil
+}
+
+func (s *XormStore) GetDelivery(id int64) (*WebhookDelivery, error) {
+	delivery := &WebhookDelivery{ID: id}
+	exist, err := s.db.Get(delivery)
+	if err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, ErrNotFound
+	}
+	if err := delivery.setPayload(); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+func (s *XormStore) UpdateDelivery(delivery *WebhookDelivery) error {
+	return s.inTransaction(func(session *xorm.Session) error {
+		affected, err := session.ID(delivery.ID).AllCols().Update(delivery)
+		if err != nil {
+			return err
+		} else if affected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (s *XormStore) DeleteDeliveries(before time.Time) (int64, error) {
+	return s.db.Where("state = ? AND updated_at < ?", DeliveryDelivered, before).Delete(new(WebhookDelivery))
+}
+
+// setPayload reads the payload out of the stored body
+func (d *WebhookDelivery) setPayload() error {
+	d.Payload = new(WebhookPayload)
+	return json.Unmarshal([]byte(d.Body), d.Payload)
+}
+
+// Webhook handlers, they are for admins only
+
+func (s *Server) showWebhooks(ctx *macaron.Context) error {
+	if err := s.checkAdmin(ctx); err != nil {
+		return err
+	}
+	hooks, err := s.store.ListWebhooks()
+	if err != nil {
+		return err
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	return s.render(ctx, http.StatusOK, hooks)
+}
+
+func (s *Server) showWebhook(ctx *macaron.Context) error {
+	if err := s.checkAdmin(ctx); err != nil {
+		return err
+	}
+	hook, err := s.getWebhook(ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+	hook.Secret = ""
+	return s.render(ctx, http.StatusOK, hook)
+}
+
+// addWebhook subscribes a URL to events, the secret is generated if none
+// is given and shown only in the response
+func (s *Server) addWebhook(ctx *macaron.Context) error {
+	if err := s.checkAdmin(ctx); err != nil {
+		return err
+	}
+	var hook Webhook
+	if err := s.decode(ctx, &hook); err != nil {
+		return err
+	}
+	if errs := validateWebhook(&hook); len(errs) > 0 {
+		return validationFailed(errs...)
+	}
+
+	hook.ID = newID()
+	if hook.Secret == "" {
+		hook.Secret = newID()
+	}
+	hook.CreatedAt = s.clock.Now()
+	hook.UpdatedAt = hook.CreatedAt
+	hook.Version = 0
+	if err := s.store.CreateWebhook(&hook); err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusCreated, hook)
+}
+
+// updateWebhook changes the URL, the events or the state of a webhook,
+// the secret is kept unless a new one is given
+func (s *Server) updateWebhook(ctx *macaron.Context) error {
+	if err := s.checkAdmin(ctx); err != nil {
+		return err
+	}
+	var newHook Webhook
+	if err := s.decode(ctx, &newHook); err != nil {
+		return err
+	}
+	errs := validateWebhook(&newHook)
+	if newHook.ID != "" && newHook.ID != ctx.Params("id") {
+		errs = append(errs, FieldError{Field: "id", Message: "can't be changed"})
+	}
+	if len(errs) > 0 {
+		return validationFailed(errs...)
+	}
+
+	hook, err := s.getWebhook(ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+	hook.URL = newHook.URL
+	hook.Events = newHook.Events
+	hook.Disabled = newHook.Disabled
+	if newHook.Secret != "" {
+		hook.Secret = newHook.Secret
+	}
+	hook.UpdatedAt = s.clock.Now()
+	if err := s.store.UpdateWebhook(hook); err == ErrNotFound {
+		return conflict("Webhook %q was changed by another request, try again", hook.ID)
+	} else if err != nil {
+		return err
+	}
+	hook.Secret = ""
+	return s.render(ctx, http.StatusOK, hook)
+}
+
+func (s *Server) deleteWebhook(ctx *macaron.Context) error {
+	if err := s.checkAdmin(ctx); err != nil {
+		return err
+	}
+	if err := checkAcceptable(ctx, StatusMessage{}); err != nil {
+		return err
+	}
+	id := ctx.Params("id")
+	if err := s.store.DeleteWebhook(id); err == ErrNotFound {
+		return notFound("Webhook %q does not exist", id)
+	} else if err != nil {
+		return err
+	}
+	return s.renderDeleted(ctx)
+}
+
+// showDeliveries shows the delivery log of a webhook, newest first
+func (s *Server) showDeliveries(ctx *macaron.Context) error {
+	if err := s.checkAdmin(ctx); err != nil {
+		return err
+	}
+	state, err := deliveryState(ctx)
+	if err != nil {
+		return err
+	}
+	hook, err := s.getWebhook(ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+	deliveries, err := s.store.ListDeliveries(DeliveryFilter{WebhookID: hook.ID, State: state, Limit: deliveryLogLimit})
+	if err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusOK, deliveries)
+}
+
+// showDeadLetters lists the deliveries of every webhook that failed all
+// of their attempts, newest first
+func (s *Server) showDeadLetters(ctx *macaron.Context) error {
+	if err := s.checkAdmin(ctx); err != nil {
+		return err
+	}
+	deliveries, err := s.store.ListDeliveries(DeliveryFilter{State: DeliveryDead, Limit: deliveryLogLimit})
+	if err != nil {
+		return err
+	}
+	return s.render(ctx, http.StatusOK, deliveries)
+}
+
+// retryDelivery sends a dead delivery again, it is tried as many times as
+// a new one
+func (s *Server) retryDelivery(ctx *macaron.Context) error {
+	if err := s.checkAdmin(ctx); err != nil {
+		return err
+	}
+	hook, err := s.getWebhook(ctx.Params("id"))
+	if err != nil {
+		return err
+	}
+	id, err := strconv.ParseInt(ctx.Params("delivery"), 10, 64)
+	if err != nil {
+		return notFound("Delivery %q does not exist", ctx.Params("delivery"))
+	}
+	delivery, err := s.store.GetDelivery(id)
+	if err == ErrNotFound || err == nil && delivery.WebhookID != hook.ID {
+		return notFound("Delivery %d does not exist", id)
+	} else if err != nil {
+		return err
+	}
+	if delivery.State != DeliveryDead {
+		return conflict("Delivery %d is %s, only dead deliveries can be retried", id, delivery.State)
+	}
+
+	delivery.State = DeliveryPending
+	delivery.Failures = 0
+	delivery.NextAttemptAt = s.clock.Now()
+	delivery.UpdatedAt = s.clock.Now()
+	if err := s.store.UpdateDelivery(delivery); err == ErrNotFound {
+		return conflict("Delivery %d was changed by another request, try again", id)
+	} else if err != nil {
+		return err
+	}
+	s.wakeWebhooks()
+	return s.render(ctx, http.StatusOK, delivery)
+}
+
+// deliveryLogLimit is how many deliveries are shown at most
+const deliveryLogLimit = 100
+
+func deliveryState(ctx *macaron.Context) (string, error) {
+	state := ctx.Query("state")
+	if state != "" && state != DeliveryPending && state != DeliveryDelivered && state != DeliveryDead {
+		return "", validationFailed(FieldError{Field: "state", Message: "must be one of pending, delivered, dead"})
+	}
+	return state, nil
+}
+
+// checkAdmin lets only admins manage the webhooks, as they see the
+// salaries of every worker
+func (s *Server) checkAdmin(ctx *macaron.Context) error {
+	if !s.hasRole(ctx, RoleAdmin) {
+		return forbidden("Only admins can manage the webhooks")
+	}
+	return nil
+}
+
+// getWebhook is GetWebhook with the 404 worded for the client
+func (s *Server) getWebhook(id string) (*Webhook, error) {
+	hook, err := s.store.GetWebhook(id)
+	if err == ErrNotFound {
+		return nil, notFound("Webhook %q does not exist", id)
+	}
+	return hook, err
+}
+
+// validateWebhook checks the webhook, and puts its events in the order of
+// webhookEvents without duplicates
+func validateWebhook(hook *Webhook) []FieldError {
+	errs := validate(hook, opCreate)
+	if hook.URL != "" && !hasFieldError(errs, "url") {
+		u, err := url.Parse(hook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, FieldError{Field: "url", Message: "must be an absolute http or https URL"})
+		}
+	}
+
+	var events []string
+	for _, event := range hook.Events {
+		if !contains(webhookEvents, event) {
+			errs = append(errs, FieldError{Field: "events", Message: "can only hold " + strings.Join(webhookEvents, ", ")})
+			return errs
+		}
+	}
+	for _, event := range webhookEvents {
+		if contains(hook.Events, event) {
+			events = append(events, event)
+		}
+	}
+	if len(events) == 0 {
+		errs = append(errs, FieldError{Field: "events", Message: "must hold one of " + strings.Join(webhookEvents, ", ")})
+	}
+	hook.Events = events
+	return errs
+}
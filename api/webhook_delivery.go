@@ -0,0 +1,266 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// webhookTimeout is how long a webhook may take to answer
+	webhookTimeout = 10 * time.Second
+	// webhookMaxBackoff caps the wait between two attempts of a delivery
+	webhookMaxBackoff = time.Hour
+	// webhookBatch is how many deliveries are sent at once
+	webhookBatch = 20
+	// deliveryLogSize is how many attempts of a delivery are logged, the
+	// oldest ones are dropped
+	deliveryLogSize = 50
+	// deliveryRetention is how long the sent deliveries are logged
+	deliveryRetention = 7 * 24 * time.Hour
+)
+
+// errWebhookDisabled is logged for the deliveries of a webhook disabled or
+// deleted before they were sent, they go dead right away
+const errWebhookDisabled = "the webhook is disabled"
+
+// errWebhookRedirected is logged for the deliveries answered with a
+// redirect, which isn't followed, as the signed body would be dropped
+const errWebhookRedirected = "the webhook redirected the delivery, redirects aren't followed"
+
+// newWebhookClient returns the client posting the deliveries, which takes
+// a redirect as the answer
+func newWebhookClient() *http.Client {
+	return &http.Client{
+		Timeout: webhookTimeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// WebhookPayload is the body POSTed to a webhook. Previous is the worker
+// before the change, for worker.salary_changed.
+type WebhookPayload struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Worker    Worker    `json:"worker"`
+	Previous  *Worker   `json:"previous,omitempty"`
+}
+
+// queueWebhooks queues the deliveries of the event to the webhooks
+// subscribed to it in tx, they are sent once tx is committed
+func (s *Server) queueWebhooks(tx Store, event string, worker Worker, previous *Worker) error {
+	hooks, err := tx.ListWebhooks()
+	if err != nil {
+		return err
+	}
+	now := s.clock.Now()
+	var payload *WebhookPayload
+	for i := range hooks {
+		if !hooks[i].subscribes(event) {
+			continue
+		}
+		if payload == nil {
+			payload = &WebhookPayload{ID: newID(), Event: event, CreatedAt: now, Worker: worker, Previous: previous}
+		}
+		err := tx.CreateDelivery(&WebhookDelivery{
+			WebhookID:     hooks[i].ID,
+			EventID:       payload.ID,
+			Event:         event,
+			Payload:       payload,
+			State:         DeliveryPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// signWebhook signs the body sent at timestamp with the secret of the
+// webhook, the way a receiver checks it
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// DeliverWebhooks sends the deliveries that are due, and returns how many
+// of them were delivered. Run sends them every webhook interval and right
+// after the changes made by this server.
+func (s *Server) DeliverWebhooks() (int, error) {
+	delivered := 0
+	for {
+		due, err := s.store.ListDeliveries(DeliveryFilter{State: DeliveryPending, Due: s.clock.Now(), Limit: webhookBatch})
+		if err != nil {
+			return delivered, err
+		}
+		hooks, err := s.store.ListWebhooks()
+		if err != nil {
+			return delivered, err
+		}
+		byID := make(map[string]*Webhook, len(hooks))
+		for i := range hooks {
+			byID[hooks[i].ID] = &hooks[i]
+		}
+
+		// Every delivery is claimed for longer than sending it takes, so
+		// the other servers sharing the database leave it be
+		claimed := make([]*WebhookDelivery, 0, len(due))
+		for i := range due {
+			due[i].NextAttemptAt = s.clock.Now().Add(2 * webhookTimeout)
+			if err := s.store.UpdateDelivery(&due[i]); err == ErrNotFound {
+				continue
+			} else if err != nil {
+				return delivered, err
+			}
+			claimed = append(claimed, &due[i])
+		}
+
+		// The webhooks are called side by side, the attempts recorded one
+		// at a time
+		attempts := make([]WebhookAttempt, len(claimed))
+		var wg sync.WaitGroup
+		for i, delivery := range claimed {
+			wg.Add(1)
+			go func(i int, delivery *WebhookDelivery) {
+				defer wg.Done()
+				attempts[i] = s.postDelivery(byID[delivery.WebhookID], delivery)
+			}(i, delivery)
+		}
+		wg.Wait()
+
+		for i, delivery := range claimed {
+			if err := s.recordAttempt(delivery, attempts[i]); err != nil && err != ErrNotFound {
+				return delivered, err
+			}
+			if delivery.State == DeliveryDelivered {
+				delivered++
+			}
+		}
+		if len(due) < webhookBatch {
+			return delivered, nil
+		}
+	}
+}
+
+// postDelivery sends the delivery to its webhook, hook is nil if the
+// webhook was deleted since
+func (s *Server) postDelivery(hook *Webhook, delivery *WebhookDelivery) WebhookAttempt {
+	attempt := WebhookAttempt{At: s.clock.Now()}
+	if hook == nil || hook.Disabled {
+		attempt.Error = errWebhookDisabled
+		return attempt
+	}
+	req, err := http.NewRequest("POST", hook.URL, strings.NewReader(delivery.Body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	timestamp := strconv.FormatInt(attempt.At.Unix(), 10)
+	req.Header.Set("Content-Type", mediaJSON)
+	req.Header.Set("User-Agent", "apiserver-webhooks")
+	req.Header.Set("X-Webhook-ID", delivery.EventID)
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", signWebhook(hook.Secret, timestamp, []byte(delivery.Body)))
+
+	resp, err := s.webhookClient.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	attempt.Status = resp.StatusCode
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		attempt.Error = errWebhookRedirected
+	}
+	return attempt
+}
+
+// recordAttempt logs the attempt and moves the delivery on: delivered if
+// the webhook took it, tried again after a backoff doubling with every
+// failure, or dead once it failed webhookAttempts times
+func (s *Server) recordAttempt(delivery *WebhookDelivery, attempt WebhookAttempt) error {
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	if len(delivery.Attempts) > deliveryLogSize {
+		delivery.Attempts = delivery.Attempts[len(delivery.Attempts)-deliveryLogSize:]
+	}
+	delivery.UpdatedAt = s.clock.Now()
+
+	switch {
+	case attempt.Status >= 200 && attempt.Status < 300:
+		delivery.State = DeliveryDelivered
+	case attempt.Error == errWebhookDisabled:
+		delivery.State = DeliveryDead
+	default:
+		delivery.Failures++
+		if delivery.Failures >= s.webhookAttempts {
+			delivery.State = DeliveryDead
+		} else {
+			delivery.NextAttemptAt = s.clock.Now().Add(s.webhookBackoffAfter(delivery.Failures))
+		}
+	}
+	return s.store.UpdateDelivery(delivery)
+}
+
+// webhookBackoffAfter is the wait before trying a delivery again after
+// its nth failure
+func (s *Server) webhookBackoffAfter(failures int) time.Duration {
+	backoff := s.webhookBackoff
+	for i := 1; i < failures && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > webhookMaxBackoff {
+		backoff = webhookMaxBackoff
+	}
+	return backoff
+}
+
+// PruneDeliveries deletes the deliveries sent more than a week ago from
+// the logs of the webhooks. Run prunes them along with the events.
+func (s *Server) PruneDeliveries() (int64, error) {
+	return s.store.DeleteDeliveries(s.clock.Now().Add(-deliveryRetention))
+}
+
+// wakeWebhooks has Run send the deliveries right away
+func (s *Server) wakeWebhooks() {
+	select {
+	case s.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// runWebhooks sends the deliveries every webhookInterval, or when woken,
+// until ctx is done
+func (s *Server) runWebhooks(ctx context.Context) {
+	ticker := time.NewTicker(s.webhookInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.DeliverWebhooks(); err != nil {
+			s.logger.Println("delivering webhooks:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.webhookWake:
+		}
+	}
+}
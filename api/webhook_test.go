@@ -0,0 +1,250 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	// idPattern matches the generated IDs and secrets, which differ on
+	// every run, as does the address of the receiver
+	idPattern       = regexp.MustCompile(`[0-9a-f]{32}`)
+	receiverPattern = regexp.MustCompile(`http://127\.0\.0\.1:[0-9]+`)
+)
+
+func maskIDs(b []byte) []byte {
+	b = receiverPattern.ReplaceAll(b, []byte("http://receiver"))
+	return idPattern.ReplaceAll(b, []byte("<id>"))
+}
+
+// webhookReceiver records the deliveries it gets and answers them with status
+type webhookReceiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	w.WriteHeader(r.status)
+}
+
+func (r *webhookReceiver) setStatus(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+// addTestWebhook subscribes the receiver behind ts and returns the webhook
+func addTestWebhook(t *testing.T, srvr *Server, name, url, events string) Webhook {
+	t.Helper()
+	rec := serveTest(t, srvr, testData{name, "POST", "/appscode/webhooks", 201,
+		strings.NewReader(`{"url":"` + url + `","events":[` + events + `],"secret":"a-secret-of-the-receiver"}`)})
+	checkGolden(t, name, maskIDs(dumpResponse(rec)))
+	var hook Webhook
+	if err := json.Unmarshal(rec.Body.Bytes(), &hook); err != nil {
+		t.Fatal(err)
+	}
+	return hook
+}
+
+func deliverWebhooks(t *testing.T, srvr *Server, expected int) {
+	t.Helper()
+	n, err := srvr.DeliverWebhooks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != expected {
+		t.Errorf("delivered %d webhooks expected %d", n, expected)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	receiver := &webhookReceiver{status: http.StatusNoContent}
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+	srvr := newTestServer(t)
+
+	for _, data := range []testData{
+		{"add_webhook_invalid", "POST", "/appscode/webhooks", 422, strings.NewReader(`{"url":"ftp://example.com","events":["worker.hired"],"secret":"short"}`)},
+		{"add_webhook_no_events", "POST", "/appscode/webhooks", 422, strings.NewReader(`{"url":"https://example.com/hook","events":[]}`)},
+	} {
+		runTest(t, srvr, data)
+	}
+	hook := addTestWebhook(t, srvr, "add_webhook", ts.URL+"/hook", `"worker.deleted","worker.created","worker.salary_changed","worker.created"`)
+
+	changeRahim(t, srvr)
+	deliverWebhooks(t, srvr, 3)
+
+	// The payloads are signed with the secret over the timestamp and the
+	// body. They are sent side by side, so they are compared by event.
+	var payloads []string
+	for i, req := range receiver.requests {
+		timestamp := req.Header.Get("X-Webhook-Timestamp")
+		if timestamp != "1553084227" {
+			t.Errorf("got X-Webhook-Timestamp %q", timestamp)
+		}
+		if sig := req.Header.Get("X-Webhook-Signature"); sig != signWebhook("a-secret-of-the-receiver", timestamp, receiver.bodies[i]) {
+			t.Errorf("got X-Webhook-Signature %q", sig)
+		}
+		var payload WebhookPayload
+		if err := json.Unmarshal(receiver.bodies[i], &payload); err != nil {
+			t.Fatal(err)
+		}
+		if req.Header.Get("X-Webhook-Event") != payload.Event || req.Header.Get("X-Webhook-ID") != payload.ID {
+			t.Errorf("got X-Webhook-Event %q and X-Webhook-ID %q for %s", req.Header.Get("X-Webhook-Event"), req.Header.Get("X-Webhook-ID"), receiver.bodies[i])
+		}
+		payloads = append(payloads, payload.Event+" "+string(receiver.bodies[i])+"\n")
+	}
+	sort.Strings(payloads)
+	checkGolden(t, "webhook_payloads", maskIDs([]byte(strings.Join(payloads, ""))))
+
+	for _, data := range []testData{
+		{"show_webhooks", "GET", "/appscode/webhooks", 200, nil},
+		{"show_webhook", "GET", "/appscode/webhooks/" + hook.ID, 200, nil},
+		{"show_webhook_deliveries", "GET", "/appscode/webhooks/" + hook.ID + "/deliveries?state=delivered", 200, nil},
+		{"show_webhook_deliveries_bad_state", "GET", "/appscode/webhooks/" + hook.ID + "/deliveries?state=lost", 422, nil},
+		{"update_webhook", "PUT", "/appscode/webhooks/" + hook.ID, 200, strings.NewReader(`{"url":"` + ts.URL + `/hook","events":["worker.updated"],"disabled":true}`)},
+		{"show_webhook_not_found", "GET", "/appscode/webhooks/nothing", 404, nil},
+	} {
+		checkGolden(t, data.name, maskIDs(dumpResponse(serveTest(t, srvr, data))))
+	}
+
+	// A disabled webhook gets nothing
+	serveTest(t, srvr, testData{"", "PUT", "/appscode/workers/jenny", 201, strings.NewReader(`{"firstname":"Jannatul","lastname":"Ferdows","city":"Chattogram","division":"Chattogram","position":"Software Engineer","salary":5500,"manager":"masud"}`)})
+	deliverWebhooks(t, srvr, 0)
+
+	runTest(t, srvr, testData{"delete_webhook", "DELETE", "/appscode/webhooks/" + hook.ID, 200, nil})
+	if deliveries, err := srvr.store.ListDeliveries(DeliveryFilter{}); err != nil || len(deliveries) != 0 {
+		t.Errorf("got %d deliveries, %v, expected them deleted along with the webhook", len(deliveries), err)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	receiver := &webhookReceiver{status: http.StatusServiceUnavailable}
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+	clock := &movingClock{now: testTime.Now()}
+	srvr := newTestServer(t, WithClock(clock), WithWebhookRetries(3, time.Minute))
+	hook := addTestWebhook(t, srvr, "add_webhook_created", ts.URL, `"worker.created"`)
+
+	serveTest(t, srvr, testData{"", "POST", "/appscode/workers", 201, strings.NewReader(`{"username":"rahim","firstname":"Rahim","lastname":"Uddin","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500}`)})
+
+	// Waiting a minute after the first failure, two after the second, and
+	// giving up after the third
+	deliverWebhooks(t, srvr, 0)
+	clock.now = clock.now.Add(59 * time.Second)
+	deliverWebhooks(t, srvr, 0)
+	if len(receiver.requests) != 1 {
+		t.Fatalf("got %d attempts expected the delivery to back off", len(receiver.requests))
+	}
+	clock.now = clock.now.Add(time.Second)
+	deliverWebhooks(t, srvr, 0)
+	clock.now = clock.now.Add(2 * time.Minute)
+	deliverWebhooks(t, srvr, 0)
+	clock.now = clock.now.Add(time.Hour)
+	deliverWebhooks(t, srvr, 0)
+	if len(receiver.requests) != 3 {
+		t.Fatalf("got %d attempts expected 3", len(receiver.requests))
+	}
+
+	rec := serveTest(t, srvr, testData{"show_dead_letters", "GET", "/appscode/webhooks/dead-letters", 200, nil})
+	checkGolden(t, "show_dead_letters", maskIDs(dumpResponse(rec)))
+	var dead []WebhookDelivery
+	if err := json.Unmarshal(rec.Body.Bytes(), &dead); err != nil || len(dead) != 1 {
+		t.Fatalf("got dead letters %s, %v", rec.Body, err)
+	}
+
+	// Retrying sends it again, as many times as a new delivery
+	receiver.setStatus(http.StatusOK)
+	retry := "/appscode/webhooks/" + hook.ID + "/deliveries/" + strconv.FormatInt(dead[0].ID, 10) + "/retry"
+	checkGolden(t, "retry_webhook_delivery", maskIDs(dumpResponse(serveTest(t, srvr, testData{"retry_webhook_delivery", "POST", retry, 200, nil}))))
+	deliverWebhooks(t, srvr, 1)
+	for _, data := range []testData{
+		{"retry_webhook_delivery_delivered", "POST", retry, 409, nil},
+		{"retry_webhook_delivery_not_found", "POST", "/appscode/webhooks/" + hook.ID + "/deliveries/99/retry", 404, nil},
+	} {
+		checkGolden(t, data.name, maskIDs(dumpResponse(serveTest(t, srvr, data))))
+	}
+}
+
+func TestWebhookRedirect(t *testing.T) {
+	receiver := &webhookReceiver{status: http.StatusOK}
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(ts.URL, http.StatusFound))
+	defer redirect.Close()
+	srvr := newTestServer(t)
+	hook := addTestWebhook(t, srvr, "add_webhook_created", redirect.URL, `"worker.created"`)
+
+	serveTest(t, srvr, testData{"", "POST", "/appscode/workers", 201, strings.NewReader(`{"username":"rahim","firstname":"Rahim","lastname":"Uddin","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500}`)})
+
+	// The redirect is a failed attempt, the body is never sent elsewhere
+	deliverWebhooks(t, srvr, 0)
+	if len(receiver.requests) != 0 {
+		t.Errorf("got %d requests expected the redirect not to be followed", len(receiver.requests))
+	}
+	var deliveries []WebhookDelivery
+	rec := serveTest(t, srvr, testData{"", "GET", "/appscode/webhooks/" + hook.ID + "/deliveries", 200, nil})
+	if err := json.Unmarshal(rec.Body.Bytes(), &deliveries); err != nil || len(deliveries) != 1 {
+		t.Fatalf("got deliveries %s, %v", rec.Body, err)
+	}
+	attempts := deliveries[0].Attempts
+	if deliveries[0].State != DeliveryPending || len(attempts) != 1 || attempts[0].Status != http.StatusFound || attempts[0].Error != errWebhookRedirected {
+		t.Errorf("got the delivery %+v expected a failed attempt", deliveries[0])
+	}
+}
+
+// TestWebhookOutbox checks that the deliveries are queued along with the
+// changes, so the ones rolled back are never sent
+func TestWebhookOutbox(t *testing.T) {
+	receiver := &webhookReceiver{status: http.StatusOK}
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+	srvr := newTestServer(t)
+	addTestWebhook(t, srvr, "add_webhook_all", ts.URL, `"worker.created","worker.updated","worker.salary_changed","worker.deleted"`)
+
+	// The first worker is created in the transaction of the import, which
+	// the invalid second one rolls back
+	rows := `{"username":"rahim","firstname":"Rahim","lastname":"Uddin","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":6000}
+{"username":"karim","firstname":"Karim","lastname":"Uddin","city":"Nowhere","division":"Dhaka","position":"Software Engineer","salary":6000}
+`
+	serveTestWithType(t, srvr, testData{"", "POST", "/appscode/workers/import", 422, strings.NewReader(rows)}, "application/x-ndjson")
+	// masud can't be deleted without moving their report rahim
+	serveTest(t, srvr, testData{"", "POST", "/appscode/workers", 201, strings.NewReader(`{"username":"rahim","firstname":"Rahim","lastname":"Uddin","city":"Madaripur","division":"Dhaka","position":"Software Engineer","salary":5500,"manager":"masud"}`)})
+	serveTest(t, srvr, testData{"", "DELETE", "/appscode/workers/masud", 409, nil})
+	serveTest(t, srvr, testData{"", "PUT", "/appscode/workers/jenny", 422, strings.NewReader(`{"firstname":"Jannatul","lastname":"Ferdows","city":"Chattogram","division":"Chattogram","position":"Software Engineer","salary":5500,"manager":"nobody"}`)})
+
+	deliveries, err := srvr.store.ListDeliveries(DeliveryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Event != WebhookWorkerCreated {
+		t.Errorf("got %d deliveries expected only the one of creating rahim", len(deliveries))
+	}
+	deliverWebhooks(t, srvr, 1)
+}
+
+func TestWebhooksAdminOnly(t *testing.T) {
+	srvr := newTestServer(t, WithBypassAuth(false), WithAuthProvider(StaticAuth{"masud": "pass", "admin": "admin"}))
+	header := http.Header{"Authorization": {"Basic bWFzdWQ6cGFzcw=="}}
+	rec := serveTestWithHeader(t, srvr, testData{"show_webhooks_forbidden", "GET", "/appscode/webhooks", 403, nil}, header)
+	checkGolden(t, "show_webhooks_forbidden", dumpResponse(rec))
+
+	header.Set("Authorization", "Basic YWRtaW46YWRtaW4=")
+	serveTestWithHeader(t, srvr, testData{"", "GET", "/appscode/webhooks", 200, nil}, header)
+}
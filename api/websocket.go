@@ -0,0 +1,186 @@
+package api
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+)
+
+// The subset of RFC 6455 a watch needs: the server sends unfragmented
+// text messages and pings, and answers the pings and the close of the
+// client.
+
+// websocketGUID is appended to the key of the client to accept it
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xa
+)
+
+// WebSocket close codes
+const (
+	wsNormalClosure = 1000
+	wsGoingAway     = 1001
+	wsProtocolError = 1002
+	wsTooBig        = 1009
+	wsInternalError = 1011
+	wsTryAgainLater = 1013
+)
+
+// wsMaxMessage is the largest frame a client may send, a watch has no use
+// for them and discards their payload
+const wsMaxMessage = 4096
+
+var (
+	errWSClosed   = errors.New("websocket: closed by the client")
+	errWSTooBig   = errors.New("websocket: frame too big")
+	errWSUnmasked = errors.New("websocket: frame of the client not masked")
+)
+
+func websocketAccept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func validWebSocketKey(key string) bool {
+	b, err := base64.StdEncoding.DecodeString(key)
+	return err == nil && len(b) == 16
+}
+
+// wsConn is the server side of a WebSocket, the frames are written by
+// the watch and by the reader answering the client, one at a time
+type wsConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// writeFrame writes a final frame. Frames of the server are not masked.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errWSClosed
+	}
+	if opcode == wsClose {
+		c.closed = true
+	}
+
+	header := []byte{0x80 | opcode, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	n := 2
+	switch {
+	case len(payload) < 126:
+		header[1] = byte(len(payload))
+	case len(payload) <= 0xffff:
+		header[1] = 126
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+		n = 4
+	default:
+		header[1] = 127
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+		n = 10
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(watchWriteTimeout))
+	c.rw.Write(header[:n])
+	c.rw.Write(payload)
+	return c.rw.Flush()
+}
+
+// writeClose sends a close frame, nothing is written after it
+func (c *wsConn) writeClose(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return c.writeFrame(wsClose, append(payload, reason...))
+}
+
+// readFrames reads the frames of the client until it closes the
+// WebSocket or the connection, answering its pings and its close
+func (c *wsConn) readFrames() error {
+	for {
+		opcode, payload, err := c.readFrame()
+		if err == errWSTooBig {
+			c.writeClose(wsTooBig, "")
+			return err
+		} else if err == errWSUnmasked {
+			c.writeClose(wsProtocolError, "")
+			return err
+		} else if err != nil {
+			return err
+		}
+
+		switch opcode {
+		case wsPing:
+			c.writeFrame(wsPong, payload)
+		case wsClose:
+			code := wsNormalClosure
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			c.writeClose(code, "")
+			return errWSClosed
+		}
+	}
+}
+
+// readFrame reads a frame of the client and unmasks its payload
+func (c *wsConn) readFrame() (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.rw, head[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := head[0] & 0x0f
+	if head[1]&0x80 == 0 {
+		return 0, nil, errWSUnmasked
+	}
+
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > wsMaxMessage {
+		return 0, nil, errWSTooBig
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.rw, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	if opcode != wsPing && opcode != wsClose {
+		_, err := io.CopyN(ioutil.Discard, c.rw, int64(length))
+		return opcode, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.rw, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
@@ -0,0 +1,132 @@
+package api
+
+import "time"
+
+type Worker struct {
+	Username string `json:"username" xorm:"pk not null unique" validate:"required_on=create,min=3,max=32,charset=username"`
+
+	FirstName string `json:"firstname" validate:"required,max=64,charset=name"`
+	LastName  string `json:"lastname" validate:"required,max=64,charset=name"`
+
+	// City and Division are checked against the location reference data,
+	// and stored under their canonical names
+	City     string `json:"city" validate:"required,max=64,charset=name"`
+	Division string `json:"division" validate:"required,max=64"`
+
+	Position string `json:"position" validate:"required,max=64,charset=title"`
+	// Salary is in the minor unit of Currency, 5500 BDT is 55.00 taka. A
+	// worker given no currency is paid in the currency of their position.
+	Salary   int64  `json:"salary" validate:"min=0,max=1000000000000"`
+	Currency string `json:"currency" xorm:"varchar(3)" validate:"charset=currency"`
+	// SalaryOverride is the reason HR gave for a salary outside of the
+	// position's band, SalaryOverrideBy is set to the user who gave it
+	SalaryOverride   string `json:"salary_override,omitempty" validate:"max=256"`
+	SalaryOverrideBy string `json:"salary_override_by,omitempty"`
+
+	// Department and Team are the IDs of the groups the worker belongs to,
+	// the department is taken from the team when only the team is given
+	Department string `json:"department,omitempty" xorm:"index" validate:"max=32"`
+	Team       string `json:"team,omitempty" xorm:"index" validate:"max=32"`
+	// Manager is the username of the worker this one reports to
+	Manager string `json:"manager,omitempty" xorm:"index" validate:"max=32"`
+
+	// CreatedAt and UpdatedAt are stamped by the Server from its Clock,
+	// so they are plain columns rather than xorm's created/updated tags.
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	DeletedAt time.Time `json:"-" xorm:"deleted"`
+	Version   int       `json:"version" xorm:"version"`
+}
+
+// DefaultWorkers returns the initial worker profiles the server is seeded with
+func DefaultWorkers() []Worker {
+	return []Worker{
+		{
+			Username:  "masud",
+			FirstName: "Masudur",
+			LastName:  "Rahman",
+			City:      "Madaripur",
+			Division:  "Dhaka",
+			Position:  "Software Engineer",
+			Salary:    5500,
+			Currency:  "BDT",
+		},
+		{
+			Username:  "fahim",
+			FirstName: "Fahim",
+			LastName:  "Abrar",
+			City:      "Chattogram",
+			Division:  "Chattogram",
+			Position:  "Software Engineer",
+			Salary:    5500,
+			Currency:  "BDT",
+		},
+		{
+			Username:  "tahsin",
+			FirstName: "Tahsin",
+			LastName:  "Rahman",
+			City:      "Chattogram",
+			Division:  "Chattogram",
+			Position:  "Software Engineer",
+			Salary:    5500,
+			Currency:  "BDT",
+		},
+		{
+			Username:  "jenny",
+			FirstName: "Jannatul",
+			LastName:  "Ferdows",
+			City:      "Chattogram",
+			Division:  "Chattogram",
+			Position:  "Software Engineer",
+			Salary:    5500,
+			Currency:  "BDT",
+		},
+	}
+}
+
+// validateWorker checks the worker's fields for op, and then checks the
+// references to other resources of the fields that are valid on their own
+func (s *Server) validateWorker(worker *Worker, op string) ([]FieldError, error) {
+	errs := validate(worker, op)
+	checks := []struct {
+		fields []string
+		check  func(*Worker) ([]FieldError, error)
+	}{
+		{[]string{"city", "division"}, s.checkLocation},
+		{[]string{"department", "team"}, s.checkMembership},
+		{[]string{"username", "manager"}, s.checkManager},
+		{[]string{"position", "salary", "currency", "salary_override"}, s.checkPosition},
+	}
+	for _, c := range checks {
+		if hasFieldError(errs, c.fields...) {
+			continue
+		}
+		refErrs, err := c.check(worker)
+		if err != nil {
+			return nil, err
+		}
+		errs = append(errs, refErrs...)
+	}
+	return errs, nil
+}
+
+func hasFieldError(errs []FieldError, fields ...string) bool {
+	for _, e := range errs {
+		if contains(fields, e.Field) {
+			return true
+		}
+	}
+	return false
+}
+
+// SeedWorkers inserts the given profiles, skipping the ones that already exist
+func (s *Server) SeedWorkers(workers []Worker) error {
+	for _, worker := range workers {
+		worker.CreatedAt = s.clock.Now()
+		worker.UpdatedAt = worker.CreatedAt
+		if err := s.createWorker(s.store, &worker); err != nil && err != ErrAlreadyExists {
+			return err
+		}
+	}
+	return nil
+}
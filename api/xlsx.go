@@ -0,0 +1,124 @@
+package api
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// xlsxParts are the parts of a workbook of one sheet besides the sheet
+// itself, %s is the name of the sheet
+var xlsxParts = []struct {
+	name, content string
+}{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/></Relationships>`},
+	{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><fonts count="1"><font/></fonts><fills count="1"><fill/></fills><borders count="1"><border/></borders><cellStyleXfs count="1"><xf/></cellStyleXfs><cellXfs count="1"><xf/></cellXfs></styleSheet>`},
+}
+
+// xlsxWriter streams the rows of a single sheet workbook. Strings are
+// written inline instead of to a shared table, so rows don't have to be
+// kept until the end.
+type xlsxWriter struct {
+	out   io.Writer
+	sheet string
+	zip   *zip.Writer
+	w     *bufio.Writer
+}
+
+func newXLSXWriter(out io.Writer, sheet string) *xlsxWriter {
+	return &xlsxWriter{out: out, sheet: sheet}
+}
+
+func (x *xlsxWriter) writeHeader(columns []exportColumn) error {
+	x.zip = zip.NewWriter(x.out)
+	for _, part := range xlsxParts {
+		f, err := x.zip.Create(part.name)
+		if err != nil {
+			return err
+		}
+		content := part.content
+		if part.name == "xl/workbook.xml" {
+			var name bytes.Buffer
+			if err := xml.EscapeText(&name, []byte(x.sheet)); err != nil {
+				return err
+			}
+			content = fmt.Sprintf(content, name.String())
+		}
+		if _, err := io.WriteString(f, content); err != nil {
+			return err
+		}
+	}
+
+	f, err := x.zip.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	x.w = bufio.NewWriter(f)
+	x.w.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	values := make([]interface{}, len(columns))
+	for i, c := range columns {
+		values[i] = c.name
+	}
+	return x.writeRow(values)
+}
+
+func (x *xlsxWriter) writeRow(values []interface{}) error {
+	x.w.WriteString("<row>")
+	for _, v := range values {
+		switch v := v.(type) {
+		case int64:
+			x.w.WriteString(`<c t="n"><v>`)
+			x.w.WriteString(strconv.FormatInt(v, 10))
+			x.w.WriteString(`</v></c>`)
+		case time.Time:
+			x.writeString(v.Format(time.RFC3339))
+		default:
+			x.writeString(fmt.Sprint(v))
+		}
+	}
+	_, err := x.w.WriteString("</row>")
+	return err
+}
+
+func (x *xlsxWriter) writeString(s string) {
+	if s == "" {
+		x.w.WriteString("<c/>")
+		return
+	}
+	x.w.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+	// EscapeText only fails when the writer does
+	xml.EscapeText(x.w, []byte(s))
+	x.w.WriteString(`</t></is></c>`)
+}
+
+func (x *xlsxWriter) flush() error {
+	if err := x.w.Flush(); err != nil {
+		return err
+	}
+	return x.zip.Flush()
+}
+
+func (x *xlsxWriter) close() error {
+	x.w.WriteString("</sheetData></worksheet>")
+	if err := x.w.Flush(); err != nil {
+		return err
+	}
+	return x.zip.Close()
+}
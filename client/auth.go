@@ -0,0 +1,30 @@
+package client
+
+import "net/http"
+
+// Auth adds the credentials of a user to requests
+type Auth interface {
+	Apply(req *http.Request)
+}
+
+// AuthFunc is an Auth calling itself
+type AuthFunc func(req *http.Request)
+
+func (f AuthFunc) Apply(req *http.Request) { f(req) }
+
+// BasicAuth authenticates with a username and password
+func BasicAuth(username, password string) Auth {
+	return AuthFunc(func(req *http.Request) { req.SetBasicAuth(username, password) })
+}
+
+// BearerToken authenticates with a token in the Authorization header, one
+// the server was given with api.WithTokens
+func BearerToken(token string) Auth {
+	return AuthFunc(func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token) })
+}
+
+// APIKey authenticates with a key in header, the server takes the ones
+// given with api.WithAPIKeys in api.APIKeyHeader
+func APIKey(header, key string) Auth {
+	return AuthFunc(func(req *http.Request) { req.Header.Set(header, key) })
+}
@@ -0,0 +1,218 @@
+// Package client is a typed Go client of the worker API.
+//
+//	c, err := client.New("http://localhost:8080", client.WithAuth(client.BasicAuth("admin", "admin")))
+//	it := c.ListWorkers(ctx, &client.ListWorkersOptions{Department: "engineering"})
+//	for it.Next() {
+//		fmt.Println(it.Worker().Username)
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls the API of one server, it is safe for concurrent use
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	auth       Auth
+	userAgent  string
+
+	// retries is how many times a failed request is sent again, the
+	// waits between them grow from minBackoff up to maxBackoff
+	retries    int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the http.Client requests are sent with,
+// http.DefaultClient by default
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithAuth sets how requests are authenticated, they aren't by default
+func WithAuth(auth Auth) Option {
+	return func(c *Client) { c.auth = auth }
+}
+
+// WithUserAgent sets the User-Agent header of the requests
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) { c.userAgent = userAgent }
+}
+
+// WithRetries sets how many times a failed request is retried, 3 by
+// default, zero turns retrying off
+func WithRetries(retries int) Option {
+	return func(c *Client) { c.retries = retries }
+}
+
+// WithBackoff sets the wait before the first retry, which doubles with
+// every retry up to max. It is 100ms up to 5s by default.
+func WithBackoff(min, max time.Duration) Option {
+	return func(c *Client) { c.minBackoff, c.maxBackoff = min, max }
+}
+
+// New returns a Client of the server at baseURL, e.g. http://localhost:8080
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("client: the base URL %q isn't an http or https URL", baseURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	c := &Client{
+		baseURL:    u,
+		httpClient: http.DefaultClient,
+		userAgent:  "apiserver-client",
+		retries:    3,
+		minBackoff: 100 * time.Millisecond,
+		maxBackoff: 5 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// request is a call of the API, path is relative to the base URL
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	body   interface{}
+}
+
+// do sends req, retrying it as retryable allows, and decodes the response
+// body into out unless out is nil. It returns the headers of the response.
+func (c *Client) do(ctx context.Context, req request, out interface{}) (http.Header, error) {
+	var body []byte
+	if req.body != nil {
+		var err error
+		if body, err = json.Marshal(req.body); err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, req, body)
+		if err == nil && resp.StatusCode < 300 {
+			defer drain(resp.Body)
+			if out != nil {
+				if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+					return nil, fmt.Errorf("client: can't decode the response of %s %s: %v", req.method, req.path, err)
+				}
+			}
+			return resp.Header, nil
+		}
+		if err == nil {
+			err = responseError(resp)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if attempt >= c.retries || !retryable(req.method, resp) {
+			return nil, err
+		}
+		if err := sleep(ctx, c.backoff(attempt, resp)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, req request, body []byte) (*http.Response, error) {
+	u := *c.baseURL
+	u.Path += req.path
+	u.RawQuery = req.query.Encode()
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequest(req.method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	httpReq = httpReq.WithContext(ctx)
+	httpReq.Header.Set("Accept", "application/json")
+	httpReq.Header.Set("User-Agent", c.userAgent)
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if c.auth != nil {
+		c.auth.Apply(httpReq)
+	}
+	return c.httpClient.Do(httpReq)
+}
+
+// retryable tells if a request can be sent again after it failed with
+// resp, which is nil when the request didn't get a response. Every
+// request can be retried when the server is rate limiting, but only the
+// idempotent ones when it failed or couldn't be reached, as a POST may
+// have been done before the failure.
+func retryable(method string, resp *http.Response) bool {
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE":
+		return resp == nil || resp.StatusCode >= 500
+	}
+	return false
+}
+
+// backoff returns how long to wait before the retry after attempt, as
+// told by the Retry-After header of resp if it has one
+func (c *Client) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	wait := c.minBackoff << uint(attempt)
+	if wait > c.maxBackoff || wait <= 0 {
+		wait = c.maxBackoff
+	}
+	// The jitter keeps clients failing together from retrying together
+	if wait > 0 {
+		wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+	}
+	return wait
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drain reads the rest of a body so the connection can be reused
+func drain(body io.ReadCloser) {
+	io.Copy(ioutil.Discard, io.LimitReader(body, 1<<16))
+	body.Close()
+}
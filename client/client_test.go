@@ -0,0 +1,279 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/masudur-rahman/apiserver/api"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var dbCounter int32
+
+// newTestServer serves the API from an in-memory SQLite database seeded
+// with the default workers, through handler which may wrap the API
+func newTestServer(t *testing.T, wrap func(http.Handler) http.Handler, opts ...api.Option) *httptest.Server {
+	name := fmt.Sprintf("file:client%d?mode=memory&cache=shared", atomic.AddInt32(&dbCounter, 1))
+	engine, err := api.NewXormEngine("sqlite3", name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := api.NewXormStore(engine)
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	srvr := api.NewServer(append([]api.Option{
+		api.WithStore(store),
+		api.WithBypassAuth(false),
+		api.WithAuthProvider(api.StaticAuth{"admin": "admin"}),
+		api.WithLogger(log.New(ioutil.Discard, "", 0)),
+	}, opts...)...)
+	if err := srvr.SeedLocations(api.BangladeshLocations()); err != nil {
+		t.Fatal(err)
+	}
+	if err := srvr.SeedPositions(api.DefaultPositions()); err != nil {
+		t.Fatal(err)
+	}
+	if err := srvr.SeedWorkers(api.DefaultWorkers()); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := srvr.Handler()
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+	return httptest.NewServer(handler)
+}
+
+func newTestClient(t *testing.T, ts *httptest.Server, opts ...Option) *Client {
+	opts = append([]Option{WithAuth(BasicAuth("admin", "admin")), WithBackoff(time.Millisecond, 10*time.Millisecond)}, opts...)
+	c, err := New(ts.URL, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// counting counts the requests reaching the API
+func counting(requests *int32) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(requests, 1)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestListWorkers(t *testing.T) {
+	var requests int32
+	ts := newTestServer(t, counting(&requests))
+	defer ts.Close()
+	c := newTestClient(t, ts)
+
+	workers, err := c.ListWorkers(context.Background(), &ListWorkersOptions{PageSize: 2, Currency: "BDT"}).All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var usernames []string
+	for _, w := range workers {
+		usernames = append(usernames, w.Username)
+		if w.Currency != "BDT" {
+			t.Errorf("%s: got currency %q expected BDT", w.Username, w.Currency)
+		}
+	}
+	expected := []string{"fahim", "jenny", "masud", "tahsin"}
+	if !reflect.DeepEqual(usernames, expected) {
+		t.Errorf("got workers %v expected %v", usernames, expected)
+	}
+	// Two full pages, and an empty one telling they were the last
+	if requests != 3 {
+		t.Errorf("got %d requests expected 3", requests)
+	}
+
+	it := c.ListWorkers(context.Background(), &ListWorkersOptions{Department: "nowhere"})
+	if it.Next() {
+		t.Errorf("got worker %s of a department without workers", it.Worker().Username)
+	}
+	if it.Err() != nil {
+		t.Error(it.Err())
+	}
+}
+
+func TestWorkerLifecycle(t *testing.T) {
+	ts := newTestServer(t, nil)
+	defer ts.Close()
+	c := newTestClient(t, ts)
+	ctx := context.Background()
+
+	worker := &Worker{
+		Username:  "rahim",
+		FirstName: "Rahim",
+		LastName:  "Uddin",
+		City:      "Madaripur",
+		Division:  "Dhaka",
+		Position:  "Software Engineer",
+		Salary:    6000,
+	}
+	created, err := c.CreateWorker(ctx, worker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Version != 1 || created.Currency != "BDT" {
+		t.Errorf("got version %d and currency %q of the created worker", created.Version, created.Currency)
+	}
+	if _, err := c.CreateWorker(ctx, worker); !IsConflict(err) {
+		t.Errorf("got %v creating a worker twice, expected a conflict", err)
+	}
+
+	created.City, created.Division = "Chittagong", "Chittagong"
+	if err := c.UpdateWorker(ctx, created); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := c.GetWorker(ctx, "rahim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.City != "Chattogram" || updated.Version != 2 {
+		t.Errorf("got city %q and version %d after the update", updated.City, updated.Version)
+	}
+
+	if err := c.DeleteWorker(ctx, "rahim", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetWorker(ctx, "rahim"); !IsNotFound(err) {
+		t.Errorf("got %v getting a deleted worker, expected not found", err)
+	}
+
+	restored, err := c.RestoreWorker(ctx, "rahim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.City != "Chattogram" {
+		t.Errorf("got city %q of the restored worker", restored.City)
+	}
+	if _, err := c.RestoreWorker(ctx, "rahim"); !IsConflict(err) {
+		t.Errorf("got %v restoring a live worker, expected a conflict", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	ts := newTestServer(t, nil)
+	defer ts.Close()
+	ctx := context.Background()
+
+	_, err := newTestClient(t, ts).CreateWorker(ctx, &Worker{Username: "x"})
+	if !IsValidation(err) {
+		t.Fatalf("got %v creating an invalid worker, expected a validation error", err)
+	}
+	e := err.(*Error)
+	if e.Type != "/problems/validation" || len(e.Errors) == 0 || e.Errors[0].Field != "username" {
+		t.Errorf("got %+v", e)
+	}
+
+	_, err = newTestClient(t, ts, WithAuth(BasicAuth("admin", "wrong"))).GetWorker(ctx, "masud")
+	if !IsUnauthorized(err) {
+		t.Errorf("got %v with a wrong password, expected unauthorized", err)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	ts := newTestServer(t, nil, api.WithTokens(map[string]string{"token": "admin"}), api.WithAPIKeys(map[string]string{"key": "admin"}))
+	defer ts.Close()
+
+	for _, data := range []struct {
+		name string
+		auth Auth
+		ok   bool
+	}{
+		{"basic", BasicAuth("admin", "admin"), true},
+		{"bearer", BearerToken("token"), true},
+		{"api_key", APIKey(api.APIKeyHeader, "key"), true},
+		{"wrong_password", BasicAuth("admin", "wrong"), false},
+		{"wrong_token", BearerToken("wrong"), false},
+		{"wrong_api_key", APIKey(api.APIKeyHeader, "wrong"), false},
+	} {
+		c, err := New(ts.URL, WithAuth(data.auth))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.GetWorker(context.Background(), "masud")
+		if data.ok && err != nil {
+			t.Errorf("%s: %v", data.name, err)
+		} else if !data.ok && !IsUnauthorized(err) {
+			t.Errorf("%s: got %v expected 401", data.name, err)
+		}
+	}
+}
+
+// failing answers the first failures requests with status
+func failing(failures int32, status int, requests *int32) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(requests, 1) <= failures {
+				w.Header().Set("Retry-After", "0")
+				http.Error(w, http.StatusText(status), status)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestRetries(t *testing.T) {
+	ctx := context.Background()
+	newWorker := &Worker{Username: "rahim", FirstName: "Rahim", LastName: "Uddin", City: "Madaripur", Division: "Dhaka", Position: "Software Engineer", Salary: 6000}
+
+	for _, data := range []struct {
+		name     string
+		status   int
+		failures int32
+		call     func(c *Client) error
+		ok       bool
+		requests int32
+	}{
+		{"get_after_unavailable", http.StatusServiceUnavailable, 2, func(c *Client) error { _, err := c.GetWorker(ctx, "masud"); return err }, true, 3},
+		{"get_after_rate_limit", http.StatusTooManyRequests, 1, func(c *Client) error { _, err := c.GetWorker(ctx, "masud"); return err }, true, 2},
+		{"get_out_of_retries", http.StatusBadGateway, 10, func(c *Client) error { _, err := c.GetWorker(ctx, "masud"); return err }, false, 4},
+		{"post_after_rate_limit", http.StatusTooManyRequests, 1, func(c *Client) error { _, err := c.CreateWorker(ctx, newWorker); return err }, true, 2},
+		{"post_after_failure", http.StatusInternalServerError, 1, func(c *Client) error { _, err := c.CreateWorker(ctx, newWorker); return err }, false, 1},
+		{"delete_after_failure", http.StatusInternalServerError, 1, func(c *Client) error { return c.DeleteWorker(ctx, "fahim", nil) }, true, 2},
+	} {
+		var requests int32
+		ts := newTestServer(t, failing(data.failures, data.status, &requests))
+		err := data.call(newTestClient(t, ts))
+		if data.ok && err != nil {
+			t.Errorf("%s: %v", data.name, err)
+		}
+		if !data.ok && statusOf(err) != data.status {
+			t.Errorf("%s: got %v expected a %d", data.name, err, data.status)
+		}
+		if requests != data.requests {
+			t.Errorf("%s: got %d requests expected %d", data.name, requests, data.requests)
+		}
+		ts.Close()
+	}
+}
+
+func TestRetryCanceled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	// Without Retry-After the client would wait the hour of its backoff
+	c := newTestClient(t, ts, WithBackoff(time.Hour, time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.GetWorker(ctx, "masud"); err != context.DeadlineExceeded {
+		t.Errorf("got %v expected the deadline to be exceeded", err)
+	}
+}
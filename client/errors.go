@@ -0,0 +1,98 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Error is a failed response of the server, decoded from its RFC 7807
+// problem document when it has one
+type Error struct {
+	// StatusCode is the HTTP status of the response
+	StatusCode int `json:"status"`
+	// Type is the URI reference of the kind of problem, e.g.
+	// /problems/not-found
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Detail    string       `json:"detail"`
+	RequestID string       `json:"request_id"`
+	Errors    []FieldError `json:"errors"`
+}
+
+// FieldError tells which field of the request failed validation and why
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%d %s", e.StatusCode, e.Title)
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	for _, fe := range e.Errors {
+		msg += fmt.Sprintf("; %s %s", fe.Field, fe.Message)
+	}
+	return msg
+}
+
+// responseError reads the failed response resp into an *Error
+func responseError(resp *http.Response) error {
+	defer drain(resp.Body)
+
+	e := &Error{StatusCode: resp.StatusCode, Title: http.StatusText(resp.StatusCode)}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return e
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "application/problem+json" || mediaType == "application/json" {
+		if json.Unmarshal(body, e) == nil {
+			e.StatusCode = resp.StatusCode
+			return e
+		}
+	}
+	e.Detail = strings.TrimSpace(string(body))
+	return e
+}
+
+// statusOf returns the status of the response err was made from, or 0 if
+// err isn't an *Error
+func statusOf(err error) int {
+	if e, ok := err.(*Error); ok {
+		return e.StatusCode
+	}
+	return 0
+}
+
+// IsBadRequest tells if the server couldn't read the request
+func IsBadRequest(err error) bool { return statusOf(err) == http.StatusBadRequest }
+
+// IsUnauthorized tells if the request had no valid credentials
+func IsUnauthorized(err error) bool { return statusOf(err) == http.StatusUnauthorized }
+
+// IsForbidden tells if the user isn't allowed to make the request
+func IsForbidden(err error) bool { return statusOf(err) == http.StatusForbidden }
+
+// IsNotFound tells if the resource doesn't exist
+func IsNotFound(err error) bool { return statusOf(err) == http.StatusNotFound }
+
+// IsConflict tells if the request conflicts with the stored data, e.g. a
+// username that is taken
+func IsConflict(err error) bool { return statusOf(err) == http.StatusConflict }
+
+// IsValidation tells if fields of the request are invalid, the Errors of
+// the *Error tell which
+func IsValidation(err error) bool { return statusOf(err) == http.StatusUnprocessableEntity }
+
+// IsRateLimited tells if the server turned the request down for coming
+// too often, after all the retries
+func IsRateLimited(err error) bool { return statusOf(err) == http.StatusTooManyRequests }
+
+// IsServerError tells if the server failed to handle the request
+func IsServerError(err error) bool { return statusOf(err) >= 500 }
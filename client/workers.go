@@ -0,0 +1,226 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Worker is the profile of a worker
+type Worker struct {
+	Username  string `json:"username"`
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
+	City      string `json:"city"`
+	Division  string `json:"division"`
+	Position  string `json:"position"`
+	// Salary is in the minor unit of Currency, 5500 BDT is 55.00 taka
+	Salary   int64  `json:"salary"`
+	Currency string `json:"currency,omitempty"`
+	// SalaryOverride is the reason for a salary outside of the
+	// position's band, only users with the hr role can give one
+	SalaryOverride   string `json:"salary_override,omitempty"`
+	SalaryOverrideBy string `json:"salary_override_by,omitempty"`
+
+	Department string `json:"department,omitempty"`
+	Team       string `json:"team,omitempty"`
+	Manager    string `json:"manager,omitempty"`
+
+	// CreatedAt, UpdatedAt and Version are set by the server
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Version   int       `json:"version"`
+}
+
+const workersPath = "/appscode/workers"
+
+func workerPath(username string) string {
+	return workersPath + "/" + url.PathEscape(username)
+}
+
+// ListWorkersOptions selects the workers ListWorkers lists, the zero
+// value lists all of them
+type ListWorkersOptions struct {
+	Department string
+	Team       string
+	Manager    string
+	// Currency converts the salaries, at the rates of Date (YYYY-MM-DD)
+	// or today's
+	Currency string
+	Date     string
+	// PageSize is how many workers are fetched with a request, 100 by
+	// default
+	PageSize int
+}
+
+func (o *ListWorkersOptions) query() url.Values {
+	query := make(url.Values)
+	set := func(key, value string) {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	set("department", o.Department)
+	set("team", o.Team)
+	set("manager", o.Manager)
+	set("currency", o.Currency)
+	set("date", o.Date)
+	return query
+}
+
+// WorkerIterator walks the workers page by page, in the order of their
+// usernames
+type WorkerIterator struct {
+	c     *Client
+	ctx   context.Context
+	query url.Values
+	page  []Worker
+	// done tells there are no more pages after page
+	done   bool
+	worker Worker
+	err    error
+}
+
+// ListWorkers returns an iterator over the workers selected by opts,
+// which may be nil. Pages are fetched as the iterator reaches them.
+func (c *Client) ListWorkers(ctx context.Context, opts *ListWorkersOptions) *WorkerIterator {
+	if opts == nil {
+		opts = &ListWorkersOptions{}
+	}
+	query := opts.query()
+	pageSize := opts.PageSize
+	if pageSize <= 0 {
+		pageSize = 100
+	}
+	query.Set("limit", strconv.Itoa(pageSize))
+	return &WorkerIterator{c: c, ctx: ctx, query: query}
+}
+
+// Next moves to the next worker, fetching the next page if needed. It
+// returns false at the end of the list or on an error, which Err tells.
+func (it *WorkerIterator) Next() bool {
+	for len(it.page) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		it.fetch()
+	}
+	it.worker, it.page = it.page[0], it.page[1:]
+	return true
+}
+
+func (it *WorkerIterator) fetch() {
+	var page []Worker
+	header, err := it.c.do(it.ctx, request{method: "GET", path: workersPath, query: it.query}, &page)
+	if err != nil {
+		it.err = err
+		return
+	}
+	it.page = page
+
+	next := nextLink(header)
+	if next == "" {
+		it.done = true
+		return
+	}
+	// The link is relative to the server, which may be mounted under a
+	// prefix the server doesn't know of, so only its query is followed
+	u, err := url.Parse(next)
+	if err != nil {
+		it.err = err
+		return
+	}
+	it.query = u.Query()
+}
+
+// Worker returns the worker Next moved to
+func (it *WorkerIterator) Worker() Worker {
+	return it.worker
+}
+
+// Err returns the error that stopped the iteration, if any
+func (it *WorkerIterator) Err() error {
+	return it.err
+}
+
+// All collects the rest of the workers
+func (it *WorkerIterator) All() ([]Worker, error) {
+	workers := make([]Worker, 0)
+	for it.Next() {
+		workers = append(workers, it.Worker())
+	}
+	return workers, it.Err()
+}
+
+// nextLink returns the URI of the rel="next" link of a Link header
+func nextLink(header http.Header) string {
+	for _, value := range header["Link"] {
+		for _, link := range strings.Split(value, ",") {
+			parts := strings.Split(link, ";")
+			uri := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(uri, "<") || !strings.HasSuffix(uri, ">") {
+				continue
+			}
+			for _, param := range parts[1:] {
+				if strings.TrimSpace(param) == `rel="next"` || strings.TrimSpace(param) == "rel=next" {
+					return uri[1 : len(uri)-1]
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// GetWorker returns the worker with username
+func (c *Client) GetWorker(ctx context.Context, username string) (*Worker, error) {
+	worker := new(Worker)
+	if _, err := c.do(ctx, request{method: "GET", path: workerPath(username)}, worker); err != nil {
+		return nil, err
+	}
+	return worker, nil
+}
+
+// CreateWorker adds worker and returns it as stored by the server
+func (c *Client) CreateWorker(ctx context.Context, worker *Worker) (*Worker, error) {
+	created := new(Worker)
+	if _, err := c.do(ctx, request{method: "POST", path: workersPath, body: worker}, created); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// UpdateWorker replaces the profile of the worker with worker.Username
+func (c *Client) UpdateWorker(ctx context.Context, worker *Worker) error {
+	_, err := c.do(ctx, request{method: "PUT", path: workerPath(worker.Username), body: worker}, nil)
+	return err
+}
+
+// DeleteWorkerOptions tell how to delete a worker
+type DeleteWorkerOptions struct {
+	// ReassignTo is the username of the worker who takes over the
+	// reports of the deleted one, a worker with reports can't be
+	// deleted without it
+	ReassignTo string
+}
+
+// DeleteWorker deletes the worker with username, opts may be nil
+func (c *Client) DeleteWorker(ctx context.Context, username string, opts *DeleteWorkerOptions) error {
+	query := make(url.Values)
+	if opts != nil && opts.ReassignTo != "" {
+		query.Set("reassign_to", opts.ReassignTo)
+	}
+	_, err := c.do(ctx, request{method: "DELETE", path: workerPath(username), query: query}, nil)
+	return err
+}
+
+// RestoreWorker brings a deleted worker back and returns them
+func (c *Client) RestoreWorker(ctx context.Context, username string) (*Worker, error) {
+	worker := new(Worker)
+	if _, err := c.do(ctx, request{method: "POST", path: workerPath(username) + "/restore"}, worker); err != nil {
+		return nil, err
+	}
+	return worker, nil
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// bashCompletion completes the usernames of the workers commands taking
+// one, fetching them from the server of the environment or config file
+const bashCompletion = `
+__apiserver_worker_usernames()
+{
+    local usernames
+    if usernames=$(apiserver workers usernames 2>/dev/null); then
+        COMPREPLY=( $(compgen -W "${usernames}" -- "$cur") )
+    fi
+}
+
+__custom_func()
+{
+    case ${last_command} in
+        apiserver_workers_get | apiserver_workers_update | apiserver_workers_delete | apiserver_workers_restore)
+            __apiserver_worker_usernames
+            return
+            ;;
+    esac
+}
+`
+
+var completionCmd = &cobra.Command{
+	Use:   "completion bash|zsh",
+	Short: "Print the shell completion script",
+	Long: "This prints the completion script of the shell, e.g. source <(apiserver completion bash)." +
+		" Bash also completes the usernames of the workers commands from the server.",
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{"bash", "zsh"},
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		switch args[0] {
+		case "bash":
+			err = rootCmd.GenBashCompletion(os.Stdout)
+		case "zsh":
+			err = rootCmd.GenZshCompletion(os.Stdout)
+		default:
+			log.Fatalf("there is no completion of %s, only of bash and zsh\n", args[0])
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.BashCompletionFunction = bashCompletion
+	rootCmd.AddCommand(completionCmd)
+}
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/masudur-rahman/apiserver/client"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// defaultServer is the server the client commands talk to when neither
+// a flag, the environment nor a context names one
+const defaultServer = "http://localhost:8080"
+
+// Config is the ~/.apiserver/config file, holding the servers the client
+// commands can talk to as named contexts
+//
+//	current-context: local
+//	contexts:
+//	- name: local
+//	  server: http://localhost:8080
+//	  user: admin
+//	  password: admin
+type Config struct {
+	CurrentContext string    `yaml:"current-context"`
+	Contexts       []Context `yaml:"contexts"`
+}
+
+// Context is a server and the credentials to use with it, a token is
+// sent as a bearer token instead of the user and password, the server
+// takes the ones given to apiserver start --token
+type Context struct {
+	Name     string `yaml:"name"`
+	Server   string `yaml:"server"`
+	User     string `yaml:"user,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	Token    string `yaml:"token,omitempty"`
+}
+
+var (
+	configFile  string
+	contextName string
+	connection  Context
+)
+
+// addConnectionFlags adds the flags choosing the server and credentials
+// of the client commands under cmd
+func addConnectionFlags(cmd *cobra.Command) {
+	flags := cmd.PersistentFlags()
+	flags.StringVar(&configFile, "config", defaultConfigFile(), "the file holding the contexts, $APISERVER_CONFIG")
+	flags.StringVar(&contextName, "context", "", "the context of the config file to use instead of its current one, $APISERVER_CONTEXT")
+	flags.StringVar(&connection.Server, "server", "", "the URL of the server, $APISERVER_SERVER")
+	flags.StringVarP(&connection.User, "user", "u", "", "the user to authenticate as, $APISERVER_USER")
+	flags.StringVar(&connection.Password, "password", "", "the password of the user, $APISERVER_PASSWORD")
+	flags.StringVar(&connection.Token, "token", "", "a bearer token to authenticate with instead of a user, $APISERVER_TOKEN")
+}
+
+func defaultConfigFile() string {
+	if file := os.Getenv("APISERVER_CONFIG"); file != "" {
+		return file
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".apiserver", "config")
+}
+
+// loadConfig reads the config file, a missing one is an empty config
+func loadConfig(file string) (*Config, error) {
+	config := new(Config)
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) || file == "" {
+		return config, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return config, nil
+}
+
+// resolveContext fills in the settings the flags left out from the
+// environment, then from the context of the config file
+func resolveContext() (Context, error) {
+	resolved := connection
+	env := []struct {
+		value *string
+		name  string
+	}{
+		{&resolved.Server, "APISERVER_SERVER"},
+		{&resolved.User, "APISERVER_USER"},
+		{&resolved.Password, "APISERVER_PASSWORD"},
+		{&resolved.Token, "APISERVER_TOKEN"},
+	}
+	for _, e := range env {
+		if *e.value == "" {
+			*e.value = os.Getenv(e.name)
+		}
+	}
+
+	config, err := loadConfig(configFile)
+	if err != nil {
+		return resolved, err
+	}
+	name := contextName
+	if name == "" {
+		name = os.Getenv("APISERVER_CONTEXT")
+	}
+	explicit := name != ""
+	if name == "" {
+		name = config.CurrentContext
+	}
+
+	var found *Context
+	for i := range config.Contexts {
+		if config.Contexts[i].Name == name {
+			found = &config.Contexts[i]
+		}
+	}
+	if found == nil && explicit {
+		return resolved, fmt.Errorf("there is no context %q in %s", name, configFile)
+	}
+	// The credentials of a context only go to its own server
+	if found != nil && (resolved.Server == "" || resolved.Server == found.Server) {
+		resolved.Server = found.Server
+		if resolved.User == "" && resolved.Token == "" {
+			resolved.User, resolved.Password, resolved.Token = found.User, found.Password, found.Token
+		}
+	}
+	if resolved.Server == "" {
+		resolved.Server = defaultServer
+	}
+	return resolved, nil
+}
+
+// newClient returns a client of the server chosen by the flags,
+// environment and config file
+func newClient() (*client.Client, error) {
+	ctx, err := resolveContext()
+	if err != nil {
+		return nil, err
+	}
+	var opts []client.Option
+	switch {
+	case ctx.Token != "":
+		opts = append(opts, client.WithAuth(client.BearerToken(ctx.Token)))
+	case ctx.User != "":
+		opts = append(opts, client.WithAuth(client.BasicAuth(ctx.User, ctx.Password)))
+	}
+	return client.New(ctx.Server, opts...)
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/masudur-rahman/apiserver/api"
+	"github.com/spf13/cobra"
+)
+
+var dryRun bool
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Migrate the stored data",
+	Long:  "This rewrites the stored data into its current form",
+}
+
+var migrateLocationsCmd = &cobra.Command{
+	Use:   "locations",
+	Short: "Map the workers' city and division to the location reference data",
+	Long: "This replaces the free text city and division of every worker" +
+		" with the canonical names and reports the values it couldn't match",
+	Run: func(cmd *cobra.Command, args []string) {
+		store, closeStore := openStore()
+		defer closeStore()
+
+		srvr := api.NewServer(api.WithStore(store))
+		if err := srvr.SeedLocations(api.BangladeshLocations()); err != nil {
+			log.Fatalln(err)
+		}
+
+		report, err := srvr.MigrateLocations(dryRun)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		for _, change := range report.Changes {
+			fmt.Printf("%s: %s %q -> %q\n", change.Username, change.Field, change.From, change.To)
+		}
+		for _, unmatched := range report.Unmatched {
+			fmt.Printf("%s: %s %q doesn't match any location\n", unmatched.Username, unmatched.Field, unmatched.From)
+		}
+		updated := "updated"
+		if dryRun {
+			updated = "to update"
+		}
+		fmt.Printf("%d workers checked, %d %s, %d values unmatched\n", report.Checked, report.Updated, updated, len(report.Unmatched))
+	},
+}
+
+var migrateSalariesCmd = &cobra.Command{
+	Use:   "salaries",
+	Short: "Store the salaries in minor units of a currency",
+	Long: "This converts the salaries stored before they had a currency, and the" +
+		" salary bands of the positions, from whole units to minor units of" +
+		" the position's currency. Run it once after upgrading.",
+	Run: func(cmd *cobra.Command, args []string) {
+		store, closeStore := openStore()
+		defer closeStore()
+
+		srvr := api.NewServer(api.WithStore(store))
+		report, err := srvr.MigrateSalaries(dryRun)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		converted := "converted"
+		if dryRun {
+			converted = "to convert"
+		}
+		fmt.Printf("%d workers, %d positions and %d employment records %s\n", report.Workers, report.Positions, report.Records, converted)
+	},
+}
+
+func init() {
+	migrateLocationsCmd.Flags().BoolVar(&dryRun, "dry-run", false, "report the changes without writing them")
+
+	migrateSalariesCmd.Flags().BoolVar(&dryRun, "dry-run", false, "report the changes without writing them")
+
+	migrateCmd.AddCommand(migrateLocationsCmd)
+	migrateCmd.AddCommand(migrateSalariesCmd)
+	rootCmd.AddCommand(migrateCmd)
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/masudur-rahman/apiserver/api"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(openapiCmd)
+}
+
+var openapiCmd = &cobra.Command{
+	Use:   "openapi",
+	Short: "Print the OpenAPI document of the API",
+	Long:  "This prints the OpenAPI 3.1 document served at /openapi.json, for generating clients without a running server",
+	Run: func(cmd *cobra.Command, args []string) {
+		doc, err := api.NewServer().OpenAPI()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if _, err := os.Stdout.Write(doc); err != nil {
+			log.Fatalln(err)
+		}
+	},
+}
@@ -2,11 +2,15 @@ package cmd
 
 import (
 	"fmt"
+	"log"
 	"os"
 
+	"github.com/masudur-rahman/apiserver/api"
 	"github.com/spf13/cobra"
 )
 
+var database string
+
 var rootCmd = &cobra.Command{
 	Use:   "apiserver",
 	Short: "It's a server containing workers of appscode",
@@ -23,3 +27,26 @@ func Execute() {
 		os.Exit(1)
 	}
 }
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&database, "database", "user=masud password=masud123 host=127.0.0.1 port=5432 dbname=apiserver sslmode=disable", "postgres connection string")
+}
+
+// openStore connects to the database and creates the missing tables,
+// the returned func closes the connection
+func openStore() (*api.XormStore, func()) {
+	engine, err := api.NewXormEngine("postgres", database, "apiserver.log")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	store := api.NewXormStore(engine)
+	if err := store.Sync(); err != nil {
+		log.Fatalln(err)
+	}
+	return store, func() {
+		if err := engine.Close(); err != nil {
+			log.Println(err)
+		}
+	}
+}
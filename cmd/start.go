@@ -1,28 +1,156 @@
 package cmd
 
 import (
-	"github.com/spf13/cobra"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
 	"github.com/masudur-rahman/apiserver/api"
+	"github.com/spf13/cobra"
 )
 
 var port string
+var grpcPort string
 var bypass bool
 var stopTime int16
+var gracefulTimeout time.Duration
+var rateLimit string
+var routeRateLimits []string
+var trustedProxies []string
+var sharedRateLimits bool
+var cacheSize int64
+var cacheTTL time.Duration
+var tokens []string
+var apiKeys []string
 
 var startApp = &cobra.Command{
 	Use:   "start",
 	Short: "Start the app",
 	Long:  "This starts the apiserver",
 	Run: func(cmd *cobra.Command, args []string) {
-		api.AssignValues(port, bypass, stopTime)
-		api.StartTheApp()
+		store, closeStore := openStore()
+		defer closeStore()
+
+		opts := []api.Option{
+			api.WithAddr(":" + port),
+			api.WithStore(store),
+			api.WithBypassAuth(bypass),
+			api.WithGracefulTimeout(gracefulTimeout),
+		}
+		if grpcPort != "" {
+			opts = append(opts, api.WithGRPCAddr(":"+grpcPort))
+		}
+		limitOpts, err := rateLimitOptions()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		opts = append(opts, limitOpts...)
+		credentialOpts, err := credentialOptions()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		opts = append(opts, credentialOpts...)
+		if cacheSize > 0 {
+			opts = append(opts, api.WithResponseCache(api.NewLRUCache(cacheSize<<20), cacheTTL))
+		}
+		srvr := api.NewServer(opts...)
+		if err := srvr.SeedLocations(api.BangladeshLocations()); err != nil {
+			log.Fatalln(err)
+		}
+		if err := srvr.SeedPositions(api.DefaultPositions()); err != nil {
+			log.Fatalln(err)
+		}
+		if err := srvr.SeedWorkers(api.DefaultWorkers()); err != nil {
+			log.Fatalln(err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// Channel to interrupt the server from keyboard
+		go func() {
+			channel := make(chan os.Signal, 1)
+			signal.Notify(channel, os.Interrupt)
+			<-channel
+
+			time.Sleep(time.Second * time.Duration(stopTime))
+			cancel()
+		}()
+
+		if err := srvr.Run(ctx); err != nil {
+			log.Fatalln(err)
+		}
 	},
 }
 
+// rateLimitOptions reads the rate limits of the flags
+func rateLimitOptions() ([]api.Option, error) {
+	var opts []api.Option
+	if rateLimit != "" {
+		limit, err := api.ParseRateLimit(rateLimit)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, api.WithRateLimit(limit))
+	}
+	for _, spec := range routeRateLimits {
+		route, limit, err := api.ParseRouteRateLimit(spec)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, api.WithRouteRateLimit(route, limit))
+	}
+	proxies, err := api.ParseTrustedProxies(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return append(opts, api.WithTrustedProxies(proxies...), api.WithSharedRateLimits(sharedRateLimits)), nil
+}
+
+// credentialOptions reads the bearer tokens and the API keys of the flags
+func credentialOptions() ([]api.Option, error) {
+	tokenUsers, err := secretUsers("--token", tokens)
+	if err != nil {
+		return nil, err
+	}
+	keyUsers, err := secretUsers("--api-key", apiKeys)
+	if err != nil {
+		return nil, err
+	}
+	return []api.Option{api.WithTokens(tokenUsers), api.WithAPIKeys(keyUsers)}, nil
+}
+
+// secretUsers maps the secrets given to flag as user=secret to their users
+func secretUsers(flag string, specs []string) (map[string]string, error) {
+	users := make(map[string]string, len(specs))
+	for _, spec := range specs {
+		i := strings.Index(spec, "=")
+		if i <= 0 || i == len(spec)-1 {
+			return nil, fmt.Errorf("%s %q is not user=secret", flag, spec)
+		}
+		users[spec[i+1:]] = spec[:i]
+	}
+	return users, nil
+}
+
 func init() {
 	startApp.PersistentFlags().StringVarP(&port, "port", "p", "8080", "port number for the server")
+	startApp.PersistentFlags().StringVar(&grpcPort, "grpc-port", "9090", "port number for the gRPC server, empty to serve HTTP only")
 	startApp.PersistentFlags().BoolVarP(&bypass, "bypass", "b", false, "Bypass authentication parameter")
 	startApp.PersistentFlags().Int16VarP(&stopTime, "stopTime", "s", 0, "The time after which the server will stop")
+	startApp.PersistentFlags().StringVar(&rateLimit, "rate-limit", "600/m", "the requests every client can make, e.g. 10/s or 600/m, empty for no limit")
+	startApp.PersistentFlags().StringArrayVar(&routeRateLimits, "route-rate-limit", nil, "a limit of its own for a route, e.g. 'POST /appscode/workers/import=10/h', can be repeated")
+	startApp.PersistentFlags().StringSliceVar(&trustedProxies, "trusted-proxy", nil, "the addresses or CIDR ranges of the proxies whose X-Forwarded-For tells the clients apart")
+	startApp.PersistentFlags().BoolVar(&sharedRateLimits, "shared-rate-limits", false, "keep the rate limits in the database, so they hold across the servers sharing it")
+	startApp.PersistentFlags().StringArrayVar(&tokens, "token", nil, "a bearer token a user can authenticate with, as user=token, can be repeated")
+	startApp.PersistentFlags().StringArrayVar(&apiKeys, "api-key", nil, "an API key a user can authenticate with in the X-API-Key header, as user=key, can be repeated")
+	startApp.PersistentFlags().Int64Var(&cacheSize, "cache-size", 0, "the MiB of worker reads to keep in memory, 0 for no cache")
+	startApp.PersistentFlags().DurationVar(&cacheTTL, "cache-ttl", time.Minute, "how long a cached worker read is served for at most - e.g. 30s or 5m")
+	startApp.PersistentFlags().DurationVar(&gracefulTimeout, "graceful-timeout", time.Second*15, "the duration for which the server gracefully wait for existing connections to finish - e.g. 15s or 1m")
 
 	rootCmd.AddCommand(startApp)
 }
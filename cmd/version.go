@@ -2,6 +2,8 @@ package cmd
 
 import (
 	"fmt"
+
+	"github.com/masudur-rahman/apiserver/api"
 	"github.com/spf13/cobra"
 )
 
@@ -14,6 +16,6 @@ var versionCmd = &cobra.Command{
 	Short: "Version of apiserver",
 	Long:  "The version of the apiserver app is",
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("apiserver - v2.0")
+		fmt.Println("apiserver - v" + api.Version)
 	},
 }
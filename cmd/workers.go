@@ -0,0 +1,306 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/masudur-rahman/apiserver/client"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	output       string
+	fromFile     string
+	reassignTo   string
+	listWorkers  client.ListWorkersOptions
+	outputFormat = []string{"table", "json", "yaml"}
+)
+
+var workersCmd = &cobra.Command{
+	Use:   "workers",
+	Short: "Manage the workers of a running server",
+	Long: "These talk to a running server, chosen by --server, $APISERVER_SERVER" +
+		" or a context of ~/.apiserver/config, http://localhost:8080 by default",
+}
+
+var workersListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the workers",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		c := mustClient()
+		workers, err := c.ListWorkers(context.Background(), &listWorkers).All()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		printWorkers(workers)
+	},
+}
+
+var workersGetCmd = &cobra.Command{
+	Use:   "get USERNAME",
+	Short: "Show a worker",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		worker, err := mustClient().GetWorker(context.Background(), args[0])
+		if err != nil {
+			log.Fatalln(err)
+		}
+		printWorker(worker)
+	},
+}
+
+var workersCreateCmd = &cobra.Command{
+	Use:   "create --from-file FILE",
+	Short: "Add a worker",
+	Long:  "This adds the worker described by a YAML or JSON file, - reads it from the standard input",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		worker := new(client.Worker)
+		if err := readWorker(fromFile, worker); err != nil {
+			log.Fatalln(err)
+		}
+		created, err := mustClient().CreateWorker(context.Background(), worker)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		printWorker(created)
+	},
+}
+
+var workersUpdateCmd = &cobra.Command{
+	Use:   "update USERNAME --from-file FILE",
+	Short: "Update a worker",
+	Long: "This changes the fields of a worker given in a YAML or JSON file," +
+		" the fields the file leaves out are kept as they are",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		c := mustClient()
+		ctx := context.Background()
+		worker, err := c.GetWorker(ctx, args[0])
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if err := readWorker(fromFile, worker); err != nil {
+			log.Fatalln(err)
+		}
+		if worker.Username != args[0] {
+			log.Fatalf("the username of %s can't be changed\n", args[0])
+		}
+		if err := c.UpdateWorker(ctx, worker); err != nil {
+			log.Fatalln(err)
+		}
+		if worker, err = c.GetWorker(ctx, args[0]); err != nil {
+			log.Fatalln(err)
+		}
+		printWorker(worker)
+	},
+}
+
+var workersDeleteCmd = &cobra.Command{
+	Use:   "delete USERNAME",
+	Short: "Delete a worker",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := mustClient().DeleteWorker(context.Background(), args[0], &client.DeleteWorkerOptions{ReassignTo: reassignTo})
+		if err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Printf("worker %s deleted\n", args[0])
+	},
+}
+
+var workersRestoreCmd = &cobra.Command{
+	Use:   "restore USERNAME",
+	Short: "Bring a deleted worker back",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		worker, err := mustClient().RestoreWorker(context.Background(), args[0])
+		if err != nil {
+			log.Fatalln(err)
+		}
+		printWorker(worker)
+	},
+}
+
+// workersUsernamesCmd prints the usernames for the shell completion
+var workersUsernamesCmd = &cobra.Command{
+	Use:    "usernames",
+	Hidden: true,
+	Args:   cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		c, err := newClient()
+		if err != nil {
+			os.Exit(1)
+		}
+		it := c.ListWorkers(context.Background(), &client.ListWorkersOptions{PageSize: 1000})
+		for it.Next() {
+			fmt.Println(it.Worker().Username)
+		}
+		if it.Err() != nil {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	addConnectionFlags(workersCmd)
+	workersCmd.PersistentFlags().StringVarP(&output, "output", "o", "table", "the format of the output: "+strings.Join(outputFormat, ", "))
+
+	flags := workersListCmd.Flags()
+	flags.StringVar(&listWorkers.Department, "department", "", "only the workers of this department")
+	flags.StringVar(&listWorkers.Team, "team", "", "only the workers of this team")
+	flags.StringVar(&listWorkers.Manager, "manager", "", "only the direct reports of this worker")
+	flags.StringVar(&listWorkers.Currency, "currency", "", "convert the salaries to this currency")
+	flags.IntVar(&listWorkers.PageSize, "page-size", 100, "how many workers are fetched with a request")
+
+	for _, cmd := range []*cobra.Command{workersCreateCmd, workersUpdateCmd} {
+		cmd.Flags().StringVarP(&fromFile, "from-file", "f", "", "the YAML or JSON file of the worker, - for the standard input")
+		cmd.MarkFlagRequired("from-file")
+		cmd.MarkFlagFilename("from-file", "yaml", "yml", "json")
+	}
+	workersDeleteCmd.Flags().StringVar(&reassignTo, "reassign-to", "", "the worker who takes over the reports of the deleted one")
+
+	workersCmd.AddCommand(workersListCmd, workersGetCmd, workersCreateCmd, workersUpdateCmd, workersDeleteCmd, workersRestoreCmd, workersUsernamesCmd)
+	rootCmd.AddCommand(workersCmd)
+}
+
+func mustClient() *client.Client {
+	if !contains(outputFormat, output) {
+		log.Fatalf("the output can be %s\n", strings.Join(outputFormat, ", "))
+	}
+	c, err := newClient()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return c
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// readWorker decodes the YAML or JSON file into worker, a field the
+// worker doesn't have is an error rather than silently dropped
+func readWorker(file string, worker *client.Worker) error {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return err
+	}
+
+	// JSON is YAML, so both are read as YAML and handed to the JSON
+	// decoder, which knows the field names of the worker
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	body, err := json.Marshal(jsonValue(v))
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(worker); err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	return nil
+}
+
+// jsonValue turns the maps of a YAML document into ones JSON can encode
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = jsonValue(value)
+		}
+		return m
+	case []interface{}:
+		for i := range v {
+			v[i] = jsonValue(v[i])
+		}
+	}
+	return v
+}
+
+func printWorker(worker *client.Worker) {
+	if output == "table" {
+		printTable(os.Stdout, []client.Worker{*worker})
+		return
+	}
+	printValue(worker)
+}
+
+func printWorkers(workers []client.Worker) {
+	if output == "table" {
+		printTable(os.Stdout, workers)
+		return
+	}
+	printValue(workers)
+}
+
+func printTable(w io.Writer, workers []client.Worker) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "USERNAME\tNAME\tPOSITION\tDEPARTMENT\tTEAM\tMANAGER\tSALARY")
+	for _, worker := range workers {
+		fmt.Fprintf(tw, "%s\t%s %s\t%s\t%s\t%s\t%s\t%s\n",
+			worker.Username, worker.FirstName, worker.LastName, worker.Position,
+			orNone(worker.Department), orNone(worker.Team), orNone(worker.Manager),
+			strconv.FormatInt(worker.Salary, 10)+" "+worker.Currency)
+	}
+	tw.Flush()
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// printValue prints v as JSON or YAML, keeping the order of the fields
+func printValue(v interface{}) {
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if output == "json" {
+		fmt.Println(string(body))
+		return
+	}
+
+	// JSON read as YAML into yaml.MapSlice keeps the order of the fields
+	var ordered interface{} = new(yaml.MapSlice)
+	if bytes.HasPrefix(body, []byte("[")) {
+		ordered = new([]yaml.MapSlice)
+	}
+	if err := yaml.Unmarshal(body, ordered); err != nil {
+		log.Fatalln(err)
+	}
+	body, err = yaml.Marshal(ordered)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Print(string(body))
+}
@@ -0,0 +1,5 @@
+// Package workerpb holds the protobuf messages and the gRPC service of the
+// worker API, generated from worker.proto with protoc-gen-go v1.3.1
+package workerpb
+
+//go:generate protoc -I .. -I ../third_party/googleapis --go_out=Mgoogle/protobuf/empty.proto=github.com/golang/protobuf/ptypes/empty,Mgoogle/protobuf/timestamp.proto=github.com/golang/protobuf/ptypes/timestamp,plugins=grpc,paths=source_relative:.. workerpb/worker.proto
@@ -0,0 +1,982 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: workerpb/worker.proto
+
+package workerpb
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	empty "github.com/golang/protobuf/ptypes/empty"
+	timestamp "github.com/golang/protobuf/ptypes/timestamp"
+	_ "google.golang.org/genproto/googleapis/api/annotations"
+	grpc "google.golang.org/grpc"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type WorkerEvent_Type int32
+
+const (
+	WorkerEvent_TYPE_UNSPECIFIED WorkerEvent_Type = 0
+	WorkerEvent_CREATED          WorkerEvent_Type = 1
+	WorkerEvent_UPDATED          WorkerEvent_Type = 2
+	WorkerEvent_DELETED          WorkerEvent_Type = 3
+)
+
+var WorkerEvent_Type_name = map[int32]string{
+	0: "TYPE_UNSPECIFIED",
+	1: "CREATED",
+	2: "UPDATED",
+	3: "DELETED",
+}
+
+var WorkerEvent_Type_value = map[string]int32{
+	"TYPE_UNSPECIFIED": 0,
+	"CREATED":          1,
+	"UPDATED":          2,
+	"DELETED":          3,
+}
+
+func (x WorkerEvent_Type) String() string {
+	return proto.EnumName(WorkerEvent_Type_name, int32(x))
+}
+
+func (WorkerEvent_Type) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_e5e70f8309ff9e66, []int{7, 0}
+}
+
+// Worker is a worker profile, the fields are the ones of the REST API
+type Worker struct {
+	Username  string `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	Firstname string `protobuf:"bytes,2,opt,name=firstname,proto3" json:"firstname,omitempty"`
+	Lastname  string `protobuf:"bytes,3,opt,name=lastname,proto3" json:"lastname,omitempty"`
+	City      string `protobuf:"bytes,4,opt,name=city,proto3" json:"city,omitempty"`
+	Division  string `protobuf:"bytes,5,opt,name=division,proto3" json:"division,omitempty"`
+	Position  string `protobuf:"bytes,6,opt,name=position,proto3" json:"position,omitempty"`
+	// salary is in the minor unit of currency
+	Salary               int64                `protobuf:"varint,7,opt,name=salary,proto3" json:"salary,omitempty"`
+	Currency             string               `protobuf:"bytes,8,opt,name=currency,proto3" json:"currency,omitempty"`
+	SalaryOverride       string               `protobuf:"bytes,9,opt,name=salary_override,json=salaryOverride,proto3" json:"salary_override,omitempty"`
+	SalaryOverrideBy     string               `protobuf:"bytes,10,opt,name=salary_override_by,json=salaryOverrideBy,proto3" json:"salary_override_by,omitempty"`
+	Department           string               `protobuf:"bytes,11,opt,name=department,proto3" json:"department,omitempty"`
+	Team                 string               `protobuf:"bytes,12,opt,name=team,proto3" json:"team,omitempty"`
+	Manager              string               `protobuf:"bytes,13,opt,name=manager,proto3" json:"manager,omitempty"`
+	CreatedAt            *timestamp.Timestamp `protobuf:"bytes,14,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	UpdatedAt            *timestamp.Timestamp `protobuf:"bytes,15,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	Version              int64                `protobuf:"varint,16,opt,name=version,proto3" json:"version,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
+	XXX_unrecognized     []byte               `json:"-"`
+	XXX_sizecache        int32                `json:"-"`
+}
+
+func (m *Worker) Reset()         { *m = Worker{} }
+func (m *Worker) String() string { return proto.CompactTextString(m) }
+func (*Worker) ProtoMessage()    {}
+func (*Worker) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e5e70f8309ff9e66, []int{0}
+}
+
+func (m *Worker) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Worker.Unmarshal(m, b)
+}
+func (m *Worker) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Worker.Marshal(b, m, deterministic)
+}
+func (m *Worker) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Worker.Merge(m, src)
+}
+func (m *Worker) XXX_Size() int {
+	return xxx_messageInfo_Worker.Size(m)
+}
+func (m *Worker) XXX_DiscardUnknown() {
+	xxx_messageInfo_Worker.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Worker proto.InternalMessageInfo
+
+func (m *Worker) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
+func (m *Worker) GetFirstname() string {
+	if m != nil {
+		return m.Firstname
+	}
+	return ""
+}
+
+func (m *Worker) GetLastname() string {
+	if m != nil {
+		return m.Lastname
+	}
+	return ""
+}
+
+func (m *Worker) GetCity() string {
+	if m != nil {
+		return m.City
+	}
+	return ""
+}
+
+func (m *Worker) GetDivision() string {
+	if m != nil {
+		return m.Division
+	}
+	return ""
+}
+
+func (m *Worker) GetPosition() string {
+	if m != nil {
+		return m.Position
+	}
+	return ""
+}
+
+func (m *Worker) GetSalary() int64 {
+	if m != nil {
+		return m.Salary
+	}
+	return 0
+}
+
+func (m *Worker) GetCurrency() string {
+	if m != nil {
+		return m.Currency
+	}
+	return ""
+}
+
+func (m *Worker) GetSalaryOverride() string {
+	if m != nil {
+		return m.SalaryOverride
+	}
+	return ""
+}
+
+func (m *Worker) GetSalaryOverrideBy() string {
+	if m != nil {
+		return m.SalaryOverrideBy
+	}
+	return ""
+}
+
+func (m *Worker) GetDepartment() string {
+	if m != nil {
+		return m.Department
+	}
+	return ""
+}
+
+func (m *Worker) GetTeam() string {
+	if m != nil {
+		return m.Team
+	}
+	return ""
+}
+
+func (m *Worker) GetManager() string {
+	if m != nil {
+		return m.Manager
+	}
+	return ""
+}
+
+func (m *Worker) GetCreatedAt() *timestamp.Timestamp {
+	if m != nil {
+		return m.CreatedAt
+	}
+	return nil
+}
+
+func (m *Worker) GetUpdatedAt() *timestamp.Timestamp {
+	if m != nil {
+		return m.UpdatedAt
+	}
+	return nil
+}
+
+func (m *Worker) GetVersion() int64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+type GetWorkerRequest struct {
+	Username string `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	// currency converts the salary at the exchange rates of date, today by
+	// default
+	Currency             string   `protobuf:"bytes,2,opt,name=currency,proto3" json:"currency,omitempty"`
+	Date                 string   `protobuf:"bytes,3,opt,name=date,proto3" json:"date,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GetWorkerRequest) Reset()         { *m = GetWorkerRequest{} }
+func (m *GetWorkerRequest) String() string { return proto.CompactTextString(m) }
+func (*GetWorkerRequest) ProtoMessage()    {}
+func (*GetWorkerRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e5e70f8309ff9e66, []int{1}
+}
+
+func (m *GetWorkerRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GetWorkerRequest.Unmarshal(m, b)
+}
+func (m *GetWorkerRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GetWorkerRequest.Marshal(b, m, deterministic)
+}
+func (m *GetWorkerRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GetWorkerRequest.Merge(m, src)
+}
+func (m *GetWorkerRequest) XXX_Size() int {
+	return xxx_messageInfo_GetWorkerRequest.Size(m)
+}
+func (m *GetWorkerRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GetWorkerRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GetWorkerRequest proto.InternalMessageInfo
+
+func (m *GetWorkerRequest) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
+func (m *GetWorkerRequest) GetCurrency() string {
+	if m != nil {
+		return m.Currency
+	}
+	return ""
+}
+
+func (m *GetWorkerRequest) GetDate() string {
+	if m != nil {
+		return m.Date
+	}
+	return ""
+}
+
+type ListWorkersRequest struct {
+	Department string `protobuf:"bytes,1,opt,name=department,proto3" json:"department,omitempty"`
+	Team       string `protobuf:"bytes,2,opt,name=team,proto3" json:"team,omitempty"`
+	Manager    string `protobuf:"bytes,3,opt,name=manager,proto3" json:"manager,omitempty"`
+	// currency converts the salaries at the exchange rates of date, today
+	// by default
+	Currency             string   `protobuf:"bytes,4,opt,name=currency,proto3" json:"currency,omitempty"`
+	Date                 string   `protobuf:"bytes,5,opt,name=date,proto3" json:"date,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListWorkersRequest) Reset()         { *m = ListWorkersRequest{} }
+func (m *ListWorkersRequest) String() string { return proto.CompactTextString(m) }
+func (*ListWorkersRequest) ProtoMessage()    {}
+func (*ListWorkersRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e5e70f8309ff9e66, []int{2}
+}
+
+func (m *ListWorkersRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListWorkersRequest.Unmarshal(m, b)
+}
+func (m *ListWorkersRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListWorkersRequest.Marshal(b, m, deterministic)
+}
+func (m *ListWorkersRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListWorkersRequest.Merge(m, src)
+}
+func (m *ListWorkersRequest) XXX_Size() int {
+	return xxx_messageInfo_ListWorkersRequest.Size(m)
+}
+func (m *ListWorkersRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListWorkersRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListWorkersRequest proto.InternalMessageInfo
+
+func (m *ListWorkersRequest) GetDepartment() string {
+	if m != nil {
+		return m.Department
+	}
+	return ""
+}
+
+func (m *ListWorkersRequest) GetTeam() string {
+	if m != nil {
+		return m.Team
+	}
+	return ""
+}
+
+func (m *ListWorkersRequest) GetManager() string {
+	if m != nil {
+		return m.Manager
+	}
+	return ""
+}
+
+func (m *ListWorkersRequest) GetCurrency() string {
+	if m != nil {
+		return m.Currency
+	}
+	return ""
+}
+
+func (m *ListWorkersRequest) GetDate() string {
+	if m != nil {
+		return m.Date
+	}
+	return ""
+}
+
+type CreateWorkerRequest struct {
+	Worker               *Worker  `protobuf:"bytes,1,opt,name=worker,proto3" json:"worker,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CreateWorkerRequest) Reset()         { *m = CreateWorkerRequest{} }
+func (m *CreateWorkerRequest) String() string { return proto.CompactTextString(m) }
+func (*CreateWorkerRequest) ProtoMessage()    {}
+func (*CreateWorkerRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e5e70f8309ff9e66, []int{3}
+}
+
+func (m *CreateWorkerRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CreateWorkerRequest.Unmarshal(m, b)
+}
+func (m *CreateWorkerRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CreateWorkerRequest.Marshal(b, m, deterministic)
+}
+func (m *CreateWorkerRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CreateWorkerRequest.Merge(m, src)
+}
+func (m *CreateWorkerRequest) XXX_Size() int {
+	return xxx_messageInfo_CreateWorkerRequest.Size(m)
+}
+func (m *CreateWorkerRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_CreateWorkerRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CreateWorkerRequest proto.InternalMessageInfo
+
+func (m *CreateWorkerRequest) GetWorker() *Worker {
+	if m != nil {
+		return m.Worker
+	}
+	return nil
+}
+
+type UpdateWorkerRequest struct {
+	Username             string   `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	Worker               *Worker  `protobuf:"bytes,2,opt,name=worker,proto3" json:"worker,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *UpdateWorkerRequest) Reset()         { *m = UpdateWorkerRequest{} }
+func (m *UpdateWorkerRequest) String() string { return proto.CompactTextString(m) }
+func (*UpdateWorkerRequest) ProtoMessage()    {}
+func (*UpdateWorkerRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e5e70f8309ff9e66, []int{4}
+}
+
+func (m *UpdateWorkerRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_UpdateWorkerRequest.Unmarshal(m, b)
+}
+func (m *UpdateWorkerRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_UpdateWorkerRequest.Marshal(b, m, deterministic)
+}
+func (m *UpdateWorkerRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_UpdateWorkerRequest.Merge(m, src)
+}
+func (m *UpdateWorkerRequest) XXX_Size() int {
+	return xxx_messageInfo_UpdateWorkerRequest.Size(m)
+}
+func (m *UpdateWorkerRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_UpdateWorkerRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_UpdateWorkerRequest proto.InternalMessageInfo
+
+func (m *UpdateWorkerRequest) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
+func (m *UpdateWorkerRequest) GetWorker() *Worker {
+	if m != nil {
+		return m.Worker
+	}
+	return nil
+}
+
+type DeleteWorkerRequest struct {
+	Username             string   `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	ReassignTo           string   `protobuf:"bytes,2,opt,name=reassign_to,json=reassignTo,proto3" json:"reassign_to,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DeleteWorkerRequest) Reset()         { *m = DeleteWorkerRequest{} }
+func (m *DeleteWorkerRequest) String() string { return proto.CompactTextString(m) }
+func (*DeleteWorkerRequest) ProtoMessage()    {}
+func (*DeleteWorkerRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e5e70f8309ff9e66, []int{5}
+}
+
+func (m *DeleteWorkerRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeleteWorkerRequest.Unmarshal(m, b)
+}
+func (m *DeleteWorkerRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeleteWorkerRequest.Marshal(b, m, deterministic)
+}
+func (m *DeleteWorkerRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeleteWorkerRequest.Merge(m, src)
+}
+func (m *DeleteWorkerRequest) XXX_Size() int {
+	return xxx_messageInfo_DeleteWorkerRequest.Size(m)
+}
+func (m *DeleteWorkerRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeleteWorkerRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeleteWorkerRequest proto.InternalMessageInfo
+
+func (m *DeleteWorkerRequest) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
+func (m *DeleteWorkerRequest) GetReassignTo() string {
+	if m != nil {
+		return m.ReassignTo
+	}
+	return ""
+}
+
+type WatchWorkersRequest struct {
+	Department string `protobuf:"bytes,1,opt,name=department,proto3" json:"department,omitempty"`
+	Team       string `protobuf:"bytes,2,opt,name=team,proto3" json:"team,omitempty"`
+	Manager    string `protobuf:"bytes,3,opt,name=manager,proto3" json:"manager,omitempty"`
+	// resource_version resumes a watch after the event of that version, the
+	// call fails with OUT_OF_RANGE if the events after it were pruned
+	ResourceVersion      int64    `protobuf:"varint,4,opt,name=resource_version,json=resourceVersion,proto3" json:"resource_version,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WatchWorkersRequest) Reset()         { *m = WatchWorkersRequest{} }
+func (m *WatchWorkersRequest) String() string { return proto.CompactTextString(m) }
+func (*WatchWorkersRequest) ProtoMessage()    {}
+func (*WatchWorkersRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e5e70f8309ff9e66, []int{6}
+}
+
+func (m *WatchWorkersRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WatchWorkersRequest.Unmarshal(m, b)
+}
+func (m *WatchWorkersRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WatchWorkersRequest.Marshal(b, m, deterministic)
+}
+func (m *WatchWorkersRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WatchWorkersRequest.Merge(m, src)
+}
+func (m *WatchWorkersRequest) XXX_Size() int {
+	return xxx_messageInfo_WatchWorkersRequest.Size(m)
+}
+func (m *WatchWorkersRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_WatchWorkersRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WatchWorkersRequest proto.InternalMessageInfo
+
+func (m *WatchWorkersRequest) GetDepartment() string {
+	if m != nil {
+		return m.Department
+	}
+	return ""
+}
+
+func (m *WatchWorkersRequest) GetTeam() string {
+	if m != nil {
+		return m.Team
+	}
+	return ""
+}
+
+func (m *WatchWorkersRequest) GetManager() string {
+	if m != nil {
+		return m.Manager
+	}
+	return ""
+}
+
+func (m *WatchWorkersRequest) GetResourceVersion() int64 {
+	if m != nil {
+		return m.ResourceVersion
+	}
+	return 0
+}
+
+type WorkerEvent struct {
+	Type WorkerEvent_Type `protobuf:"varint,1,opt,name=type,proto3,enum=apiserver.v1.WorkerEvent_Type" json:"type,omitempty"`
+	// worker is the worker after the change, or as it was when deleted
+	Worker *Worker `protobuf:"bytes,2,opt,name=worker,proto3" json:"worker,omitempty"`
+	// resource_version orders the events, a watch resumes after it
+	ResourceVersion      int64    `protobuf:"varint,3,opt,name=resource_version,json=resourceVersion,proto3" json:"resource_version,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WorkerEvent) Reset()         { *m = WorkerEvent{} }
+func (m *WorkerEvent) String() string { return proto.CompactTextString(m) }
+func (*WorkerEvent) ProtoMessage()    {}
+func (*WorkerEvent) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e5e70f8309ff9e66, []int{7}
+}
+
+func (m *WorkerEvent) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WorkerEvent.Unmarshal(m, b)
+}
+func (m *WorkerEvent) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WorkerEvent.Marshal(b, m, deterministic)
+}
+func (m *WorkerEvent) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WorkerEvent.Merge(m, src)
+}
+func (m *WorkerEvent) XXX_Size() int {
+	return xxx_messageInfo_WorkerEvent.Size(m)
+}
+func (m *WorkerEvent) XXX_DiscardUnknown() {
+	xxx_messageInfo_WorkerEvent.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WorkerEvent proto.InternalMessageInfo
+
+func (m *WorkerEvent) GetType() WorkerEvent_Type {
+	if m != nil {
+		return m.Type
+	}
+	return WorkerEvent_TYPE_UNSPECIFIED
+}
+
+func (m *WorkerEvent) GetWorker() *Worker {
+	if m != nil {
+		return m.Worker
+	}
+	return nil
+}
+
+func (m *WorkerEvent) GetResourceVersion() int64 {
+	if m != nil {
+		return m.ResourceVersion
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterEnum("apiserver.v1.WorkerEvent_Type", WorkerEvent_Type_name, WorkerEvent_Type_value)
+	proto.RegisterType((*Worker)(nil), "apiserver.v1.Worker")
+	proto.RegisterType((*GetWorkerRequest)(nil), "apiserver.v1.GetWorkerRequest")
+	proto.RegisterType((*ListWorkersRequest)(nil), "apiserver.v1.ListWorkersRequest")
+	proto.RegisterType((*CreateWorkerRequest)(nil), "apiserver.v1.CreateWorkerRequest")
+	proto.RegisterType((*UpdateWorkerRequest)(nil), "apiserver.v1.UpdateWorkerRequest")
+	proto.RegisterType((*DeleteWorkerRequest)(nil), "apiserver.v1.DeleteWorkerRequest")
+	proto.RegisterType((*WatchWorkersRequest)(nil), "apiserver.v1.WatchWorkersRequest")
+	proto.RegisterType((*WorkerEvent)(nil), "apiserver.v1.WorkerEvent")
+}
+
+func init() { proto.RegisterFile("workerpb/worker.proto", fileDescriptor_e5e70f8309ff9e66) }
+
+var fileDescriptor_e5e70f8309ff9e66 = []byte{
+	// 839 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x56, 0xdd, 0x72, 0xdb, 0x44,
+	0x14, 0x46, 0xb6, 0xe3, 0xd4, 0xc7, 0x6e, 0x62, 0xd6, 0xa5, 0x28, 0x6e, 0x26, 0x49, 0x35, 0x0c,
+	0x04, 0x26, 0x48, 0xc5, 0x5c, 0xc1, 0x5d, 0x6a, 0x0b, 0xa6, 0x33, 0x1d, 0xc8, 0xb8, 0x0e, 0x1d,
+	0xb8, 0xc0, 0xb3, 0x96, 0xb7, 0x8e, 0x20, 0xd2, 0x8a, 0xdd, 0x95, 0x3b, 0x1a, 0x86, 0x1b, 0x1e,
+	0x80, 0x0b, 0xfa, 0x04, 0x3c, 0x13, 0x0f, 0xc0, 0x0d, 0x0f, 0xc2, 0xec, 0xae, 0xd6, 0x95, 0x65,
+	0x39, 0x34, 0x17, 0xbd, 0xdb, 0x73, 0xce, 0xb7, 0xfb, 0x9d, 0xbf, 0xcf, 0x32, 0xbc, 0xf7, 0x92,
+	0xb2, 0x9f, 0x09, 0x4b, 0x66, 0x9e, 0x3e, 0xb8, 0x09, 0xa3, 0x82, 0xa2, 0x0e, 0x4e, 0x42, 0x4e,
+	0xd8, 0x92, 0x30, 0x77, 0xf9, 0x59, 0xff, 0x70, 0x41, 0xe9, 0xe2, 0x9a, 0x78, 0x38, 0x09, 0x3d,
+	0x1c, 0xc7, 0x54, 0x60, 0x11, 0xd2, 0x98, 0x6b, 0x6c, 0xff, 0x41, 0x1e, 0x55, 0xd6, 0x2c, 0x7d,
+	0xe1, 0x91, 0x28, 0x11, 0x59, 0x1e, 0x3c, 0x2e, 0x07, 0x45, 0x18, 0x11, 0x2e, 0x70, 0x94, 0x68,
+	0x80, 0xf3, 0xaa, 0x01, 0xcd, 0xe7, 0x8a, 0x1a, 0xf5, 0xe1, 0x4e, 0xca, 0x09, 0x8b, 0x71, 0x44,
+	0x6c, 0xeb, 0xc4, 0x3a, 0x6d, 0x8d, 0x57, 0x36, 0x3a, 0x84, 0xd6, 0x8b, 0x90, 0x71, 0xa1, 0x82,
+	0x35, 0x15, 0x7c, 0xed, 0x90, 0x37, 0xaf, 0x71, 0x1e, 0xac, 0xeb, 0x9b, 0xc6, 0x46, 0x08, 0x1a,
+	0x41, 0x28, 0x32, 0xbb, 0xa1, 0xfc, 0xea, 0x2c, 0xf1, 0xf3, 0x70, 0x19, 0xf2, 0x90, 0xc6, 0xf6,
+	0x8e, 0xc6, 0x1b, 0x5b, 0xc6, 0x12, 0xca, 0x43, 0x59, 0xa1, 0xdd, 0xd4, 0x31, 0x63, 0xa3, 0xfb,
+	0xd0, 0xe4, 0xf8, 0x1a, 0xb3, 0xcc, 0xde, 0x3d, 0xb1, 0x4e, 0xeb, 0xe3, 0xdc, 0x92, 0x77, 0x82,
+	0x94, 0x31, 0x12, 0x07, 0x99, 0x7d, 0x47, 0xdf, 0x31, 0x36, 0xfa, 0x08, 0xf6, 0x35, 0x6a, 0x4a,
+	0x97, 0x84, 0xb1, 0x70, 0x4e, 0xec, 0x96, 0x82, 0xec, 0x69, 0xf7, 0xb7, 0xb9, 0x17, 0x9d, 0x01,
+	0x2a, 0x01, 0xa7, 0xb3, 0xcc, 0x06, 0x85, 0xed, 0xae, 0x63, 0x1f, 0x67, 0xe8, 0x08, 0x60, 0x4e,
+	0x12, 0xcc, 0x44, 0x44, 0x62, 0x61, 0xb7, 0x15, 0xaa, 0xe0, 0x91, 0x65, 0x0b, 0x82, 0x23, 0xbb,
+	0xa3, 0xcb, 0x96, 0x67, 0x64, 0xc3, 0x6e, 0x84, 0x63, 0xbc, 0x20, 0xcc, 0xbe, 0xab, 0xdc, 0xc6,
+	0x44, 0x5f, 0x00, 0x04, 0x8c, 0x60, 0x41, 0xe6, 0x53, 0x2c, 0xec, 0xbd, 0x13, 0xeb, 0xb4, 0x3d,
+	0xe8, 0xbb, 0x7a, 0x76, 0xae, 0x99, 0x9d, 0x3b, 0x31, 0xb3, 0x1b, 0xb7, 0x72, 0xf4, 0xb9, 0x90,
+	0x57, 0xd3, 0x64, 0x6e, 0xae, 0xee, 0xff, 0xff, 0xd5, 0x1c, 0x7d, 0x2e, 0x64, 0x3e, 0x4b, 0xc2,
+	0xd4, 0x14, 0xba, 0xaa, 0x9f, 0xc6, 0x74, 0x7e, 0x84, 0xee, 0xd7, 0x44, 0xe8, 0xbd, 0x18, 0x93,
+	0x5f, 0x52, 0xc2, 0xc5, 0x8d, 0xeb, 0x51, 0x1c, 0x40, 0xad, 0x34, 0x00, 0x04, 0x0d, 0x49, 0x98,
+	0x2f, 0x86, 0x3a, 0x3b, 0x7f, 0x5a, 0x80, 0x9e, 0x86, 0x3c, 0x67, 0xe0, 0x86, 0x62, 0xbd, 0xa9,
+	0xd6, 0xd6, 0xa6, 0xd6, 0xaa, 0x9b, 0x5a, 0x5f, 0x6f, 0x6a, 0x31, 0xa9, 0xc6, 0x96, 0xa4, 0x76,
+	0x0a, 0x49, 0x0d, 0xa1, 0x37, 0x54, 0x6d, 0x5d, 0xaf, 0xfb, 0x0c, 0x9a, 0x5a, 0x9b, 0x2a, 0xa1,
+	0xf6, 0xe0, 0x9e, 0x5b, 0x14, 0xa7, 0x9b, 0x83, 0x73, 0x8c, 0x33, 0x85, 0xde, 0xa5, 0x6a, 0xf0,
+	0x9b, 0x37, 0xef, 0x35, 0x41, 0xed, 0x0d, 0x08, 0xc6, 0xd0, 0x1b, 0x91, 0x6b, 0x72, 0x1b, 0x82,
+	0x63, 0x68, 0x33, 0x82, 0x39, 0x0f, 0x17, 0xf1, 0x54, 0xd0, 0xbc, 0x7b, 0x60, 0x5c, 0x13, 0xea,
+	0xfc, 0x61, 0x41, 0xef, 0x39, 0x16, 0xc1, 0xd5, 0x5b, 0x9d, 0xc7, 0xc7, 0xd0, 0x65, 0x84, 0xd3,
+	0x94, 0x05, 0x64, 0x6a, 0xf6, 0xae, 0xa1, 0xf6, 0x6e, 0xdf, 0xf8, 0xbf, 0xcb, 0xf7, 0xef, 0x1f,
+	0x0b, 0xda, 0x3a, 0x17, 0x7f, 0x29, 0x89, 0x06, 0xd0, 0x10, 0x59, 0xa2, 0x2b, 0xdb, 0x1b, 0x1c,
+	0x55, 0x35, 0x48, 0x01, 0xdd, 0x49, 0x96, 0x90, 0xb1, 0xc2, 0xde, 0xae, 0xad, 0x95, 0xc9, 0xd5,
+	0xab, 0x93, 0x1b, 0x42, 0x43, 0xd2, 0xa0, 0x7b, 0xd0, 0x9d, 0x7c, 0x7f, 0xe1, 0x4f, 0x2f, 0xbf,
+	0x79, 0x76, 0xe1, 0x0f, 0x9f, 0x7c, 0xf5, 0xc4, 0x1f, 0x75, 0xdf, 0x41, 0x6d, 0xd8, 0x1d, 0x8e,
+	0xfd, 0xf3, 0x89, 0x3f, 0xea, 0x5a, 0xd2, 0xb8, 0xbc, 0x18, 0x29, 0xa3, 0x26, 0x8d, 0x91, 0xff,
+	0xd4, 0x97, 0x46, 0x7d, 0xf0, 0xd7, 0x0e, 0xdc, 0xd5, 0x29, 0x3c, 0x23, 0x6c, 0x19, 0x06, 0x04,
+	0x2d, 0xa0, 0xb5, 0xd2, 0x1c, 0x2a, 0x95, 0x58, 0x16, 0x63, 0xbf, 0xb2, 0x18, 0xe7, 0x83, 0xdf,
+	0xff, 0xfe, 0xf7, 0x55, 0xed, 0x08, 0x1d, 0x7a, 0x38, 0x49, 0x78, 0x40, 0xe7, 0x24, 0xff, 0xac,
+	0x70, 0xef, 0x57, 0xb3, 0x0d, 0xbf, 0xa1, 0x19, 0xb4, 0x0b, 0xda, 0x43, 0x27, 0xeb, 0x4f, 0x6d,
+	0xca, 0x72, 0x0b, 0xd9, 0x81, 0x22, 0xeb, 0xa1, 0x77, 0x37, 0xc8, 0x1e, 0x59, 0xe8, 0x27, 0xe8,
+	0x14, 0xb5, 0x84, 0x1e, 0xae, 0x3f, 0x51, 0xa1, 0xb3, 0x2d, 0x2c, 0x0f, 0x15, 0xcb, 0x03, 0x67,
+	0x93, 0xe5, 0x4b, 0x33, 0xba, 0x14, 0x3a, 0x45, 0xc9, 0x95, 0xb9, 0x2a, 0xe4, 0xb8, 0x85, 0xeb,
+	0x4c, 0x71, 0x7d, 0xd8, 0xbf, 0xb1, 0x7d, 0x2b, 0x5a, 0x0a, 0x9d, 0xa2, 0x10, 0xcb, 0xb4, 0x15,
+	0x22, 0xed, 0xdf, 0xdf, 0xf8, 0x5d, 0xf6, 0xe5, 0xb7, 0xda, 0xcc, 0xed, 0x93, 0x9b, 0xe7, 0x46,
+	0xa1, 0x53, 0x14, 0x69, 0x99, 0xb0, 0x42, 0xc0, 0xfd, 0x83, 0xad, 0x4a, 0x71, 0x8e, 0x15, 0xe7,
+	0x01, 0x7a, 0x7f, 0x93, 0xf3, 0xa5, 0x7c, 0xe9, 0x91, 0xf5, 0xd8, 0xfd, 0xe1, 0x6c, 0x11, 0x8a,
+	0xab, 0x74, 0xe6, 0x06, 0x34, 0xf2, 0x22, 0xcc, 0xd3, 0x79, 0xca, 0x3e, 0x65, 0xf8, 0x2a, 0xc2,
+	0xb1, 0xb7, 0x7a, 0xd8, 0x33, 0x7f, 0x61, 0x66, 0x4d, 0x55, 0xd6, 0xe7, 0xff, 0x0d, 0x00, 0x6b,
+	0x00, 0xf4, 0xfa, 0xd5, 0x08, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// WorkerServiceClient is the client API for WorkerService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type WorkerServiceClient interface {
+	// GetWorker returns a worker by their username
+	GetWorker(ctx context.Context, in *GetWorkerRequest, opts ...grpc.CallOption) (*Worker, error)
+	// ListWorkers streams the workers in the order of their usernames, as
+	// they are read from the database
+	ListWorkers(ctx context.Context, in *ListWorkersRequest, opts ...grpc.CallOption) (WorkerService_ListWorkersClient, error)
+	CreateWorker(ctx context.Context, in *CreateWorkerRequest, opts ...grpc.CallOption) (*Worker, error)
+	// UpdateWorker replaces the profile of a worker
+	UpdateWorker(ctx context.Context, in *UpdateWorkerRequest, opts ...grpc.CallOption) (*Worker, error)
+	// DeleteWorker deletes a worker, a worker with reports can only be
+	// deleted by moving them to reassign_to
+	DeleteWorker(ctx context.Context, in *DeleteWorkerRequest, opts ...grpc.CallOption) (*empty.Empty, error)
+	// WatchWorkers streams the changes of the selected workers made from
+	// now on, or after resource_version, until the client cancels the call
+	WatchWorkers(ctx context.Context, in *WatchWorkersRequest, opts ...grpc.CallOption) (WorkerService_WatchWorkersClient, error)
+}
+
+type workerServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewWorkerServiceClient(cc *grpc.ClientConn) WorkerServiceClient {
+	return &workerServiceClient{cc}
+}
+
+func (c *workerServiceClient) GetWorker(ctx context.Context, in *GetWorkerRequest, opts ...grpc.CallOption) (*Worker, error) {
+	out := new(Worker)
+	err := c.cc.Invoke(ctx, "/apiserver.v1.WorkerService/GetWorker", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *workerServiceClient) ListWorkers(ctx context.Context, in *ListWorkersRequest, opts ...grpc.CallOption) (WorkerService_ListWorkersClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_WorkerService_serviceDesc.Streams[0], "/apiserver.v1.WorkerService/ListWorkers", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &workerServiceListWorkersClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type WorkerService_ListWorkersClient interface {
+	Recv() (*Worker, error)
+	grpc.ClientStream
+}
+
+type workerServiceListWorkersClient struct {
+	grpc.ClientStream
+}
+
+func (x *workerServiceListWorkersClient) Recv() (*Worker, error) {
+	m := new(Worker)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *workerServiceClient) CreateWorker(ctx context.Context, in *CreateWorkerRequest, opts ...grpc.CallOption) (*Worker, error) {
+	out := new(Worker)
+	err := c.cc.Invoke(ctx, "/apiserver.v1.WorkerService/CreateWorker", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *workerServiceClient) UpdateWorker(ctx context.Context, in *UpdateWorkerRequest, opts ...grpc.CallOption) (*Worker, error) {
+	out := new(Worker)
+	err := c.cc.Invoke(ctx, "/apiserver.v1.WorkerService/UpdateWorker", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *workerServiceClient) DeleteWorker(ctx context.Context, in *DeleteWorkerRequest, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/apiserver.v1.WorkerService/DeleteWorker", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *workerServiceClient) WatchWorkers(ctx context.Context, in *WatchWorkersRequest, opts ...grpc.CallOption) (WorkerService_WatchWorkersClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_WorkerService_serviceDesc.Streams[1], "/apiserver.v1.WorkerService/WatchWorkers", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &workerServiceWatchWorkersClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type WorkerService_WatchWorkersClient interface {
+	Recv() (*WorkerEvent, error)
+	grpc.ClientStream
+}
+
+type workerServiceWatchWorkersClient struct {
+	grpc.ClientStream
+}
+
+func (x *workerServiceWatchWorkersClient) Recv() (*WorkerEvent, error) {
+	m := new(WorkerEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WorkerServiceServer is the server API for WorkerService service.
+type WorkerServiceServer interface {
+	// GetWorker returns a worker by their username
+	GetWorker(context.Context, *GetWorkerRequest) (*Worker, error)
+	// ListWorkers streams the workers in the order of their usernames, as
+	// they are read from the database
+	ListWorkers(*ListWorkersRequest, WorkerService_ListWorkersServer) error
+	CreateWorker(context.Context, *CreateWorkerRequest) (*Worker, error)
+	// UpdateWorker replaces the profile of a worker
+	UpdateWorker(context.Context, *UpdateWorkerRequest) (*Worker, error)
+	// DeleteWorker deletes a worker, a worker with reports can only be
+	// deleted by moving them to reassign_to
+	DeleteWorker(context.Context, *DeleteWorkerRequest) (*empty.Empty, error)
+	// WatchWorkers streams the changes of the selected workers made from
+	// now on, or after resource_version, until the client cancels the call
+	WatchWorkers(*WatchWorkersRequest, WorkerService_WatchWorkersServer) error
+}
+
+func RegisterWorkerServiceServer(s *grpc.Server, srv WorkerServiceServer) {
+	s.RegisterService(&_WorkerService_serviceDesc, srv)
+}
+
+func _WorkerService_GetWorker_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetWorkerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerServiceServer).GetWorker(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/apiserver.v1.WorkerService/GetWorker",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerServiceServer).GetWorker(ctx, req.(*GetWorkerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WorkerService_ListWorkers_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListWorkersRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WorkerServiceServer).ListWorkers(m, &workerServiceListWorkersServer{stream})
+}
+
+type WorkerService_ListWorkersServer interface {
+	Send(*Worker) error
+	grpc.ServerStream
+}
+
+type workerServiceListWorkersServer struct {
+	grpc.ServerStream
+}
+
+func (x *workerServiceListWorkersServer) Send(m *Worker) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _WorkerService_CreateWorker_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateWorkerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerServiceServer).CreateWorker(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/apiserver.v1.WorkerService/CreateWorker",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerServiceServer).CreateWorker(ctx, req.(*CreateWorkerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WorkerService_UpdateWorker_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateWorkerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerServiceServer).UpdateWorker(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/apiserver.v1.WorkerService/UpdateWorker",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerServiceServer).UpdateWorker(ctx, req.(*UpdateWorkerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WorkerService_DeleteWorker_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteWorkerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerServiceServer).DeleteWorker(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/apiserver.v1.WorkerService/DeleteWorker",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerServiceServer).DeleteWorker(ctx, req.(*DeleteWorkerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WorkerService_WatchWorkers_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchWorkersRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WorkerServiceServer).WatchWorkers(m, &workerServiceWatchWorkersServer{stream})
+}
+
+type WorkerService_WatchWorkersServer interface {
+	Send(*WorkerEvent) error
+	grpc.ServerStream
+}
+
+type workerServiceWatchWorkersServer struct {
+	grpc.ServerStream
+}
+
+func (x *workerServiceWatchWorkersServer) Send(m *WorkerEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _WorkerService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "apiserver.v1.WorkerService",
+	HandlerType: (*WorkerServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetWorker",
+			Handler:    _WorkerService_GetWorker_Handler,
+		},
+		{
+			MethodName: "CreateWorker",
+			Handler:    _WorkerService_CreateWorker_Handler,
+		},
+		{
+			MethodName: "UpdateWorker",
+			Handler:    _WorkerService_UpdateWorker_Handler,
+		},
+		{
+			MethodName: "DeleteWorker",
+			Handler:    _WorkerService_DeleteWorker_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListWorkers",
+			Handler:       _WorkerService_ListWorkers_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchWorkers",
+			Handler:       _WorkerService_WatchWorkers_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "workerpb/worker.proto",
+}